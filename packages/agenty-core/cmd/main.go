@@ -11,17 +11,26 @@ import (
 	"github.com/masteryyh/agenty-core/pkg/agentloop"
 	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
 	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
 	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+	"github.com/masteryyh/agenty-core/pkg/infra/cassette"
 	"github.com/masteryyh/agenty-core/pkg/infra/config"
+	"github.com/masteryyh/agenty-core/pkg/infra/hooks"
 	"github.com/masteryyh/agenty-core/pkg/infra/initialize"
 	"github.com/masteryyh/agenty-core/pkg/infra/llm"
 	"github.com/masteryyh/agenty-core/pkg/infra/logging"
 	"github.com/masteryyh/agenty-core/pkg/infra/rpc"
 	"github.com/masteryyh/agenty-core/pkg/infra/rpc/adapter"
+	"github.com/masteryyh/agenty-core/pkg/infra/tokenizer"
+	"github.com/masteryyh/agenty-core/pkg/utils/sandbox"
 	"github.com/masteryyh/agenty-core/pkg/utils/signal"
 )
 
 func main() {
+	// Sandboxed commands start as a copy of this binary that sets up the
+	// sandbox before anything else runs.
+	sandbox.RunHelper()
 	os.Exit(run())
 }
 
@@ -61,31 +70,91 @@ func run() (exitCode int) {
 
 	slog.InfoContext(ctx, "agenty-core started", "dataDir", config.Get().Paths().DataDir)
 	toolRegistry := agentloop.NewRegistry()
-	if err := builtin.RegisterAll(toolRegistry); err != nil {
+	toolRegistry.SetMaxParallelism(config.Get().Config().MaxParallelTools)
+	processes := builtin.NewProcesses()
+	shellConfig := config.Get().Config().Shell
+	workspaceConfig := config.Get().Config().Workspace
+	builtinOptions := builtin.Options{
+		Workspace: builtin.WorkspaceOptions{
+			AllowedPaths: workspaceConfig.AllowedPaths,
+			ReadAnywhere: workspaceConfig.ReadAnywhere,
+		},
+		Shell: builtin.ShellOptions{
+			Persistent: shellConfig.Persistent,
+			Limits: builtin.LimitOptions{
+				CPUSeconds:        shellConfig.Limits.CPUSeconds,
+				AddressSpaceBytes: shellConfig.Limits.AddressSpaceMB << 20,
+				OpenFiles:         shellConfig.Limits.OpenFiles,
+				Processes:         shellConfig.Limits.Processes,
+			},
+		},
+	}
+	if shellConfig.Limits.SessionMemoryMB > 0 || shellConfig.Limits.SessionProcesses > 0 {
+		cgroups, err := sandbox.NewCgroups(shellConfig.Limits.SessionMemoryMB<<20, shellConfig.Limits.SessionProcesses)
+		if err != nil {
+			slog.WarnContext(ctx, "session limits need a delegated cgroup v2 group; they are ignored", "error", err)
+		} else {
+			builtinOptions.Shell.Limits.Cgroups = cgroups
+		}
+	}
+	if shellConfig.Sandbox.Enabled {
+		if !sandbox.Supported() {
+			slog.WarnContext(ctx, "the shell sandbox is only supported on Linux; sandboxed commands will fail")
+		}
+		builtinOptions.Shell.Sandbox = &builtin.SandboxOptions{
+			WritablePaths: shellConfig.Sandbox.WritablePaths,
+			HiddenPaths:   []string{config.Get().Paths().DataDir},
+			DenyNetwork:   shellConfig.Sandbox.DenyNetwork,
+		}
+	}
+	if err := builtin.RegisterAll(toolRegistry, processes, builtinOptions); err != nil {
 		slog.ErrorContext(ctx, "failed to register built-in tools", "error", err)
 		return 1
 	}
 
+	recorder, err := cassette.FromEnv(os.Getenv)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to open model call cassette", "error", err)
+		return 1
+	}
+	if recorder != nil {
+		slog.InfoContext(ctx, "model calls go through a cassette", "mode", recorder.Mode(), "path", recorder.Path())
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				slog.ErrorContext(ctx, "failed to close model call cassette", "error", err)
+				exitCode = 1
+			}
+		}()
+	}
+
+	hookRunner, err := hooks.NewRunner(config.Get().Config().Hooks)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load hooks", "error", err)
+		return 1
+	}
+
 	disp := rpc.NewDispatcher()
 	srv := rpc.NewServer(disp, os.Stdin, os.Stdout)
 	execution, err := agentloop.NewEngine(ctx, agentloop.Dependencies{
-		Sessions: repos.Conversation,
-		Agents:   repos.Agent,
-		Catalog:  repos.Catalog,
-		Tools:    toolRegistry,
-		NewCaller: func(
-			callerCtx context.Context,
-			provider catalog.Provider,
-			model catalog.Model,
-		) (agentloop.Caller, error) {
-			return llm.NewCaller(callerCtx, provider, model)
-		},
+		Sessions:  repos.Conversation,
+		Agents:    repos.Agent,
+		Catalog:   repos.Catalog,
+		Tools:     toolRegistry,
+		NewCaller: callerFactory(recorder),
 		Events: func(eventCtx context.Context, event agentloop.SessionEvent) error {
 			return srv.Notify(eventCtx, "session.event", event)
 		},
 		Compactions: func(eventCtx context.Context, event agentloop.CompactionEvent) error {
 			return srv.Notify(eventCtx, "session.compaction", event)
 		},
+		Queue: func(eventCtx context.Context, event agentloop.QueueEvent) error {
+			return srv.Notify(eventCtx, "session.queue", event)
+		},
+		ToolPolicies:   toolPolicies(config.Get().Config()),
+		Hooks:          hookRunner,
+		Tokenizers:     tokenizer.New,
+		GenerateTitles: true,
+		Resources:      processes,
 	})
 	if err != nil {
 		slog.ErrorContext(ctx, "failed to initialize execution engine", "error", err)
@@ -103,6 +172,7 @@ func run() (exitCode int) {
 	sessionService := application.NewSessionService(
 		repos.Conversation,
 		application.WithSessionExecutionState(execution),
+		application.WithSessionResources(processes),
 	)
 	agentService := application.NewAgentService(repos.Agent)
 	providerService := application.NewProviderService(repos.Catalog)
@@ -113,6 +183,7 @@ func run() (exitCode int) {
 		initializeService,
 		sessionService,
 		execution,
+		processes,
 	)
 
 	asm := rpc.NewChunkAssembler(disp)
@@ -125,3 +196,34 @@ func run() (exitCode int) {
 	}
 	return 0
 }
+
+// callerFactory builds provider callers, routing them through recorder when
+// one is set. Replayed calls never reach the providers.
+func callerFactory(recorder *cassette.Cassette) agentloop.CallerFactory {
+	return func(
+		callerCtx context.Context,
+		provider catalog.Provider,
+		model catalog.Model,
+	) (agentloop.Caller, error) {
+		if recorder == nil {
+			return llm.NewCaller(callerCtx, provider, model)
+		}
+		ref := shared.NewModelRef(provider.Code, model.Code)
+		if recorder.Mode() == cassette.ModeReplay {
+			return recorder.Wrap(ref, nil), nil
+		}
+		caller, err := llm.NewCaller(callerCtx, provider, model)
+		if err != nil {
+			return nil, err
+		}
+		return recorder.Wrap(ref, caller), nil
+	}
+}
+
+func toolPolicies(cfg *config.Config) map[string]agent.ToolPolicy {
+	policies := make(map[string]agent.ToolPolicy, len(cfg.ToolPolicies))
+	for name, policy := range cfg.ToolPolicies {
+		policies[name] = agent.ToolPolicy(policy)
+	}
+	return policies
+}
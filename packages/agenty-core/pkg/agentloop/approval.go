@@ -0,0 +1,356 @@
+package agentloop
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/application/apperrors"
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+type ToolApprovalResult struct {
+	SessionID uuid.UUID `json:"sessionId"`
+	RoundID   uuid.UUID `json:"roundId"`
+	Decided   []string  `json:"decided"`
+	Pending   []string  `json:"pending"`
+}
+
+type toolApprovalDecision struct {
+	approved bool
+	reason   string
+}
+
+// pendingApproval holds the gated calls of one loop iteration. Decisions are
+// written under Engine.mu; the waiting loop reads them only after done closes.
+type pendingApproval struct {
+	calls     []conversation.ToolUseBlock
+	decisions map[string]toolApprovalDecision
+	done      chan struct{}
+}
+
+func newPendingApproval(calls []conversation.ToolUseBlock) *pendingApproval {
+	return &pendingApproval{
+		calls:     calls,
+		decisions: make(map[string]toolApprovalDecision, len(calls)),
+		done:      make(chan struct{}),
+	}
+}
+
+func (approval *pendingApproval) decide(
+	toolUseIDs []string,
+	decision toolApprovalDecision,
+) ([]string, error) {
+	targets := toolUseIDs
+	if len(targets) == 0 {
+		targets = approval.pendingIDs()
+	}
+	if len(targets) == 0 {
+		return nil, apperrors.Validation("no tool calls are awaiting approval")
+	}
+	for _, id := range targets {
+		if !slices.ContainsFunc(approval.calls, func(call conversation.ToolUseBlock) bool {
+			return call.ID == id
+		}) {
+			return nil, apperrors.Validation("tool call " + id + " is not awaiting approval")
+		}
+		if _, decided := approval.decisions[id]; decided {
+			return nil, apperrors.Validation("tool call " + id + " was already decided")
+		}
+	}
+
+	for _, id := range targets {
+		approval.decisions[id] = decision
+	}
+	if len(approval.decisions) == len(approval.calls) {
+		close(approval.done)
+	}
+	return targets, nil
+}
+
+func (approval *pendingApproval) pendingIDs() []string {
+	ids := make([]string, 0, len(approval.calls))
+	for _, call := range approval.calls {
+		if _, decided := approval.decisions[call.ID]; !decided {
+			ids = append(ids, call.ID)
+		}
+	}
+	return ids
+}
+
+func (engine *Engine) ApproveToolCalls(
+	_ context.Context,
+	sessionID string,
+	toolUseIDs []string,
+) (*ToolApprovalResult, error) {
+	return engine.decideToolCalls(sessionID, toolUseIDs, toolApprovalDecision{approved: true})
+}
+
+func (engine *Engine) RejectToolCalls(
+	_ context.Context,
+	sessionID string,
+	toolUseIDs []string,
+	reason string,
+) (*ToolApprovalResult, error) {
+	return engine.decideToolCalls(sessionID, toolUseIDs, toolApprovalDecision{reason: reason})
+}
+
+func (engine *Engine) decideToolCalls(
+	sessionID string,
+	toolUseIDs []string,
+	decision toolApprovalDecision,
+) (*ToolApprovalResult, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	execution, ok := engine.active[id]
+	if !ok || execution.approval == nil {
+		return nil, apperrors.NotFound("session " + sessionID + " has no tool calls awaiting approval")
+	}
+	decided, err := execution.approval.decide(toolUseIDs, decision)
+	if err != nil {
+		return nil, err
+	}
+	return &ToolApprovalResult{
+		SessionID: id,
+		RoundID:   execution.roundID,
+		Decided:   decided,
+		Pending:   execution.approval.pendingIDs(),
+	}, nil
+}
+
+// toolAvailable reports whether the tool may be used at all, given the
+// session's agent and mode.
+func (engine *Engine) toolAvailable(prepared *preparedExecution, name string) bool {
+	if refusedInPlanMode(prepared, name) {
+		return false
+	}
+	return prepared.agentDefinition == nil || prepared.agentDefinition.Tools.Allows(name)
+}
+
+// toolDefinitions returns the definitions of the tools available to the
+// session's agent in its current mode.
+func (engine *Engine) toolDefinitions(prepared *preparedExecution) []ToolDefinition {
+	definitions := engine.tools.Definitions()
+	available := make([]ToolDefinition, 0, len(definitions))
+	for _, definition := range definitions {
+		if engine.toolAvailable(prepared, definition.Name) {
+			available = append(available, definition)
+		}
+	}
+	return available
+}
+
+func (engine *Engine) toolPolicy(prepared *preparedExecution, name string) agent.ToolPolicy {
+	if prepared.agentDefinition != nil {
+		if policy, ok := prepared.agentDefinition.ToolPolicy(name); ok {
+			return policy
+		}
+	}
+	if policy, ok := engine.toolPolicies[name]; ok {
+		return policy
+	}
+	return agent.ToolPolicyAllow
+}
+
+// executeTools applies the session mode, the agent's tool access, the tool
+// policies and pre_tool_use hooks before running a batch. Calls that need a
+// human checkpoint are announced through a session event and block the loop
+// until every one of them is approved or rejected. It also returns the context
+// added by the batch's hooks.
+func (engine *Engine) executeTools(
+	ctx context.Context,
+	execution *activeExecution,
+	prepared *preparedExecution,
+	iteration int,
+	calls []conversation.ToolUseBlock,
+) ([]conversation.ToolResultBlock, []conversation.Content, error) {
+	calls = slices.Clone(calls)
+	results := make([]conversation.ToolResultBlock, len(calls))
+	resolved := make([]bool, len(calls))
+	gated := make([]conversation.ToolUseBlock, 0)
+	var hookContext []conversation.Content
+	for index, call := range calls {
+		if refusedInPlanMode(prepared, call.Name) {
+			results[index] = toolErrorResult(call, fmt.Sprintf("tool %q is not available in plan mode", call.Name))
+			resolved[index] = true
+			continue
+		}
+		if !engine.toolAvailable(prepared, call.Name) {
+			results[index] = toolErrorResult(call, fmt.Sprintf("tool %q is not available to this agent", call.Name))
+			resolved[index] = true
+			continue
+		}
+		if engine.toolPolicy(prepared, call.Name) == agent.ToolPolicyDeny {
+			results[index] = toolErrorResult(call, fmt.Sprintf("tool %q is denied by policy", call.Name))
+			resolved[index] = true
+			continue
+		}
+		var blocked *conversation.ToolResultBlock
+		var contexts []conversation.Content
+		calls[index], blocked, contexts = engine.preToolUse(ctx, prepared, call)
+		hookContext = append(hookContext, contexts...)
+		if blocked != nil {
+			results[index] = *blocked
+			resolved[index] = true
+			continue
+		}
+		if engine.toolPolicy(prepared, call.Name) == agent.ToolPolicyAsk {
+			gated = append(gated, calls[index])
+		}
+	}
+
+	if len(gated) > 0 {
+		decisions, err := engine.awaitApproval(ctx, execution, prepared, iteration, gated)
+		if err != nil {
+			return nil, nil, err
+		}
+		for index, call := range calls {
+			decision, ok := decisions[call.ID]
+			if !ok || decision.approved {
+				continue
+			}
+			results[index] = toolErrorResult(call, rejectedToolCallMessage(decision.reason))
+			resolved[index] = true
+		}
+	}
+
+	runnable := make([]conversation.ToolUseBlock, 0, len(calls))
+	for index, call := range calls {
+		if !resolved[index] {
+			runnable = append(runnable, call)
+		}
+	}
+	if len(runnable) == 0 {
+		return results, hookContext, nil
+	}
+
+	running := newRunningToolCalls()
+	engine.mu.Lock()
+	execution.tools = running
+	engine.mu.Unlock()
+	executed := engine.tools.ExecuteBatch(ctx, CallContext{
+		SessionID:      prepared.session.ID,
+		RoundID:        prepared.roundID,
+		Cwd:            roundCwdValue(prepared),
+		WorkspaceRoots: prepared.session.WorkspaceRoots,
+		Mode:           preparedMode(prepared),
+		Subagents:      engine,
+		Progress:       engine.toolProgress(ctx, prepared, iteration),
+		running:        running,
+	}, runnable)
+	engine.mu.Lock()
+	execution.tools = nil
+	engine.mu.Unlock()
+	next := 0
+	for index, call := range calls {
+		if resolved[index] {
+			continue
+		}
+		results[index] = executed[next]
+		next++
+		hookContext = append(hookContext, engine.postToolUse(ctx, prepared, call, &results[index])...)
+	}
+	return results, hookContext, nil
+}
+
+func (engine *Engine) awaitApproval(
+	ctx context.Context,
+	execution *activeExecution,
+	prepared *preparedExecution,
+	iteration int,
+	calls []conversation.ToolUseBlock,
+) (map[string]toolApprovalDecision, error) {
+	approval := newPendingApproval(calls)
+	engine.mu.Lock()
+	execution.approval = approval
+	engine.mu.Unlock()
+	defer func() {
+		engine.mu.Lock()
+		if execution.approval == approval {
+			execution.approval = nil
+		}
+		engine.mu.Unlock()
+	}()
+
+	if err := engine.emit(ctx, prepared, SessionEvent{
+		Type:      SessionEventToolApprovalRequested,
+		Iteration: iteration,
+		ToolCalls: calls,
+	}); err != nil {
+		return nil, fmt.Errorf("emit tool approval request: %w", err)
+	}
+
+	select {
+	case <-approval.done:
+		return approval.decisions, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// abandonToolCalls closes a batch whose calls will never run because the round
+// was stopped or ran out of budget, so the persisted transcript keeps every
+// tool use paired with a result.
+func (engine *Engine) abandonToolCalls(
+	ctx context.Context,
+	prepared *preparedExecution,
+	iteration int,
+	calls []conversation.ToolUseBlock,
+	reason string,
+) {
+	finishCtx := context.WithoutCancel(ctx)
+	content := make(conversation.Content, 0, len(calls))
+	for _, call := range calls {
+		content = append(content, toolErrorResult(call, "tool call was not executed because "+reason))
+	}
+	message, err := prepared.session.AppendUserMessage(prepared.roundID, content)
+	if err == nil {
+		err = engine.saveProgress(finishCtx, prepared.session)
+	}
+	if err == nil {
+		err = engine.emit(finishCtx, prepared, SessionEvent{
+			Type:      SessionEventMessageAppended,
+			Iteration: iteration,
+			Message:   &message,
+		})
+	}
+	if err != nil {
+		engine.logger.ErrorContext(finishCtx, "failed to close abandoned tool calls",
+			"sessionId", prepared.session.ID,
+			"roundId", prepared.roundID,
+			"error", err,
+		)
+	}
+}
+
+func toolErrorResult(call conversation.ToolUseBlock, message string) conversation.ToolResultBlock {
+	return conversation.ToolResultBlock{
+		ToolUseID: call.ID,
+		Content:   conversation.Text(message),
+		IsError:   true,
+	}
+}
+
+func rejectedToolCallMessage(reason string) string {
+	if reason == "" {
+		return "the user rejected this tool call"
+	}
+	return "the user rejected this tool call: " + reason
+}
+
+func roundCwdValue(prepared *preparedExecution) string {
+	round := prepared.session.Rounds[len(prepared.session.Rounds)-1]
+	if round.Cwd == nil {
+		return ""
+	}
+	return *round.Cwd
+}
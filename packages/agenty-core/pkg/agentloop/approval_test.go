@@ -0,0 +1,366 @@
+package agentloop_test
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func registerCountingTool(t *testing.T, fixture *executionFixture, name string) *atomic.Int32 {
+	t.Helper()
+
+	var calls atomic.Int32
+	if err := fixture.registry.Register(&executionTestTool{
+		definition: agentloop.ToolDefinition{
+			Name:        name,
+			InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+		},
+		execute: func(context.Context, agentloop.CallContext, []byte) (conversation.Content, error) {
+			calls.Add(1)
+			return conversation.Text(name + " ran"), nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return &calls
+}
+
+func setAgentToolPolicies(t *testing.T, fixture *executionFixture, policies map[string]agent.ToolPolicy) {
+	t.Helper()
+
+	definition, err := fixture.agents.Get(t.Context(), "coder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition.ToolPolicies = policies
+	if err := fixture.agents.Save(t.Context(), definition); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func approvalEvents(events chan agentloop.SessionEvent) func(context.Context, agentloop.SessionEvent) error {
+	return func(_ context.Context, event agentloop.SessionEvent) error {
+		if event.Type == agentloop.SessionEventToolApprovalRequested {
+			events <- event
+		}
+		return nil
+	}
+}
+
+func toolUseResponse(calls ...conversation.ToolUseBlock) *agentloop.Response {
+	content := make(conversation.Content, 0, len(calls))
+	for _, call := range calls {
+		content = append(content, call)
+	}
+	return &agentloop.Response{Content: content, StopReason: agentloop.StopReasonToolUse}
+}
+
+func TestEngineWaitsForApprovalBeforeRunningGatedTools(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	shellCalls := registerCountingTool(t, fixture, "shell")
+	readCalls := registerCountingTool(t, fixture, "read_file")
+	setAgentToolPolicies(t, fixture, map[string]agent.ToolPolicy{"shell": agent.ToolPolicyAsk})
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(
+			conversation.ToolUseBlock{ID: "call-shell", Name: "shell", Input: []byte(`{}`)},
+			conversation.ToolUseBlock{ID: "call-read", Name: "read_file", Input: []byte(`{}`)},
+		),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	events := make(chan agentloop.SessionEvent, 4)
+	engine := fixture.newEngineWithEvents(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	}, approvalEvents(events))
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	var requested agentloop.SessionEvent
+	select {
+	case requested = <-events:
+	case <-time.After(time.Second):
+		t.Fatal("approval was not requested")
+	}
+	if len(requested.ToolCalls) != 1 || requested.ToolCalls[0].ID != "call-shell" {
+		t.Fatalf("approval request calls = %+v", requested.ToolCalls)
+	}
+	if shellCalls.Load() != 0 || readCalls.Load() != 0 {
+		t.Fatalf("tools ran before approval: shell=%d read=%d", shellCalls.Load(), readCalls.Load())
+	}
+	if _, err := engine.ApproveToolCalls(t.Context(), session.ID.String(), []string{"call-unknown"}); appErrorCode(err) != application.CodeValidation {
+		t.Fatalf("approve unknown call error = %v, want validation", err)
+	}
+
+	result, err := engine.ApproveToolCalls(t.Context(), session.ID.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Decided) != 1 || result.Decided[0] != "call-shell" || len(result.Pending) != 0 {
+		t.Errorf("approval result = %+v", result)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	if shellCalls.Load() != 1 || readCalls.Load() != 1 {
+		t.Errorf("tool calls after approval: shell=%d read=%d", shellCalls.Load(), readCalls.Load())
+	}
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Rounds[0].Status != conversation.RoundCompleted {
+		t.Fatalf("round = %+v", loaded.Rounds[0])
+	}
+	if _, err := engine.ApproveToolCalls(t.Context(), session.ID.String(), nil); appErrorCode(err) != application.CodeNotFound {
+		t.Errorf("approve after round error = %v, want not found", err)
+	}
+}
+
+func TestEngineReturnsRejectionReasonAndDeniedToolsToModel(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	writeCalls := registerCountingTool(t, fixture, "write_file")
+	deleteCalls := registerCountingTool(t, fixture, "delete_file")
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(
+			conversation.ToolUseBlock{ID: "call-write", Name: "write_file", Input: []byte(`{}`)},
+			conversation.ToolUseBlock{ID: "call-delete", Name: "delete_file", Input: []byte(`{}`)},
+		),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	events := make(chan agentloop.SessionEvent, 4)
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return caller, nil
+		},
+		Events: approvalEvents(events),
+		ToolPolicies: map[string]agent.ToolPolicy{
+			"write_file":  agent.ToolPolicyAsk,
+			"delete_file": agent.ToolPolicyDeny,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = engine.Shutdown(shutdownCtx)
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("approval was not requested")
+	}
+	if _, err := engine.RejectToolCalls(t.Context(), session.ID.String(), []string{"call-write"}, "wrong file"); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	if writeCalls.Load() != 0 || deleteCalls.Load() != 0 {
+		t.Fatalf("blocked tools ran: write=%d delete=%d", writeCalls.Load(), deleteCalls.Load())
+	}
+	requests := caller.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(requests))
+	}
+	results := toolResultBlocks(requests[1].Messages[len(requests[1].Messages)-1].Content)
+	if len(results) != 2 {
+		t.Fatalf("tool results = %+v", results)
+	}
+	rejected, ok := results[0].Content[0].(conversation.TextBlock)
+	if results[0].ToolUseID != "call-write" || !results[0].IsError || !ok || !strings.Contains(rejected.Text, "wrong file") {
+		t.Errorf("rejected result = %+v", results[0])
+	}
+	denied, ok := results[1].Content[0].(conversation.TextBlock)
+	if results[1].ToolUseID != "call-delete" || !results[1].IsError || !ok || !strings.Contains(denied.Text, "denied") {
+		t.Errorf("denied result = %+v", results[1])
+	}
+}
+
+func TestEngineStopWhileAwaitingApprovalClosesPendingCalls(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	shellCalls := registerCountingTool(t, fixture, "shell")
+	setAgentToolPolicies(t, fixture, map[string]agent.ToolPolicy{"shell": agent.ToolPolicyAsk})
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-shell", Name: "shell", Input: []byte(`{}`)}),
+	}}
+	events := make(chan agentloop.SessionEvent, 4)
+	engine := fixture.newEngineWithEvents(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	}, approvalEvents(events))
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("approval was not requested")
+	}
+	if _, err := engine.Stop(t.Context(), session.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	if shellCalls.Load() != 0 {
+		t.Fatalf("shell ran after stop: %d", shellCalls.Load())
+	}
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	round := loaded.Rounds[0]
+	if round.Status != conversation.RoundCancelled {
+		t.Fatalf("round = %+v", round)
+	}
+	results := toolResultBlocks(round.Messages[len(round.Messages)-1].Content)
+	if len(results) != 1 || results[0].ToolUseID != "call-shell" || !results[0].IsError {
+		t.Errorf("closing tool results = %+v", results)
+	}
+	if _, err := engine.ApproveToolCalls(t.Context(), session.ID.String(), nil); appErrorCode(err) != application.CodeNotFound {
+		t.Errorf("approve after stop error = %v, want not found", err)
+	}
+}
+
+func TestEngineLimitsToolsToTheAgentToolAccess(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	shellCalls := registerCountingTool(t, fixture, "shell")
+	readCalls := registerCountingTool(t, fixture, "read_file")
+	definition, err := fixture.agents.Get(t.Context(), "coder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition.Tools = agent.ToolAccess{Allow: []string{"read_*"}}
+	if err := fixture.agents.Save(t.Context(), definition); err != nil {
+		t.Fatal(err)
+	}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(
+			conversation.ToolUseBlock{ID: "call-shell", Name: "shell", Input: []byte(`{}`)},
+			conversation.ToolUseBlock{ID: "call-read", Name: "read_file", Input: []byte(`{}`)},
+		),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	tools := caller.requests[0].Tools
+	if len(tools) != 1 || tools[0].Name != "read_file" {
+		t.Errorf("request tools = %+v, want only read_file", tools)
+	}
+	if shellCalls.Load() != 0 || readCalls.Load() != 1 {
+		t.Errorf("tool calls: shell=%d read=%d", shellCalls.Load(), readCalls.Load())
+	}
+	messages := caller.requests[1].Messages
+	results := toolResultBlocks(messages[len(messages)-1].Content)
+	if len(results) != 2 {
+		t.Fatalf("tool results = %+v", results)
+	}
+	unavailable, ok := results[0].Content[0].(conversation.TextBlock)
+	if results[0].ToolUseID != "call-shell" || !results[0].IsError || !ok || !strings.Contains(unavailable.Text, "not available") {
+		t.Errorf("shell result = %+v", results[0])
+	}
+	if results[1].IsError {
+		t.Errorf("read result = %+v", results[1])
+	}
+}
+
+func TestEnginePlanModeRefusesMutatingTools(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	shellCalls := registerCountingTool(t, fixture, "shell")
+	registerCountingTool(t, fixture, "read_file")
+	registerCountingTool(t, fixture, "process_kill")
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-shell", Name: "shell", Input: []byte(`{}`)}),
+		{Content: conversation.Text("the plan"), StopReason: agentloop.StopReasonEndTurn},
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-build", Name: "shell", Input: []byte(`{}`)}),
+		{Content: conversation.Text("built"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+	session.SetMode(conversation.ModePlan)
+	if err := fixture.sessions.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("plan it")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	planning := caller.requests[0]
+	for _, tool := range planning.Tools {
+		if tool.Name == "shell" || tool.Name == "process_kill" {
+			t.Errorf("plan mode request offers %s: %+v", tool.Name, planning.Tools)
+		}
+	}
+	if metadata := messageText(planning.Messages[0]); !strings.Contains(metadata, "<mode>plan</mode>") {
+		t.Errorf("plan metadata = %q", metadata)
+	}
+	messages := caller.requests[1].Messages
+	results := toolResultBlocks(messages[len(messages)-1].Content)
+	if len(results) != 1 || !results[0].IsError || shellCalls.Load() != 0 {
+		t.Fatalf("plan mode shell results = %+v, calls = %d", results, shellCalls.Load())
+	}
+	if refused, _ := results[0].Content[0].(conversation.TextBlock); !strings.Contains(refused.Text, "plan mode") {
+		t.Errorf("plan mode result = %q", refused.Text)
+	}
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.SetMode(conversation.ModeBuild)
+	if err := fixture.sessions.Save(t.Context(), loaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("go ahead")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	if shellCalls.Load() != 1 {
+		t.Errorf("build mode shell calls = %d, want 1", shellCalls.Load())
+	}
+	building := caller.requests[2].Messages
+	if metadata := messageText(building[len(building)-2]); !strings.Contains(metadata, "<mode>build</mode>") {
+		t.Errorf("build metadata = %q", metadata)
+	}
+}
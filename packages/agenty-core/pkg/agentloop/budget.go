@@ -0,0 +1,95 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+// defaultMaxIterations applies when an agent does not set its own iteration
+// budget.
+const defaultMaxIterations = 20
+
+// budgetExceededError ends a round with conversation.RoundBudgetExceeded
+// instead of a generic failure.
+type budgetExceededError struct {
+	limit   conversation.BudgetLimit
+	message string
+}
+
+func (err *budgetExceededError) Error() string {
+	return err.message
+}
+
+// roundBudget enforces an agent's execution budget across one round.
+type roundBudget struct {
+	agent.Budget
+	toolCalls int
+}
+
+func newRoundBudget(definition *agent.Agent) *roundBudget {
+	budget := &roundBudget{}
+	if definition != nil {
+		budget.Budget = definition.Budget
+	}
+	if budget.MaxIterations <= 0 {
+		budget.MaxIterations = defaultMaxIterations
+	}
+	return budget
+}
+
+// context derives the loop context that carries the wall-clock budget. When it
+// expires, the cause is the budget error rather than a bare deadline.
+func (budget *roundBudget) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	duration := budget.MaxDuration()
+	if duration <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, duration, &budgetExceededError{
+		limit:   conversation.BudgetLimitDuration,
+		message: fmt.Sprintf("agent round exceeded its time budget of %s", duration),
+	})
+}
+
+// expired reports the duration budget error once loopCtx has run out while the
+// round itself is still live.
+func (budget *roundBudget) expired(ctx context.Context, loopCtx context.Context) *budgetExceededError {
+	if ctx.Err() != nil || loopCtx.Err() == nil {
+		return nil
+	}
+	var exceeded *budgetExceededError
+	if errors.As(context.Cause(loopCtx), &exceeded) {
+		return exceeded
+	}
+	return nil
+}
+
+// spend checks the token and tool call budgets before a batch of tool calls
+// runs, and counts the batch when it fits.
+func (budget *roundBudget) spend(usage conversation.TokenUsage, calls int) *budgetExceededError {
+	if budget.MaxTotalTokens > 0 && usage.Total >= budget.MaxTotalTokens {
+		return &budgetExceededError{
+			limit: conversation.BudgetLimitTokens,
+			message: fmt.Sprintf("agent round used %d tokens, reaching its budget of %d",
+				usage.Total, budget.MaxTotalTokens),
+		}
+	}
+	if budget.MaxToolCalls > 0 && budget.toolCalls+calls > budget.MaxToolCalls {
+		return &budgetExceededError{
+			limit:   conversation.BudgetLimitToolCalls,
+			message: fmt.Sprintf("agent round would exceed its budget of %d tool calls", budget.MaxToolCalls),
+		}
+	}
+	budget.toolCalls += calls
+	return nil
+}
+
+func (budget *roundBudget) iterationsExceeded() *budgetExceededError {
+	return &budgetExceededError{
+		limit:   conversation.BudgetLimitIterations,
+		message: fmt.Sprintf("agent loop exceeded %d iterations", budget.MaxIterations),
+	}
+}
@@ -0,0 +1,162 @@
+package agentloop_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func setAgentBudget(t *testing.T, fixture *executionFixture, budget agent.Budget) {
+	t.Helper()
+
+	definition, err := fixture.agents.Get(t.Context(), "coder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition.Budget = budget
+	if err := fixture.agents.Save(t.Context(), definition); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func roundEndedEvents(events chan agentloop.SessionEvent) func(context.Context, agentloop.SessionEvent) error {
+	return func(_ context.Context, event agentloop.SessionEvent) error {
+		if event.Type == agentloop.SessionEventRoundEnded {
+			events <- event
+		}
+		return nil
+	}
+}
+
+func TestEngineEndsRoundWhenAgentBudgetIsExhausted(t *testing.T) {
+	t.Parallel()
+
+	lookup := func(id string) *agentloop.Response {
+		response := toolUseResponse(conversation.ToolUseBlock{ID: id, Name: "lookup", Input: []byte(`{}`)})
+		response.Usage = conversation.TokenUsage{Input: 40, Output: 10, Total: 50}
+		return response
+	}
+	tests := []struct {
+		name         string
+		budget       agent.Budget
+		responses    []*agentloop.Response
+		wantLimit    conversation.BudgetLimit
+		wantRequests int
+		wantExecuted int32
+	}{
+		{
+			name:         "iterations",
+			budget:       agent.Budget{MaxIterations: 2},
+			responses:    []*agentloop.Response{lookup("call-1"), lookup("call-2"), lookup("call-3")},
+			wantLimit:    conversation.BudgetLimitIterations,
+			wantRequests: 2,
+			wantExecuted: 2,
+		},
+		{
+			name:         "tokens",
+			budget:       agent.Budget{MaxTotalTokens: 100},
+			responses:    []*agentloop.Response{lookup("call-1"), lookup("call-2"), lookup("call-3")},
+			wantLimit:    conversation.BudgetLimitTokens,
+			wantRequests: 2,
+			wantExecuted: 1,
+		},
+		{
+			name:         "tool calls",
+			budget:       agent.Budget{MaxToolCalls: 1},
+			responses:    []*agentloop.Response{lookup("call-1"), lookup("call-2"), lookup("call-3")},
+			wantLimit:    conversation.BudgetLimitToolCalls,
+			wantRequests: 2,
+			wantExecuted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := newExecutionFixture(t, 8_192)
+			executed := registerCountingTool(t, fixture, "lookup")
+			setAgentBudget(t, fixture, tt.budget)
+			caller := &scriptedCaller{responses: tt.responses}
+			events := make(chan agentloop.SessionEvent, 1)
+			engine := fixture.newEngineWithEvents(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+				return caller, nil
+			}, roundEndedEvents(events))
+			session := fixture.createSession(t)
+
+			if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+				t.Fatal(err)
+			}
+			waitForExecution(t, engine, session.ID)
+
+			if got := len(caller.Requests()); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if got := executed.Load(); got != tt.wantExecuted {
+				t.Errorf("executed tool calls = %d, want %d", got, tt.wantExecuted)
+			}
+			loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			round := loaded.Rounds[0]
+			if round.Status != conversation.RoundBudgetExceeded || round.BudgetLimit != tt.wantLimit || round.Error == nil {
+				t.Fatalf("round = %+v", round)
+			}
+			last := round.Messages[len(round.Messages)-1]
+			if results := toolResultBlocks(last.Content); len(results) != 1 {
+				t.Errorf("last message tool results = %+v", results)
+			}
+
+			select {
+			case event := <-events:
+				if event.Status != conversation.RoundBudgetExceeded || event.BudgetLimit != tt.wantLimit {
+					t.Errorf("round_ended event = %+v", event)
+				}
+			default:
+				t.Error("round_ended event was not emitted")
+			}
+		})
+	}
+}
+
+func TestEngineEndsRoundWhenDurationBudgetExpires(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	setAgentBudget(t, fixture, agent.Budget{MaxDurationSeconds: 1})
+	caller := &scriptedCaller{
+		responses: []*agentloop.Response{{Content: conversation.Text("late"), StopReason: agentloop.StopReasonEndTurn}},
+		release:   make(chan struct{}),
+	}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		round := loaded.Rounds[0]
+		if round.Status.Terminal() {
+			if round.Status != conversation.RoundBudgetExceeded || round.BudgetLimit != conversation.BudgetLimitDuration {
+				t.Fatalf("round = %+v", round)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("round did not end after its duration budget")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -64,6 +64,27 @@ func (tool *applyPatchTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+// Effects covers the paths of a single operation. Patch envelopes are not
+// parsed here, so they may touch anything.
+func (tool *applyPatchTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	effects := agentloop.ToolEffects{Concurrency: agentloop.ToolMutating}
+	var arguments applyPatchArguments
+	if decodeArguments(input, &arguments) != nil || arguments.Operation == nil {
+		return effects
+	}
+	for _, path := range []string{arguments.Operation.Path, arguments.Operation.MoveTo} {
+		if path == "" {
+			continue
+		}
+		resolved, err := resolvePath(path, callContext.Cwd, false)
+		if err != nil {
+			return agentloop.ToolEffects{Concurrency: agentloop.ToolMutating}
+		}
+		effects.Resources = append(effects.Resources, resolved)
+	}
+	return effects
+}
+
 func (tool *applyPatchTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -90,6 +111,28 @@ func (tool *applyPatchTool) Execute(
 		}
 		operations = parsed
 	}
+	// Check every path first, so a patch reaching outside the workspace
+	// changes nothing.
+	for index, operation := range operations {
+		// Content is written through symlinks, but a deleted or moved entry is
+		// judged by itself rather than its target.
+		type pathCheck struct {
+			path   string
+			access pathAccess
+		}
+		checks := []pathCheck{{operation.Path, accessWrite}}
+		if operation.Type == conversation.ApplyPatchDeleteFile {
+			checks = []pathCheck{{operation.Path, accessRemove}}
+		}
+		if operation.MoveTo != "" {
+			checks = append(checks, pathCheck{operation.Path, accessRemove}, pathCheck{operation.MoveTo, accessWrite})
+		}
+		for _, check := range checks {
+			if _, err := tool.fileSystem.resolve(check.path, callContext, check.access, false); err != nil {
+				return nil, fmt.Errorf("apply_patch: operation %d %s %q: %w", index+1, operation.Type, check.path, err)
+			}
+		}
+	}
 
 	tool.fileSystem.mu.Lock()
 	defer tool.fileSystem.mu.Unlock()
@@ -21,7 +21,8 @@ const (
 )
 
 type fileSystem struct {
-	mu sync.RWMutex
+	mu        sync.RWMutex
+	workspace WorkspaceOptions
 }
 
 func decodeArguments(input []byte, target any) error {
@@ -68,6 +69,29 @@ func resolvePath(path, cwd string, allowEmpty bool) (string, error) {
 	return filepath.Clean(resolved), nil
 }
 
+// pathEffects declares a call on the path argument of input, resolved as the
+// call will resolve it. Calls without a usable path may touch anything.
+func pathEffects(
+	concurrency agentloop.ToolConcurrency,
+	callContext agentloop.CallContext,
+	input []byte,
+	allowEmpty bool,
+) agentloop.ToolEffects {
+	effects := agentloop.ToolEffects{Concurrency: concurrency}
+	var arguments struct {
+		Path string `json:"path"`
+	}
+	if decodeArguments(input, &arguments) != nil {
+		return effects
+	}
+	path, err := resolvePath(arguments.Path, callContext.Cwd, allowEmpty)
+	if err != nil {
+		return effects
+	}
+	effects.Resources = []string{path}
+	return effects
+}
+
 func regularFileInfo(path string) (os.FileInfo, error) {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -49,6 +49,10 @@ func (tool *readFileTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+func (tool *readFileTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	return pathEffects(agentloop.ToolReadOnly, callContext, input, false)
+}
+
 func (tool *readFileTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -77,7 +81,7 @@ func (tool *readFileTool) Execute(
 		return nil, fmt.Errorf("read_file: start_line must not exceed end_line")
 	}
 
-	path, err := resolvePath(arguments.Path, callContext.Cwd, false)
+	path, err := tool.fileSystem.resolve(arguments.Path, callContext, accessRead, false)
 	if err != nil {
 		return nil, fmt.Errorf("read_file: %w", err)
 	}
@@ -228,6 +232,10 @@ func (tool *writeFileTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+func (tool *writeFileTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	return pathEffects(agentloop.ToolMutating, callContext, input, false)
+}
+
 func (tool *writeFileTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -244,7 +252,7 @@ func (tool *writeFileTool) Execute(
 		return nil, err
 	}
 
-	path, err := resolvePath(arguments.Path, callContext.Cwd, false)
+	path, err := tool.fileSystem.resolve(arguments.Path, callContext, accessWrite, false)
 	if err != nil {
 		return nil, fmt.Errorf("write_file: %w", err)
 	}
@@ -328,6 +336,10 @@ func (tool *patchFileTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+func (tool *patchFileTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	return pathEffects(agentloop.ToolMutating, callContext, input, false)
+}
+
 func (tool *patchFileTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -348,7 +360,7 @@ func (tool *patchFileTool) Execute(
 		return nil, err
 	}
 
-	path, err := resolvePath(arguments.Path, callContext.Cwd, false)
+	path, err := tool.fileSystem.resolve(arguments.Path, callContext, accessWrite, false)
 	if err != nil {
 		return nil, fmt.Errorf("patch_file: %w", err)
 	}
@@ -422,6 +434,10 @@ func (tool *deleteFileTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+func (tool *deleteFileTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	return pathEffects(agentloop.ToolMutating, callContext, input, false)
+}
+
 func (tool *deleteFileTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -435,7 +451,7 @@ func (tool *deleteFileTool) Execute(
 		return nil, err
 	}
 
-	path, err := resolvePath(arguments.Path, callContext.Cwd, false)
+	path, err := tool.fileSystem.resolve(arguments.Path, callContext, accessRemove, false)
 	if err != nil {
 		return nil, fmt.Errorf("delete_file: %w", err)
 	}
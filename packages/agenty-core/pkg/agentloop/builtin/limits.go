@@ -0,0 +1,156 @@
+package builtin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/utils/sandbox"
+)
+
+const shellOutcomeResourceLimit = "resource_limit"
+
+// LimitOptions caps the resources of shell commands and background processes.
+// Zero leaves a limit unset. The rlimits need a Unix system; elsewhere limited
+// commands fail.
+type LimitOptions struct {
+	CPUSeconds        uint64
+	AddressSpaceBytes uint64
+	OpenFiles         uint64
+	// Processes caps the processes of the user, counting those the command
+	// did not start.
+	Processes uint64
+	// Cgroups, when set, puts the commands of each session in a cgroup that
+	// caps what they use together. Processes removes the group of a deleted
+	// session and every group on Close.
+	Cgroups *sandbox.Cgroups
+}
+
+// memoryExhaustedMessages are the errors programs print when an allocation
+// fails.
+var memoryExhaustedMessages = []string{
+	"Cannot allocate memory",
+	"MemoryError",
+	"out of memory",
+	"std::bad_alloc",
+}
+
+func (limits LimitOptions) rlimits() sandbox.Limits {
+	return sandbox.Limits{
+		CPUSeconds:        limits.CPUSeconds,
+		AddressSpaceBytes: limits.AddressSpaceBytes,
+		OpenFiles:         limits.OpenFiles,
+		Processes:         limits.Processes,
+	}
+}
+
+// limitShellProcess makes process run under limits and in the cgroup of
+// sessionID, if any. It returns the cgroup events so far, for
+// markResourceLimit to tell which the command caused.
+func limitShellProcess(process *exec.Cmd, limits LimitOptions, sessionID uuid.UUID) (sandbox.CgroupEvents, error) {
+	if err := sandbox.SetLimits(process, limits.rlimits()); err != nil {
+		return sandbox.CgroupEvents{}, err
+	}
+	return cgroupEvents(limits, sessionID), limits.joinCgroup(process, sessionID)
+}
+
+func (limits LimitOptions) joinCgroup(process *exec.Cmd, sessionID uuid.UUID) error {
+	if limits.Cgroups == nil {
+		return nil
+	}
+	return limits.Cgroups.Join(process, sessionID.String())
+}
+
+func cgroupEvents(limits LimitOptions, sessionID uuid.UUID) sandbox.CgroupEvents {
+	if limits.Cgroups == nil {
+		return sandbox.CgroupEvents{}
+	}
+	return limits.Cgroups.Events(sessionID.String())
+}
+
+// markResourceLimit turns the outcome of a command that hit a limit into a
+// resource limit and explains it on stderr. The cgroup events tell it best;
+// the output only counts when the command failed. state is nil for commands
+// of a persistent shell, and before holds the cgroup events from before the
+// command.
+func markResourceLimit(
+	output *conversation.ShellCommandOutput,
+	state *os.ProcessState,
+	limits LimitOptions,
+	sessionID uuid.UUID,
+	before sandbox.CgroupEvents,
+) {
+	if output.Outcome.Type != "exit" {
+		return
+	}
+	text := ""
+	if failed(output.Outcome) {
+		text = output.Stdout + output.Stderr
+	}
+	var reasons []string
+	if limits.CPUSeconds > 0 && exceededCPULimit(state, output.Outcome.ExitCode) {
+		reasons = append(reasons, fmt.Sprintf("limit: CPU time is capped at %d s", limits.CPUSeconds))
+	}
+	if limits.AddressSpaceBytes > 0 && containsAny(text, memoryExhaustedMessages) {
+		reasons = append(reasons, "limit: address space is capped at "+formatBytes(limits.AddressSpaceBytes))
+	}
+	if limits.OpenFiles > 0 && strings.Contains(text, "Too many open files") {
+		reasons = append(reasons, fmt.Sprintf("limit: open files are capped at %d", limits.OpenFiles))
+	}
+	after := cgroupEvents(limits, sessionID)
+	if (limits.Processes > 0 && strings.Contains(text, "Resource temporarily unavailable")) ||
+		after.ProcessLimitDenied > before.ProcessLimitDenied {
+		reasons = append(reasons, "limit: the command could not start more processes")
+	}
+	if after.OOMKills > before.OOMKills {
+		reasons = append(reasons, "limit: the session ran out of memory and a process was killed")
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	if output.Stderr != "" && !strings.HasSuffix(output.Stderr, "\n") {
+		output.Stderr += "\n"
+	}
+	output.Stderr += strings.Join(reasons, "\n")
+	output.Outcome.Type = shellOutcomeResourceLimit
+}
+
+// shellUsage returns what the exited process consumed.
+func shellUsage(state *os.ProcessState) *conversation.ShellUsage {
+	if state == nil {
+		return nil
+	}
+	return &conversation.ShellUsage{
+		PeakRSSBytes: peakRSS(state),
+		CPUTimeMs:    (state.UserTime() + state.SystemTime()).Milliseconds(),
+	}
+}
+
+// limitsDescription tells the model the limits of each command.
+func limitsDescription(limits LimitOptions) string {
+	var caps []string
+	if limits.CPUSeconds > 0 {
+		caps = append(caps, fmt.Sprintf("%d s of CPU time", limits.CPUSeconds))
+	}
+	if limits.AddressSpaceBytes > 0 {
+		caps = append(caps, formatBytes(limits.AddressSpaceBytes)+" of address space")
+	}
+	if limits.OpenFiles > 0 {
+		caps = append(caps, fmt.Sprintf("%d open files", limits.OpenFiles))
+	}
+	if len(caps) == 0 {
+		return ""
+	}
+	return " Each command may use at most " + strings.Join(caps, ", ") + "."
+}
+
+func formatBytes(value uint64) string {
+	if value >= 1<<20 && value%(1<<20) == 0 {
+		return fmt.Sprintf("%d MiB", value>>20)
+	}
+	return fmt.Sprintf("%d bytes", value)
+}
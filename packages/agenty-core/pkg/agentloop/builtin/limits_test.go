@@ -0,0 +1,56 @@
+package builtin_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func TestShellReportsResourceLimitsAndUsage(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("rlimits need a Unix system")
+	}
+
+	registry := agentloop.NewRegistry()
+	processes := builtin.NewProcesses()
+	t.Cleanup(processes.Close)
+	options := builtin.Options{Shell: builtin.ShellOptions{
+		Limits: builtin.LimitOptions{CPUSeconds: 1, OpenFiles: 32},
+	}}
+	if err := builtin.RegisterAll(registry, processes, options); err != nil {
+		t.Fatal(err)
+	}
+	tool, _ := registry.Get("shell")
+	if !strings.Contains(tool.Definition().Description, "1 s of CPU time, 32 open files") {
+		t.Errorf("description = %q, want the limits explained", tool.Definition().Description)
+	}
+
+	input := `{"commands":["ulimit -n","while :; do :; done","echo 'Too many open files'"],"timeout_ms":20000}`
+	content, err := tool.Execute(t.Context(), agentloop.CallContext{Cwd: t.TempDir()}, []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := content[0].(conversation.ShellCallOutputBlock).Output
+
+	if strings.TrimSpace(output[0].Stdout) != "32" || output[0].Outcome.Type != "exit" {
+		t.Errorf("limited command = %+v, want 32 open files", output[0])
+	}
+	if output[0].Usage == nil {
+		t.Errorf("limited command has no usage")
+	}
+	if output[1].Outcome.Type != "resource_limit" || output[1].Outcome.ExitCode == nil ||
+		!strings.Contains(output[1].Stderr, "limit: CPU time is capped at 1 s") {
+		t.Errorf("busy loop = %+v, want a resource limit", output[1])
+	}
+	if usage := output[1].Usage; usage == nil || usage.CPUTimeMs < 900 {
+		t.Errorf("busy loop usage = %+v, want about a second of CPU time", usage)
+	}
+	if output[2].Outcome.Type != "exit" || strings.Contains(output[2].Stderr, "limit:") {
+		t.Errorf("successful command quoting a limit error = %+v, want a plain exit", output[2])
+	}
+}
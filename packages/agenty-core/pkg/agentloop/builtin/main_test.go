@@ -0,0 +1,14 @@
+package builtin_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/masteryyh/agenty-core/pkg/utils/sandbox"
+)
+
+func TestMain(m *testing.M) {
+	// Sandboxed commands start as a copy of the test binary.
+	sandbox.RunHelper()
+	os.Exit(m.Run())
+}
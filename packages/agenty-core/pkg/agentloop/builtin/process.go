@@ -0,0 +1,448 @@
+package builtin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/utils/sandbox"
+)
+
+const (
+	// maxProcessOutputBytes is how much output each process keeps for reads;
+	// older output is dropped.
+	maxProcessOutputBytes   = 1 << 20
+	maxProcessesPerSession  = 16
+	defaultProcessReadBytes = 16 << 10
+	maxProcessReadBytes     = 256 << 10
+	maxProcessReadWaitMs    = 30_000
+	processKillTimeout      = 5 * time.Second
+	processStatusRunning    = "running"
+	processStatusExited     = "exited"
+	processHandlePrefix     = "proc_"
+)
+
+// ProcessInfo describes a background process started by the process tools.
+type ProcessInfo struct {
+	ID        string    `json:"id"`
+	SessionID uuid.UUID `json:"sessionId"`
+	Command   string    `json:"command"`
+	Cwd       string    `json:"cwd,omitempty"`
+	PID       int       `json:"pid"`
+	Status    string    `json:"status"`
+	ExitCode  *int      `json:"exitCode,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// Processes tracks the background processes and persistent shells of every
+// session until they are killed, their session is deleted or the engine shuts
+// down.
+type Processes struct {
+	mu        sync.Mutex
+	processes map[string]*backgroundProcess
+	shells    map[uuid.UUID]*persistentShell
+	// cgroups holds the cgroup of each session, if commands are limited.
+	cgroups *sandbox.Cgroups
+	nextID  int
+	closed  bool
+}
+
+var _ agentloop.SessionResources = (*Processes)(nil)
+
+func NewProcesses() *Processes {
+	return &Processes{
+		processes: make(map[string]*backgroundProcess),
+		shells:    make(map[uuid.UUID]*persistentShell),
+	}
+}
+
+type backgroundProcess struct {
+	id        string
+	sessionID uuid.UUID
+	command   string
+	cwd       string
+	startedAt time.Time
+	process   *exec.Cmd
+	stdin     io.WriteCloser
+	cancel    context.CancelFunc
+	output    *processOutput
+	done      chan struct{}
+	// exitCode is written before done closes.
+	exitCode int
+}
+
+func (processes *Processes) start(
+	sessionID uuid.UUID,
+	command string,
+	cwd string,
+	sandbox *SandboxOptions,
+	limits LimitOptions,
+) (*backgroundProcess, error) {
+	processes.mu.Lock()
+	defer processes.mu.Unlock()
+
+	if processes.closed {
+		return nil, fmt.Errorf("background processes are shutting down")
+	}
+	// Only running processes count. Exited ones whose output was read have
+	// nothing left to tell and are forgotten.
+	count := 0
+	for id, process := range processes.processes {
+		if process.sessionID != sessionID {
+			continue
+		}
+		switch {
+		case !process.exited():
+			count++
+		case process.output.drained():
+			delete(processes.processes, id)
+		}
+	}
+	if count >= maxProcessesPerSession {
+		return nil, fmt.Errorf("the session already has %d running background processes; kill one first", count)
+	}
+
+	processCtx, cancel := context.WithCancel(context.Background())
+	process := newShellCommand(processCtx, command)
+	prepareShellProcess(process)
+	if strings.TrimSpace(cwd) != "" {
+		process.Dir = cwd
+	}
+	output := newProcessOutput()
+	process.Stdout = output
+	process.Stderr = output
+	if err := sandboxShellProcess(process, sandbox, cwd); err != nil {
+		cancel()
+		return nil, err
+	}
+	if _, err := limitShellProcess(process, limits, sessionID); err != nil {
+		cancel()
+		return nil, err
+	}
+	stdin, err := process.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("open stdin: %w", err)
+	}
+	if err := process.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("start process: %w", err)
+	}
+
+	processes.nextID++
+	background := &backgroundProcess{
+		id:        processHandlePrefix + strconv.Itoa(processes.nextID),
+		sessionID: sessionID,
+		command:   command,
+		cwd:       cwd,
+		startedAt: time.Now().UTC(),
+		process:   process,
+		stdin:     stdin,
+		cancel:    cancel,
+		output:    output,
+		done:      make(chan struct{}),
+	}
+	go background.wait()
+	processes.processes[background.id] = background
+	return background, nil
+}
+
+func (process *backgroundProcess) wait() {
+	_ = process.process.Wait()
+	process.exitCode = -1
+	if state := process.process.ProcessState; state != nil {
+		process.exitCode = state.ExitCode()
+	}
+	process.cancel()
+	process.output.close()
+	close(process.done)
+}
+
+// get returns the process with the given handle if it belongs to sessionID.
+func (processes *Processes) get(sessionID uuid.UUID, id string) (*backgroundProcess, error) {
+	processes.mu.Lock()
+	defer processes.mu.Unlock()
+
+	process, ok := processes.processes[id]
+	if !ok || process.sessionID != sessionID {
+		return nil, fmt.Errorf("background process %q not found", id)
+	}
+	return process, nil
+}
+
+// kill stops the process with the given handle and forgets it.
+func (processes *Processes) kill(sessionID uuid.UUID, id string) (*backgroundProcess, error) {
+	processes.mu.Lock()
+	process, ok := processes.processes[id]
+	if ok && process.sessionID == sessionID {
+		delete(processes.processes, id)
+	}
+	processes.mu.Unlock()
+	if !ok || process.sessionID != sessionID {
+		return nil, fmt.Errorf("background process %q not found", id)
+	}
+
+	process.stop()
+	return process, nil
+}
+
+// List returns the background processes of sessionID, or of every session
+// when it is uuid.Nil, oldest first.
+func (processes *Processes) List(sessionID uuid.UUID) []ProcessInfo {
+	processes.mu.Lock()
+	infos := make([]ProcessInfo, 0, len(processes.processes))
+	for _, process := range processes.processes {
+		if sessionID == uuid.Nil || process.sessionID == sessionID {
+			infos = append(infos, process.info())
+		}
+	}
+	processes.mu.Unlock()
+
+	slices.SortFunc(infos, func(a, b ProcessInfo) int {
+		if compared := a.StartedAt.Compare(b.StartedAt); compared != 0 {
+			return compared
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return infos
+}
+
+// shell returns the persistent shell of sessionID, starting one in cwd and
+// under limits when the session has none or its last one exited.
+func (processes *Processes) shell(sessionID uuid.UUID, cwd string, limits LimitOptions) (*persistentShell, error) {
+	processes.mu.Lock()
+	if processes.closed {
+		processes.mu.Unlock()
+		return nil, fmt.Errorf("persistent shells are shutting down")
+	}
+	previous := processes.shells[sessionID]
+	if previous != nil && previous.usable() {
+		processes.mu.Unlock()
+		return previous, nil
+	}
+	delete(processes.shells, sessionID)
+	processes.mu.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+
+	shell, err := startPersistentShell(sessionID, cwd, limits)
+	if err != nil {
+		return nil, err
+	}
+	processes.mu.Lock()
+	defer processes.mu.Unlock()
+	if processes.closed {
+		go shell.close()
+		return nil, fmt.Errorf("persistent shells are shutting down")
+	}
+	if existing := processes.shells[sessionID]; existing != nil && existing.usable() {
+		go shell.close()
+		return existing, nil
+	}
+	processes.shells[sessionID] = shell
+	return shell, nil
+}
+
+// ReleaseSession kills the background processes and persistent shell of a
+// deleted session and removes its cgroup.
+func (processes *Processes) ReleaseSession(sessionID uuid.UUID) {
+	processes.mu.Lock()
+	var released []*backgroundProcess
+	for id, process := range processes.processes {
+		if process.sessionID == sessionID {
+			released = append(released, process)
+			delete(processes.processes, id)
+		}
+	}
+	var shells []*persistentShell
+	if shell, ok := processes.shells[sessionID]; ok {
+		shells = append(shells, shell)
+		delete(processes.shells, sessionID)
+	}
+	cgroups := processes.cgroups
+	processes.mu.Unlock()
+
+	stopAll(released, shells)
+	if cgroups != nil {
+		cgroups.Remove(sessionID.String())
+	}
+}
+
+// Close kills every background process and persistent shell, removes the
+// cgroups and refuses new ones.
+func (processes *Processes) Close() {
+	processes.mu.Lock()
+	processes.closed = true
+	released := make([]*backgroundProcess, 0, len(processes.processes))
+	for id, process := range processes.processes {
+		released = append(released, process)
+		delete(processes.processes, id)
+	}
+	shells := make([]*persistentShell, 0, len(processes.shells))
+	for sessionID, shell := range processes.shells {
+		shells = append(shells, shell)
+		delete(processes.shells, sessionID)
+	}
+	cgroups := processes.cgroups
+	processes.mu.Unlock()
+
+	stopAll(released, shells)
+	if cgroups != nil {
+		cgroups.Close()
+	}
+}
+
+func stopAll(processes []*backgroundProcess, shells []*persistentShell) {
+	var waitGroup sync.WaitGroup
+	for _, process := range processes {
+		waitGroup.Go(process.stop)
+	}
+	for _, shell := range shells {
+		waitGroup.Go(shell.close)
+	}
+	waitGroup.Wait()
+}
+
+func (process *backgroundProcess) exited() bool {
+	select {
+	case <-process.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop kills the process tree and waits until it is reaped.
+func (process *backgroundProcess) stop() {
+	process.cancel()
+	select {
+	case <-process.done:
+	case <-time.After(processKillTimeout):
+	}
+}
+
+func (process *backgroundProcess) info() ProcessInfo {
+	info := ProcessInfo{
+		ID:        process.id,
+		SessionID: process.sessionID,
+		Command:   process.command,
+		Cwd:       process.cwd,
+		PID:       process.process.Process.Pid,
+		Status:    processStatusRunning,
+		StartedAt: process.startedAt,
+	}
+	select {
+	case <-process.done:
+		exitCode := process.exitCode
+		info.Status = processStatusExited
+		info.ExitCode = &exitCode
+	default:
+	}
+	return info
+}
+
+// processOutput keeps the latest combined stdout and stderr of a process and
+// how far the agent has read it.
+type processOutput struct {
+	mu sync.Mutex
+	// data holds the retained output, which starts at offset start of
+	// everything the process wrote.
+	data  []byte
+	start int64
+	read  int64
+	// changed is closed and replaced whenever output arrives or the process
+	// exits.
+	changed chan struct{}
+	closed  bool
+}
+
+func newProcessOutput() *processOutput {
+	return &processOutput{changed: make(chan struct{})}
+}
+
+func (output *processOutput) Write(data []byte) (int, error) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	output.data = append(output.data, data...)
+	if overflow := len(output.data) - maxProcessOutputBytes; overflow > 0 {
+		output.data = append([]byte(nil), output.data[overflow:]...)
+		output.start += int64(overflow)
+	}
+	output.notifyLocked()
+	return len(data), nil
+}
+
+func (output *processOutput) close() {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	output.closed = true
+	output.notifyLocked()
+}
+
+// drained reports whether the process exited and all its output was read.
+func (output *processOutput) drained() bool {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	return output.closed && output.read == output.start+int64(len(output.data))
+}
+
+func (output *processOutput) notifyLocked() {
+	close(output.changed)
+	output.changed = make(chan struct{})
+}
+
+// next returns up to limit bytes of unread output, waiting up to wait for
+// some to arrive, and how many unread bytes were dropped before it.
+func (output *processOutput) next(ctx context.Context, limit int, wait time.Duration) (string, int64) {
+	output.mu.Lock()
+	if output.read == output.start+int64(len(output.data)) && !output.closed && wait > 0 {
+		changed := output.changed
+		output.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		output.mu.Lock()
+	}
+	defer output.mu.Unlock()
+
+	var dropped int64
+	if output.read < output.start {
+		dropped = output.start - output.read
+		output.read = output.start
+	}
+	chunk := output.data[output.read-output.start:]
+	limited := len(chunk) > limit
+	if limited {
+		chunk = chunk[:limit]
+	}
+	// Leave a rune split by the limit, or by a process still writing, for
+	// the next read.
+	for end := len(chunk) - 1; end >= 0 && end >= len(chunk)-utf8.UTFMax; end-- {
+		if utf8.RuneStart(chunk[end]) {
+			if !utf8.FullRune(chunk[end:]) && (limited || !output.closed) {
+				chunk = chunk[:end]
+			}
+			break
+		}
+	}
+	output.read += int64(len(chunk))
+	return strings.ToValidUTF8(string(chunk), "�"), dropped
+}
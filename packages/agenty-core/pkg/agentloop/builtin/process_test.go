@@ -0,0 +1,182 @@
+package builtin_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+type processOutput struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	ExitCode     *int   `json:"exitCode"`
+	Output       string `json:"output"`
+	DroppedBytes int64  `json:"droppedBytes"`
+}
+
+func executeProcessTool(
+	t *testing.T,
+	registry *agentloop.Registry,
+	sessionID uuid.UUID,
+	name string,
+	arguments string,
+) string {
+	t.Helper()
+
+	encoded, err := executeToolWithSession(t, registry, sessionID, name, arguments)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return encoded
+}
+
+func TestBackgroundProcessLifecycle(t *testing.T) {
+	t.Parallel()
+
+	registry, processes := newRegistryWithProcesses(t)
+	sessionID := uuid.New()
+	started := decodeResult[struct {
+		ID     string `json:"id"`
+		PID    int    `json:"pid"`
+		Status string `json:"status"`
+	}](t, executeProcessTool(t, registry, sessionID, "process_start",
+		`{"command":"printf 'ready\\n'; read line; printf 'got %s\\n' \"$line\"; exit 3"}`))
+	if started.ID == "" || started.PID == 0 || started.Status != "running" {
+		t.Fatalf("started = %+v", started)
+	}
+
+	read := decodeResult[processOutput](t, executeProcessTool(t, registry, sessionID, "process_read",
+		`{"id":"`+started.ID+`","wait_ms":5000}`))
+	if read.Output != "ready\n" || read.Status != "running" {
+		t.Fatalf("first read = %+v", read)
+	}
+	listed := processes.List(uuid.Nil)
+	if len(listed) != 1 || listed[0].ID != started.ID || listed[0].SessionID != sessionID {
+		t.Fatalf("listed = %+v", listed)
+	}
+	if other := processes.List(uuid.New()); len(other) != 0 {
+		t.Errorf("processes of another session = %+v", other)
+	}
+	if _, err := executeToolWithSession(t, registry, uuid.New(), "process_read", `{"id":"`+started.ID+`"}`); err == nil {
+		t.Error("another session read the process")
+	}
+
+	executeProcessTool(t, registry, sessionID, "process_write", `{"id":"`+started.ID+`","input":"hello\n"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	var output strings.Builder
+	for {
+		read = decodeResult[processOutput](t, executeProcessTool(t, registry, sessionID, "process_read",
+			`{"id":"`+started.ID+`","wait_ms":500}`))
+		output.WriteString(read.Output)
+		if read.Status == "exited" || time.Now().After(deadline) {
+			break
+		}
+	}
+	if output.String() != "got hello\n" || read.ExitCode == nil || *read.ExitCode != 3 {
+		t.Fatalf("output = %q, final read = %+v", output.String(), read)
+	}
+
+	killed := decodeResult[processOutput](t, executeProcessTool(t, registry, sessionID, "process_kill",
+		`{"id":"`+started.ID+`"}`))
+	if killed.Status != "exited" {
+		t.Errorf("killed = %+v", killed)
+	}
+	if listed := processes.List(uuid.Nil); len(listed) != 0 {
+		t.Errorf("processes after kill = %+v", listed)
+	}
+}
+
+func TestBackgroundProcessesAreKilledWithTheirSession(t *testing.T) {
+	t.Parallel()
+
+	registry, processes := newRegistryWithProcesses(t)
+	released := uuid.New()
+	kept := uuid.New()
+	for _, sessionID := range []uuid.UUID{released, kept} {
+		executeProcessTool(t, registry, sessionID, "process_start", `{"command":"sleep 30"}`)
+	}
+
+	started := time.Now()
+	processes.ReleaseSession(released)
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("releasing took %v", elapsed)
+	}
+	listed := processes.List(uuid.Nil)
+	if len(listed) != 1 || listed[0].SessionID != kept || listed[0].Status != "running" {
+		t.Fatalf("processes after release = %+v", listed)
+	}
+
+	processes.Close()
+	if listed := processes.List(uuid.Nil); len(listed) != 0 {
+		t.Errorf("processes after close = %+v", listed)
+	}
+	if _, err := executeToolWithSession(t, registry, kept, "process_start", `{"command":"true"}`); err == nil {
+		t.Error("process_start succeeded after close")
+	}
+}
+
+func TestBackgroundProcessLimitCountsRunningProcesses(t *testing.T) {
+	t.Parallel()
+
+	registry, processes := newRegistryWithProcesses(t)
+	sessionID := uuid.New()
+	executeProcessTool(t, registry, sessionID, "process_start", `{"command":"echo unread"}`)
+	for range 15 {
+		executeProcessTool(t, registry, sessionID, "process_start", `{"command":"true"}`)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for slices.ContainsFunc(processes.List(sessionID), func(info builtin.ProcessInfo) bool {
+		return info.Status == "running"
+	}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("processes did not exit: %+v", processes.List(sessionID))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for range 16 {
+		executeProcessTool(t, registry, sessionID, "process_start", `{"command":"sleep 30"}`)
+	}
+	if _, err := executeToolWithSession(t, registry, sessionID, "process_start", `{"command":"true"}`); err == nil ||
+		!strings.Contains(err.Error(), "16 running background processes") {
+		t.Errorf("start beyond the limit error = %v", err)
+	}
+
+	listed := processes.List(sessionID)
+	exited := slices.DeleteFunc(slices.Clone(listed), func(info builtin.ProcessInfo) bool {
+		return info.Status == "running"
+	})
+	if len(listed) != 17 || len(exited) != 1 || exited[0].Command != "echo unread" {
+		t.Errorf("processes = %+v, want the exited process with unread output kept", listed)
+	}
+}
+
+func executeToolWithSession(
+	t *testing.T,
+	registry *agentloop.Registry,
+	sessionID uuid.UUID,
+	name string,
+	arguments string,
+) (string, error) {
+	t.Helper()
+
+	tool, ok := registry.Get(name)
+	if !ok {
+		t.Fatalf("tool %q is not registered", name)
+	}
+	content, err := tool.Execute(t.Context(), agentloop.CallContext{SessionID: sessionID}, []byte(arguments))
+	if err != nil {
+		return "", err
+	}
+	block, ok := content[0].(conversation.TextBlock)
+	if !ok {
+		t.Fatalf("tool %q block = %T, want conversation.TextBlock", name, content[0])
+	}
+	return block.Text, nil
+}
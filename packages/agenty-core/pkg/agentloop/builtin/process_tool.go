@@ -0,0 +1,287 @@
+package builtin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+type processStartTool struct {
+	processes *Processes
+	sandbox   *SandboxOptions
+	limits    LimitOptions
+}
+
+type processStartArguments struct {
+	Command string `json:"command"`
+	Cwd     string `json:"cwd,omitempty"`
+}
+
+type processStartResult struct {
+	ID     string `json:"id"`
+	PID    int    `json:"pid"`
+	Status string `json:"status"`
+}
+
+func (tool *processStartTool) Definition() agentloop.ToolDefinition {
+	return agentloop.ToolDefinition{
+		Name: "process_start",
+		Description: "Start a long-running command, such as a dev server, watcher or long test suite, in the " +
+			"background and return its handle. Unlike shell it has no time limit. Read its output with " +
+			"process_read, write to its stdin with process_write and stop it with process_kill." +
+			sandboxDescription(tool.sandbox) + limitsDescription(tool.limits),
+		InputSchema: objectSchema(
+			map[string]agentloop.JSONSchema{
+				"command": stringSchema("Command run through the same shell as the shell tool."),
+				"cwd":     stringSchema("Optional working directory, absolute or relative to the session working directory."),
+			},
+			[]string{"command"},
+		),
+	}
+}
+
+// Effects makes process starts run alone, as shell calls do.
+func (tool *processStartTool) Effects(agentloop.CallContext, []byte) agentloop.ToolEffects {
+	return agentloop.ToolEffects{Concurrency: agentloop.ToolExclusive}
+}
+
+func (tool *processStartTool) Execute(
+	ctx context.Context,
+	callContext agentloop.CallContext,
+	input []byte,
+) (conversation.Content, error) {
+	var arguments processStartArguments
+	if err := decodeArguments(input, &arguments); err != nil {
+		return nil, fmt.Errorf("process_start: %w", err)
+	}
+	if strings.TrimSpace(arguments.Command) == "" {
+		return nil, fmt.Errorf("process_start: command must not be empty")
+	}
+	cwd, err := resolvePath(arguments.Cwd, callContext.Cwd, true)
+	if err != nil {
+		return nil, fmt.Errorf("process_start: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	process, err := tool.processes.start(callContext.SessionID, arguments.Command, cwd, tool.sandbox, tool.limits)
+	if err != nil {
+		return nil, fmt.Errorf("process_start: %w", err)
+	}
+	info := process.info()
+	return resultContent(processStartResult{ID: info.ID, PID: info.PID, Status: info.Status})
+}
+
+type processReadTool struct {
+	processes *Processes
+}
+
+type processReadArguments struct {
+	ID       string `json:"id"`
+	MaxBytes *int   `json:"max_bytes,omitempty"`
+	WaitMs   *int   `json:"wait_ms,omitempty"`
+}
+
+type processOutputResult struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Output   string `json:"output"`
+	// DroppedBytes counts unread output discarded because the process wrote
+	// more than is kept.
+	DroppedBytes int64 `json:"droppedBytes,omitempty"`
+}
+
+func (tool *processReadTool) Definition() agentloop.ToolDefinition {
+	return agentloop.ToolDefinition{
+		Name: "process_read",
+		Description: "Read the combined stdout and stderr a background process wrote since the last read, " +
+			"with its status and exit code once it has exited.",
+		InputSchema: objectSchema(
+			map[string]agentloop.JSONSchema{
+				"id":        stringSchema("Handle returned by process_start."),
+				"max_bytes": integerSchema(fmt.Sprintf("Maximum bytes to return, at most %d.", maxProcessReadBytes), 1),
+				"wait_ms": integerSchema(fmt.Sprintf(
+					"Wait up to this many milliseconds, at most %d, for output when none is pending.",
+					maxProcessReadWaitMs,
+				), 0),
+			},
+			[]string{"id"},
+		),
+	}
+}
+
+func (tool *processReadTool) Execute(
+	ctx context.Context,
+	callContext agentloop.CallContext,
+	input []byte,
+) (conversation.Content, error) {
+	var arguments processReadArguments
+	if err := decodeArguments(input, &arguments); err != nil {
+		return nil, fmt.Errorf("process_read: %w", err)
+	}
+	maxBytes := defaultProcessReadBytes
+	if arguments.MaxBytes != nil {
+		maxBytes = *arguments.MaxBytes
+	}
+	if maxBytes < 1 || maxBytes > maxProcessReadBytes {
+		return nil, fmt.Errorf("process_read: max_bytes must be between 1 and %d", maxProcessReadBytes)
+	}
+	waitMs := 0
+	if arguments.WaitMs != nil {
+		waitMs = *arguments.WaitMs
+	}
+	if waitMs < 0 || waitMs > maxProcessReadWaitMs {
+		return nil, fmt.Errorf("process_read: wait_ms must be between 0 and %d", maxProcessReadWaitMs)
+	}
+
+	process, err := tool.processes.get(callContext.SessionID, arguments.ID)
+	if err != nil {
+		return nil, fmt.Errorf("process_read: %w", err)
+	}
+	output, dropped := process.output.next(ctx, maxBytes, time.Duration(waitMs)*time.Millisecond)
+	return resultContent(newProcessOutputResult(process.info(), output, dropped))
+}
+
+type processWriteTool struct {
+	processes *Processes
+}
+
+type processWriteArguments struct {
+	ID         string `json:"id"`
+	Input      string `json:"input"`
+	CloseStdin bool   `json:"close_stdin,omitempty"`
+}
+
+type processWriteResult struct {
+	ID           string `json:"id"`
+	WrittenBytes int    `json:"writtenBytes"`
+	StdinClosed  bool   `json:"stdinClosed"`
+}
+
+func (tool *processWriteTool) Definition() agentloop.ToolDefinition {
+	return agentloop.ToolDefinition{
+		Name:        "process_write",
+		Description: "Write text to the stdin of a background process. Include a trailing newline to submit a line.",
+		InputSchema: objectSchema(
+			map[string]agentloop.JSONSchema{
+				"id":          stringSchema("Handle returned by process_start."),
+				"input":       stringSchema("Text written to stdin as is."),
+				"close_stdin": booleanSchema("Close stdin after writing, signalling end of input."),
+			},
+			[]string{"id", "input"},
+		),
+	}
+}
+
+// Effects makes stdin writes run alone, as the process may do anything with
+// its input.
+func (tool *processWriteTool) Effects(agentloop.CallContext, []byte) agentloop.ToolEffects {
+	return agentloop.ToolEffects{Concurrency: agentloop.ToolExclusive}
+}
+
+func (tool *processWriteTool) Execute(
+	ctx context.Context,
+	callContext agentloop.CallContext,
+	input []byte,
+) (conversation.Content, error) {
+	var arguments processWriteArguments
+	if err := decodeArguments(input, &arguments); err != nil {
+		return nil, fmt.Errorf("process_write: %w", err)
+	}
+	process, err := tool.processes.get(callContext.SessionID, arguments.ID)
+	if err != nil {
+		return nil, fmt.Errorf("process_write: %w", err)
+	}
+
+	// A process that does not read its stdin blocks the write once the pipe
+	// is full, so the write must not outlive the call.
+	type writeResult struct {
+		written int
+		err     error
+	}
+	finished := make(chan writeResult, 1)
+	go func() {
+		written, err := process.stdin.Write([]byte(arguments.Input))
+		finished <- writeResult{written: written, err: err}
+	}()
+	var result writeResult
+	select {
+	case result = <-finished:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if result.err != nil {
+		return nil, fmt.Errorf("process_write: write stdin: %w", result.err)
+	}
+	written := result.written
+	if arguments.CloseStdin {
+		if err := process.stdin.Close(); err != nil {
+			return nil, fmt.Errorf("process_write: close stdin: %w", err)
+		}
+	}
+	return resultContent(processWriteResult{
+		ID:           process.id,
+		WrittenBytes: written,
+		StdinClosed:  arguments.CloseStdin,
+	})
+}
+
+type processKillTool struct {
+	processes *Processes
+}
+
+type processKillArguments struct {
+	ID string `json:"id"`
+}
+
+func (tool *processKillTool) Definition() agentloop.ToolDefinition {
+	return agentloop.ToolDefinition{
+		Name: "process_kill",
+		Description: "Stop a background process and its children, returning its unread output. " +
+			"Also use it to forget a process that already exited.",
+		InputSchema: objectSchema(
+			map[string]agentloop.JSONSchema{
+				"id": stringSchema("Handle returned by process_start."),
+			},
+			[]string{"id"},
+		),
+	}
+}
+
+// Effects makes kills run alone, as they end what other calls may still use.
+func (tool *processKillTool) Effects(agentloop.CallContext, []byte) agentloop.ToolEffects {
+	return agentloop.ToolEffects{Concurrency: agentloop.ToolExclusive}
+}
+
+func (tool *processKillTool) Execute(
+	ctx context.Context,
+	callContext agentloop.CallContext,
+	input []byte,
+) (conversation.Content, error) {
+	var arguments processKillArguments
+	if err := decodeArguments(input, &arguments); err != nil {
+		return nil, fmt.Errorf("process_kill: %w", err)
+	}
+	process, err := tool.processes.kill(callContext.SessionID, arguments.ID)
+	if err != nil {
+		return nil, fmt.Errorf("process_kill: %w", err)
+	}
+	output, dropped := process.output.next(ctx, defaultProcessReadBytes, 0)
+	return resultContent(newProcessOutputResult(process.info(), output, dropped))
+}
+
+func newProcessOutputResult(info ProcessInfo, output string, dropped int64) processOutputResult {
+	return processOutputResult{
+		ID:           info.ID,
+		Status:       info.Status,
+		ExitCode:     info.ExitCode,
+		Output:       output,
+		DroppedBytes: dropped,
+	}
+}
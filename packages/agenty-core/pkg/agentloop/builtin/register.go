@@ -6,14 +6,30 @@ import (
 	"github.com/masteryyh/agenty-core/pkg/agentloop"
 )
 
-func RegisterAll(registry *agentloop.Registry) error {
+// Options configures the builtin tools.
+type Options struct {
+	Shell     ShellOptions
+	Workspace WorkspaceOptions
+}
+
+// RegisterAll registers the builtin tools. The process tools keep their
+// background processes, and the shell tool its persistent shells, in
+// processes.
+func RegisterAll(registry *agentloop.Registry, processes *Processes, options Options) error {
 	if registry == nil {
 		return fmt.Errorf("builtin: registry must not be nil")
 	}
+	if processes == nil {
+		return fmt.Errorf("builtin: processes must not be nil")
+	}
+
+	processes.mu.Lock()
+	processes.cgroups = options.Shell.Limits.Cgroups
+	processes.mu.Unlock()
 
-	fileSystem := &fileSystem{}
+	fileSystem := &fileSystem{workspace: options.Workspace}
 	tools := []agentloop.Tool{
-		&shellTool{},
+		newShellTool(processes, options.Shell),
 		&readFileTool{fileSystem: fileSystem},
 		&writeFileTool{fileSystem: fileSystem},
 		&patchFileTool{fileSystem: fileSystem},
@@ -22,6 +38,11 @@ func RegisterAll(registry *agentloop.Registry) error {
 		&grepTool{fileSystem: fileSystem},
 		&globTool{fileSystem: fileSystem},
 		&listTool{fileSystem: fileSystem},
+		&taskTool{},
+		&processStartTool{processes: processes, sandbox: options.Shell.Sandbox, limits: options.Shell.Limits},
+		&processReadTool{processes: processes},
+		&processWriteTool{processes: processes},
+		&processKillTool{processes: processes},
 	}
 	for _, tool := range tools {
 		if err := registry.Register(tool); err != nil {
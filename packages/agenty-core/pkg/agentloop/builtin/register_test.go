@@ -2,6 +2,8 @@ package builtin_test
 
 import (
 	"context"
+	"path/filepath"
+	"reflect"
 	"regexp"
 	"testing"
 
@@ -16,10 +18,7 @@ func TestRegisterAll(t *testing.T) {
 	t.Parallel()
 	snakeCase := regexp.MustCompile(`^[a-z][a-z0-9]*(?:_[a-z0-9]+)*$`)
 
-	registry := agentloop.NewRegistry()
-	if err := builtin.RegisterAll(registry); err != nil {
-		t.Fatal(err)
-	}
+	registry := newRegistry(t)
 
 	wantNames := []string{
 		"apply_patch",
@@ -28,8 +27,13 @@ func TestRegisterAll(t *testing.T) {
 		"grep",
 		"ls",
 		"patch_file",
+		"process_kill",
+		"process_read",
+		"process_start",
+		"process_write",
 		"read_file",
 		"shell",
+		"task",
 		"write_file",
 	}
 	definitions := registry.Definitions()
@@ -73,27 +77,107 @@ func TestRegisterAll(t *testing.T) {
 func TestRegisterAllRejectsInvalidRegistryState(t *testing.T) {
 	t.Parallel()
 
-	if err := builtin.RegisterAll(nil); err == nil {
+	if err := builtin.RegisterAll(nil, builtin.NewProcesses(), builtin.Options{}); err == nil {
 		t.Error("RegisterAll(nil) succeeded")
 	}
+	if err := builtin.RegisterAll(agentloop.NewRegistry(), nil, builtin.Options{}); err == nil {
+		t.Error("RegisterAll without processes succeeded")
+	}
 
 	registry := agentloop.NewRegistry()
-	if err := builtin.RegisterAll(registry); err != nil {
+	processes := builtin.NewProcesses()
+	if err := builtin.RegisterAll(registry, processes, builtin.Options{}); err != nil {
 		t.Fatal(err)
 	}
-	if err := builtin.RegisterAll(registry); err == nil {
+	if err := builtin.RegisterAll(registry, processes, builtin.Options{}); err == nil {
 		t.Error("second RegisterAll call succeeded")
 	}
 }
 
+func TestBuiltinToolEffects(t *testing.T) {
+	t.Parallel()
+
+	registry := newRegistry(t)
+	cwd := t.TempDir()
+	for _, test := range []struct {
+		name      string
+		arguments string
+		want      agentloop.ToolEffects
+	}{
+		{
+			name:      "read_file",
+			arguments: `{"path":"a.txt"}`,
+			want:      agentloop.ToolEffects{Concurrency: agentloop.ToolReadOnly, Resources: []string{filepath.Join(cwd, "a.txt")}},
+		},
+		{
+			name:      "grep",
+			arguments: `{"pattern":"x"}`,
+			want:      agentloop.ToolEffects{Concurrency: agentloop.ToolReadOnly, Resources: []string{cwd}},
+		},
+		{
+			name:      "write_file",
+			arguments: `{"path":"a.txt","content":""}`,
+			want:      agentloop.ToolEffects{Concurrency: agentloop.ToolMutating, Resources: []string{filepath.Join(cwd, "a.txt")}},
+		},
+		{
+			name:      "delete_file",
+			arguments: `{}`,
+			want:      agentloop.ToolEffects{Concurrency: agentloop.ToolMutating},
+		},
+		{
+			name:      "apply_patch",
+			arguments: `{"operation":{"type":"update_file","path":"a.txt","moveTo":"b.txt"}}`,
+			want: agentloop.ToolEffects{
+				Concurrency: agentloop.ToolMutating,
+				Resources:   []string{filepath.Join(cwd, "a.txt"), filepath.Join(cwd, "b.txt")},
+			},
+		},
+		{
+			name:      "shell",
+			arguments: `{"commands":["true"]}`,
+			want:      agentloop.ToolEffects{Concurrency: agentloop.ToolExclusive},
+		},
+		{
+			name:      "process_kill",
+			arguments: `{"id":"p1"}`,
+			want:      agentloop.ToolEffects{Concurrency: agentloop.ToolExclusive},
+		},
+	} {
+		tool, _ := registry.Get(test.name)
+		scheduled, ok := tool.(agentloop.ScheduledTool)
+		if !ok {
+			t.Errorf("tool %q declares no effects", test.name)
+			continue
+		}
+		if got := scheduled.Effects(agentloop.CallContext{Cwd: cwd}, []byte(test.arguments)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tool %q effects = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	// Sub-agents work in their own sessions, so delegations run in parallel.
+	task, _ := registry.Get("task")
+	if _, ok := task.(agentloop.ScheduledTool); ok {
+		t.Error("task declares effects")
+	}
+}
+
 func newRegistry(t *testing.T) *agentloop.Registry {
 	t.Helper()
 
+	registry, _ := newRegistryWithProcesses(t)
+	return registry
+}
+
+func newRegistryWithProcesses(t *testing.T) (*agentloop.Registry, *builtin.Processes) {
+	t.Helper()
+
 	registry := agentloop.NewRegistry()
-	if err := builtin.RegisterAll(registry); err != nil {
+	processes := builtin.NewProcesses()
+	t.Cleanup(processes.Close)
+	if err := builtin.RegisterAll(registry, processes, builtin.Options{}); err != nil {
 		t.Fatal(err)
 	}
-	return registry
+	return registry, processes
 }
 
 func executeTool(
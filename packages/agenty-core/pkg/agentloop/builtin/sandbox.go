@@ -0,0 +1,114 @@
+package builtin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/utils/sandbox"
+)
+
+const shellOutcomeSandboxViolation = "sandbox_violation"
+
+// SandboxOptions confines shell commands and background processes. Commands
+// may read everything but HiddenPaths and write only below the session
+// working directory, WritablePaths and a private /tmp.
+type SandboxOptions struct {
+	WritablePaths []string
+	// HiddenPaths are seen as empty directories, such as the data directory
+	// holding API keys.
+	HiddenPaths []string
+	// DenyNetwork leaves commands only a loopback interface of their own.
+	DenyNetwork bool
+}
+
+// networkDeniedMessages are the errors programs print when the sandbox has
+// no network.
+var networkDeniedMessages = []string{
+	"Network is unreachable",
+	"Temporary failure in name resolution",
+	"Could not resolve host",
+}
+
+// sandboxShellProcess makes process run in the sandbox described by options,
+// if any, with cwd writable.
+func sandboxShellProcess(process *exec.Cmd, options *SandboxOptions, cwd string) error {
+	if options == nil {
+		return nil
+	}
+	if strings.TrimSpace(cwd) == "" {
+		var err error
+		if cwd, err = os.Getwd(); err != nil {
+			return fmt.Errorf("sandbox: resolve working directory: %w", err)
+		}
+	}
+	return sandbox.Wrap(process, sandbox.Policy{
+		Writable:    append([]string{cwd}, options.WritablePaths...),
+		Hidden:      options.HiddenPaths,
+		DenyNetwork: options.DenyNetwork,
+	})
+}
+
+// markSandboxViolation turns the outcome of a failed command the sandbox
+// stopped into a sandbox violation and explains it on stderr. The kernel
+// reports denied writes as a read-only file system.
+func markSandboxViolation(output *conversation.ShellCommandOutput, options *SandboxOptions, cwd string) {
+	if options == nil || output.Outcome.Type != "exit" || !failed(output.Outcome) {
+		return
+	}
+	text := output.Stdout + output.Stderr
+	var reasons []string
+	if strings.Contains(text, "Read-only file system") {
+		if cwd == "" {
+			cwd = "the working directory"
+		}
+		writable := append([]string{cwd}, options.WritablePaths...)
+		reasons = append(reasons, fmt.Sprintf(
+			"sandbox: writes are only allowed below %s and /tmp", strings.Join(writable, ", "),
+		))
+	}
+	if options.DenyNetwork && containsAny(text, networkDeniedMessages) {
+		reasons = append(reasons, "sandbox: network access is disabled")
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	if output.Stderr != "" && !strings.HasSuffix(output.Stderr, "\n") {
+		output.Stderr += "\n"
+	}
+	output.Stderr += strings.Join(reasons, "\n")
+	output.Outcome.Type = shellOutcomeSandboxViolation
+}
+
+// failed reports whether a command exited non-zero or was killed by a
+// signal. Only then does the output of a command explain its outcome.
+func failed(outcome conversation.ShellOutcome) bool {
+	return outcome.ExitCode != nil && *outcome.ExitCode != 0
+}
+
+func containsAny(text string, values []string) bool {
+	for _, value := range values {
+		if strings.Contains(text, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// sandboxDescription tells the model what the sandbox allows.
+func sandboxDescription(options *SandboxOptions) string {
+	if options == nil {
+		return ""
+	}
+	description := " Commands run in a sandbox: they may write only below the working directory"
+	if len(options.WritablePaths) > 0 {
+		description += ", " + strings.Join(options.WritablePaths, ", ")
+	}
+	description += " and a private /tmp"
+	if options.DenyNetwork {
+		description += ", and have no network access"
+	}
+	return description + "."
+}
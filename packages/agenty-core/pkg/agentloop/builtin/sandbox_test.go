@@ -0,0 +1,69 @@
+package builtin_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/utils/sandbox"
+)
+
+func TestShellSandboxReportsViolations(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" {
+		t.Skip("the sandbox needs Linux")
+	}
+
+	hidden := t.TempDir()
+	if err := os.WriteFile(filepath.Join(hidden, "key"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry := agentloop.NewRegistry()
+	processes := builtin.NewProcesses()
+	t.Cleanup(processes.Close)
+	options := builtin.Options{Shell: builtin.ShellOptions{
+		Sandbox: &builtin.SandboxOptions{HiddenPaths: []string{hidden}, DenyNetwork: true},
+	}}
+	if err := builtin.RegisterAll(registry, processes, options); err != nil {
+		t.Fatal(err)
+	}
+	tool, _ := registry.Get("shell")
+	if !strings.Contains(tool.Definition().Description, "sandbox") {
+		t.Errorf("description = %q, want the sandbox explained", tool.Definition().Description)
+	}
+
+	cwd := t.TempDir()
+	input := `{"commands":[
+		"printf inside > file && cat file",
+		"touch /agenty-sandbox-probe",
+		"cat ` + filepath.Join(hidden, "key") + `; ls -A ` + hidden + `",
+		"echo 'touch: cannot touch x: Read-only file system'; echo 'Could not resolve host'"
+	]}`
+	content, err := tool.Execute(t.Context(), agentloop.CallContext{Cwd: cwd}, []byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := content[0].(conversation.ShellCallOutputBlock).Output
+	if code := output[0].Outcome.ExitCode; code != nil && *code == sandbox.HelperFailureExitCode {
+		t.Skipf("sandbox unavailable: %s", output[0].Stderr)
+	}
+
+	if output[0].Stdout != "inside" || output[0].Outcome.Type != "exit" {
+		t.Errorf("write inside the working directory = %+v", output[0])
+	}
+	if output[1].Outcome.Type != "sandbox_violation" || output[1].Outcome.ExitCode == nil ||
+		!strings.Contains(output[1].Stderr, "sandbox: writes are only allowed below "+cwd) {
+		t.Errorf("write outside = %+v, want a sandbox violation", output[1])
+	}
+	if strings.Contains(output[2].Stdout, "secret") || strings.Contains(output[2].Stdout, "key") {
+		t.Errorf("hidden path output = %+v, want it empty", output[2])
+	}
+	if output[3].Outcome.Type != "exit" || strings.Contains(output[3].Stderr, "sandbox:") {
+		t.Errorf("successful command quoting sandbox errors = %+v, want a plain exit", output[3])
+	}
+}
@@ -63,6 +63,10 @@ func (tool *grepTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+func (tool *grepTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	return pathEffects(agentloop.ToolReadOnly, callContext, input, true)
+}
+
 func (tool *grepTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -80,7 +84,7 @@ func (tool *grepTool) Execute(
 	if err != nil {
 		return nil, fmt.Errorf("grep: %w", err)
 	}
-	root, err := resolvePath(arguments.Path, callContext.Cwd, true)
+	root, err := tool.fileSystem.resolve(arguments.Path, callContext, accessRead, true)
 	if err != nil {
 		return nil, fmt.Errorf("grep: %w", err)
 	}
@@ -284,6 +288,10 @@ func (tool *globTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+func (tool *globTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	return pathEffects(agentloop.ToolReadOnly, callContext, input, true)
+}
+
 func (tool *globTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -304,7 +312,7 @@ func (tool *globTool) Execute(
 	if err != nil {
 		return nil, fmt.Errorf("glob: %w", err)
 	}
-	root, err := resolvePath(arguments.Path, callContext.Cwd, true)
+	root, err := tool.fileSystem.resolve(arguments.Path, callContext, accessRead, true)
 	if err != nil {
 		return nil, fmt.Errorf("glob: %w", err)
 	}
@@ -499,6 +507,10 @@ func (tool *listTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+func (tool *listTool) Effects(callContext agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	return pathEffects(agentloop.ToolReadOnly, callContext, input, true)
+}
+
 func (tool *listTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -512,7 +524,7 @@ func (tool *listTool) Execute(
 		return nil, err
 	}
 
-	path, err := resolvePath(arguments.Path, callContext.Cwd, true)
+	path, err := tool.fileSystem.resolve(arguments.Path, callContext, accessRead, true)
 	if err != nil {
 		return nil, fmt.Errorf("ls: %w", err)
 	}
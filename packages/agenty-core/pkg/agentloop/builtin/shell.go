@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os/exec"
 	"strings"
 	"sync"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/masteryyh/agenty-core/pkg/agentloop"
 	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/utils/sandbox"
 )
 
 const (
@@ -23,6 +25,9 @@ const (
 	maxShellCommands              = 4
 	maxShellConcurrency           = 4
 	shellWaitDelay                = 500 * time.Millisecond
+	// maxShellProgressBytes caps the live output reported for each stream of
+	// a command; the result keeps its own limit.
+	maxShellProgressBytes int64 = 1 << 20
 )
 
 type shellArguments struct {
@@ -31,15 +36,48 @@ type shellArguments struct {
 	MaxOutputLength *int64   `json:"max_output_length,omitempty"`
 }
 
-type shellTool struct{}
+// ShellOptions configures the shell tool.
+type ShellOptions struct {
+	// Persistent runs the commands of each session in one bash on a
+	// pseudo-terminal, so their state carries over between calls. It is
+	// ignored where that is unavailable and when commands are sandboxed.
+	Persistent bool
+	// Sandbox confines commands when set. It is Linux only; elsewhere
+	// sandboxed commands fail.
+	Sandbox *SandboxOptions
+	// Limits caps the resources of commands.
+	Limits LimitOptions
+}
+
+type shellTool struct {
+	processes  *Processes
+	persistent bool
+	sandbox    *SandboxOptions
+	limits     LimitOptions
+}
+
+func newShellTool(processes *Processes, options ShellOptions) *shellTool {
+	return &shellTool{
+		processes:  processes,
+		persistent: options.Persistent && options.Sandbox == nil && persistentShellExecutable() != "",
+		sandbox:    options.Sandbox,
+		limits:     options.Limits,
+	}
+}
 
 func (tool *shellTool) Definition() agentloop.ToolDefinition {
+	description := "Execute up to 4 shell commands in parallel. " +
+		"Uses zsh on macOS, bash on Linux, and sh as a fallback when the preferred shell is unavailable. " +
+		"On Windows, uses pwsh.exe or powershell.exe when available, then cmd.exe."
+	if tool.persistent {
+		description = "Execute up to 4 shell commands in order in a persistent bash session on a terminal. " +
+			"The working directory, environment variables and functions a command sets carry over to later " +
+			"commands and calls. Stdout and stderr are combined, as on a terminal."
+	}
 	return agentloop.ToolDefinition{
-		Type: agentloop.ToolTypeShell,
-		Name: "shell",
-		Description: "Execute up to 4 shell commands in parallel. " +
-			"Uses zsh on macOS, bash on Linux, and sh as a fallback when the preferred shell is unavailable. " +
-			"On Windows, uses pwsh.exe or powershell.exe when available, then cmd.exe.",
+		Type:        agentloop.ToolTypeShell,
+		Name:        "shell",
+		Description: description + sandboxDescription(tool.sandbox) + limitsDescription(tool.limits),
 		InputSchema: objectSchema(map[string]agentloop.JSONSchema{
 			"commands": {
 				Type:     agentloop.JSONSchemaTypeArray,
@@ -63,6 +101,11 @@ func (tool *shellTool) Definition() agentloop.ToolDefinition {
 	}
 }
 
+// Effects makes shell calls run alone, as commands may touch any file.
+func (tool *shellTool) Effects(agentloop.CallContext, []byte) agentloop.ToolEffects {
+	return agentloop.ToolEffects{Concurrency: agentloop.ToolExclusive}
+}
+
 func (tool *shellTool) Execute(
 	ctx context.Context,
 	callContext agentloop.CallContext,
@@ -78,6 +121,16 @@ func (tool *shellTool) Execute(
 	}
 
 	results := make([]conversation.ShellCommandOutput, len(arguments.Commands))
+	if tool.persistent {
+		for index, command := range arguments.Commands {
+			results[index] = tool.executePersistent(ctx, callContext, index, command, timeout, outputLimit)
+		}
+		return conversation.Content{conversation.ShellCallOutputBlock{
+			MaxOutputLength: outputLimit,
+			Output:          results,
+		}}, nil
+	}
+
 	jobs := make(chan shellJob)
 	workerCount := min(len(arguments.Commands), maxShellConcurrency)
 	var waitGroup sync.WaitGroup
@@ -86,7 +139,9 @@ func (tool *shellTool) Execute(
 		go func() {
 			defer waitGroup.Done()
 			for job := range jobs {
-				results[job.index] = executeShellCommand(ctx, callContext.Cwd, job.command, timeout, outputLimit)
+				results[job.index] = executeShellCommand(
+					ctx, callContext, job.index, job.command, timeout, outputLimit, tool.sandbox, tool.limits,
+				)
 			}
 		}()
 	}
@@ -102,6 +157,23 @@ func (tool *shellTool) Execute(
 	}}, nil
 }
 
+// executePersistent runs command in the session's persistent shell, or in a
+// fresh one when the persistent shell cannot start.
+func (tool *shellTool) executePersistent(
+	ctx context.Context,
+	callContext agentloop.CallContext,
+	index int,
+	command string,
+	timeout time.Duration,
+	outputLimit int64,
+) conversation.ShellCommandOutput {
+	shell, err := tool.processes.shell(callContext.SessionID, callContext.Cwd, tool.limits)
+	if err != nil {
+		return executeShellCommand(ctx, callContext, index, command, timeout, outputLimit, nil, tool.limits)
+	}
+	return shell.run(ctx, callContext, index, command, timeout, outputLimit)
+}
+
 type shellJob struct {
 	index   int
 	command string
@@ -141,24 +213,43 @@ func normalizeShellArguments(arguments shellArguments) (time.Duration, int64, er
 
 func executeShellCommand(
 	parent context.Context,
-	cwd string,
+	callContext agentloop.CallContext,
+	index int,
 	command string,
 	timeout time.Duration,
 	outputLimit int64,
-) conversation.ShellCommandOutput {
+	sandboxOptions *SandboxOptions,
+	limits LimitOptions,
+) (output conversation.ShellCommandOutput) {
+	defer markSandboxViolation(&output, sandboxOptions, callContext.Cwd)
 	commandContext, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
 	process := newShellCommand(commandContext, command)
 	prepareShellProcess(process)
-	if strings.TrimSpace(cwd) != "" {
-		process.Dir = cwd
+	var before sandbox.CgroupEvents
+	defer func() {
+		output.Usage = shellUsage(process.ProcessState)
+		markResourceLimit(&output, process.ProcessState, limits, callContext.SessionID, before)
+	}()
+	if strings.TrimSpace(callContext.Cwd) != "" {
+		process.Dir = callContext.Cwd
 	}
 	stdout := newShellOutputBuffer(outputLimit)
 	stderr := newShellOutputBuffer(outputLimit)
-	process.Stdout = &stdout
-	process.Stderr = &stderr
-	err := process.Run()
+	process.Stdout = shellStreamWriter(&stdout, callContext, "stdout", index)
+	process.Stderr = shellStreamWriter(&stderr, callContext, "stderr", index)
+	err := sandboxShellProcess(process, sandboxOptions, callContext.Cwd)
+	if err == nil {
+		before, err = limitShellProcess(process, limits, callContext.SessionID)
+	}
+	if err == nil {
+		err = process.Run()
+	}
+	if err != nil && parent.Err() != nil {
+		// The call or its round was stopped, which killed the command.
+		err = context.Cause(parent)
+	}
 
 	if errors.Is(commandContext.Err(), context.DeadlineExceeded) {
 		capturedStdout, capturedStderr := truncateShellOutput(stdout.String(), stderr.String(), outputLimit)
@@ -197,6 +288,61 @@ func executeShellCommand(
 	}
 }
 
+// shellStreamWriter captures a stream of a command and, when the call is
+// followed, reports it live.
+func shellStreamWriter(
+	buffer *shellOutputBuffer,
+	callContext agentloop.CallContext,
+	stream string,
+	index int,
+) io.Writer {
+	if callContext.Progress == nil {
+		return buffer
+	}
+	return io.MultiWriter(buffer, &shellProgressWriter{
+		callContext: callContext,
+		stream:      stream,
+		index:       index,
+		remaining:   maxShellProgressBytes,
+	})
+}
+
+// shellProgressWriter reports output as it arrives, holding back a rune split
+// across writes.
+type shellProgressWriter struct {
+	callContext agentloop.CallContext
+	stream      string
+	index       int
+	pending     []byte
+	remaining   int64
+}
+
+func (writer *shellProgressWriter) Write(data []byte) (int, error) {
+	written := len(data)
+	if writer.remaining <= 0 {
+		return written, nil
+	}
+	data = append(writer.pending, data...)
+	complete := len(data)
+	for start := len(data) - 1; start >= 0 && start >= len(data)-utf8.UTFMax; start-- {
+		if utf8.RuneStart(data[start]) {
+			if !utf8.FullRune(data[start:]) {
+				complete = start
+			}
+			break
+		}
+	}
+	writer.pending = append([]byte(nil), data[complete:]...)
+
+	chunk := data[:complete]
+	if int64(len(chunk)) > writer.remaining {
+		chunk = chunk[:writer.remaining]
+	}
+	writer.remaining -= int64(len(chunk))
+	writer.callContext.ReportProgress(writer.stream, writer.index, strings.ToValidUTF8(string(chunk), ""))
+	return written, nil
+}
+
 type shellOutputBuffer struct {
 	buffer    bytes.Buffer
 	remaining int64
@@ -0,0 +1,374 @@
+package builtin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+const (
+	persistentShellStartTimeout = 5 * time.Second
+	// persistentShellInterruptTimeout is how long an interrupted command has
+	// to give the prompt back before its shell is replaced.
+	persistentShellInterruptTimeout = 2 * time.Second
+	interruptedExitCode             = 130
+	// maxTerminalLineBytes bounds a line held back from the live output
+	// until its control sequences can be stripped.
+	maxTerminalLineBytes = 64 << 10
+)
+
+// terminalControlSequence matches the CSI, OSC, DCS and two-byte escape
+// sequences programs write to terminals.
+var terminalControlSequence = regexp.MustCompile(
+	`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)?|\x1b[PX^_][^\x1b]*(?:\x1b\\)?|\x1b[ -/]*[0-~]`,
+)
+
+// persistentShell is an interactive bash on a pseudo-terminal that runs the
+// commands of one session in turn, so that the working directory, variables
+// and functions they set carry over.
+type persistentShell struct {
+	// mu serializes commands.
+	mu       sync.Mutex
+	process  *exec.Cmd
+	terminal *os.File
+	output   *processOutput
+	// marker starts the lines the shell prints around each command. It is
+	// random, so command output cannot fake them.
+	marker   string
+	commands int
+	// cwd is the session working directory the shell last followed.
+	cwd    string
+	limits LimitOptions
+	closed atomic.Bool
+	done   chan struct{}
+	// exitCode is written before done closes.
+	exitCode int
+}
+
+func startPersistentShell(sessionID uuid.UUID, cwd string, limits LimitOptions) (*persistentShell, error) {
+	process := exec.Command(persistentShellExecutable(), "--noprofile", "--norc", "--noediting", "-i")
+	if strings.TrimSpace(cwd) != "" {
+		process.Dir = cwd
+	}
+	process.Env = append(os.Environ(),
+		"TERM=dumb", "PS0=", "PS1=", "PS2=", "PROMPT_COMMAND=", "HISTFILE=", "PAGER=cat", "GIT_PAGER=cat")
+	if _, err := limitShellProcess(process, limits, sessionID); err != nil {
+		return nil, fmt.Errorf("start persistent shell: %w", err)
+	}
+	terminal, err := startOnTerminal(process)
+	if err != nil {
+		return nil, fmt.Errorf("start persistent shell: %w", err)
+	}
+
+	shell := &persistentShell{
+		process:  process,
+		terminal: terminal,
+		output:   newProcessOutput(),
+		marker:   "AGENTY_" + rand.Text(),
+		cwd:      cwd,
+		limits:   limits,
+		done:     make(chan struct{}),
+	}
+	go shell.wait()
+
+	ready := shell.marker + "_ready\n"
+	if _, err := fmt.Fprintf(terminal, "printf '%%s_ready\\n' %s\n", shell.marker); err == nil {
+		var received string
+		deadline := time.Now().Add(persistentShellStartTimeout)
+		for !strings.Contains(received, ready) && time.Now().Before(deadline) && !shell.exited() {
+			text, _ := shell.output.next(context.Background(), maxProcessOutputBytes, time.Until(deadline))
+			received += text
+		}
+		if strings.Contains(received, ready) {
+			return shell, nil
+		}
+	}
+	shell.close()
+	return nil, fmt.Errorf("start persistent shell: it did not become ready")
+}
+
+func (shell *persistentShell) wait() {
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(shell.output, shell.terminal)
+		close(copied)
+	}()
+
+	_ = shell.process.Wait()
+	shell.exitCode = -1
+	if state := shell.process.ProcessState; state != nil {
+		shell.exitCode = state.ExitCode()
+	}
+	// Give the reader the output left in the terminal, unless a background
+	// job keeps the terminal open.
+	select {
+	case <-copied:
+	case <-time.After(shellWaitDelay):
+	}
+	_ = shell.terminal.Close()
+	<-copied
+	shell.output.close()
+	close(shell.done)
+}
+
+func (shell *persistentShell) exited() bool {
+	select {
+	case <-shell.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// usable reports whether the shell can run another command.
+func (shell *persistentShell) usable() bool {
+	return !shell.closed.Load() && !shell.exited()
+}
+
+// close hangs up the terminal, which sends SIGHUP to the shell's jobs, kills
+// the shell and waits until it is reaped.
+func (shell *persistentShell) close() {
+	shell.closed.Store(true)
+	_ = shell.terminal.Close()
+	killTerminalSession(shell.process.Process.Pid)
+	select {
+	case <-shell.done:
+	case <-time.After(processKillTimeout):
+	}
+}
+
+// run executes command in the shell and returns its output in the shape of
+// a fresh command's. The terminal combines stdout and stderr, so all output
+// is reported as stdout.
+func (shell *persistentShell) run(
+	parent context.Context,
+	callContext agentloop.CallContext,
+	index int,
+	command string,
+	timeout time.Duration,
+	outputLimit int64,
+) (output conversation.ShellCommandOutput) {
+	shell.mu.Lock()
+	defer shell.mu.Unlock()
+	before := cgroupEvents(shell.limits, callContext.SessionID)
+	defer markResourceLimit(&output, nil, shell.limits, callContext.SessionID, before)
+
+	// Drop what background jobs wrote since the last command.
+	for {
+		if text, _ := shell.output.next(parent, maxProcessOutputBytes, 0); text == "" {
+			break
+		}
+	}
+	shell.commands++
+	stdout := newShellOutputBuffer(outputLimit)
+	frame := newCommandFrame(shell.marker, shell.commands, terminalStreamWriter(&stdout, callContext, index))
+
+	var script strings.Builder
+	if callContext.Cwd != "" && callContext.Cwd != shell.cwd {
+		// Follow changes of the session working directory; output before the
+		// start line is dropped.
+		fmt.Fprintf(&script, "cd -- %s; ", quoteShellWord(callContext.Cwd))
+		shell.cwd = callContext.Cwd
+	}
+	// Everything is one line, so bash reads it whole before running the
+	// command, and the command cannot read the end line as its input.
+	fmt.Fprintf(&script, "printf '\\n%%s_%%d_start\\n' %[1]s %[2]d; eval -- %[3]s; printf '\\n%%s_%%d_end:%%d\\n' %[1]s %[2]d \"$?\"\n",
+		shell.marker, shell.commands, quoteShellWord(command))
+	if _, err := io.WriteString(shell.terminal, script.String()); err != nil {
+		shell.close()
+		return terminalCommandOutput(stdout, fmt.Sprintf("write to persistent shell: %v", err), -1, outputLimit)
+	}
+
+	commandContext, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	if shell.await(commandContext, frame) {
+		return terminalCommandOutput(stdout, "", frame.exitCode, outputLimit)
+	}
+	if shell.exited() {
+		return terminalCommandOutput(stdout,
+			"the persistent shell exited; the next command starts a new one", int64(shell.exitCode), outputLimit)
+	}
+
+	// Interrupt the command as Ctrl-C would, then ask for an end line in case
+	// the interrupt also dropped the rest of the command line.
+	_, _ = io.WriteString(shell.terminal, "\x03")
+	_, _ = fmt.Fprintf(shell.terminal, "printf '\\n%%s_%%d_end:%%d\\n' %s %d %d\n",
+		shell.marker, shell.commands, interruptedExitCode)
+	interruptContext, cancelInterrupt := context.WithTimeout(context.Background(), persistentShellInterruptTimeout)
+	defer cancelInterrupt()
+	if !shell.await(interruptContext, frame) {
+		shell.close()
+	}
+
+	if parent.Err() != nil {
+		// The call or its round was stopped.
+		return terminalCommandOutput(stdout, context.Cause(parent).Error(), -1, outputLimit)
+	}
+	stripped, _ := truncateShellOutput(stripTerminalControls(stdout.String()), "", outputLimit)
+	return conversation.ShellCommandOutput{
+		Stdout:  stripped,
+		Outcome: conversation.ShellOutcome{Type: "timeout"},
+	}
+}
+
+// await feeds the shell's output to frame until the command ends, the shell
+// exits or ctx is done, and reports whether the command ended.
+func (shell *persistentShell) await(ctx context.Context, frame *commandFrame) bool {
+	for {
+		deadline, _ := ctx.Deadline()
+		text, _ := shell.output.next(ctx, maxProcessOutputBytes, time.Until(deadline))
+		if frame.feed(text) {
+			return true
+		}
+		if text == "" && (shell.exited() || ctx.Err() != nil) {
+			return false
+		}
+	}
+}
+
+func terminalCommandOutput(
+	stdout shellOutputBuffer,
+	stderr string,
+	exitCode int64,
+	outputLimit int64,
+) conversation.ShellCommandOutput {
+	capturedStdout, capturedStderr := truncateShellOutput(stripTerminalControls(stdout.String()), stderr, outputLimit)
+	return conversation.ShellCommandOutput{
+		Stdout:  capturedStdout,
+		Stderr:  capturedStderr,
+		Outcome: conversation.ShellOutcome{Type: "exit", ExitCode: &exitCode},
+	}
+}
+
+// commandFrame picks the output of one command out of the terminal output by
+// the lines the shell prints around it.
+type commandFrame struct {
+	start   string
+	end     string
+	started bool
+	// pending is output that may hold the start of a frame line.
+	pending  string
+	output   io.Writer
+	exitCode int64
+}
+
+func newCommandFrame(marker string, command int, output io.Writer) *commandFrame {
+	prefix := marker + "_" + strconv.Itoa(command)
+	return &commandFrame{
+		start:  prefix + "_start\n",
+		end:    "\n" + prefix + "_end:",
+		output: output,
+	}
+}
+
+// feed takes the next output of the terminal and reports whether the end
+// line of the command has arrived.
+func (frame *commandFrame) feed(text string) bool {
+	frame.pending += text
+	if !frame.started {
+		index := strings.Index(frame.pending, frame.start)
+		if index < 0 {
+			frame.pending = frame.pending[max(len(frame.pending)-len(frame.start), 0):]
+			return false
+		}
+		frame.started = true
+		frame.pending = frame.pending[index+len(frame.start):]
+	}
+
+	if index := strings.Index(frame.pending, frame.end); index >= 0 {
+		code, _, found := strings.Cut(frame.pending[index+len(frame.end):], "\n")
+		if !found {
+			return false
+		}
+		exitCode, err := strconv.ParseInt(code, 10, 64)
+		if err != nil {
+			exitCode = -1
+		}
+		_, _ = io.WriteString(frame.output, frame.pending[:index])
+		frame.pending = ""
+		frame.exitCode = exitCode
+		return true
+	}
+	if complete := len(frame.pending) - len(frame.end); complete > 0 {
+		_, _ = io.WriteString(frame.output, frame.pending[:complete])
+		frame.pending = frame.pending[complete:]
+	}
+	return false
+}
+
+// terminalStreamWriter captures terminal output and, when the call is
+// followed, reports it live line by line without control sequences.
+func terminalStreamWriter(buffer *shellOutputBuffer, callContext agentloop.CallContext, index int) io.Writer {
+	if callContext.Progress == nil {
+		return buffer
+	}
+	return io.MultiWriter(buffer, &terminalLineWriter{
+		progress: &shellProgressWriter{
+			callContext: callContext,
+			stream:      "stdout",
+			index:       index,
+			remaining:   maxShellProgressBytes,
+		},
+	})
+}
+
+// terminalLineWriter passes complete lines on with their control sequences
+// stripped, as a sequence may be split across writes.
+type terminalLineWriter struct {
+	progress io.Writer
+	line     []byte
+}
+
+func (writer *terminalLineWriter) Write(data []byte) (int, error) {
+	writer.line = append(writer.line, data...)
+	complete := bytes.LastIndexByte(writer.line, '\n') + 1
+	if len(writer.line) > maxTerminalLineBytes {
+		complete = len(writer.line)
+	}
+	if complete > 0 {
+		_, _ = writer.progress.Write([]byte(stripTerminalControls(string(writer.line[:complete]))))
+		writer.line = append([]byte(nil), writer.line[complete:]...)
+	}
+	return len(data), nil
+}
+
+// stripTerminalControls removes escape sequences and control characters from
+// terminal output. A line rewritten with carriage returns, as progress bars
+// do, keeps only its last version.
+func stripTerminalControls(text string) string {
+	text = terminalControlSequence.ReplaceAllString(text, "")
+	lines := strings.Split(text, "\n")
+	for index, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if carriage := strings.LastIndexByte(line, '\r'); carriage >= 0 {
+			line = line[carriage+1:]
+		}
+		lines[index] = strings.Map(func(r rune) rune {
+			if (r < 0x20 && r != '\t') || r == 0x7f {
+				return -1
+			}
+			return r
+		}, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quoteShellWord quotes text as a single POSIX shell word.
+func quoteShellWord(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
+}
@@ -0,0 +1,136 @@
+package builtin_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	json "github.com/bytedance/sonic"
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func TestPersistentShellKeepsStateBetweenCalls(t *testing.T) {
+	t.Parallel()
+	registry := newPersistentShellRegistry(t)
+
+	cwd := t.TempDir()
+	if err := os.Mkdir(filepath.Join(cwd, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	session := agentloop.CallContext{SessionID: uuid.New(), Cwd: cwd}
+	setup := executePersistentShell(t, registry, session, 0, []string{
+		"cd sub && export GREETING=hello",
+		`greet() { printf '%s from %s\n' "$GREETING" "${PWD##*/}"; }`,
+	})
+	for index, result := range setup.Output {
+		if code := result.Outcome.ExitCode; code == nil || *code != 0 {
+			t.Fatalf("setup command %d = %+v, want exit 0", index, result)
+		}
+	}
+
+	output := executePersistentShell(t, registry, session, 0, []string{
+		`greet; printf '\033[1;31mred\033[0m\n'; printf 'step 1\rstep 2\n'; test -t 1 && echo terminal; (exit 3)`,
+		"if then",
+	})
+	if got, want := output.Output[0].Stdout, "hello from sub\nred\nstep 2\nterminal\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if code := output.Output[0].Outcome.ExitCode; code == nil || *code != 3 {
+		t.Errorf("outcome = %+v, want exit 3", output.Output[0].Outcome)
+	}
+	if code := output.Output[1].Outcome.ExitCode; code == nil || *code != 2 {
+		t.Errorf("syntax error outcome = %+v, want exit 2", output.Output[1])
+	}
+
+	other := executePersistentShell(t, registry, agentloop.CallContext{SessionID: uuid.New(), Cwd: cwd}, 0, []string{
+		`printf '%s|%s' "${PWD##*/}" "$GREETING"`,
+	})
+	if got, want := other.Output[0].Stdout, filepath.Base(cwd)+"|"; got != want {
+		t.Errorf("other session stdout = %q, want %q", got, want)
+	}
+}
+
+func TestPersistentShellInterruptsTimedOutCommands(t *testing.T) {
+	t.Parallel()
+	registry := newPersistentShellRegistry(t)
+	session := agentloop.CallContext{SessionID: uuid.New(), Cwd: t.TempDir()}
+
+	started := time.Now()
+	output := executePersistentShell(t, registry, session, 300, []string{"export KEPT=yes; printf before; sleep 30"})
+	if elapsed := time.Since(started); elapsed >= 5*time.Second {
+		t.Fatalf("timed out command took %v, want an interrupt", elapsed)
+	}
+	if output.Output[0].Outcome.Type != "timeout" || !strings.HasPrefix(output.Output[0].Stdout, "before") {
+		t.Errorf("timeout output = %+v", output.Output[0])
+	}
+
+	output = executePersistentShell(t, registry, session, 0, []string{`printf '%s' "$KEPT"`, "exit 4", "echo $KEPT"})
+	if output.Output[0].Stdout != "yes" {
+		t.Errorf("stdout after the interrupt = %q, want the shell kept", output.Output[0].Stdout)
+	}
+	if code := output.Output[1].Outcome.ExitCode; code == nil || *code != 4 {
+		t.Errorf("exit outcome = %+v, want 4", output.Output[1])
+	}
+	if output.Output[2].Stdout != "\n" {
+		t.Errorf("stdout after the shell exited = %q, want a new shell", output.Output[2].Stdout)
+	}
+}
+
+func newPersistentShellRegistry(t *testing.T) *agentloop.Registry {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("persistent shells need Linux")
+	}
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("persistent shells need bash")
+	}
+
+	registry := agentloop.NewRegistry()
+	processes := builtin.NewProcesses()
+	t.Cleanup(processes.Close)
+	options := builtin.Options{Shell: builtin.ShellOptions{Persistent: true}}
+	if err := builtin.RegisterAll(registry, processes, options); err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func executePersistentShell(
+	t *testing.T,
+	registry *agentloop.Registry,
+	callContext agentloop.CallContext,
+	timeoutMs int64,
+	commands []string,
+) conversation.ShellCallOutputBlock {
+	t.Helper()
+
+	arguments := map[string]any{"commands": commands}
+	if timeoutMs > 0 {
+		arguments["timeout_ms"] = timeoutMs
+	}
+	input, err := json.Marshal(arguments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool, ok := registry.Get("shell")
+	if !ok {
+		t.Fatal("shell is not registered")
+	}
+	content, err := tool.Execute(t.Context(), callContext, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, ok := content[0].(conversation.ShellCallOutputBlock)
+	if !ok || len(output.Output) != len(commands) {
+		t.Fatalf("shell content = %#v", content)
+	}
+	return output
+}
@@ -2,8 +2,20 @@
 
 package builtin
 
-import "os/exec"
+import (
+	"os"
+	"os/exec"
+)
 
 func prepareShellProcess(process *exec.Cmd) {
 	process.WaitDelay = shellWaitDelay
 }
+
+func exceededCPULimit(*os.ProcessState, *int64) bool {
+	return false
+}
+
+// peakRSS returns zero, as the platform does not report it.
+func peakRSS(*os.ProcessState) int64 {
+	return 0
+}
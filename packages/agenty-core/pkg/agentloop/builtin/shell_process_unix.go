@@ -6,6 +6,7 @@ import (
 	"errors"
 	"os"
 	"os/exec"
+	"runtime"
 	"syscall"
 )
 
@@ -23,3 +24,29 @@ func prepareShellProcess(process *exec.Cmd) {
 	}
 	process.WaitDelay = shellWaitDelay
 }
+
+// exceededCPULimit reports whether RLIMIT_CPU stopped the command or, as the
+// shell reports it, one of its children.
+func exceededCPULimit(state *os.ProcessState, exitCode *int64) bool {
+	if exitCode != nil && *exitCode == 128+int64(syscall.SIGXCPU) {
+		return true
+	}
+	if state == nil {
+		return false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
+
+// peakRSS returns the largest resident set size of the process and the
+// children it waited for, in bytes.
+func peakRSS(state *os.ProcessState) int64 {
+	usage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) << 10
+}
@@ -25,3 +25,12 @@ func prepareShellProcess(process *exec.Cmd) {
 	}
 	process.WaitDelay = shellWaitDelay
 }
+
+func exceededCPULimit(*os.ProcessState, *int64) bool {
+	return false
+}
+
+// peakRSS returns zero, as the platform does not report it.
+func peakRSS(*os.ProcessState) int64 {
+	return 0
+}
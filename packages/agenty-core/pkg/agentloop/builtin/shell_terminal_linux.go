@@ -0,0 +1,108 @@
+//go:build linux
+
+package builtin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	terminalRows    = 50
+	terminalColumns = 200
+)
+
+// persistentShellExecutable returns the bash persistent shells run, or ""
+// when there is none.
+func persistentShellExecutable() string {
+	if executable := shellExecutable(); filepath.Base(executable) == "bash" {
+		return executable
+	}
+	return ""
+}
+
+// startOnTerminal starts process as the leader of a new session whose
+// controlling terminal is a fresh pseudo-terminal, and returns the master
+// side. The terminal neither echoes input nor turns newlines into CRLF, and
+// passes input on without buffering lines, which it caps at 4 KiB.
+func startOnTerminal(process *exec.Cmd) (*os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open pseudo-terminal: %w", err)
+	}
+	replica, err := openReplica(master)
+	if err != nil {
+		_ = master.Close()
+		return nil, err
+	}
+	defer replica.Close()
+
+	process.Stdin = replica
+	process.Stdout = replica
+	process.Stderr = replica
+	if process.SysProcAttr == nil {
+		process.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	process.SysProcAttr.Setsid = true
+	process.SysProcAttr.Setctty = true
+	process.SysProcAttr.Ctty = 0
+	if err := process.Start(); err != nil {
+		_ = master.Close()
+		return nil, err
+	}
+	return master, nil
+}
+
+func openReplica(master *os.File) (*os.File, error) {
+	connection, err := master.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("open pseudo-terminal: %w", err)
+	}
+	var number uint32
+	var ioctlErr error
+	if err := connection.Control(func(fd uintptr) {
+		if ioctlErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ioctlErr != nil {
+			return
+		}
+		number, ioctlErr = unix.IoctlGetUint32(int(fd), unix.TIOCGPTN)
+	}); err != nil {
+		return nil, fmt.Errorf("open pseudo-terminal: %w", err)
+	}
+	if ioctlErr != nil {
+		return nil, fmt.Errorf("unlock pseudo-terminal: %w", ioctlErr)
+	}
+
+	replica, err := os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(number), 10), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open pseudo-terminal replica: %w", err)
+	}
+	fd := int(replica.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err == nil {
+		termios.Lflag &^= unix.ECHO | unix.ICANON
+		termios.Oflag &^= unix.ONLCR
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
+		err = unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+	}
+	if err == nil {
+		err = unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Row: terminalRows, Col: terminalColumns})
+	}
+	if err != nil {
+		_ = replica.Close()
+		return nil, fmt.Errorf("configure pseudo-terminal: %w", err)
+	}
+	return replica, nil
+}
+
+// killTerminalSession kills the process group of the session leader pid.
+// Jobs the shell moved to other groups get SIGHUP when the terminal closes.
+func killTerminalSession(pid int) {
+	_ = syscall.Kill(-pid, syscall.SIGKILL)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package builtin
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func persistentShellExecutable() string {
+	return ""
+}
+
+func startOnTerminal(*exec.Cmd) (*os.File, error) {
+	return nil, errors.New("persistent shells are only supported on Linux")
+}
+
+func killTerminalSession(int) {}
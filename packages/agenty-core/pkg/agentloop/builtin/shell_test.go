@@ -2,8 +2,10 @@ package builtin_test
 
 import (
 	"context"
+	"errors"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -122,6 +124,46 @@ func TestShellRejectsInvalidArguments(t *testing.T) {
 	}
 }
 
+func TestShellReportsOutputWhileRunning(t *testing.T) {
+	t.Parallel()
+
+	registry := newRegistry(t)
+	tool, ok := registry.Get("shell")
+	if !ok {
+		t.Fatal("shell is not registered")
+	}
+	ctx, cancel := context.WithCancelCause(t.Context())
+	defer cancel(nil)
+	var mu sync.Mutex
+	streamed := make(map[string]string)
+	callContext := agentloop.CallContext{
+		ToolUseID: "call_shell",
+		Progress: func(progress agentloop.ToolProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			if progress.ToolUseID != "call_shell" || progress.Index != 0 {
+				t.Errorf("progress = %+v", progress)
+			}
+			streamed[progress.Stream] += progress.Text
+			if streamed["stdout"] == "héllo" && streamed["stderr"] == "oops" {
+				cancel(errors.New("stopped by the test"))
+			}
+		},
+	}
+
+	content, err := tool.Execute(ctx, callContext, []byte(`{"commands":["printf 'héllo'; printf oops >&2; sleep 5"],"timeout_ms":10000}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := content[0].(conversation.ShellCallOutputBlock).Output[0]
+	if output.Stdout != "héllo" || !strings.HasPrefix(output.Stderr, "oops") {
+		t.Errorf("output = %+v", output)
+	}
+	if !strings.Contains(output.Stderr, "stopped by the test") || output.Outcome.ExitCode == nil || *output.Outcome.ExitCode != -1 {
+		t.Errorf("stopped command output = %+v, want exit -1 with the cause", output)
+	}
+}
+
 func TestShellReportsProcessStartErrors(t *testing.T) {
 	t.Parallel()
 
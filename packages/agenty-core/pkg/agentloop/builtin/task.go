@@ -0,0 +1,65 @@
+package builtin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+type taskTool struct{}
+
+type taskArguments struct {
+	Agent  string `json:"agent"`
+	Prompt string `json:"prompt"`
+}
+
+func (tool *taskTool) Definition() agentloop.ToolDefinition {
+	return agentloop.ToolDefinition{
+		Name: "task",
+		Description: "Delegate a self-contained subtask to another agent. The agent runs in a separate session " +
+			"with its own context window and cannot see this conversation, so the prompt must carry every detail " +
+			"it needs. Only the agent's final answer is returned.",
+		InputSchema: objectSchema(
+			map[string]agentloop.JSONSchema{
+				"agent":  stringSchema("Code of the agent that should perform the subtask."),
+				"prompt": stringSchema("Complete, self-contained instructions for the subtask."),
+			},
+			[]string{"agent", "prompt"},
+		),
+	}
+}
+
+func (tool *taskTool) Execute(
+	ctx context.Context,
+	callContext agentloop.CallContext,
+	input []byte,
+) (conversation.Content, error) {
+	var arguments taskArguments
+	if err := decodeArguments(input, &arguments); err != nil {
+		return nil, fmt.Errorf("task: %w", err)
+	}
+	if strings.TrimSpace(arguments.Agent) == "" {
+		return nil, fmt.Errorf("task: agent must not be empty")
+	}
+	if strings.TrimSpace(arguments.Prompt) == "" {
+		return nil, fmt.Errorf("task: prompt must not be empty")
+	}
+	if callContext.Subagents == nil {
+		return nil, fmt.Errorf("task: sub-agent delegation is not available here")
+	}
+
+	result, err := callContext.Subagents.RunSubagent(ctx, callContext, agentloop.SubagentRequest{
+		AgentCode: arguments.Agent,
+		Prompt:    arguments.Prompt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("task: %w", err)
+	}
+	if result.Answer == "" {
+		return conversation.Text("The sub-agent finished without a text answer."), nil
+	}
+	return conversation.Text(result.Answer), nil
+}
@@ -0,0 +1,137 @@
+package builtin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/utils"
+)
+
+// maxSymlinkHops bounds the links followed to resolve one path, as the kernel
+// does.
+const maxSymlinkHops = 40
+
+// WorkspaceOptions bounds the paths the file, search and patch tools may use.
+// The workspace of a call is the session working directory, the session's
+// workspace roots and AllowedPaths.
+type WorkspaceOptions struct {
+	// AllowedPaths are directories in the workspace of every session.
+	// Relative ones are below the session working directory.
+	AllowedPaths []string
+	// ReadAnywhere lets the tools read outside the workspace. They still
+	// write only inside it.
+	ReadAnywhere bool
+}
+
+type pathAccess int
+
+const (
+	accessRead pathAccess = iota
+	accessWrite
+	// accessRemove is a write to the entry itself, so a final symlink is not
+	// followed.
+	accessRemove
+)
+
+// resolve resolves path like resolvePath and checks that the call may access
+// it.
+func (fileSystem *fileSystem) resolve(
+	path string,
+	callContext agentloop.CallContext,
+	access pathAccess,
+	allowEmpty bool,
+) (string, error) {
+	resolved, err := resolvePath(path, callContext.Cwd, allowEmpty)
+	if err != nil {
+		return "", err
+	}
+	if err := fileSystem.checkAccess(resolved, callContext, access); err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// checkAccess reports why the call may not access the absolute path, after
+// following its symlinks.
+func (fileSystem *fileSystem) checkAccess(path string, callContext agentloop.CallContext, access pathAccess) error {
+	options := fileSystem.workspace
+	if access == accessRead && options.ReadAnywhere {
+		return nil
+	}
+	roots, err := workspaceRoots(callContext, options.AllowedPaths)
+	if err != nil {
+		return err
+	}
+
+	actual := realPath(path, maxSymlinkHops)
+	if access == accessRemove {
+		actual = filepath.Join(realPath(filepath.Dir(path), maxSymlinkHops), filepath.Base(path))
+	}
+	lexicallyInside := false
+	for _, root := range roots {
+		if utils.WithinPath(actual, realPath(root, maxSymlinkHops)) {
+			return nil
+		}
+		lexicallyInside = lexicallyInside || utils.WithinPath(path, root)
+	}
+
+	if lexicallyInside {
+		return fmt.Errorf("path %q resolves through a symlink to %q, outside the workspace", path, actual)
+	}
+	if options.ReadAnywhere {
+		return fmt.Errorf(
+			"path %q is outside the workspace, where files are read-only; writable directories are %s",
+			path, strings.Join(roots, ", "),
+		)
+	}
+	return fmt.Errorf(
+		"path %q is outside the workspace; the tools may only use paths below %s",
+		path, strings.Join(roots, ", "),
+	)
+}
+
+// workspaceRoots returns the absolute, cleaned workspace roots of a call.
+func workspaceRoots(callContext agentloop.CallContext, allowed []string) ([]string, error) {
+	cwd, err := resolvePath("", callContext.Cwd, true)
+	if err != nil {
+		return nil, err
+	}
+	roots := []string{cwd}
+	for _, root := range append(append([]string(nil), callContext.WorkspaceRoots...), allowed...) {
+		if strings.TrimSpace(root) == "" {
+			continue
+		}
+		resolved, err := resolvePath(root, cwd, false)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, resolved)
+	}
+	return roots, nil
+}
+
+// realPath follows the symlinks of the absolute path. Missing parts are kept
+// as they are, so a file about to be created resolves to where it will be.
+func realPath(path string, hops int) string {
+	if actual, err := filepath.EvalSymlinks(path); err == nil {
+		return actual
+	}
+	parent := filepath.Dir(path)
+	if parent == path || hops <= 0 {
+		return path
+	}
+	// A dangling symlink is followed to where writing through it would
+	// create the file.
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(path); err == nil {
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(parent, target)
+			}
+			return realPath(filepath.Clean(target), hops-1)
+		}
+	}
+	return filepath.Join(realPath(parent, hops), filepath.Base(path))
+}
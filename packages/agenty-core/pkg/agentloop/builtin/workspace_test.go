@@ -0,0 +1,211 @@
+package builtin_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func TestFileToolsStayInsideWorkspace(t *testing.T) {
+	t.Parallel()
+
+	cwd := t.TempDir()
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	if err := os.WriteFile(secret, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	registry := newRegistry(t)
+
+	tests := []struct {
+		name      string
+		tool      string
+		arguments string
+		wantError string
+	}{
+		{
+			name:      "absolute path",
+			tool:      "write_file",
+			arguments: `{"path":` + quote(filepath.Join(outside, "new.txt")) + `,"content":"x"}`,
+			wantError: "is outside the workspace; the tools may only use paths below " + cwd,
+		},
+		{
+			name:      "parent escape",
+			tool:      "read_file",
+			arguments: `{"path":` + quote(filepath.Join("..", filepath.Base(outside), "secret.txt")) + `}`,
+			wantError: "is outside the workspace",
+		},
+		{
+			name:      "search root",
+			tool:      "grep",
+			arguments: `{"pattern":"key","path":` + quote(outside) + `}`,
+			wantError: "is outside the workspace",
+		},
+		{
+			name:      "delete",
+			tool:      "delete_file",
+			arguments: `{"path":` + quote(secret) + `}`,
+			wantError: "is outside the workspace",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := executeTool(t, registry, test.tool, cwd, test.arguments)
+			if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Errorf("error = %v, want %q", err, test.wantError)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("file written outside the workspace: %v", err)
+	}
+	if _, err := os.Stat(secret); err != nil {
+		t.Errorf("file deleted outside the workspace: %v", err)
+	}
+}
+
+func TestFileToolsResolveSymlinks(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	cwd := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(cwd, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "missing.txt"), filepath.Join(cwd, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	registry := newRegistry(t)
+
+	if _, err := executeTool(t, registry, "read_file", cwd, `{"path":"escape/secret.txt"}`); err == nil ||
+		!strings.Contains(err.Error(), "resolves through a symlink to") {
+		t.Errorf("read through symlink error = %v", err)
+	}
+	if _, err := executeTool(t, registry, "write_file", cwd, `{"path":"dangling","content":"x"}`); err == nil ||
+		!strings.Contains(err.Error(), "outside the workspace") {
+		t.Errorf("write through dangling symlink error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("file created through a dangling symlink: %v", err)
+	}
+	// Deleting a link removes only the link, which lies inside.
+	if _, err := executeTool(t, registry, "delete_file", cwd, `{"path":"dangling"}`); err != nil {
+		t.Errorf("delete symlink: %v", err)
+	}
+}
+
+func TestApplyPatchChecksBothEndsOfAMove(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+
+	cwd := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cwd, "file.txt"), []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The link's target lies inside, but moving the link changes the outside
+	// directory that holds it.
+	if err := os.Symlink(outside, filepath.Join(cwd, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(cwd, "file.txt"), filepath.Join(outside, "link")); err != nil {
+		t.Fatal(err)
+	}
+	registry := newRegistry(t)
+	move := func(source, destination string) error {
+		patch := "*** Begin Patch\n*** Update File: " + source + "\n*** Move to: " + destination +
+			"\n@@\n-old\n+new\n*** End Patch"
+		_, err := executeTool(t, registry, "apply_patch", cwd, `{"patch":`+quote(patch)+`}`)
+		return err
+	}
+
+	destination := filepath.Join(outside, "moved.txt")
+	if err := move("file.txt", destination); err == nil || !strings.Contains(err.Error(), "update_file "+quote(destination)+":") {
+		t.Errorf("move outside error = %v, want it to name %s", err, destination)
+	}
+	if err := move(filepath.Join("escape", "link"), "moved.txt"); err == nil ||
+		!strings.Contains(err.Error(), "outside the workspace") {
+		t.Errorf("move of a link outside error = %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "link")); err != nil {
+		t.Errorf("link moved out of the outside directory: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(cwd, "file.txt")); err != nil || string(data) != "old\n" {
+		t.Errorf("file.txt = %q, %v; want it unchanged", data, err)
+	}
+}
+
+func TestFileToolsUseWorkspaceRootsAndReadAnywhere(t *testing.T) {
+	t.Parallel()
+
+	cwd := t.TempDir()
+	root := t.TempDir()
+	elsewhere := t.TempDir()
+	if err := os.WriteFile(filepath.Join(elsewhere, "notes.txt"), []byte("notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	registry := agentloop.NewRegistry()
+	processes := builtin.NewProcesses()
+	t.Cleanup(processes.Close)
+	options := builtin.Options{Workspace: builtin.WorkspaceOptions{ReadAnywhere: true}}
+	if err := builtin.RegisterAll(registry, processes, options); err != nil {
+		t.Fatal(err)
+	}
+	callContext := agentloop.CallContext{Cwd: cwd, WorkspaceRoots: []string{root}}
+	execute := func(name, arguments string) (conversation.Content, error) {
+		tool, _ := registry.Get(name)
+		return tool.Execute(t.Context(), callContext, []byte(arguments))
+	}
+
+	if _, err := execute("write_file", `{"path":`+quote(filepath.Join(root, "a.txt"))+`,"content":"a"}`); err != nil {
+		t.Errorf("write inside a workspace root: %v", err)
+	}
+	if _, err := execute("read_file", `{"path":`+quote(filepath.Join(elsewhere, "notes.txt"))+`}`); err != nil {
+		t.Errorf("read outside with read-anywhere: %v", err)
+	}
+	_, err := execute("write_file", `{"path":`+quote(filepath.Join(elsewhere, "notes.txt"))+`,"content":"x"}`)
+	if err == nil || !strings.Contains(err.Error(), "where files are read-only") {
+		t.Errorf("write outside with read-anywhere error = %v", err)
+	}
+
+	patch := "*** Begin Patch\n*** Add File: inside.txt\n+inside\n*** Add File: " +
+		filepath.Join(elsewhere, "outside.txt") + "\n+outside\n*** End Patch"
+	if _, err := execute("apply_patch", `{"patch":`+quote(patch)+`}`); err == nil ||
+		!strings.Contains(err.Error(), "outside the workspace") {
+		t.Errorf("patch outside error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cwd, "inside.txt")); !os.IsNotExist(err) {
+		t.Errorf("patch reaching outside the workspace changed the workspace: %v", err)
+	}
+}
+
+func TestFileToolsIgnoreCaseWhereFileSystemsDo(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		t.Skip("file systems are case-sensitive here")
+	}
+
+	cwd := t.TempDir()
+	path := filepath.Join(strings.ToUpper(cwd), "file.txt")
+	if _, err := executeTool(t, newRegistry(t), "write_file", cwd, `{"path":`+quote(path)+`,"content":"x"}`); err != nil {
+		t.Errorf("write with a differently cased workspace path: %v", err)
+	}
+}
+
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
@@ -2,13 +2,15 @@ package agentloop
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
 	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
 	"github.com/masteryyh/agenty-core/pkg/domain/shared"
 )
@@ -59,44 +61,169 @@ func ShouldCompact(contextTokens, contextWindow int64) bool {
 	return threshold == 0 || contextTokens >= threshold
 }
 
-func estimateRequestTokens(request Request) int64 {
-	tokens := estimateTextTokens(request.SystemPrompt)
-	for _, message := range request.Messages {
-		tokens += estimateMessageTokens(message)
+const (
+	// prunedToolResultsWindowShare keeps the most recent tool output up to a
+	// fifth of the context window in full when pruning.
+	prunedToolResultsWindowShare = 5
+	// minPrunedToolResultTokens leaves short output alone, as its placeholder
+	// would save little.
+	minPrunedToolResultTokens = 100
+)
+
+var errContextTooLarge = errors.New("session context remains too large for target model after compaction")
+
+// fitContextWindow compacts the session when its context would not fit a model
+// with contextWindow, as required before switching to that model.
+func (engine *Engine) fitContextWindow(
+	ctx context.Context,
+	prepared *preparedExecution,
+	contextWindow int64,
+) error {
+	request := engine.sessionRequestForWindow(prepared, contextWindow)
+	if !ShouldCompact(requestTokens(prepared, request), contextWindow) {
+		return nil
+	}
+	if _, err := engine.compactPreparedForWindow(ctx, prepared, conversation.CompactionTriggerModelSwitch, contextWindow); err != nil {
+		return fmt.Errorf("compact session before model switch: %w", err)
 	}
-	for _, tool := range request.Tools {
-		encoded, _ := json.Marshal(tool)
-		tokens += estimateTextTokens(string(encoded))
+	request = engine.sessionRequestForWindow(prepared, contextWindow)
+	if ShouldCompact(estimateRequestTokens(preparedTokenizer(prepared), request), contextWindow) {
+		return errContextTooLarge
 	}
-	return tokens
+	return nil
 }
 
-func estimateMessageTokens(message conversation.Message) int64 {
-	encoded, _ := json.Marshal(message.Content)
-	return 4 + estimateTextTokens(string(encoded))
+func (engine *Engine) compactPrepared(
+	ctx context.Context,
+	prepared *preparedExecution,
+	trigger conversation.CompactionTrigger,
+) (*CompactResult, error) {
+	return engine.compactPreparedForWindow(ctx, prepared, trigger, modelContextWindow(prepared))
 }
 
-func estimateTextTokens(text string) int64 {
-	if text == "" {
-		return 0
+// compactPreparedForWindow first prunes stale tool output and summarizes the
+// session only when that does not bring it under the compaction threshold.
+// Manual compactions always summarize.
+func (engine *Engine) compactPreparedForWindow(
+	ctx context.Context,
+	prepared *preparedExecution,
+	trigger conversation.CompactionTrigger,
+	contextWindow int64,
+) (*CompactResult, error) {
+	if trigger != conversation.CompactionTriggerManual {
+		pruned, err := engine.pruneToolResults(ctx, prepared, trigger, contextWindow)
+		if err != nil {
+			return nil, err
+		}
+		if pruned != nil && !ShouldCompact(pruned.ContextTokensAfter, contextWindow) {
+			return pruned, nil
+		}
 	}
-	return int64((len([]rune(text)) + 3) / 4)
+	return engine.summarize(ctx, prepared, trigger, contextWindow)
 }
 
-func (engine *Engine) compactPrepared(
+// pruneToolResults replaces the output of earlier tool calls with a
+// placeholder, keeping the most recent output in full. It returns nil when no
+// output is worth pruning.
+func (engine *Engine) pruneToolResults(
 	ctx context.Context,
 	prepared *preparedExecution,
 	trigger conversation.CompactionTrigger,
-) (*conversation.SessionCompacted, error) {
-	return engine.compactPreparedForWindow(ctx, prepared, trigger, modelContextWindow(prepared))
+	contextWindow int64,
+) (*CompactResult, error) {
+	if contextWindow <= 0 {
+		return nil, nil
+	}
+	request := engine.sessionRequestForWindow(prepared, contextWindow)
+	tokenizer := preparedTokenizer(prepared)
+	toolUseIDs := staleToolResults(tokenizer, request.Messages, contextWindow/prunedToolResultsWindowShare)
+	if len(toolUseIDs) == 0 {
+		return nil, nil
+	}
+
+	contextTokensBefore := requestTokens(prepared, request)
+	compactionID := uuid.Must(uuid.NewV7())
+	if err := engine.emitCompaction(ctx, CompactionEvent{
+		Type:                CompactionEventStarted,
+		SessionID:           prepared.session.ID,
+		CompactionID:        compactionID,
+		Trigger:             trigger,
+		Tier:                CompactionTierPrune,
+		ContextTokensBefore: contextTokensBefore,
+	}); err != nil {
+		return nil, err
+	}
+	event, err := prepared.session.PruneToolResults(conversation.PruneInput{
+		CompactionID:        compactionID,
+		Trigger:             trigger,
+		ToolUseIDs:          toolUseIDs,
+		ContextTokensBefore: contextTokensBefore,
+	})
+	if err != nil {
+		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, CompactionTierPrune, err)
+		return nil, fmt.Errorf("record pruned tool results: %w", err)
+	}
+	prepared.calibration = nil
+	if err := engine.saveProgress(ctx, prepared.session); err != nil {
+		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, CompactionTierPrune, err)
+		return nil, fmt.Errorf("save pruned tool results: %w", err)
+	}
+
+	result := &CompactResult{
+		SessionID:           event.SessionID,
+		CompactionID:        event.CompactionID,
+		Trigger:             event.Trigger,
+		Tier:                CompactionTierPrune,
+		ContextTokensBefore: event.ContextTokensBefore,
+		ContextTokensAfter:  estimateRequestTokens(tokenizer, engine.sessionRequestForWindow(prepared, contextWindow)),
+	}
+	if err := engine.emitCompaction(ctx, CompactionEvent{
+		Type:                CompactionEventCompleted,
+		SessionID:           result.SessionID,
+		CompactionID:        result.CompactionID,
+		Trigger:             result.Trigger,
+		Tier:                result.Tier,
+		ContextTokensBefore: result.ContextTokensBefore,
+		ContextTokensAfter:  result.ContextTokensAfter,
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-func (engine *Engine) compactPreparedForWindow(
+// staleToolResults returns the tool calls whose output is older than the most
+// recent protectedTokens of tool output, leaving out output too short to be
+// worth a placeholder.
+func staleToolResults(tokenizer Tokenizer, messages []conversation.Message, protectedTokens int64) []string {
+	var recent int64
+	var stale []string
+	for index := len(messages) - 1; index >= 0; index-- {
+		for _, block := range messages[index].Content {
+			result, ok := block.(conversation.ToolResultBlock)
+			if !ok || conversation.IsPrunedToolResult(result) {
+				continue
+			}
+			tokens := estimateContentTokens(tokenizer, result.Content)
+			if recent < protectedTokens {
+				recent += tokens
+				continue
+			}
+			if tokens >= minPrunedToolResultTokens {
+				stale = append(stale, result.ToolUseID)
+			}
+		}
+	}
+	slices.Reverse(stale)
+	return stale
+}
+
+// summarize replaces the session context with a summary written by the model.
+func (engine *Engine) summarize(
 	ctx context.Context,
 	prepared *preparedExecution,
 	trigger conversation.CompactionTrigger,
 	contextWindow int64,
-) (*conversation.SessionCompacted, error) {
+) (*CompactResult, error) {
 	baseMessages := sessionMessages(prepared.session)
 	if len(baseMessages) == 0 {
 		return nil, fmt.Errorf("cannot compact an empty session")
@@ -105,31 +232,35 @@ func (engine *Engine) compactPreparedForWindow(
 	baseRequest := Request{
 		SystemPrompt:    prepared.systemPrompt,
 		Messages:        baseMessages,
-		Tools:           engine.tools.Definitions(),
+		Tools:           engine.toolDefinitions(prepared),
 		MaxOutputTokens: prepared.maxOutputTokens,
 		ReasoningEffort: preparedReasoningEffort(prepared),
+		CacheKey:        prepared.session.ID.String(),
 	}
-	contextTokensBefore := estimateRequestTokens(baseRequest)
+	contextTokensBefore := requestTokens(prepared, baseRequest)
+	hooked := engine.runHooks(ctx, prepared, HookInput{Event: HookPreCompaction, Trigger: trigger})
+	baseRequest.Messages = append(baseRequest.Messages, hookContextMessages(HookPreCompaction, hooked.Context)...)
 	compactionID := uuid.Must(uuid.NewV7())
 	if err := engine.emitCompaction(ctx, CompactionEvent{
 		Type:                CompactionEventStarted,
 		SessionID:           prepared.session.ID,
 		CompactionID:        compactionID,
 		Trigger:             trigger,
+		Tier:                CompactionTierSummary,
 		ContextTokensBefore: contextTokensBefore,
 	}); err != nil {
 		return nil, err
 	}
 
-	response, err := engine.invokeCompaction(ctx, prepared, compactionID, baseRequest)
+	response, err := engine.invokeSummary(ctx, prepared, compactionID, baseRequest)
 	if err != nil {
-		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, err)
+		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, CompactionTierSummary, err)
 		return nil, fmt.Errorf("invoke compaction conversation: %w", err)
 	}
 
 	summary, err := textFromContent(response.Content)
 	if err != nil {
-		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, err)
+		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, CompactionTierSummary, err)
 		return nil, fmt.Errorf("read compaction summary: %w", err)
 	}
 	event, err := prepared.session.Compact(conversation.CompactionInput{
@@ -140,34 +271,65 @@ func (engine *Engine) compactPreparedForWindow(
 		Usage:               response.Usage,
 	})
 	if err != nil {
-		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, err)
+		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, CompactionTierSummary, err)
 		return nil, fmt.Errorf("record compaction: %w", err)
 	}
+	prepared.calibration = nil
 	compactedRequest := engine.sessionRequestForWindow(prepared, contextWindow)
-	event.ContextTokensAfter = estimateRequestTokens(compactedRequest)
+	event.ContextTokensAfter = estimateRequestTokens(preparedTokenizer(prepared), compactedRequest)
 
 	if err := engine.saveProgress(ctx, prepared.session); err != nil {
-		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, err)
+		engine.emitCompactionFailure(ctx, prepared.session.ID, compactionID, trigger, CompactionTierSummary, err)
 		return nil, fmt.Errorf("save compaction: %w", err)
 	}
-	usage := event.Usage
-	if err := engine.emitCompaction(ctx, CompactionEvent{
-		Type:                CompactionEventCompleted,
-		SessionID:           prepared.session.ID,
+	result := &CompactResult{
+		SessionID:           event.SessionID,
 		CompactionID:        event.CompactionID,
 		Trigger:             event.Trigger,
+		Tier:                CompactionTierSummary,
 		ContextTokensBefore: event.ContextTokensBefore,
 		ContextTokensAfter:  event.ContextTokensAfter,
+		Usage:               event.Usage,
+	}
+	usage := result.Usage
+	if err := engine.emitCompaction(ctx, CompactionEvent{
+		Type:                CompactionEventCompleted,
+		SessionID:           result.SessionID,
+		CompactionID:        result.CompactionID,
+		Trigger:             result.Trigger,
+		Tier:                result.Tier,
+		ContextTokensBefore: result.ContextTokensBefore,
+		ContextTokensAfter:  result.ContextTokensAfter,
 		Usage:               &usage,
 	}); err != nil {
 		return nil, err
 	}
-	return &event, nil
+	return result, nil
+}
+
+// invokeSummary asks the light model for the summary when it can read the
+// whole request, and the session model otherwise or when the light one fails.
+func (engine *Engine) invokeSummary(
+	ctx context.Context,
+	prepared *preparedExecution,
+	compactionID uuid.UUID,
+	baseRequest Request,
+) (*Response, error) {
+	if light := engine.summaryModel(ctx, prepared, baseRequest); light != nil {
+		response, err := engine.invokeCompaction(ctx, prepared, light.caller, compactionID, lightRequest(light, baseRequest))
+		if err == nil || ctx.Err() != nil {
+			return response, err
+		}
+		engine.logger.WarnContext(ctx, "light model failed to summarize, using the session model",
+			"sessionId", prepared.session.ID, "model", light.ref.String(), "error", err)
+	}
+	return engine.invokeCompaction(ctx, prepared, prepared.caller, compactionID, baseRequest)
 }
 
 func (engine *Engine) invokeCompaction(
 	ctx context.Context,
 	prepared *preparedExecution,
+	caller Caller,
 	compactionID uuid.UUID,
 	baseRequest Request,
 ) (*Response, error) {
@@ -182,10 +344,16 @@ func (engine *Engine) invokeCompaction(
 	})
 
 	var totalUsage conversation.TokenUsage
-	for iteration := 1; iteration <= maxAgentLoopIterations; iteration++ {
+	for iteration := 1; iteration <= defaultMaxIterations; iteration++ {
 		request := baseRequest
 		request.Messages = messages
-		response, err := prepared.caller.Invoke(ctx, request)
+		response, err := engine.withRetry(ctx, func(int) (*Response, error) {
+			return caller.Invoke(ctx, request)
+		}, func(retry ModelRetry) error {
+			engine.logger.WarnContext(ctx, "retrying compaction model call",
+				"sessionId", prepared.session.ID, "attempt", retry.Attempt, "delayMs", retry.DelayMs, "reason", retry.Reason)
+			return nil
+		})
 		if err != nil {
 			return nil, fmt.Errorf("invoke compaction iteration %d: %w", iteration, err)
 		}
@@ -212,11 +380,7 @@ func (engine *Engine) invokeCompaction(
 			CreatedAt: time.Now().UTC(),
 		})
 
-		results := engine.tools.ExecuteBatch(ctx, CallContext{
-			SessionID: prepared.session.ID,
-			RoundID:   compactionID,
-			Cwd:       sessionCwd(prepared.session),
-		}, calls)
+		results, hookContext := engine.executeCompactionTools(ctx, prepared, compactionID, calls)
 		markNativeShellResults(response.Content, results)
 		if err := ctx.Err(); err != nil {
 			return nil, err
@@ -232,9 +396,54 @@ func (engine *Engine) invokeCompaction(
 			Content:   content,
 			CreatedAt: time.Now().UTC(),
 		})
+		messages = append(messages, hiddenUserMessages(hookContext)...)
 	}
 
-	return nil, fmt.Errorf("compaction conversation exceeded %d iterations", maxAgentLoopIterations)
+	return nil, fmt.Errorf("compaction conversation exceeded %d iterations", defaultMaxIterations)
+}
+
+// executeCompactionTools runs only the calls allowed without approval, since
+// nobody is asked to review tool calls made while summarizing. The tool hooks
+// run as they do in a round; their context is returned.
+func (engine *Engine) executeCompactionTools(
+	ctx context.Context,
+	prepared *preparedExecution,
+	compactionID uuid.UUID,
+	calls []conversation.ToolUseBlock,
+) ([]conversation.ToolResultBlock, []conversation.Content) {
+	results := make([]conversation.ToolResultBlock, len(calls))
+	allowed := make([]conversation.ToolUseBlock, 0, len(calls))
+	allowedIndexes := make([]int, 0, len(calls))
+	var hookContext []conversation.Content
+	for index, call := range calls {
+		if !engine.toolAvailable(prepared, call.Name) || engine.toolPolicy(prepared, call.Name) != agent.ToolPolicyAllow {
+			results[index] = toolErrorResult(call, fmt.Sprintf("tool %q cannot run during compaction", call.Name))
+			continue
+		}
+		call, blocked, contexts := engine.preToolUse(ctx, prepared, call)
+		hookContext = append(hookContext, contexts...)
+		if blocked != nil {
+			results[index] = *blocked
+			continue
+		}
+		allowed = append(allowed, call)
+		allowedIndexes = append(allowedIndexes, index)
+	}
+	if len(allowed) == 0 {
+		return results, hookContext
+	}
+
+	executed := engine.tools.ExecuteBatch(ctx, CallContext{
+		SessionID:      prepared.session.ID,
+		RoundID:        compactionID,
+		Cwd:            sessionCwd(prepared.session),
+		WorkspaceRoots: prepared.session.WorkspaceRoots,
+	}, allowed)
+	for position, index := range allowedIndexes {
+		results[index] = executed[position]
+		hookContext = append(hookContext, engine.postToolUse(ctx, prepared, allowed[position], &results[index])...)
+	}
+	return results, hookContext
 }
 
 func preparedReasoningEffort(prepared *preparedExecution) shared.ReasoningEffort {
@@ -263,6 +472,7 @@ func (engine *Engine) emitCompactionFailure(
 	sessionID uuid.UUID,
 	compactionID uuid.UUID,
 	trigger conversation.CompactionTrigger,
+	tier CompactionTier,
 	err error,
 ) {
 	if emitErr := engine.emitCompaction(ctx, CompactionEvent{
@@ -270,6 +480,7 @@ func (engine *Engine) emitCompactionFailure(
 		SessionID:    sessionID,
 		CompactionID: compactionID,
 		Trigger:      trigger,
+		Tier:         tier,
 		Error:        err.Error(),
 	}); emitErr != nil {
 		engine.logger.WarnContext(ctx, "failed to emit compaction failure", "error", emitErr)
@@ -291,13 +502,13 @@ func textFromContent(content conversation.Content) (string, error) {
 	return text, nil
 }
 
-func fitCompactedRequest(request Request, contextWindow int64) Request {
+func fitCompactedRequest(tokenizer Tokenizer, request Request, contextWindow int64) Request {
 	if contextWindow <= 0 || !hasCompactionSummary(request.Messages) {
 		return request
 	}
 
 	limit := CompactionThreshold(contextWindow)
-	for estimateRequestTokens(request) >= limit {
+	for estimateRequestTokens(tokenizer, request) >= limit {
 		removeIndex := retainedMessageIndex(request.Messages, "retained_assistant")
 		if removeIndex < 0 {
 			removeIndex = retainedMessageIndex(request.Messages, "retained_user")
@@ -37,7 +37,7 @@ func TestFitCompactedRequestDropsAssistantContextBeforeUserContext(t *testing.T)
 		},
 	}
 
-	fitted := fitCompactedRequest(request, 100)
+	fitted := fitCompactedRequest(estimatedTokenizer{}, request, 100)
 	if len(fitted.Messages) != 3 {
 		t.Fatalf("fitted messages = %+v, want retained user, summary, metadata", fitted.Messages)
 	}
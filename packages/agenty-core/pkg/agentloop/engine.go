@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"sync"
 
 	"github.com/google/uuid"
@@ -18,13 +19,12 @@ import (
 
 const (
 	DefaultMaxOutputTokens int64 = catalog.DefaultMaxOutputTokens
-
-	maxAgentLoopIterations = 20
 )
 
 type ExecutionSessionRepository interface {
 	Load(ctx context.Context, id uuid.UUID) (*conversation.Session, error)
 	Save(ctx context.Context, session *conversation.Session) error
+	Delete(ctx context.Context, id uuid.UUID) error
 }
 
 type ExecutionAgentRepository interface {
@@ -49,18 +49,50 @@ type Dependencies struct {
 	NewCaller   CallerFactory
 	Events      SessionEventHandler
 	Compactions CompactionEventHandler
+	// Queue receives changes to the per-session prompt queues.
+	Queue QueueEventHandler
+	// MaxDelegationDepth limits nested sub-agent sessions; zero selects the
+	// default of 2.
+	MaxDelegationDepth int
+	// ToolPolicies sets the default policy per tool name. Agents may override
+	// individual tools, and tools absent from both are allowed.
+	ToolPolicies map[string]agent.ToolPolicy
+	// Retry controls how transient model call failures are repeated; zero
+	// fields fall back to DefaultRetryPolicy.
+	Retry RetryPolicy
+	// CircuitBreaker decides when a failing provider is skipped in favour of
+	// agent fallback models; zero fields fall back to
+	// DefaultCircuitBreakerPolicy.
+	CircuitBreaker CircuitBreakerPolicy
+	// Hooks runs user commands around tool calls, rounds and compactions;
+	// nil disables hooks.
+	Hooks HookRunner
+	// Tokenizers selects how prompt tokens are counted per model; nil
+	// estimates every model from the UTF-8 width of its text.
+	Tokenizers TokenizerFactory
+	// GenerateTitles names untitled sessions with the light model after
+	// their first completed round.
+	GenerateTitles bool
+	// Resources holds what tools leave running, such as background
+	// processes; the engine releases it on shutdown. It may be nil.
+	Resources SessionResources
 }
 
 type StartResult struct {
 	SessionID uuid.UUID                `json:"sessionId"`
 	RoundID   uuid.UUID                `json:"roundId"`
 	Status    conversation.RoundStatus `json:"status"`
+	// QueuedPrompt and QueuePosition are set instead of RoundID when the
+	// content was queued behind a running round.
+	QueuedPrompt  *QueuedPrompt `json:"queuedPrompt,omitempty"`
+	QueuePosition int           `json:"queuePosition,omitempty"`
 }
 
 type CompactResult struct {
 	SessionID           uuid.UUID                      `json:"sessionId"`
 	CompactionID        uuid.UUID                      `json:"compactionId"`
 	Trigger             conversation.CompactionTrigger `json:"trigger"`
+	Tier                CompactionTier                 `json:"tier"`
 	ContextTokensBefore int64                          `json:"contextTokensBefore"`
 	ContextTokensAfter  int64                          `json:"contextTokensAfter"`
 	Usage               conversation.TokenUsage        `json:"usage"`
@@ -73,27 +105,41 @@ type StopResult struct {
 }
 
 type activeExecution struct {
-	roundID uuid.UUID
-	cancel  context.CancelFunc
+	roundID        uuid.UUID
+	cancel         context.CancelFunc
+	approval       *pendingApproval
+	tools          *runningToolCalls
+	steering       []conversation.Content
+	steeringClosed bool
 }
 
 type Engine struct {
-	ctx         context.Context
-	cancel      context.CancelFunc
-	sessions    ExecutionSessionRepository
-	agents      ExecutionAgentRepository
-	catalog     ExecutionCatalogRepository
-	tools       ToolRuntime
-	newCaller   CallerFactory
-	events      SessionEventHandler
-	compactions CompactionEventHandler
-	logger      *slog.Logger
-	mu          sync.Mutex
-	active      map[uuid.UUID]*activeExecution
-	waitGroup   sync.WaitGroup
-	shutdown    bool
-	stopOnce    sync.Once
-	stopped     chan struct{}
+	ctx                context.Context
+	cancel             context.CancelFunc
+	sessions           ExecutionSessionRepository
+	agents             ExecutionAgentRepository
+	catalog            ExecutionCatalogRepository
+	tools              ToolRuntime
+	newCaller          CallerFactory
+	events             SessionEventHandler
+	compactions        CompactionEventHandler
+	queueEvents        QueueEventHandler
+	toolPolicies       map[string]agent.ToolPolicy
+	maxDelegationDepth int
+	retry              RetryPolicy
+	breaker            *circuitBreaker
+	hooks              HookRunner
+	newTokenizer       TokenizerFactory
+	generateTitles     bool
+	resources          SessionResources
+	logger             *slog.Logger
+	mu                 sync.Mutex
+	active             map[uuid.UUID]*activeExecution
+	queues             map[uuid.UUID][]QueuedPrompt
+	waitGroup          sync.WaitGroup
+	shutdown           bool
+	stopOnce           sync.Once
+	stopped            chan struct{}
 }
 
 func NewEngine(parentCtx context.Context, dependencies Dependencies) (*Engine, error) {
@@ -115,21 +161,47 @@ func NewEngine(parentCtx context.Context, dependencies Dependencies) (*Engine, e
 	if dependencies.NewCaller == nil {
 		return nil, apperrors.Validation("LLM caller factory must not be nil")
 	}
+	if err := agent.ValidateToolPolicies(dependencies.ToolPolicies); err != nil {
+		return nil, apperrors.Validation(err.Error())
+	}
+	if dependencies.MaxDelegationDepth < 0 {
+		return nil, apperrors.Validation("max delegation depth must not be negative")
+	}
+	if err := dependencies.Retry.Validate(); err != nil {
+		return nil, apperrors.Validation(err.Error())
+	}
+	if err := dependencies.CircuitBreaker.Validate(); err != nil {
+		return nil, apperrors.Validation(err.Error())
+	}
+	maxDelegationDepth := dependencies.MaxDelegationDepth
+	if maxDelegationDepth == 0 {
+		maxDelegationDepth = defaultMaxDelegationDepth
+	}
 
 	ctx, cancel := context.WithCancel(parentCtx)
 	return &Engine{
-		ctx:         ctx,
-		cancel:      cancel,
-		sessions:    dependencies.Sessions,
-		agents:      dependencies.Agents,
-		catalog:     dependencies.Catalog,
-		tools:       dependencies.Tools,
-		newCaller:   dependencies.NewCaller,
-		events:      dependencies.Events,
-		compactions: dependencies.Compactions,
-		logger:      slog.Default(),
-		active:      make(map[uuid.UUID]*activeExecution),
-		stopped:     make(chan struct{}),
+		ctx:                ctx,
+		cancel:             cancel,
+		sessions:           dependencies.Sessions,
+		agents:             dependencies.Agents,
+		catalog:            dependencies.Catalog,
+		tools:              dependencies.Tools,
+		newCaller:          dependencies.NewCaller,
+		events:             dependencies.Events,
+		compactions:        dependencies.Compactions,
+		queueEvents:        dependencies.Queue,
+		toolPolicies:       maps.Clone(dependencies.ToolPolicies),
+		maxDelegationDepth: maxDelegationDepth,
+		retry:              dependencies.Retry.withDefaults(),
+		breaker:            newCircuitBreaker(dependencies.CircuitBreaker.withDefaults()),
+		hooks:              dependencies.Hooks,
+		newTokenizer:       dependencies.Tokenizers,
+		generateTitles:     dependencies.GenerateTitles,
+		resources:          dependencies.Resources,
+		logger:             slog.Default(),
+		active:             make(map[uuid.UUID]*activeExecution),
+		queues:             make(map[uuid.UUID][]QueuedPrompt),
+		stopped:            make(chan struct{}),
 	}, nil
 }
 
@@ -150,6 +222,36 @@ func (engine *Engine) Start(
 	if err != nil {
 		return nil, err
 	}
+	return engine.launch(ctx, runCtx, id, execution, userPrompt(content))
+}
+
+// launch prepares a round on a reserved execution and runs it in the
+// background, releasing the reservation if preparation fails.
+func (engine *Engine) launch(
+	ctx context.Context,
+	runCtx context.Context,
+	id uuid.UUID,
+	execution *activeExecution,
+	opening roundOpening,
+) (*StartResult, error) {
+	session, err := engine.loadSession(ctx, id)
+	if err != nil {
+		engine.release(id, execution)
+		return nil, err
+	}
+	return engine.launchSession(ctx, runCtx, session, execution, opening)
+}
+
+// launchSession is launch for a session already loaded, whose unsaved changes
+// are saved with the opened round.
+func (engine *Engine) launchSession(
+	ctx context.Context,
+	runCtx context.Context,
+	session *conversation.Session,
+	execution *activeExecution,
+	opening roundOpening,
+) (*StartResult, error) {
+	id := session.ID
 	launched := false
 	defer func() {
 		if !launched {
@@ -157,7 +259,7 @@ func (engine *Engine) Start(
 		}
 	}()
 
-	prepared, err := engine.prepare(ctx, runCtx, id, content)
+	prepared, err := engine.prepareSession(ctx, runCtx, session, opening)
 	if err != nil {
 		return nil, err
 	}
@@ -216,12 +318,9 @@ func (engine *Engine) Compact(
 	}
 	defer engine.release(id, execution)
 
-	session, err := engine.sessions.Load(ctx, id)
+	session, err := engine.loadSession(ctx, id)
 	if err != nil {
-		if errors.Is(err, conversation.ErrSessionNotFound) {
-			return nil, apperrors.NotFound("session " + id.String() + " not found")
-		}
-		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to load session", err)
+		return nil, err
 	}
 	resources, err := engine.loadResources(ctx, runCtx, session)
 	if err != nil {
@@ -229,23 +328,16 @@ func (engine *Engine) Compact(
 	}
 	prepared := &preparedExecution{
 		session:         session,
+		agentDefinition: resources.agentDefinition,
 		model:           resources.model,
+		modelRef:        *session.CurrentModel,
 		caller:          resources.caller,
+		apiType:         resources.apiType,
+		tokenizer:       resources.tokenizer,
 		systemPrompt:    resources.systemPrompt,
 		maxOutputTokens: DefaultMaxOutputTokens,
 	}
-	event, err := engine.compactPrepared(runCtx, prepared, conversation.CompactionTriggerManual)
-	if err != nil {
-		return nil, err
-	}
-	return &CompactResult{
-		SessionID:           event.SessionID,
-		CompactionID:        event.CompactionID,
-		Trigger:             event.Trigger,
-		ContextTokensBefore: event.ContextTokensBefore,
-		ContextTokensAfter:  event.ContextTokensAfter,
-		Usage:               event.Usage,
-	}, nil
+	return engine.compactPrepared(runCtx, prepared, conversation.CompactionTriggerManual)
 }
 
 func (engine *Engine) SetModel(
@@ -273,63 +365,80 @@ func (engine *Engine) SetModel(
 	}
 	defer engine.release(id, execution)
 
-	session, err := engine.sessions.Load(ctx, id)
+	session, err := engine.loadSession(ctx, id)
 	if err != nil {
-		if errors.Is(err, conversation.ErrSessionNotFound) {
-			return nil, apperrors.NotFound("session " + id.String() + " not found")
-		}
-		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to load session", err)
+		return nil, err
 	}
-	if session.CurrentModel != nil &&
-		session.CurrentModel.ProviderCode == providerCodeValue &&
-		session.CurrentModel.ModelCode == modelCodeValue {
-		return session.VisibleCopy(), nil
+	if err := engine.changeModel(ctx, runCtx, session, shared.NewModelRef(providerCodeValue, modelCodeValue)); err != nil {
+		return nil, err
 	}
+	return session.VisibleCopy(), nil
+}
 
-	source, err := engine.loadResources(ctx, runCtx, session)
-	if err != nil {
-		return nil, err
+// changeModel switches a reserved session to another catalog model with
+// setModel and saves the result.
+func (engine *Engine) changeModel(
+	ctx context.Context,
+	runCtx context.Context,
+	session *conversation.Session,
+	targetRef shared.ModelRef,
+) error {
+	if err := engine.setModel(ctx, runCtx, session, targetRef); err != nil {
+		return err
+	}
+	if err := engine.saveProgress(ctx, session); err != nil {
+		return apperrors.WrapError(apperrors.CodeInternal, "save session model", err)
 	}
-	targetRef := shared.NewModelRef(providerCodeValue, modelCodeValue)
-	_, targetModel, err := engine.loadCatalogModel(ctx, targetRef)
+	return nil
+}
+
+// setModel switches a reserved session to another catalog model, first
+// compacting its context when it does not fit the target's window. Only the
+// compaction saves the session.
+func (engine *Engine) setModel(
+	ctx context.Context,
+	runCtx context.Context,
+	session *conversation.Session,
+	targetRef shared.ModelRef,
+) error {
+	if session.CurrentModel != nil && *session.CurrentModel == targetRef {
+		return nil
+	}
+
+	targetProvider, targetModel, err := engine.loadCatalogModel(ctx, targetRef)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	targetContextWindow := int64(targetModel.ContextWindow)
 	if targetContextWindow <= 0 {
-		return nil, apperrors.Validation("target model context window must be positive")
+		return apperrors.Validation("target model context window must be positive")
 	}
-	if session.CurrentModel == nil || session.CurrentModel.IsZero() {
-		session.SetModel(targetRef, targetContextWindow)
-		if err := engine.saveProgress(ctx, session); err != nil {
-			return nil, apperrors.WrapError(apperrors.CodeInternal, "save session model", err)
+	if session.CurrentModel != nil && !session.CurrentModel.IsZero() {
+		source, err := engine.loadResources(ctx, runCtx, session)
+		if err != nil {
+			return err
 		}
-		return session.VisibleCopy(), nil
-	}
-
-	prepared := &preparedExecution{
-		session:         session,
-		model:           source.model,
-		caller:          source.caller,
-		systemPrompt:    source.systemPrompt,
-		maxOutputTokens: DefaultMaxOutputTokens,
-	}
-	request := engine.sessionRequestForWindow(prepared, targetContextWindow)
-	if ShouldCompact(estimateRequestTokens(request), targetContextWindow) {
-		if _, err := engine.compactPreparedForWindow(runCtx, prepared, conversation.CompactionTriggerModelSwitch, targetContextWindow); err != nil {
-			return nil, fmt.Errorf("compact session before model switch: %w", err)
+		prepared := &preparedExecution{
+			session:         session,
+			agentDefinition: source.agentDefinition,
+			model:           source.model,
+			modelRef:        *session.CurrentModel,
+			caller:          source.caller,
+			apiType:         targetProvider.Type,
+			tokenizer:       engine.tokenizer(*targetProvider, *targetModel),
+			systemPrompt:    source.systemPrompt,
+			maxOutputTokens: DefaultMaxOutputTokens,
 		}
-		request = engine.sessionRequestForWindow(prepared, targetContextWindow)
-		if ShouldCompact(estimateRequestTokens(request), targetContextWindow) {
-			return nil, apperrors.Validation("session context remains too large for target model after compaction")
+		if err := engine.fitContextWindow(runCtx, prepared, targetContextWindow); err != nil {
+			if errors.Is(err, errContextTooLarge) {
+				return apperrors.Validation(err.Error())
+			}
+			return err
 		}
 	}
 
 	session.SetModel(targetRef, targetContextWindow)
-	if err := engine.saveProgress(ctx, session); err != nil {
-		return nil, apperrors.WrapError(apperrors.CodeInternal, "save session model", err)
-	}
-	return session.VisibleCopy(), nil
+	return nil
 }
 
 func (engine *Engine) IsRunning(sessionID uuid.UUID) bool {
@@ -354,6 +463,32 @@ func (engine *Engine) ExecuteSessionIfIdle(
 	return true, execute()
 }
 
+// DeleteSessionIfIdle runs deleteSession like ExecuteSessionIfIdle and, once
+// it succeeds, drops the prompts still queued for the session.
+func (engine *Engine) DeleteSessionIfIdle(
+	ctx context.Context,
+	sessionID uuid.UUID,
+	deleteSession func() error,
+) (bool, error) {
+	engine.mu.Lock()
+	if _, running := engine.active[sessionID]; running {
+		engine.mu.Unlock()
+		return false, nil
+	}
+	if err := deleteSession(); err != nil {
+		engine.mu.Unlock()
+		return true, err
+	}
+	_, queued := engine.queues[sessionID]
+	engine.setQueueLocked(sessionID, nil)
+	engine.mu.Unlock()
+
+	if queued {
+		engine.emitQueue(ctx, QueueEvent{Type: QueueEventCleared, SessionID: sessionID, Prompts: []QueuedPrompt{}})
+	}
+	return true, nil
+}
+
 func (engine *Engine) Shutdown(ctx context.Context) error {
 	engine.stopOnce.Do(func() {
 		engine.mu.Lock()
@@ -363,6 +498,9 @@ func (engine *Engine) Shutdown(ctx context.Context) error {
 			execution.cancel()
 		}
 		engine.mu.Unlock()
+		if engine.resources != nil {
+			engine.resources.Close()
+		}
 
 		go func() {
 			engine.waitGroup.Wait()
@@ -391,45 +529,66 @@ func (engine *Engine) reserve(
 		return nil, nil, apperrors.AlreadyExists("session " + sessionID.String() + " is already running")
 	}
 
+	runCtx, execution := engine.reserveLocked(sessionID)
+	return runCtx, execution, nil
+}
+
+func (engine *Engine) reserveLocked(sessionID uuid.UUID) (context.Context, *activeExecution) {
 	runCtx, cancel := context.WithCancel(engine.ctx)
 	execution := &activeExecution{cancel: cancel}
 	engine.active[sessionID] = execution
 	engine.waitGroup.Add(1)
-
-	return runCtx, execution, nil
+	return runCtx, execution
 }
 
 type preparedExecution struct {
 	session         *conversation.Session
+	agentDefinition *agent.Agent
 	roundID         uuid.UUID
 	model           catalog.Model
+	// modelRef names model, which differs from the round's model once the
+	// round has fallen back; fallbacks holds the untried rest of the chain.
+	modelRef        shared.ModelRef
+	fallbacks       []shared.ModelRef
 	caller          Caller
+	apiType         catalog.APIType
+	tokenizer       Tokenizer
 	systemPrompt    string
 	maxOutputTokens int64
 	userMessage     conversation.Message
 	eventSequence   uint64
+	// calibration holds the prompt size reported for the last model call.
+	calibration *tokenCalibration
 }
 
 type executionResources struct {
-	model        catalog.Model
-	caller       Caller
-	systemPrompt string
+	agentDefinition *agent.Agent
+	model           catalog.Model
+	caller          Caller
+	apiType         catalog.APIType
+	tokenizer       Tokenizer
+	systemPrompt    string
 }
 
 func (engine *Engine) prepare(
 	ctx context.Context,
 	runCtx context.Context,
 	sessionID uuid.UUID,
-	content conversation.Content,
+	opening roundOpening,
 ) (*preparedExecution, error) {
-	session, err := engine.sessions.Load(ctx, sessionID)
+	session, err := engine.loadSession(ctx, sessionID)
 	if err != nil {
-		if errors.Is(err, conversation.ErrSessionNotFound) {
-			return nil, apperrors.NotFound("session " + sessionID.String() + " not found")
-		}
-		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to load session", err)
+		return nil, err
 	}
+	return engine.prepareSession(ctx, runCtx, session, opening)
+}
 
+func (engine *Engine) prepareSession(
+	ctx context.Context,
+	runCtx context.Context,
+	session *conversation.Session,
+	opening roundOpening,
+) (*preparedExecution, error) {
 	resources, err := engine.loadResources(ctx, runCtx, session)
 	if err != nil {
 		return nil, err
@@ -454,7 +613,7 @@ func (engine *Engine) prepare(
 		}
 	}
 
-	userMessage, err := session.AppendUserMessage(roundID, content)
+	userMessage, err := opening(session, roundID)
 	if err != nil {
 		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to append user message", err)
 	}
@@ -465,9 +624,14 @@ func (engine *Engine) prepare(
 
 	return &preparedExecution{
 		session:         session,
+		agentDefinition: resources.agentDefinition,
 		roundID:         roundID,
 		model:           resources.model,
+		modelRef:        round.Model,
+		fallbacks:       fallbackModels(resources.agentDefinition, round.Model),
 		caller:          resources.caller,
+		apiType:         resources.apiType,
+		tokenizer:       resources.tokenizer,
 		systemPrompt:    resources.systemPrompt,
 		maxOutputTokens: DefaultMaxOutputTokens,
 		userMessage:     userMessage,
@@ -503,7 +667,14 @@ func (engine *Engine) loadResources(
 	if err != nil {
 		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to create LLM caller", err)
 	}
-	return &executionResources{model: *model, caller: caller, systemPrompt: systemPrompt}, nil
+	return &executionResources{
+		agentDefinition: agentDefinition,
+		model:           *model,
+		caller:          caller,
+		apiType:         provider.Type,
+		tokenizer:       engine.tokenizer(*provider, *model),
+		systemPrompt:    systemPrompt,
+	}, nil
 }
 
 func (engine *Engine) loadCatalogModel(
@@ -535,11 +706,12 @@ func (engine *Engine) sessionRequestForWindow(prepared *preparedExecution, conte
 	request := Request{
 		SystemPrompt:    prepared.systemPrompt,
 		Messages:        sessionMessages(prepared.session),
-		Tools:           engine.tools.Definitions(),
+		Tools:           engine.toolDefinitions(prepared),
 		MaxOutputTokens: prepared.maxOutputTokens,
 		ReasoningEffort: preparedReasoningEffort(prepared),
+		CacheKey:        prepared.session.ID.String(),
 	}
-	return fitCompactedRequest(request, contextWindow)
+	return fitCompactedRequest(preparedTokenizer(prepared), request, contextWindow)
 }
 
 func (engine *Engine) run(
@@ -548,9 +720,12 @@ func (engine *Engine) run(
 	execution *activeExecution,
 	prepared *preparedExecution,
 ) {
-	defer engine.release(sessionID, execution)
-
 	status := conversation.RoundCompleted
+	// Only a completed round starts the next queued prompt.
+	defer func() {
+		engine.releaseExecution(sessionID, execution, status == conversation.RoundCompleted)
+	}()
+
 	usage := conversation.TokenUsage{}
 	var runErr error
 
@@ -569,6 +744,13 @@ func (engine *Engine) run(
 		engine.finish(ctx, prepared, status, usage, runErr)
 		return
 	}
+	started := engine.runHooks(ctx, prepared, HookInput{Event: HookRoundStart})
+	if err := engine.appendHookContext(ctx, prepared, hookContexts(HookRoundStart, started.Context)); err != nil {
+		runErr = err
+		status = conversation.RoundFailed
+		engine.finish(ctx, prepared, status, usage, runErr)
+		return
+	}
 
 	defer func() {
 		if recovered := recover(); recovered != nil {
@@ -582,36 +764,53 @@ func (engine *Engine) run(
 		engine.finish(ctx, prepared, status, usage, runErr)
 	}()
 
-	usage, runErr = engine.executeLoop(ctx, prepared)
-	if runErr != nil {
+	usage, runErr = engine.executeLoop(ctx, execution, prepared)
+	var exceeded *budgetExceededError
+	switch {
+	case errors.As(runErr, &exceeded):
+		status = conversation.RoundBudgetExceeded
+	case runErr != nil:
 		status = conversation.RoundFailed
 	}
 }
 
 func (engine *Engine) executeLoop(
 	ctx context.Context,
+	execution *activeExecution,
 	prepared *preparedExecution,
 ) (conversation.TokenUsage, error) {
 	totalUsage := conversation.TokenUsage{}
 	lastCompacted := false
+	budget := newRoundBudget(prepared.agentDefinition)
+	loopCtx, cancel := budget.context(ctx)
+	defer cancel()
 
-	for iteration := 1; iteration <= maxAgentLoopIterations; iteration++ {
+	for iteration := 1; iteration <= budget.MaxIterations; iteration++ {
 		if err := ctx.Err(); err != nil {
 			return totalUsage, err
 		}
+		if exceeded := budget.expired(ctx, loopCtx); exceeded != nil {
+			return totalUsage, exceeded
+		}
 
 		request := engine.sessionRequest(prepared)
-		if ShouldCompact(estimateRequestTokens(request), modelContextWindow(prepared)) && !lastCompacted {
-			compaction, err := engine.compactPrepared(ctx, prepared, conversation.CompactionTriggerAuto)
+		if ShouldCompact(requestTokens(prepared, request), modelContextWindow(prepared)) && !lastCompacted {
+			compaction, err := engine.compactPrepared(loopCtx, prepared, conversation.CompactionTriggerAuto)
 			if err != nil {
+				if exceeded := budget.expired(ctx, loopCtx); exceeded != nil {
+					return totalUsage, exceeded
+				}
 				return totalUsage, fmt.Errorf("compact session before iteration %d: %w", iteration, err)
 			}
 			totalUsage = totalUsage.Add(compaction.Usage)
 			lastCompacted = true
 			request = engine.sessionRequest(prepared)
 		}
-		response, err := engine.call(ctx, prepared, iteration, request)
+		response, err := engine.callModel(loopCtx, prepared, iteration, request)
 		if err != nil {
+			if exceeded := budget.expired(ctx, loopCtx); exceeded != nil {
+				return totalUsage, exceeded
+			}
 			return totalUsage, fmt.Errorf("invoke LLM at iteration %d: %w", iteration, err)
 		}
 		if response == nil {
@@ -623,7 +822,7 @@ func (engine *Engine) executeLoop(
 		message, err := prepared.session.AppendAssistantMessage(
 			prepared.roundID,
 			response.Content,
-			prepared.session.Rounds[len(prepared.session.Rounds)-1].Model,
+			prepared.modelRef,
 			&response.Usage,
 		)
 		if err != nil {
@@ -645,19 +844,33 @@ func (engine *Engine) executeLoop(
 			if response.StopReason == StopReasonError {
 				return totalUsage, fmt.Errorf("LLM stopped with an error")
 			}
-			return totalUsage, nil
+			steering := engine.takeSteering(execution, true)
+			if len(steering) == 0 {
+				return totalUsage, nil
+			}
+			if err := engine.appendSteering(ctx, prepared, iteration, steering); err != nil {
+				return totalUsage, err
+			}
+			continue
 		}
 
-		cwd := ""
-		round := prepared.session.Rounds[len(prepared.session.Rounds)-1]
-		if round.Cwd != nil {
-			cwd = *round.Cwd
+		if exceeded := budget.spend(totalUsage, len(toolCalls)); exceeded != nil {
+			engine.abandonToolCalls(ctx, prepared, iteration, toolCalls, "the round ran out of budget: "+exceeded.Error())
+			return totalUsage, exceeded
+		}
+
+		results, hookContext, err := engine.executeTools(loopCtx, execution, prepared, iteration, toolCalls)
+		if err != nil {
+			if ctx.Err() != nil {
+				engine.abandonToolCalls(ctx, prepared, iteration, toolCalls, "the round was stopped")
+				return totalUsage, ctx.Err()
+			}
+			if exceeded := budget.expired(ctx, loopCtx); exceeded != nil {
+				engine.abandonToolCalls(ctx, prepared, iteration, toolCalls, "the round ran out of budget: "+exceeded.Error())
+				return totalUsage, exceeded
+			}
+			return totalUsage, fmt.Errorf("execute tools at iteration %d: %w", iteration, err)
 		}
-		results := engine.tools.ExecuteBatch(ctx, CallContext{
-			SessionID: prepared.session.ID,
-			RoundID:   prepared.roundID,
-			Cwd:       cwd,
-		}, toolCalls)
 		markNativeShellResults(response.Content, results)
 		if err := ctx.Err(); err != nil {
 			return totalUsage, err
@@ -681,9 +894,15 @@ func (engine *Engine) executeLoop(
 		}); err != nil {
 			return totalUsage, fmt.Errorf("emit tool results at iteration %d: %w", iteration, err)
 		}
+		if err := engine.appendHookContext(ctx, prepared, hookContext); err != nil {
+			return totalUsage, err
+		}
+		if err := engine.appendSteering(ctx, prepared, iteration, engine.takeSteering(execution, false)); err != nil {
+			return totalUsage, err
+		}
 	}
 
-	return totalUsage, fmt.Errorf("agent loop exceeded %d iterations", maxAgentLoopIterations)
+	return totalUsage, budget.iterationsExceeded()
 }
 
 func modelContextWindow(prepared *preparedExecution) int64 {
@@ -699,15 +918,23 @@ func (engine *Engine) call(
 	iteration int,
 	request Request,
 ) (*Response, error) {
-	if engine.events == nil {
-		return prepared.caller.Invoke(ctx, request)
-	}
-
-	return prepared.caller.Stream(ctx, request, func(stream StreamEvent) error {
+	return engine.withRetry(ctx, func(attempt int) (*Response, error) {
+		if engine.events == nil {
+			return prepared.caller.Invoke(ctx, request)
+		}
+		return prepared.caller.Stream(ctx, request, func(stream StreamEvent) error {
+			return engine.emit(ctx, prepared, SessionEvent{
+				Type:      SessionEventModelStream,
+				Iteration: iteration,
+				Attempt:   attempt,
+				Stream:    &stream,
+			})
+		})
+	}, func(retry ModelRetry) error {
 		return engine.emit(ctx, prepared, SessionEvent{
-			Type:      SessionEventModelStream,
+			Type:      SessionEventModelRetry,
 			Iteration: iteration,
-			Stream:    &stream,
+			Retry:     &retry,
 		})
 	})
 }
@@ -724,6 +951,14 @@ func (engine *Engine) emit(
 	event.SessionID = prepared.session.ID
 	event.RoundID = prepared.roundID
 	event.Sequence = prepared.eventSequence
+	event.Parent = prepared.session.Parent
+	usage := event.Usage
+	if usage == nil && event.Message != nil {
+		usage = event.Message.Usage
+	}
+	if usage != nil {
+		event.CacheHitRatio = cacheHitRatio(prepared.apiType, *usage)
+	}
 	return engine.events(ctx, event)
 }
 
@@ -750,7 +985,18 @@ func (engine *Engine) finish(
 		message := runErr.Error()
 		errorMessage = &message
 	}
-	if err := prepared.session.CompleteRound(prepared.roundID, status, usage, errorMessage); err != nil {
+	var limit conversation.BudgetLimit
+	var exceeded *budgetExceededError
+	if status == conversation.RoundBudgetExceeded && errors.As(runErr, &exceeded) {
+		limit = exceeded.limit
+	}
+	var err error
+	if limit != "" {
+		err = prepared.session.ExceedRoundBudget(prepared.roundID, limit, usage, errorMessage)
+	} else {
+		err = prepared.session.CompleteRound(prepared.roundID, status, usage, errorMessage)
+	}
+	if err != nil {
 		engine.logger.ErrorContext(context.WithoutCancel(ctx), "failed to complete agent round",
 			"sessionId", prepared.session.ID,
 			"roundId", prepared.roundID,
@@ -770,11 +1016,13 @@ func (engine *Engine) finish(
 		return
 	}
 	prepared.session.ClearPending()
+	engine.runHooks(finishCtx, prepared, HookInput{Event: HookRoundEnd, Status: status})
 	if err := engine.emit(finishCtx, prepared, SessionEvent{
-		Type:   SessionEventRoundEnded,
-		Status: status,
-		Usage:  &usage,
-		Error:  errorMessage,
+		Type:        SessionEventRoundEnded,
+		Status:      status,
+		BudgetLimit: limit,
+		Usage:       &usage,
+		Error:       errorMessage,
 	}); err != nil {
 		engine.logger.ErrorContext(finishCtx, "failed to emit completed agent round",
 			"sessionId", prepared.session.ID,
@@ -783,20 +1031,53 @@ func (engine *Engine) finish(
 			"error", err,
 		)
 	}
+	engine.startTitle(prepared, status)
 }
 
 func (engine *Engine) release(
 	sessionID uuid.UUID,
 	execution *activeExecution,
+) {
+	engine.releaseExecution(sessionID, execution, true)
+}
+
+// releaseExecution ends a reservation and, when drain is set, starts the next
+// queued prompt. Otherwise a non-empty queue is reported as held.
+func (engine *Engine) releaseExecution(
+	sessionID uuid.UUID,
+	execution *activeExecution,
+	drain bool,
 ) {
 	execution.cancel()
 
 	engine.mu.Lock()
+	var next *queuedStart
+	var events []QueueEvent
 	if engine.active[sessionID] == execution {
 		delete(engine.active, sessionID)
+		if requeued := engine.requeueSteeringLocked(sessionID, execution); requeued != nil {
+			events = append(events, *requeued)
+		}
+		if drain {
+			next = engine.dequeueLocked(sessionID)
+		} else if queue := engine.queues[sessionID]; len(queue) > 0 {
+			events = append(events, QueueEvent{
+				Type:      QueueEventHeld,
+				SessionID: sessionID,
+				Prompts:   queueSnapshot(queue),
+			})
+		}
 	}
 	engine.mu.Unlock()
 
+	for _, event := range events {
+		engine.emitQueue(context.WithoutCancel(engine.ctx), event)
+	}
+	if next != nil {
+		go func() {
+			_, _ = engine.startQueued(sessionID, next)
+		}()
+	}
 	engine.waitGroup.Done()
 }
 
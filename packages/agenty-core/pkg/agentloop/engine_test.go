@@ -5,6 +5,7 @@ import (
 	"errors"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -488,6 +489,122 @@ func TestEngineCompactsAutomaticallyAndPreservesTranscript(t *testing.T) {
 	}
 }
 
+func TestEngineCompactsWhenTheReportedPromptNearsTheContextWindow(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	registerCountingTool(t, fixture, "lookup")
+	large := toolUseResponse(conversation.ToolUseBlock{ID: "call-1", Name: "lookup", Input: []byte(`{}`)})
+	large.Usage = conversation.TokenUsage{Input: 120_000, Output: 10, Total: 120_010}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		large,
+		{Content: conversation.Text("Task goals: look it up\nCompleted: lookup\nIncomplete: answer")},
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("look it up")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	requests := caller.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("LLM requests = %d, want call, compaction and call", len(requests))
+	}
+	last := requests[1].Messages[len(requests[1].Messages)-1]
+	if !strings.Contains(messageText(last), "session-compaction-request") {
+		t.Errorf("second request ends with %q, want the compaction request", messageText(last))
+	}
+}
+
+func TestEnginePrunesStaleToolOutputBeforeSummarizing(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	provider, err := fixture.catalog.Get(t.Context(), "openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := provider.Model("gpt-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.ContextWindow = 2_000
+	if err := fixture.catalog.Save(t.Context(), provider); err != nil {
+		t.Fatal(err)
+	}
+	if err := fixture.registry.Register(&executionTestTool{
+		definition: agentloop.ToolDefinition{
+			Name:        "read_file",
+			InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+		},
+		execute: func(context.Context, agentloop.CallContext, []byte) (conversation.Content, error) {
+			return conversation.Text(strings.Repeat("line ", 1_000)), nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	compactionEvents := make(chan agentloop.CompactionEvent, 4)
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-1", Name: "read_file", Input: []byte(`{}`)}),
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-2", Name: "read_file", Input: []byte(`{}`)}),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngineWithHandlers(
+		t,
+		func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return caller, nil
+		},
+		nil,
+		func(_ context.Context, event agentloop.CompactionEvent) error {
+			compactionEvents <- event
+			return nil
+		},
+	)
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("read twice")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	requests := caller.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("LLM requests = %d, want three calls without a summary", len(requests))
+	}
+	var results []conversation.ToolResultBlock
+	for _, message := range requests[2].Messages {
+		results = append(results, toolResultBlocks(message.Content)...)
+	}
+	if len(results) != 2 || !conversation.IsPrunedToolResult(results[0]) || conversation.IsPrunedToolResult(results[1]) {
+		t.Fatalf("last request results = %+v, want only the first output pruned", results)
+	}
+	started := <-compactionEvents
+	completed := <-compactionEvents
+	if started.Tier != agentloop.CompactionTierPrune || completed.Type != agentloop.CompactionEventCompleted || completed.Tier != agentloop.CompactionTierPrune {
+		t.Errorf("compaction events = %+v, %+v, want a completed prune", started, completed)
+	}
+	if completed.ContextTokensAfter >= completed.ContextTokensBefore {
+		t.Errorf("context tokens = %d -> %d, want fewer", completed.ContextTokensBefore, completed.ContextTokensAfter)
+	}
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range loaded.Rounds[0].Messages {
+		for _, result := range toolResultBlocks(message.Content) {
+			if conversation.IsPrunedToolResult(result) {
+				t.Errorf("round result %s was pruned, want the transcript kept in full", result.ToolUseID)
+			}
+		}
+	}
+}
+
 func TestEngineCompactsManually(t *testing.T) {
 	t.Parallel()
 
@@ -927,6 +1044,47 @@ func TestEngineShutdownCancelsAllSessions(t *testing.T) {
 	}
 }
 
+type sessionResourcesFake struct {
+	closed atomic.Int32
+}
+
+func (resources *sessionResourcesFake) ReleaseSession(uuid.UUID) {}
+
+func (resources *sessionResourcesFake) Close() {
+	resources.closed.Add(1)
+}
+
+func TestEngineShutdownClosesSessionResources(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	resources := &sessionResourcesFake{}
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return &scriptedCaller{}, nil
+		},
+		Resources: resources,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := engine.Shutdown(shutdownCtx); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+	}
+	if got := resources.closed.Load(); got != 1 {
+		t.Errorf("resources closed %d times, want 1", got)
+	}
+}
+
 func waitForExecution(t *testing.T, engine *agentloop.Engine, sessionID uuid.UUID) {
 	t.Helper()
 
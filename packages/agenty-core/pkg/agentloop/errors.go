@@ -3,6 +3,7 @@ package agentloop
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var ErrInvalidRequest = errors.New("agentloop: invalid request")
@@ -10,3 +11,23 @@ var ErrInvalidRequest = errors.New("agentloop: invalid request")
 func invalidRequest(format string, args ...any) error {
 	return fmt.Errorf("%w: %s", ErrInvalidRequest, fmt.Sprintf(format, args...))
 }
+
+// ProviderError describes a failed model call together with the caller's
+// verdict on whether repeating the same request may succeed.
+type ProviderError struct {
+	Err error
+	// StatusCode is the HTTP status returned by the provider, or zero when
+	// the request failed before a response arrived.
+	StatusCode int
+	Retryable  bool
+	// RetryAfter is the delay requested by the provider, if any.
+	RetryAfter time.Duration
+}
+
+func (e *ProviderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,231 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+// DefaultCircuitBreakerPolicy applies when Dependencies.CircuitBreaker is left
+// zero.
+var DefaultCircuitBreakerPolicy = CircuitBreakerPolicy{
+	FailureThreshold: 2,
+	Cooldown:         time.Minute,
+}
+
+// CircuitBreakerPolicy decides when a provider is skipped in favour of an
+// agent's fallback models. After FailureThreshold consecutive provider-level
+// failures the provider is skipped for Cooldown, then given one more try.
+type CircuitBreakerPolicy struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+func (policy CircuitBreakerPolicy) Validate() error {
+	if policy.FailureThreshold < 0 {
+		return errors.New("circuit breaker failure threshold must not be negative")
+	}
+	if policy.Cooldown < 0 {
+		return errors.New("circuit breaker cooldown must not be negative")
+	}
+	return nil
+}
+
+func (policy CircuitBreakerPolicy) withDefaults() CircuitBreakerPolicy {
+	if policy.FailureThreshold == 0 {
+		policy.FailureThreshold = DefaultCircuitBreakerPolicy.FailureThreshold
+	}
+	if policy.Cooldown == 0 {
+		policy.Cooldown = DefaultCircuitBreakerPolicy.Cooldown
+	}
+	return policy
+}
+
+// circuitBreaker tracks consecutive failures per provider across all sessions.
+type circuitBreaker struct {
+	policy    CircuitBreakerPolicy
+	mu        sync.Mutex
+	providers map[shared.Code]*providerCircuit
+}
+
+type providerCircuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(policy CircuitBreakerPolicy) *circuitBreaker {
+	return &circuitBreaker{
+		policy:    policy,
+		providers: make(map[shared.Code]*providerCircuit),
+	}
+}
+
+func (breaker *circuitBreaker) allow(provider shared.Code) bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	circuit, ok := breaker.providers[provider]
+	return !ok || !time.Now().Before(circuit.openUntil)
+}
+
+func (breaker *circuitBreaker) succeed(provider shared.Code) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	delete(breaker.providers, provider)
+}
+
+func (breaker *circuitBreaker) fail(provider shared.Code) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	circuit, ok := breaker.providers[provider]
+	if !ok {
+		circuit = &providerCircuit{}
+		breaker.providers[provider] = circuit
+	}
+	circuit.failures++
+	if circuit.failures >= breaker.policy.FailureThreshold {
+		circuit.openUntil = time.Now().Add(breaker.policy.Cooldown)
+	}
+}
+
+// providerFailure reports whether err means the provider, rather than the
+// request, is at fault, so another model may succeed where this one failed.
+func providerFailure(err error) bool {
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) {
+		return false
+	}
+	if providerErr.Retryable {
+		return true
+	}
+	switch providerErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden, http.StatusNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// fallbackModels lists the agent's fallback chain without the model the round
+// starts on.
+func fallbackModels(definition *agent.Agent, current shared.ModelRef) []shared.ModelRef {
+	if definition == nil {
+		return nil
+	}
+	models := make([]shared.ModelRef, 0, len(definition.FallbackModels))
+	for _, model := range definition.FallbackModels {
+		if model != current {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// callModel calls the round's current model and moves down the agent's
+// fallback chain when the provider fails or its circuit is open. The round
+// keeps the model that answered for its remaining iterations.
+func (engine *Engine) callModel(
+	ctx context.Context,
+	prepared *preparedExecution,
+	iteration int,
+	request Request,
+) (*Response, error) {
+	for {
+		provider := prepared.modelRef.ProviderCode
+		var failure error
+		if engine.breaker.allow(provider) || len(prepared.fallbacks) == 0 {
+			response, err := engine.call(ctx, prepared, iteration, request)
+			if err == nil {
+				engine.breaker.succeed(provider)
+				if response != nil {
+					calibrateTokens(prepared, request, response.Usage)
+				}
+				return response, nil
+			}
+			if !providerFailure(err) {
+				return nil, err
+			}
+			engine.breaker.fail(provider)
+			failure = err
+		} else {
+			failure = fmt.Errorf("provider %s is skipped after repeated failures", provider)
+		}
+		if ctx.Err() != nil || !engine.fallBack(ctx, prepared, iteration, failure) {
+			return nil, failure
+		}
+		request = engine.sessionRequest(prepared)
+	}
+}
+
+// fallBack switches prepared to the next usable fallback model, compacting
+// the session first when the model's context window is too small. It reports
+// false once the chain is exhausted.
+func (engine *Engine) fallBack(
+	ctx context.Context,
+	prepared *preparedExecution,
+	iteration int,
+	cause error,
+) bool {
+	for len(prepared.fallbacks) > 0 {
+		target := prepared.fallbacks[0]
+		prepared.fallbacks = slices.Delete(prepared.fallbacks, 0, 1)
+		if !engine.breaker.allow(target.ProviderCode) && len(prepared.fallbacks) > 0 {
+			continue
+		}
+		if err := engine.switchModel(ctx, prepared, target); err != nil {
+			engine.logger.WarnContext(ctx, "skipping fallback model",
+				"sessionId", prepared.session.ID, "model", target.String(), "error", err)
+			continue
+		}
+
+		from := prepared.modelRef
+		prepared.modelRef = target
+		if err := engine.emit(ctx, prepared, SessionEvent{
+			Type:      SessionEventModelFallback,
+			Iteration: iteration,
+			Fallback: &ModelFallback{
+				From:   from,
+				To:     target,
+				Reason: cause.Error(),
+			},
+		}); err != nil {
+			engine.logger.WarnContext(ctx, "failed to emit model fallback", "error", err)
+		}
+		return true
+	}
+	return false
+}
+
+func (engine *Engine) switchModel(
+	ctx context.Context,
+	prepared *preparedExecution,
+	target shared.ModelRef,
+) error {
+	provider, model, err := engine.loadCatalogModel(ctx, target)
+	if err != nil {
+		return err
+	}
+	caller, err := engine.newCaller(ctx, *provider, *model)
+	if err != nil {
+		return fmt.Errorf("create LLM caller: %w", err)
+	}
+
+	previous := *prepared
+	prepared.model, prepared.caller = *model, caller
+	prepared.apiType, prepared.tokenizer, prepared.calibration = provider.Type, engine.tokenizer(*provider, *model), nil
+	if err := engine.fitContextWindow(ctx, prepared, modelContextWindow(prepared)); err != nil {
+		prepared.model, prepared.caller = previous.model, previous.caller
+		prepared.apiType, prepared.tokenizer, prepared.calibration = previous.apiType, previous.tokenizer, previous.calibration
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package agentloop_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+func addFallbackProvider(t *testing.T, fixture *executionFixture, contextWindow int) shared.ModelRef {
+	t.Helper()
+
+	provider, err := catalog.NewProvider("backup", "Backup", catalog.APIAnthropic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.APIKey = "backup-key"
+	provider.AddModel(catalog.Model{Code: "backup-model", Name: "Backup Model", ContextWindow: contextWindow})
+	if err := fixture.catalog.Save(t.Context(), provider); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := shared.NewModelRef("backup", "backup-model")
+	definition, err := fixture.agents.Get(t.Context(), "coder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition.FallbackModels = []shared.ModelRef{ref}
+	if err := fixture.agents.Save(t.Context(), definition); err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func newFallbackEngine(
+	t *testing.T,
+	fixture *executionFixture,
+	callers map[shared.Code]agentloop.Caller,
+	events agentloop.SessionEventHandler,
+) *agentloop.Engine {
+	t.Helper()
+
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(_ context.Context, provider catalog.Provider, _ catalog.Model) (agentloop.Caller, error) {
+			return callers[provider.Code], nil
+		},
+		Events:         events,
+		Retry:          agentloop.RetryPolicy{MaxAttempts: 1},
+		CircuitBreaker: agentloop.CircuitBreakerPolicy{FailureThreshold: 1, Cooldown: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = engine.Shutdown(shutdownCtx)
+	})
+	return engine
+}
+
+func unavailable(count int) []error {
+	failures := make([]error, count)
+	for index := range failures {
+		failures[index] = &agentloop.ProviderError{Err: errors.New("service unavailable"), StatusCode: 503, Retryable: true}
+	}
+	return failures
+}
+
+func TestEngineFallsBackAndSkipsOpenCircuit(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	backup := addFallbackProvider(t, fixture, 128_000)
+	primary := &flakyCaller{failures: unavailable(4)}
+	secondary := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("first"), StopReason: agentloop.StopReasonEndTurn},
+		{Content: conversation.Text("second"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	recorder := &sessionEventRecorder{}
+	engine := newFallbackEngine(t, fixture, map[shared.Code]agentloop.Caller{
+		"openai": primary,
+		"backup": secondary,
+	}, recorder.handle)
+	session := fixture.createSession(t)
+
+	for _, prompt := range []string{"hello", "again"} {
+		if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text(prompt)); err != nil {
+			t.Fatal(err)
+		}
+		waitForExecution(t, engine, session.ID)
+	}
+
+	if primary.Attempts() != 1 {
+		t.Errorf("primary attempts = %d, want 1 before its circuit opened", primary.Attempts())
+	}
+	fallbacks := recorder.ofType(agentloop.SessionEventModelFallback)
+	if len(fallbacks) != 2 {
+		t.Fatalf("fallback events = %+v", fallbacks)
+	}
+	if got := fallbacks[0].Fallback; got.From != shared.NewModelRef("openai", "gpt-5") || got.To != backup || got.Reason == "" {
+		t.Errorf("first fallback = %+v", got)
+	}
+	if !strings.Contains(fallbacks[1].Fallback.Reason, "skipped") {
+		t.Errorf("second fallback reason = %q, want circuit skip", fallbacks[1].Fallback.Reason)
+	}
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, round := range loaded.Rounds {
+		if round.Status != conversation.RoundCompleted {
+			t.Fatalf("round = %+v", round)
+		}
+		answer := round.Messages[len(round.Messages)-1]
+		if answer.Role != conversation.RoleAssistant || answer.Model == nil || *answer.Model != backup {
+			t.Errorf("answer model = %+v, want %s", answer.Model, backup)
+		}
+		if round.Model != shared.NewModelRef("openai", "gpt-5") {
+			t.Errorf("round model = %s, want the session model", round.Model)
+		}
+	}
+}
+
+func TestEngineCompactsBeforeFallingBackToSmallerModel(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	addFallbackProvider(t, fixture, 4_000)
+	primary := &flakyCaller{failures: unavailable(1)}
+	secondary := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("fallback summary"), StopReason: agentloop.StopReasonEndTurn},
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := newFallbackEngine(t, fixture, map[shared.Code]agentloop.Caller{
+		"openai": primary,
+		"backup": secondary,
+	}, nil)
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text(strings.Repeat("important context ", 1_000))); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if round := loaded.Rounds[0]; round.Status != conversation.RoundCompleted {
+		t.Fatalf("round = %+v", round)
+	}
+	var triggers []conversation.CompactionTrigger
+	for _, event := range fixture.sessions.events[session.ID] {
+		if compacted, ok := event.(conversation.SessionCompacted); ok {
+			triggers = append(triggers, compacted.Trigger)
+		}
+	}
+	if len(triggers) != 1 || triggers[0] != conversation.CompactionTriggerModelSwitch {
+		t.Fatalf("compaction triggers = %v", triggers)
+	}
+	if requests := secondary.Requests(); len(requests) != 2 {
+		t.Fatalf("fallback requests = %d, want compaction and answer", len(requests))
+	}
+}
+
+func TestEngineDoesNotFallBackOnRequestErrors(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	addFallbackProvider(t, fixture, 128_000)
+	primary := &flakyCaller{failures: []error{
+		&agentloop.ProviderError{Err: errors.New("invalid request"), StatusCode: 400},
+	}}
+	secondary := &scriptedCaller{}
+	engine := newFallbackEngine(t, fixture, map[shared.Code]agentloop.Caller{
+		"openai": primary,
+		"backup": secondary,
+	}, nil)
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if round := loaded.Rounds[0]; round.Status != conversation.RoundFailed {
+		t.Fatalf("round = %+v", round)
+	}
+	if len(secondary.Requests()) != 0 {
+		t.Errorf("fallback model was called for a request error")
+	}
+}
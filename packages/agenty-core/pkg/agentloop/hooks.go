@@ -0,0 +1,184 @@
+package agentloop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+// HookEvent names a lifecycle point at which user hooks run.
+type HookEvent string
+
+const (
+	HookPreToolUse    HookEvent = "pre_tool_use"
+	HookPostToolUse   HookEvent = "post_tool_use"
+	HookRoundStart    HookEvent = "round_start"
+	HookRoundEnd      HookEvent = "round_end"
+	HookPreCompaction HookEvent = "pre_compaction"
+)
+
+const hookContextTemplate = `<hook-context event=%q>
+%s
+</hook-context>`
+
+// HookInput describes the lifecycle point to the hooks registered for it.
+// Tool fields are set for tool events, Status for round_end and Trigger for
+// pre_compaction.
+type HookInput struct {
+	Event      HookEvent                      `json:"event"`
+	SessionID  uuid.UUID                      `json:"sessionId"`
+	RoundID    uuid.UUID                      `json:"roundId"`
+	Cwd        string                         `json:"cwd,omitempty"`
+	ToolName   string                         `json:"toolName,omitempty"`
+	ToolUseID  string                         `json:"toolUseId,omitempty"`
+	ToolInput  json.RawMessage                `json:"toolInput,omitempty"`
+	ToolResult *conversation.ToolResultBlock  `json:"toolResult,omitempty"`
+	Status     conversation.RoundStatus       `json:"status,omitempty"`
+	Trigger    conversation.CompactionTrigger `json:"trigger,omitempty"`
+}
+
+// HookOutcome combines what the hooks of one event returned. Block and
+// ToolInput only take effect for pre_tool_use: the call is either answered
+// with Reason as an error or run with the rewritten input. Context is added
+// to the model context as hidden messages.
+type HookOutcome struct {
+	Block     bool
+	Reason    string
+	ToolInput json.RawMessage
+	Context   []string
+}
+
+// HookRunner runs the user hooks configured for an event. An error reports
+// hooks that failed to run; the engine logs it and carries on with the
+// outcome of the hooks that did run.
+type HookRunner interface {
+	Run(ctx context.Context, input HookInput) (HookOutcome, error)
+}
+
+func (engine *Engine) runHooks(ctx context.Context, prepared *preparedExecution, input HookInput) HookOutcome {
+	if engine.hooks == nil {
+		return HookOutcome{}
+	}
+	input.SessionID = prepared.session.ID
+	input.RoundID = prepared.roundID
+	if len(prepared.session.Rounds) > 0 {
+		input.Cwd = roundCwdValue(prepared)
+	} else {
+		input.Cwd = sessionCwd(prepared.session)
+	}
+
+	outcome, err := engine.hooks.Run(ctx, input)
+	if err != nil {
+		engine.logger.WarnContext(ctx, "lifecycle hook failed",
+			"sessionId", input.SessionID,
+			"roundId", input.RoundID,
+			"event", input.Event,
+			"tool", input.ToolName,
+			"error", err,
+		)
+	}
+	return outcome
+}
+
+// preToolUse runs the pre_tool_use hooks of call. It returns the call with
+// any rewritten input, or the error result when a hook blocked it.
+func (engine *Engine) preToolUse(
+	ctx context.Context,
+	prepared *preparedExecution,
+	call conversation.ToolUseBlock,
+) (conversation.ToolUseBlock, *conversation.ToolResultBlock, []conversation.Content) {
+	outcome := engine.runHooks(ctx, prepared, HookInput{
+		Event:     HookPreToolUse,
+		ToolName:  call.Name,
+		ToolUseID: call.ID,
+		ToolInput: call.Input,
+	})
+	contexts := hookContexts(HookPreToolUse, outcome.Context)
+	if outcome.Block {
+		result := toolErrorResult(call, blockedToolCallMessage(outcome.Reason))
+		return call, &result, contexts
+	}
+	if outcome.ToolInput != nil {
+		call.Input = outcome.ToolInput
+	}
+	return call, nil, contexts
+}
+
+// postToolUse runs the post_tool_use hooks of call with its result.
+func (engine *Engine) postToolUse(
+	ctx context.Context,
+	prepared *preparedExecution,
+	call conversation.ToolUseBlock,
+	result *conversation.ToolResultBlock,
+) []conversation.Content {
+	outcome := engine.runHooks(ctx, prepared, HookInput{
+		Event:      HookPostToolUse,
+		ToolName:   call.Name,
+		ToolUseID:  call.ID,
+		ToolInput:  call.Input,
+		ToolResult: result,
+	})
+	return hookContexts(HookPostToolUse, outcome.Context)
+}
+
+// appendHookContext adds hook output to the running round as hidden user
+// messages, which the model sees from its next call on.
+func (engine *Engine) appendHookContext(
+	ctx context.Context,
+	prepared *preparedExecution,
+	contents []conversation.Content,
+) error {
+	if len(contents) == 0 {
+		return nil
+	}
+	for _, content := range contents {
+		if _, err := prepared.session.AppendHiddenUserMessage(prepared.roundID, content); err != nil {
+			return fmt.Errorf("append hook context: %w", err)
+		}
+	}
+	if err := engine.saveProgress(ctx, prepared.session); err != nil {
+		return fmt.Errorf("save hook context: %w", err)
+	}
+	return nil
+}
+
+// hookContextMessages renders hook output for requests that are not part of
+// a round, such as the compaction request.
+func hookContextMessages(event HookEvent, context []string) []conversation.Message {
+	return hiddenUserMessages(hookContexts(event, context))
+}
+
+func hiddenUserMessages(contents []conversation.Content) []conversation.Message {
+	messages := make([]conversation.Message, 0, len(contents))
+	for _, content := range contents {
+		messages = append(messages, conversation.Message{
+			ID:         shared.NewID(),
+			Role:       conversation.RoleUser,
+			Visibility: conversation.MessageHidden,
+			Content:    content,
+			CreatedAt:  time.Now().UTC(),
+		})
+	}
+	return messages
+}
+
+func hookContexts(event HookEvent, context []string) []conversation.Content {
+	contents := make([]conversation.Content, 0, len(context))
+	for _, text := range context {
+		contents = append(contents, conversation.Text(fmt.Sprintf(hookContextTemplate, event, text)))
+	}
+	return contents
+}
+
+func blockedToolCallMessage(reason string) string {
+	if reason == "" {
+		return "a hook blocked this tool call"
+	}
+	return "a hook blocked this tool call: " + reason
+}
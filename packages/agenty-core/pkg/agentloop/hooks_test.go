@@ -0,0 +1,258 @@
+package agentloop_test
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+type hookRunnerFake struct {
+	mu     sync.Mutex
+	inputs []agentloop.HookInput
+	run    func(agentloop.HookInput) agentloop.HookOutcome
+}
+
+func (runner *hookRunnerFake) Run(_ context.Context, input agentloop.HookInput) (agentloop.HookOutcome, error) {
+	runner.mu.Lock()
+	runner.inputs = append(runner.inputs, input)
+	runner.mu.Unlock()
+	return runner.run(input), nil
+}
+
+func (runner *hookRunnerFake) events() []agentloop.HookEvent {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	events := make([]agentloop.HookEvent, 0, len(runner.inputs))
+	for _, input := range runner.inputs {
+		events = append(events, input.Event)
+	}
+	return events
+}
+
+func newHookedEngine(
+	t *testing.T,
+	fixture *executionFixture,
+	caller agentloop.Caller,
+	hooks agentloop.HookRunner,
+) *agentloop.Engine {
+	t.Helper()
+
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return caller, nil
+		},
+		Hooks: hooks,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := engine.Shutdown(shutdownCtx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+	return engine
+}
+
+func TestEngineRunsHooksAroundToolCallsAndRounds(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	var mu sync.Mutex
+	var inputs []string
+	for _, name := range []string{"shell", "write_file"} {
+		if err := fixture.registry.Register(&executionTestTool{
+			definition: agentloop.ToolDefinition{
+				Name:        name,
+				InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+			},
+			execute: func(_ context.Context, _ agentloop.CallContext, input []byte) (conversation.Content, error) {
+				mu.Lock()
+				inputs = append(inputs, name+" "+string(input))
+				mu.Unlock()
+				return conversation.Text(name + " ran"), nil
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hooks := &hookRunnerFake{run: func(input agentloop.HookInput) agentloop.HookOutcome {
+		switch {
+		case input.Event == agentloop.HookRoundStart:
+			return agentloop.HookOutcome{Context: []string{"branch is main"}}
+		case input.Event == agentloop.HookPreToolUse && input.ToolName == "write_file":
+			return agentloop.HookOutcome{Block: true, Reason: "read only"}
+		case input.Event == agentloop.HookPreToolUse:
+			return agentloop.HookOutcome{ToolInput: json.RawMessage(`{"command":"ls"}`)}
+		case input.Event == agentloop.HookPostToolUse:
+			return agentloop.HookOutcome{Context: []string{"linted " + input.ToolName}}
+		}
+		return agentloop.HookOutcome{}
+	}}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(
+			conversation.ToolUseBlock{ID: "call-shell", Name: "shell", Input: []byte(`{"command":"rm -rf /"}`)},
+			conversation.ToolUseBlock{ID: "call-write", Name: "write_file", Input: []byte(`{}`)},
+		),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := newHookedEngine(t, fixture, caller, hooks)
+	session := fixture.createSession(t)
+
+	result, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	wantEvents := []agentloop.HookEvent{
+		agentloop.HookRoundStart,
+		agentloop.HookPreToolUse,
+		agentloop.HookPreToolUse,
+		agentloop.HookPostToolUse,
+		agentloop.HookRoundEnd,
+	}
+	if got := hooks.events(); len(got) != len(wantEvents) {
+		t.Fatalf("hook events = %v, want %v", got, wantEvents)
+	} else {
+		for index := range got {
+			if got[index] != wantEvents[index] {
+				t.Fatalf("hook events = %v, want %v", got, wantEvents)
+			}
+		}
+	}
+	post := hooks.inputs[3]
+	if post.SessionID != session.ID || post.RoundID != result.RoundID || post.Cwd != "/workspace" {
+		t.Errorf("post hook input = %+v, want the session, round and cwd", post)
+	}
+	if string(post.ToolInput) != `{"command":"ls"}` || post.ToolResult == nil || post.ToolResult.ToolUseID != "call-shell" {
+		t.Errorf("post hook input = %+v, want the rewritten shell call and its result", post)
+	}
+	if end := hooks.inputs[4]; end.Status != conversation.RoundCompleted {
+		t.Errorf("round end status = %q, want completed", end.Status)
+	}
+	if len(inputs) != 1 || inputs[0] != `shell {"command":"ls"}` {
+		t.Errorf("tool inputs = %q, want only the rewritten shell call", inputs)
+	}
+
+	if len(caller.requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(caller.requests))
+	}
+	first := caller.requests[0].Messages
+	if text := messageText(first[len(first)-1]); !strings.Contains(text, "branch is main") {
+		t.Errorf("first request ends with %q, want the round start context", text)
+	}
+	second := caller.requests[1].Messages
+	results := second[len(second)-2]
+	blocked := toolResultBlocks(results.Content)
+	if len(blocked) != 2 || !blocked[1].IsError {
+		t.Fatalf("tool results = %+v, want the write to fail", blocked)
+	}
+	if text, _ := blocked[1].Content[0].(conversation.TextBlock); !strings.Contains(text.Text, "read only") {
+		t.Errorf("blocked result = %q, want the hook reason", text.Text)
+	}
+	hookContext := second[len(second)-1]
+	if !hookContext.IsHidden() || !strings.Contains(messageText(hookContext), "linted shell") {
+		t.Errorf("last message = %+v, want the post tool context as a hidden message", hookContext)
+	}
+}
+
+func TestEngineRunsToolHooksDuringCompaction(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	var mu sync.Mutex
+	var inputs []string
+	for _, name := range []string{"shell", "write_file"} {
+		if err := fixture.registry.Register(&executionTestTool{
+			definition: agentloop.ToolDefinition{
+				Name:        name,
+				InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+			},
+			execute: func(_ context.Context, _ agentloop.CallContext, input []byte) (conversation.Content, error) {
+				mu.Lock()
+				inputs = append(inputs, name+" "+string(input))
+				mu.Unlock()
+				return conversation.Text(name + " ran"), nil
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hooks := &hookRunnerFake{run: func(input agentloop.HookInput) agentloop.HookOutcome {
+		switch {
+		case input.Event == agentloop.HookPreToolUse && input.ToolName == "write_file":
+			return agentloop.HookOutcome{Block: true, Reason: "read only"}
+		case input.Event == agentloop.HookPreToolUse:
+			return agentloop.HookOutcome{ToolInput: json.RawMessage(`{"command":"ls"}`)}
+		case input.Event == agentloop.HookPostToolUse:
+			return agentloop.HookOutcome{Context: []string{"linted " + input.ToolName}}
+		}
+		return agentloop.HookOutcome{}
+	}}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(
+			conversation.ToolUseBlock{ID: "call-shell", Name: "shell", Input: []byte(`{"command":"rm -rf /"}`)},
+			conversation.ToolUseBlock{ID: "call-write", Name: "write_file", Input: []byte(`{}`)},
+		),
+		{Content: conversation.Text("summary"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := newHookedEngine(t, fixture, caller, hooks)
+	session := fixture.createSession(t)
+	roundID, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendUserMessage(roundID, conversation.Text("goal")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fixture.sessions.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Compact(t.Context(), session.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	wantEvents := []agentloop.HookEvent{
+		agentloop.HookPreCompaction,
+		agentloop.HookPreToolUse,
+		agentloop.HookPreToolUse,
+		agentloop.HookPostToolUse,
+	}
+	if got := hooks.events(); !slices.Equal(got, wantEvents) {
+		t.Fatalf("hook events = %v, want %v", got, wantEvents)
+	}
+	if len(inputs) != 1 || inputs[0] != `shell {"command":"ls"}` {
+		t.Errorf("tool inputs = %q, want only the rewritten shell call", inputs)
+	}
+
+	requests := caller.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("compaction requests = %d, want 2", len(requests))
+	}
+	messages := requests[1].Messages
+	results := toolResultBlocks(messages[len(messages)-2].Content)
+	if len(results) != 2 || !results[1].IsError {
+		t.Fatalf("tool results = %+v, want the write to fail", results)
+	}
+	if text, _ := results[1].Content[0].(conversation.TextBlock); !strings.Contains(text.Text, "read only") {
+		t.Errorf("blocked result = %q, want the hook reason", text.Text)
+	}
+	if hookContext := messages[len(messages)-1]; !strings.Contains(messageText(hookContext), "linted shell") {
+		t.Errorf("last message = %+v, want the post tool context", hookContext)
+	}
+}
@@ -0,0 +1,289 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+const (
+	titlePrompt = `Write a title of at most six words for the conversation above, in the
+language of the user. Reply with the title alone, without quotes or trailing
+punctuation.`
+	titleMaxOutputTokens int64 = 64
+	// titleExcerptRunes caps how much of each message the title model reads.
+	titleExcerptRunes = 2_000
+	maxTitleRunes     = 80
+	titleTimeout      = time.Minute
+)
+
+// lightModel is a cheaper model that does work beside the round, such as
+// compaction summaries and session titles.
+type lightModel struct {
+	ref       shared.ModelRef
+	model     catalog.Model
+	caller    Caller
+	tokenizer Tokenizer
+}
+
+// lightModelRef returns the agent's light model, else the first light model
+// of the provider serving current, else current itself.
+func (engine *Engine) lightModelRef(
+	ctx context.Context,
+	agentDefinition *agent.Agent,
+	current shared.ModelRef,
+) (shared.ModelRef, error) {
+	if agentDefinition != nil && agentDefinition.LightModel != nil {
+		return *agentDefinition.LightModel, nil
+	}
+	provider, err := engine.catalog.Get(ctx, current.ProviderCode)
+	if err != nil {
+		return shared.ModelRef{}, err
+	}
+	model, ok := provider.LightModel()
+	if !ok {
+		return current, nil
+	}
+	return shared.NewModelRef(provider.Code, model.Code), nil
+}
+
+// loadLightModel creates a caller for ref whose lifetime follows ctx.
+func (engine *Engine) loadLightModel(ctx context.Context, ref shared.ModelRef) (*lightModel, error) {
+	provider, model, err := engine.loadCatalogModel(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := engine.newCaller(ctx, *provider, *model)
+	if err != nil {
+		return nil, err
+	}
+	return &lightModel{
+		ref:       ref,
+		model:     *model,
+		caller:    caller,
+		tokenizer: engine.tokenizer(*provider, *model),
+	}, nil
+}
+
+// summaryModel returns the light model that should summarize request, or nil
+// when the session model has to, because no other model is configured or
+// the light one cannot read the whole request.
+func (engine *Engine) summaryModel(ctx context.Context, prepared *preparedExecution, request Request) *lightModel {
+	ref, err := engine.lightModelRef(ctx, prepared.agentDefinition, prepared.modelRef)
+	if err == nil && ref == prepared.modelRef {
+		return nil
+	}
+	var light *lightModel
+	if err == nil {
+		light, err = engine.loadLightModel(ctx, ref)
+	}
+	if err != nil {
+		engine.logger.WarnContext(ctx, "failed to load light model for compaction",
+			"sessionId", prepared.session.ID, "error", err)
+		return nil
+	}
+	window := int64(light.model.ContextWindow)
+	if window > 0 && estimateRequestTokens(light.tokenizer, request)+lightOutputTokens(light, request) > window {
+		return nil
+	}
+	return light
+}
+
+// lightRequest adapts a request built for the session model to light.
+func lightRequest(light *lightModel, request Request) Request {
+	request.MaxOutputTokens = lightOutputTokens(light, request)
+	if !light.model.SupportsReasoningEffort(request.ReasoningEffort) {
+		request.ReasoningEffort = ""
+	}
+	return request
+}
+
+func lightOutputTokens(light *lightModel, request Request) int64 {
+	if light.model.MaxOutputTokens > 0 && light.model.MaxOutputTokens < request.MaxOutputTokens {
+		return light.model.MaxOutputTokens
+	}
+	return request.MaxOutputTokens
+}
+
+// startTitle names an untitled top-level session in the background once one
+// of its rounds completes. It runs after the round's final save, so prepared
+// is no longer shared with the round.
+func (engine *Engine) startTitle(prepared *preparedExecution, status conversation.RoundStatus) {
+	if !engine.generateTitles || status != conversation.RoundCompleted ||
+		prepared.session.Title != nil || prepared.session.Parent != nil {
+		return
+	}
+	messages := titleMessages(prepared.session, prepared.roundID)
+	if len(messages) == 0 {
+		return
+	}
+
+	engine.mu.Lock()
+	if engine.shutdown || engine.ctx.Err() != nil {
+		engine.mu.Unlock()
+		return
+	}
+	engine.waitGroup.Add(1)
+	engine.mu.Unlock()
+
+	go func() {
+		defer engine.waitGroup.Done()
+
+		ctx, cancel := context.WithTimeout(engine.ctx, titleTimeout)
+		defer cancel()
+		if err := engine.generateTitle(ctx, prepared, messages); err != nil {
+			engine.logger.WarnContext(ctx, "failed to generate session title",
+				"sessionId", prepared.session.ID, "error", err)
+		}
+	}()
+}
+
+func (engine *Engine) generateTitle(
+	ctx context.Context,
+	prepared *preparedExecution,
+	messages []conversation.Message,
+) error {
+	ref, err := engine.lightModelRef(ctx, prepared.agentDefinition, prepared.modelRef)
+	if err != nil {
+		return err
+	}
+	light, err := engine.loadLightModel(ctx, ref)
+	if err != nil {
+		return err
+	}
+	response, err := light.caller.Invoke(ctx, Request{
+		Messages:        messages,
+		MaxOutputTokens: titleMaxOutputTokens,
+	})
+	if err != nil {
+		return err
+	}
+	text, err := textFromContent(response.Content)
+	if err != nil {
+		return err
+	}
+	title := cleanTitle(text)
+	if title == "" {
+		return nil
+	}
+
+	saved, err := engine.saveTitle(ctx, prepared.session.ID, title)
+	if err != nil || !saved {
+		return err
+	}
+	return engine.emit(ctx, prepared, SessionEvent{Type: SessionEventTitleSet, Title: title})
+}
+
+// saveTitle records title unless the session was titled or deleted while it
+// was being written. Holding the engine lock orders it with deletions.
+func (engine *Engine) saveTitle(ctx context.Context, sessionID uuid.UUID, title string) (bool, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	session, err := engine.sessions.Load(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, conversation.ErrSessionNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if session.Title != nil {
+		return false, nil
+	}
+	session.SetTitle(title)
+	if err := engine.saveProgress(ctx, session); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// titleMessages condenses the opening prompt and final answer of a round into
+// a request for its title.
+func titleMessages(session *conversation.Session, roundID uuid.UUID) []conversation.Message {
+	index := slices.IndexFunc(session.Rounds, func(round conversation.Round) bool {
+		return round.ID == roundID
+	})
+	if index < 0 {
+		return nil
+	}
+	round := session.Rounds[index]
+	prompt, ok := round.Prompt()
+	if !ok {
+		return nil
+	}
+	promptText := titleExcerpt(prompt.Content)
+	if promptText == "" {
+		return nil
+	}
+
+	messages := []conversation.Message{{
+		ID:      shared.NewID(),
+		RoundID: roundID,
+		Role:    conversation.RoleUser,
+		Content: conversation.Text(promptText),
+	}}
+	for index := len(round.Messages) - 1; index >= 0; index-- {
+		message := round.Messages[index]
+		if message.Role != conversation.RoleAssistant {
+			continue
+		}
+		if answer := titleExcerpt(message.Content); answer != "" {
+			messages = append(messages, conversation.Message{
+				ID:      shared.NewID(),
+				RoundID: roundID,
+				Role:    conversation.RoleAssistant,
+				Content: conversation.Text(answer),
+			})
+			break
+		}
+	}
+	return append(messages, conversation.Message{
+		ID:      shared.NewID(),
+		RoundID: roundID,
+		Role:    conversation.RoleUser,
+		Content: conversation.Text(titlePrompt),
+	})
+}
+
+func titleExcerpt(content conversation.Content) string {
+	parts := make([]string, 0, len(content))
+	for _, block := range content {
+		if text, ok := block.(conversation.TextBlock); ok && strings.TrimSpace(text.Text) != "" {
+			parts = append(parts, text.Text)
+		}
+	}
+	return truncateRunes(strings.TrimSpace(strings.Join(parts, "\n")), titleExcerptRunes)
+}
+
+// cleanTitle keeps the first line of a model reply without the quotes,
+// markdown and trailing punctuation models tend to add.
+func cleanTitle(text string) string {
+	text = strings.TrimSpace(text)
+	if line, _, found := strings.Cut(text, "\n"); found {
+		text = line
+	}
+	text = strings.TrimLeft(text, "# ")
+	text = strings.TrimPrefix(text, "Title:")
+	text = strings.TrimFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("\"'`*.。", r)
+	})
+	return truncateRunes(text, maxTitleRunes)
+}
+
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:limit]))
+}
@@ -0,0 +1,180 @@
+package agentloop_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+func addLightModel(t *testing.T, fixture *executionFixture) {
+	t.Helper()
+
+	provider, err := fixture.catalog.Get(t.Context(), "openai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.AddModel(catalog.Model{Code: "gpt-5-mini", Name: "GPT-5 mini", ContextWindow: 128_000, Light: true})
+	if err := fixture.catalog.Save(t.Context(), provider); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newLightEngine(
+	t *testing.T,
+	fixture *executionFixture,
+	callers map[shared.ModelCode]agentloop.Caller,
+	events agentloop.SessionEventHandler,
+) *agentloop.Engine {
+	t.Helper()
+
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(_ context.Context, _ catalog.Provider, model catalog.Model) (agentloop.Caller, error) {
+			return callers[model.Code], nil
+		},
+		Events:         events,
+		Retry:          agentloop.RetryPolicy{MaxAttempts: 1},
+		GenerateTitles: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = engine.Shutdown(shutdownCtx)
+	})
+	return engine
+}
+
+func TestEngineSummarizesWithTheLightModel(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name          string
+		lightReplies  []*agentloop.Response
+		wantMainCalls int
+	}{
+		{
+			name:         "light model",
+			lightReplies: []*agentloop.Response{{Content: conversation.Text("light summary")}},
+		},
+		{
+			name:          "session model after the light model fails",
+			wantMainCalls: 1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := newExecutionFixture(t, 8_192)
+			addLightModel(t, fixture)
+			main := &scriptedCaller{responses: []*agentloop.Response{{Content: conversation.Text("main summary")}}}
+			light := &scriptedCaller{responses: test.lightReplies}
+			engine := newLightEngine(t, fixture, map[shared.ModelCode]agentloop.Caller{
+				"gpt-5":      main,
+				"gpt-5-mini": light,
+			}, nil)
+			session := fixture.createSession(t)
+			roundID, err := session.StartRound()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := session.AppendUserMessage(roundID, conversation.Text("manual goal")); err != nil {
+				t.Fatal(err)
+			}
+			if err := fixture.sessions.Save(t.Context(), session); err != nil {
+				t.Fatal(err)
+			}
+			session.ClearPending()
+
+			if _, err := engine.Compact(t.Context(), session.ID.String()); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(light.Requests()); got != 1 {
+				t.Errorf("light model calls = %d, want 1", got)
+			}
+			if got := len(main.Requests()); got != test.wantMainCalls {
+				t.Errorf("session model calls = %d, want %d", got, test.wantMainCalls)
+			}
+		})
+	}
+}
+
+func TestEngineTitlesTheSessionAfterItsFirstCompletedRound(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	addLightModel(t, fixture)
+	definition, err := fixture.agents.Get(t.Context(), "coder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition.LightModel = ptr(shared.NewModelRef("openai", "gpt-5-mini"))
+	if err := fixture.agents.Save(t.Context(), definition); err != nil {
+		t.Fatal(err)
+	}
+
+	main := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("The login form now checks the token."), StopReason: agentloop.StopReasonEndTurn},
+		{Content: conversation.Text("Done."), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	light := &scriptedCaller{responses: []*agentloop.Response{{Content: conversation.Text("\"Fix the login bug.\"\n")}}}
+	titles := make(chan agentloop.SessionEvent, 1)
+	engine := newLightEngine(t, fixture, map[shared.ModelCode]agentloop.Caller{
+		"gpt-5":      main,
+		"gpt-5-mini": light,
+	}, func(_ context.Context, event agentloop.SessionEvent) error {
+		if event.Type == agentloop.SessionEventTitleSet {
+			titles <- event
+		}
+		return nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("the login form accepts expired tokens")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-titles:
+		if event.Title != "Fix the login bug" || event.SessionID != session.ID {
+			t.Fatalf("title event = %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no title event")
+	}
+	requests := light.Requests()
+	if len(requests) != 1 || len(requests[0].Messages) != 3 || len(requests[0].Tools) != 0 {
+		t.Fatalf("title requests = %+v", requests)
+	}
+
+	waitForExecution(t, engine, session.ID)
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("thanks")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+	shutdownCtx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	if err := engine.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(light.Requests()); got != 1 {
+		t.Errorf("title model calls = %d, want 1 for a titled session", got)
+	}
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Title == nil || *loaded.Title != "Fix the login bug" {
+		t.Errorf("title = %v", loaded.Title)
+	}
+}
@@ -30,6 +30,7 @@ func fullMetadataXML(session *conversation.Session) (string, error) {
 		Provider:        session.CurrentModel.ProviderCode.String(),
 		Timezone:        utils.TimezoneName(),
 		ReasoningEffort: string(session.CurrentReasoningEffort),
+		Mode:            string(session.Mode.Effective()),
 	}
 	return metadata.XML()
 }
@@ -49,6 +50,7 @@ func metadataForRound(
 		Provider:        round.Model.ProviderCode.String(),
 		Timezone:        utils.TimezoneName(),
 		ReasoningEffort: string(round.ReasoningEffort),
+		Mode:            string(round.Mode.Effective()),
 	}
 	update := current.Diff(session.LastMetadata())
 	if update.Empty() {
@@ -0,0 +1,33 @@
+package agentloop
+
+import "github.com/masteryyh/agenty-core/pkg/domain/conversation"
+
+// planModeDeniedTools are the built-in tools that change the workspace, which
+// a round in plan mode cannot use.
+var planModeDeniedTools = map[string]struct{}{
+	"write_file":    {},
+	"patch_file":    {},
+	"delete_file":   {},
+	"apply_patch":   {},
+	"shell":         {},
+	"process_start": {},
+	"process_write": {},
+	"process_kill":  {},
+}
+
+// preparedMode returns the mode of the running round, or the session's mode
+// outside of rounds.
+func preparedMode(prepared *preparedExecution) conversation.SessionMode {
+	if len(prepared.session.Rounds) == 0 {
+		return prepared.session.Mode.Effective()
+	}
+	return prepared.session.Rounds[len(prepared.session.Rounds)-1].Mode.Effective()
+}
+
+func refusedInPlanMode(prepared *preparedExecution, name string) bool {
+	if preparedMode(prepared) != conversation.ModePlan {
+		return false
+	}
+	_, denied := planModeDeniedTools[name]
+	return denied
+}
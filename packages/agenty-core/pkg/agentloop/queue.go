@@ -0,0 +1,252 @@
+package agentloop
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/application/apperrors"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+type QueuedPrompt struct {
+	ID       uuid.UUID            `json:"id"`
+	Content  conversation.Content `json:"content"`
+	QueuedAt time.Time            `json:"queuedAt"`
+}
+
+type PromptQueue struct {
+	SessionID uuid.UUID      `json:"sessionId"`
+	Prompts   []QueuedPrompt `json:"prompts"`
+}
+
+type QueueEventType string
+
+const (
+	QueueEventEnqueued QueueEventType = "enqueued"
+	QueueEventStarted  QueueEventType = "started"
+	QueueEventFailed   QueueEventType = "failed"
+	QueueEventCleared  QueueEventType = "cleared"
+	// QueueEventHeld reports that a round was stopped or did not complete, so
+	// the queue waits for ResumeQueue or ClearQueue.
+	QueueEventHeld QueueEventType = "held"
+)
+
+// QueueEvent reports a change to a session's prompt queue. Prompts is the
+// queue after the change; Prompt is the entry that was added, started or
+// failed to start.
+type QueueEvent struct {
+	Type      QueueEventType `json:"type"`
+	SessionID uuid.UUID      `json:"sessionId"`
+	Prompt    *QueuedPrompt  `json:"prompt,omitempty"`
+	RoundID   uuid.UUID      `json:"roundId,omitempty"`
+	Prompts   []QueuedPrompt `json:"prompts"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type QueueEventHandler func(ctx context.Context, event QueueEvent) error
+
+// StartOrQueue behaves like Start while the session is idle. While a round is
+// running, it appends the content to the session's FIFO queue instead; the
+// queued prompt starts automatically once the running round completes. A
+// stopped or failed round holds the queue until ResumeQueue.
+func (engine *Engine) StartOrQueue(
+	ctx context.Context,
+	sessionID string,
+	content conversation.Content,
+) (*StartResult, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+	if len(content) == 0 {
+		return nil, apperrors.Validation("session content must not be empty")
+	}
+
+	engine.mu.Lock()
+	if engine.shutdown || engine.ctx.Err() != nil {
+		engine.mu.Unlock()
+		return nil, apperrors.Internal("execution engine is shutting down")
+	}
+	if _, running := engine.active[id]; !running {
+		runCtx, execution := engine.reserveLocked(id)
+		engine.mu.Unlock()
+		return engine.launch(ctx, runCtx, id, execution, userPrompt(content))
+	}
+	prompt := QueuedPrompt{ID: shared.NewID(), Content: content, QueuedAt: time.Now().UTC()}
+	engine.queues[id] = append(engine.queues[id], prompt)
+	prompts := queueSnapshot(engine.queues[id])
+	engine.mu.Unlock()
+
+	engine.emitQueue(ctx, QueueEvent{
+		Type:      QueueEventEnqueued,
+		SessionID: id,
+		Prompt:    &prompt,
+		Prompts:   prompts,
+	})
+	return &StartResult{
+		SessionID:     id,
+		Status:        conversation.RoundPending,
+		QueuedPrompt:  &prompt,
+		QueuePosition: len(prompts),
+	}, nil
+}
+
+func (engine *Engine) ListQueue(_ context.Context, sessionID string) (*PromptQueue, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	return &PromptQueue{SessionID: id, Prompts: queueSnapshot(engine.queues[id])}, nil
+}
+
+// ResumeQueue starts the next queued prompt of an idle session, such as one
+// whose queue was held after a stopped round.
+func (engine *Engine) ResumeQueue(_ context.Context, sessionID string) (*StartResult, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+
+	engine.mu.Lock()
+	if engine.shutdown || engine.ctx.Err() != nil {
+		engine.mu.Unlock()
+		return nil, apperrors.Internal("execution engine is shutting down")
+	}
+	if _, running := engine.active[id]; running {
+		engine.mu.Unlock()
+		return nil, apperrors.AlreadyExists("session " + sessionID + " is already running")
+	}
+	next := engine.dequeueLocked(id)
+	engine.mu.Unlock()
+	if next == nil {
+		return nil, apperrors.NotFound("session " + sessionID + " has no queued prompts")
+	}
+	return engine.startQueued(id, next)
+}
+
+// ClearQueue removes the given prompts from the session's queue, or every
+// queued prompt when promptIDs is empty.
+func (engine *Engine) ClearQueue(
+	ctx context.Context,
+	sessionID string,
+	promptIDs []string,
+) (*PromptQueue, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+	remove := make(map[uuid.UUID]struct{}, len(promptIDs))
+	for _, promptID := range promptIDs {
+		parsed, err := uuid.Parse(promptID)
+		if err != nil {
+			return nil, apperrors.Validation("invalid prompt id: " + err.Error())
+		}
+		remove[parsed] = struct{}{}
+	}
+
+	engine.mu.Lock()
+	queue := engine.queues[id]
+	for promptID := range remove {
+		if !slices.ContainsFunc(queue, func(prompt QueuedPrompt) bool { return prompt.ID == promptID }) {
+			engine.mu.Unlock()
+			return nil, apperrors.NotFound("queued prompt " + promptID.String() + " not found")
+		}
+	}
+	if len(remove) == 0 {
+		queue = nil
+	} else {
+		queue = slices.DeleteFunc(slices.Clone(queue), func(prompt QueuedPrompt) bool {
+			_, ok := remove[prompt.ID]
+			return ok
+		})
+	}
+	engine.setQueueLocked(id, queue)
+	prompts := queueSnapshot(queue)
+	engine.mu.Unlock()
+
+	engine.emitQueue(ctx, QueueEvent{Type: QueueEventCleared, SessionID: id, Prompts: prompts})
+	return &PromptQueue{SessionID: id, Prompts: prompts}, nil
+}
+
+// dequeueLocked pops the next queued prompt and reserves the session for it.
+// It must be called with engine.mu held, after the previous execution left
+// the active map.
+func (engine *Engine) dequeueLocked(sessionID uuid.UUID) *queuedStart {
+	queue := engine.queues[sessionID]
+	if len(queue) == 0 || engine.shutdown || engine.ctx.Err() != nil {
+		return nil
+	}
+	prompt := queue[0]
+	engine.setQueueLocked(sessionID, queue[1:])
+	runCtx, execution := engine.reserveLocked(sessionID)
+	return &queuedStart{
+		prompt:    prompt,
+		runCtx:    runCtx,
+		execution: execution,
+		prompts:   queueSnapshot(engine.queues[sessionID]),
+	}
+}
+
+type queuedStart struct {
+	prompt    QueuedPrompt
+	runCtx    context.Context
+	execution *activeExecution
+	prompts   []QueuedPrompt
+}
+
+func (engine *Engine) startQueued(sessionID uuid.UUID, next *queuedStart) (*StartResult, error) {
+	result, err := engine.launch(next.runCtx, next.runCtx, sessionID, next.execution, userPrompt(next.prompt.Content))
+	event := QueueEvent{
+		Type:      QueueEventStarted,
+		SessionID: sessionID,
+		Prompt:    &next.prompt,
+		Prompts:   next.prompts,
+	}
+	if err != nil {
+		engine.logger.ErrorContext(engine.ctx, "failed to start queued prompt",
+			"sessionId", sessionID,
+			"promptId", next.prompt.ID,
+			"error", err,
+		)
+		event.Type = QueueEventFailed
+		event.Error = err.Error()
+	} else {
+		event.RoundID = result.RoundID
+	}
+	engine.emitQueue(context.WithoutCancel(engine.ctx), event)
+	return result, err
+}
+
+func (engine *Engine) setQueueLocked(sessionID uuid.UUID, queue []QueuedPrompt) {
+	if len(queue) == 0 {
+		delete(engine.queues, sessionID)
+		return
+	}
+	engine.queues[sessionID] = queue
+}
+
+func (engine *Engine) emitQueue(ctx context.Context, event QueueEvent) {
+	if engine.queueEvents == nil {
+		return
+	}
+	if err := engine.queueEvents(ctx, event); err != nil {
+		engine.logger.ErrorContext(ctx, "failed to emit queue event",
+			"sessionId", event.SessionID,
+			"type", event.Type,
+			"error", err,
+		)
+	}
+}
+
+func queueSnapshot(queue []QueuedPrompt) []QueuedPrompt {
+	prompts := make([]QueuedPrompt, len(queue))
+	copy(prompts, queue)
+	return prompts
+}
@@ -0,0 +1,353 @@
+package agentloop_test
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+type queueEventRecorder struct {
+	mu     sync.Mutex
+	events []agentloop.QueueEvent
+}
+
+func (recorder *queueEventRecorder) handle(_ context.Context, event agentloop.QueueEvent) error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.events = append(recorder.events, event)
+	return nil
+}
+
+func (recorder *queueEventRecorder) types() []agentloop.QueueEventType {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	types := make([]agentloop.QueueEventType, 0, len(recorder.events))
+	for _, event := range recorder.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEngineQueuesPromptsBehindRunningRound(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	caller := &scriptedCaller{
+		responses: []*agentloop.Response{
+			{Content: conversation.Text("first"), StopReason: agentloop.StopReasonEndTurn},
+			{Content: conversation.Text("third"), StopReason: agentloop.StopReasonEndTurn},
+		},
+		started: started,
+		release: release,
+	}
+	recorder := &queueEventRecorder{}
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return caller, nil
+		},
+		Queue: recorder.handle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = engine.Shutdown(shutdownCtx)
+	})
+	session := fixture.createSession(t)
+
+	first, err := engine.StartOrQueue(t.Context(), session.ID.String(), conversation.Text("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Status != conversation.RoundRunning || first.QueuedPrompt != nil {
+		t.Fatalf("idle start = %+v", first)
+	}
+	<-started
+
+	second, err := engine.StartOrQueue(t.Context(), session.ID.String(), conversation.Text("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := engine.StartOrQueue(t.Context(), session.ID.String(), conversation.Text("third"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Status != conversation.RoundPending || second.QueuePosition != 1 || third.QueuePosition != 2 {
+		t.Fatalf("queued results = %+v, %+v", second, third)
+	}
+	queue, err := engine.ListQueue(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Prompts) != 2 || queue.Prompts[0].ID != second.QueuedPrompt.ID {
+		t.Fatalf("queue = %+v", queue)
+	}
+	if _, err := engine.ClearQueue(t.Context(), session.ID.String(), []string{first.RoundID.String()}); appErrorCode(err) != application.CodeNotFound {
+		t.Errorf("clear unknown prompt error = %v, want not found", err)
+	}
+	queue, err = engine.ClearQueue(t.Context(), session.ID.String(), []string{second.QueuedPrompt.ID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Prompts) != 1 || queue.Prompts[0].ID != third.QueuedPrompt.ID {
+		t.Fatalf("queue after clear = %+v", queue)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.Rounds) == 2 && loaded.Rounds[1].Status.Terminal() {
+			if loaded.Rounds[1].Status != conversation.RoundCompleted || messageText(loaded.Rounds[1].Messages[len(loaded.Rounds[1].Messages)-2]) != "third" {
+				t.Fatalf("queued round = %+v", loaded.Rounds[1])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queued prompt did not run: rounds = %+v", loaded.Rounds)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	queue, err = engine.ListQueue(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Prompts) != 0 {
+		t.Errorf("queue after drain = %+v", queue)
+	}
+	want := []agentloop.QueueEventType{
+		agentloop.QueueEventEnqueued,
+		agentloop.QueueEventEnqueued,
+		agentloop.QueueEventCleared,
+		agentloop.QueueEventStarted,
+	}
+	if got := recorder.types(); !slices.Equal(got, want) {
+		t.Errorf("queue events = %v, want %v", got, want)
+	}
+}
+
+func TestEngineStartWithoutQueueRejectsBusySession(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	started := make(chan struct{}, 1)
+	caller := &scriptedCaller{
+		responses: []*agentloop.Response{{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn}},
+		started:   started,
+		release:   make(chan struct{}),
+	}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("again")); appErrorCode(err) != application.CodeAlreadyExists {
+		t.Fatalf("busy start error = %v, want already exists", err)
+	}
+	if _, err := engine.Stop(t.Context(), session.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	queue, err := engine.ListQueue(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Prompts) != 0 {
+		t.Errorf("queue = %+v", queue)
+	}
+}
+
+func TestEngineDeleteSessionDropsQueuedPrompts(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	started := make(chan struct{}, 1)
+	caller := &scriptedCaller{started: started, release: make(chan struct{})}
+	recorder := &queueEventRecorder{}
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return caller, nil
+		},
+		Queue: recorder.handle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = engine.Shutdown(shutdownCtx)
+	})
+	session := fixture.createSession(t)
+	deleteSession := func() error { return fixture.sessions.Delete(t.Context(), session.ID) }
+
+	if _, err := engine.StartOrQueue(t.Context(), session.ID.String(), conversation.Text("first")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := engine.StartOrQueue(t.Context(), session.ID.String(), conversation.Text("second")); err != nil {
+		t.Fatal(err)
+	}
+	if executed, err := engine.DeleteSessionIfIdle(t.Context(), session.ID, deleteSession); executed || err != nil {
+		t.Fatalf("delete running session = %v, %v", executed, err)
+	}
+
+	// Stopping the round holds the queued prompt.
+	if _, err := engine.Stop(t.Context(), session.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+	if executed, err := engine.DeleteSessionIfIdle(t.Context(), session.ID, deleteSession); !executed || err != nil {
+		t.Fatalf("delete idle session = %v, %v", executed, err)
+	}
+
+	queue, err := engine.ListQueue(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Prompts) != 0 {
+		t.Errorf("queue after delete = %+v", queue)
+	}
+	want := []agentloop.QueueEventType{
+		agentloop.QueueEventEnqueued,
+		agentloop.QueueEventHeld,
+		agentloop.QueueEventCleared,
+	}
+	if got := recorder.types(); !slices.Equal(got, want) {
+		t.Errorf("queue events = %v, want %v", got, want)
+	}
+}
+
+func TestEngineHoldsQueueWhenRoundDoesNotComplete(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name string
+		// end makes the running round end without completing.
+		end func(t *testing.T, engine *agentloop.Engine, sessionID string, release chan struct{})
+	}{
+		{
+			name: "stopped",
+			end: func(t *testing.T, engine *agentloop.Engine, sessionID string, _ chan struct{}) {
+				if _, err := engine.Stop(t.Context(), sessionID); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "failed",
+			end: func(_ *testing.T, _ *agentloop.Engine, _ string, release chan struct{}) {
+				release <- struct{}{}
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := newExecutionFixture(t, 8_192)
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			caller := &scriptedCaller{
+				responses: []*agentloop.Response{
+					{Content: conversation.Text("first"), StopReason: agentloop.StopReasonError},
+					{Content: conversation.Text("second answer"), StopReason: agentloop.StopReasonEndTurn},
+				},
+				started: started,
+				release: release,
+			}
+			recorder := &queueEventRecorder{}
+			engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+				Sessions: fixture.sessions,
+				Agents:   fixture.agents,
+				Catalog:  fixture.catalog,
+				Tools:    fixture.registry,
+				NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+					return caller, nil
+				},
+				Queue: recorder.handle,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_ = engine.Shutdown(shutdownCtx)
+			})
+			session := fixture.createSession(t)
+
+			if _, err := engine.StartOrQueue(t.Context(), session.ID.String(), conversation.Text("first")); err != nil {
+				t.Fatal(err)
+			}
+			<-started
+			queued, err := engine.StartOrQueue(t.Context(), session.ID.String(), conversation.Text("second"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.end(t, engine, session.ID.String(), release)
+			waitForExecution(t, engine, session.ID)
+
+			queue, err := engine.ListQueue(t.Context(), session.ID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(queue.Prompts) != 1 || queue.Prompts[0].ID != queued.QueuedPrompt.ID {
+				t.Fatalf("queue after the round ended = %+v, want the prompt held", queue)
+			}
+			if got := len(caller.Requests()); got != 1 {
+				t.Fatalf("requests = %d, want the queued prompt not started", got)
+			}
+			want := []agentloop.QueueEventType{agentloop.QueueEventEnqueued, agentloop.QueueEventHeld}
+			if got := recorder.types(); !slices.Equal(got, want) {
+				t.Errorf("queue events = %v, want %v", got, want)
+			}
+
+			close(release)
+			resumed, err := engine.ResumeQueue(t.Context(), session.ID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitForExecution(t, engine, session.ID)
+			loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := loaded.Rounds[len(loaded.Rounds)-1]
+			if last.ID != resumed.RoundID || last.Status != conversation.RoundCompleted {
+				t.Errorf("resumed round = %+v", last)
+			}
+			if _, err := engine.ResumeQueue(t.Context(), session.ID.String()); appErrorCode(err) != application.CodeNotFound {
+				t.Errorf("resume empty queue error = %v, want not found", err)
+			}
+		})
+	}
+}
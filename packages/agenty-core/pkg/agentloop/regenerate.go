@@ -0,0 +1,90 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/application/apperrors"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+// replayedPrompt opens a round with the same content and visibility as the
+// message that opened a reverted round.
+func replayedPrompt(message conversation.Message) roundOpening {
+	return func(session *conversation.Session, roundID uuid.UUID) (conversation.Message, error) {
+		if message.IsHidden() {
+			return session.AppendHiddenUserMessage(roundID, message.Content)
+		}
+		return session.AppendUserMessage(roundID, message.Content)
+	}
+}
+
+// Regenerate reverts the session's last round and runs its prompt again,
+// first switching to another model when providerCode and modelCode are set.
+// The reverted round stays in the transcript for audit.
+func (engine *Engine) Regenerate(
+	ctx context.Context,
+	sessionID string,
+	providerCode string,
+	modelCode string,
+) (*StartResult, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+	var model *shared.ModelRef
+	if providerCode != "" || modelCode != "" {
+		providerCodeValue, err := shared.NewCode(providerCode)
+		if err != nil {
+			return nil, apperrors.Validation(err.Error())
+		}
+		modelCodeValue, err := shared.NewModelCode(modelCode)
+		if err != nil {
+			return nil, apperrors.Validation(err.Error())
+		}
+		ref := shared.NewModelRef(providerCodeValue, modelCodeValue)
+		model = &ref
+	}
+
+	runCtx, execution, err := engine.reserve(id)
+	if err != nil {
+		return nil, err
+	}
+	session, err := engine.loadSession(ctx, id)
+	if err != nil {
+		engine.release(id, execution)
+		return nil, err
+	}
+	var last conversation.Round
+	var prompt conversation.Message
+	ok := len(session.Rounds) > 0
+	if ok {
+		last = session.Rounds[len(session.Rounds)-1]
+		prompt, ok = last.Prompt()
+	}
+	if !ok {
+		engine.release(id, execution)
+		return nil, apperrors.Validation("session " + id.String() + " has no prompt to regenerate")
+	}
+
+	if _, err := session.RevertRounds(last.Sequence); err != nil {
+		engine.release(id, execution)
+		if errors.Is(err, conversation.ErrRevertCompactedRound) {
+			return nil, apperrors.Validation(err.Error())
+		}
+		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to revert the last round", err)
+	}
+	if model != nil {
+		if err := engine.setModel(ctx, runCtx, session, *model); err != nil {
+			engine.release(id, execution)
+			return nil, err
+		}
+	}
+
+	// The revert is saved with the replacement round, so a round that cannot
+	// start leaves the last answer in place.
+	return engine.launchSession(ctx, runCtx, session, execution, replayedPrompt(prompt))
+}
@@ -0,0 +1,139 @@
+package agentloop_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+func TestEngineRegenerateRerunsLastPromptWithAnotherModel(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	backup := addFallbackProvider(t, fixture, 128_000)
+	primary := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("first answer"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	secondary := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("second answer"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := newFallbackEngine(t, fixture, map[shared.Code]agentloop.Caller{
+		"openai": primary,
+		"backup": secondary,
+	}, nil)
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+	result, err := engine.Regenerate(t.Context(), session.ID.String(), "backup", "backup-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Rounds) != 1 || loaded.Rounds[0].ID != result.RoundID || loaded.Rounds[0].Model != backup {
+		t.Fatalf("rounds = %+v", loaded.Rounds)
+	}
+	messages := loaded.VisibleCopy().Rounds[0].Messages
+	if len(messages) != 2 || messageText(messages[0]) != "hello" || messageText(messages[1]) != "second answer" {
+		t.Errorf("regenerated messages = %+v", messages)
+	}
+
+	requests := secondary.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("regenerate requests = %d, want 1", len(requests))
+	}
+	for _, message := range requests[0].Messages {
+		if messageText(message) == "first answer" {
+			t.Errorf("regenerated request still contains the reverted answer")
+		}
+	}
+
+	reverted := 0
+	for _, event := range fixture.sessions.events[session.ID] {
+		if _, ok := event.(conversation.RoundsReverted); ok {
+			reverted++
+		}
+	}
+	if reverted != 1 {
+		t.Errorf("rounds reverted events = %d, want 1", reverted)
+	}
+}
+
+func TestEngineRegenerateRequiresAPrompt(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return &scriptedCaller{}, nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Regenerate(t.Context(), session.ID.String(), "", ""); appErrorCode(err) != application.CodeValidation {
+		t.Errorf("regenerate without rounds error = %v, want validation", err)
+	}
+	if _, err := engine.Regenerate(t.Context(), session.ID.String(), "openai", ""); appErrorCode(err) != application.CodeValidation {
+		t.Errorf("regenerate with incomplete model error = %v, want validation", err)
+	}
+	if engine.IsRunning(session.ID) {
+		t.Error("rejected regenerate left the session reserved")
+	}
+}
+
+func TestEngineRegenerateKeepsLastRoundWhenReplacementCannotStart(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	var callers atomic.Int32
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("first answer"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		if callers.Add(1) > 1 {
+			return nil, errors.New("provider is unavailable")
+		}
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+	if _, err := engine.Regenerate(t.Context(), session.ID.String(), "", ""); err == nil {
+		t.Fatal("regenerate started without a caller")
+	}
+	if engine.IsRunning(session.ID) {
+		t.Error("failed regenerate left the session reserved")
+	}
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Rounds) != 1 {
+		t.Fatalf("rounds = %+v, want the last round kept", loaded.Rounds)
+	}
+	messages := loaded.VisibleCopy().Rounds[0].Messages
+	if len(messages) != 2 || messageText(messages[1]) != "first answer" {
+		t.Errorf("messages after failed regenerate = %+v", messages)
+	}
+	for _, event := range fixture.sessions.events[session.ID] {
+		if _, ok := event.(conversation.RoundsReverted); ok {
+			t.Errorf("failed regenerate saved %+v", event)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/application/apperrors"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+const resumeNotice = `<round-resumed>
+  The previous round ended with status %q before the task was finished.
+  Continue from the last message above. Do not repeat tool calls that already
+  returned results; re-run a tool only if its result was an interruption error.
+</round-resumed>`
+
+// roundOpening appends the message that opens a new round.
+type roundOpening func(session *conversation.Session, roundID uuid.UUID) (conversation.Message, error)
+
+func userPrompt(content conversation.Content) roundOpening {
+	return func(session *conversation.Session, roundID uuid.UUID) (conversation.Message, error) {
+		return session.AppendUserMessage(roundID, content)
+	}
+}
+
+func resumePrompt(status conversation.RoundStatus) roundOpening {
+	return func(session *conversation.Session, roundID uuid.UUID) (conversation.Message, error) {
+		return session.AppendHiddenUserMessage(roundID, conversation.Text(fmt.Sprintf(resumeNotice, status)))
+	}
+}
+
+// loadSession loads a session reserved by the caller. No round of a reserved
+// session can be executing, so rounds still open in its transcript were cut
+// off by a crash and are closed as interrupted before anything else happens.
+func (engine *Engine) loadSession(ctx context.Context, id uuid.UUID) (*conversation.Session, error) {
+	session, err := engine.sessions.Load(ctx, id)
+	if err != nil {
+		if errors.Is(err, conversation.ErrSessionNotFound) {
+			return nil, apperrors.NotFound("session " + id.String() + " not found")
+		}
+		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to load session", err)
+	}
+
+	recovered, err := session.RecoverInterruptedRounds()
+	if err != nil {
+		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to recover interrupted rounds", err)
+	}
+	if len(recovered) == 0 {
+		return session, nil
+	}
+	if err := engine.saveProgress(ctx, session); err != nil {
+		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to save recovered rounds", err)
+	}
+	engine.logger.InfoContext(ctx, "closed interrupted rounds", "sessionId", id, "rounds", recovered)
+	return session, nil
+}
+
+// Resume starts a new round that continues the work of the session's last
+// round when it did not complete, for example after core was killed mid-round.
+// The model sees the transcript up to the last persisted assistant or tool
+// message followed by a hidden notice asking it to carry on.
+func (engine *Engine) Resume(ctx context.Context, sessionID string) (*StartResult, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+
+	runCtx, execution, err := engine.reserve(id)
+	if err != nil {
+		return nil, err
+	}
+	session, err := engine.loadSession(ctx, id)
+	if err != nil {
+		engine.release(id, execution)
+		return nil, err
+	}
+	if len(session.Rounds) == 0 {
+		engine.release(id, execution)
+		return nil, apperrors.Validation("session " + id.String() + " has no round to resume")
+	}
+	last := session.Rounds[len(session.Rounds)-1]
+	if last.Status == conversation.RoundCompleted {
+		engine.release(id, execution)
+		return nil, apperrors.Validation("the last round of session " + id.String() + " completed; start a new round instead")
+	}
+
+	return engine.launch(ctx, runCtx, id, execution, resumePrompt(last.Status))
+}
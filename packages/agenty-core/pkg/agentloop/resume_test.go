@@ -0,0 +1,126 @@
+package agentloop_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+// createInterruptedSession persists a round that stopped after the model asked
+// for a tool call, as a transcript left behind by a killed process.
+func createInterruptedSession(t *testing.T, fixture *executionFixture) *conversation.Session {
+	t.Helper()
+
+	session := fixture.createSession(t)
+	roundID, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendUserMessage(roundID, conversation.Text("run the build")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendAssistantMessage(roundID, conversation.Content{
+		conversation.ToolUseBlock{ID: "call-build", Name: "shell", Input: []byte(`{}`)},
+	}, shared.NewModelRef("openai", "gpt-5"), &conversation.TokenUsage{Total: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fixture.sessions.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+	session.ClearPending()
+	return session
+}
+
+func TestEngineResumeClosesInterruptedRoundAndContinues(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("build finished"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := createInterruptedSession(t, fixture)
+
+	result, err := engine.Resume(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Rounds) != 2 || loaded.Rounds[1].ID != result.RoundID {
+		t.Fatalf("rounds = %+v, want the interrupted round and the resumed one", loaded.Rounds)
+	}
+	interrupted := loaded.Rounds[0]
+	if interrupted.Status != conversation.RoundInterrupted || interrupted.Usage.Total != 9 {
+		t.Errorf("interrupted round = %+v", interrupted)
+	}
+	if loaded.Rounds[1].Status != conversation.RoundCompleted {
+		t.Errorf("resumed round = %+v", loaded.Rounds[1])
+	}
+
+	requests := caller.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("requests = %d, want 1", len(requests))
+	}
+	messages := requests[0].Messages
+	notice := messages[len(messages)-1]
+	if !notice.IsHidden() || !strings.Contains(messageText(notice), "interrupted") {
+		t.Errorf("resume notice = %+v", notice)
+	}
+	var results []conversation.ToolResultBlock
+	for _, message := range messages {
+		results = append(results, toolResultBlocks(message.Content)...)
+	}
+	if len(results) != 1 || results[0].ToolUseID != "call-build" || !results[0].IsError {
+		t.Errorf("tool results sent to the model = %+v", results)
+	}
+}
+
+func TestEngineStartClosesInterruptedRoundFirst(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		{Content: conversation.Text("ok"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := createInterruptedSession(t, fixture)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("never mind")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Rounds[0].Status != conversation.RoundInterrupted || loaded.Rounds[1].Status != conversation.RoundCompleted {
+		t.Errorf("round statuses = %s, %s", loaded.Rounds[0].Status, loaded.Rounds[1].Status)
+	}
+	if _, err := engine.Resume(t.Context(), session.ID.String()); appErrorCode(err) != application.CodeValidation {
+		t.Errorf("resume after completed round error = %v, want validation", err)
+	}
+
+	empty := fixture.createSession(t)
+	if _, err := engine.Resume(t.Context(), empty.ID.String()); appErrorCode(err) != application.CodeValidation {
+		t.Errorf("resume without rounds error = %v, want validation", err)
+	}
+	if engine.IsRunning(empty.ID) {
+		t.Error("rejected resume left the session reserved")
+	}
+}
@@ -0,0 +1,111 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// DefaultRetryPolicy applies when Dependencies.Retry is left zero.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  4,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// RetryPolicy controls how model calls failing with a retryable ProviderError
+// are repeated. Delays grow exponentially from InitialDelay with jitter and
+// are capped at MaxDelay. A provider's Retry-After is honoured as is; when it
+// asks for more than MaxDelay the call fails instead of stalling the round.
+type RetryPolicy struct {
+	// MaxAttempts counts the first call, so one disables retries.
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+func (policy RetryPolicy) Validate() error {
+	if policy.MaxAttempts < 0 {
+		return errors.New("retry max attempts must not be negative")
+	}
+	if policy.InitialDelay < 0 || policy.MaxDelay < 0 {
+		return errors.New("retry delays must not be negative")
+	}
+	if policy.InitialDelay > 0 && policy.MaxDelay > 0 && policy.InitialDelay > policy.MaxDelay {
+		return errors.New("retry initial delay must not exceed max delay")
+	}
+	return nil
+}
+
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if policy.InitialDelay == 0 {
+		policy.InitialDelay = DefaultRetryPolicy.InitialDelay
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = max(DefaultRetryPolicy.MaxDelay, policy.InitialDelay)
+	}
+	return policy
+}
+
+// delay returns the wait after the given failed attempt, or false when the
+// provider asked for a longer pause than the policy allows.
+func (policy RetryPolicy) delay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= policy.MaxDelay
+	}
+	backoff := policy.InitialDelay
+	for step := 1; step < attempt && backoff < policy.MaxDelay; step++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, policy.MaxDelay)
+	// Equal jitter keeps at least half the backoff while spreading
+	// concurrent sessions that failed together.
+	half := backoff / 2
+	return half + rand.N(half+1), true
+}
+
+// withRetry runs attempt until it succeeds, fails with an error that is not a
+// retryable ProviderError, or exhausts the policy. notify is called before
+// each wait; its error aborts the call.
+func (engine *Engine) withRetry(
+	ctx context.Context,
+	attempt func(attempt int) (*Response, error),
+	notify func(retry ModelRetry) error,
+) (*Response, error) {
+	for number := 1; ; number++ {
+		response, err := attempt(number)
+		if err == nil {
+			return response, nil
+		}
+		var providerErr *ProviderError
+		if number >= engine.retry.MaxAttempts || ctx.Err() != nil ||
+			!errors.As(err, &providerErr) || !providerErr.Retryable {
+			return nil, err
+		}
+		delay, ok := engine.retry.delay(number, providerErr.RetryAfter)
+		if !ok {
+			return nil, err
+		}
+		if notifyErr := notify(ModelRetry{
+			Attempt:     number,
+			MaxAttempts: engine.retry.MaxAttempts,
+			DelayMs:     delay.Milliseconds(),
+			Reason:      err.Error(),
+			StatusCode:  providerErr.StatusCode,
+		}); notifyErr != nil {
+			return nil, notifyErr
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
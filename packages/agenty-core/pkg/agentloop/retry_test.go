@@ -0,0 +1,266 @@
+package agentloop_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+// flakyCaller streams a partial delta and fails with each of failures in turn
+// before handing over to the scripted caller.
+type flakyCaller struct {
+	scriptedCaller
+	failures []error
+	attempts int
+}
+
+func (caller *flakyCaller) nextFailure() error {
+	caller.mu.Lock()
+	defer caller.mu.Unlock()
+
+	caller.attempts++
+	if len(caller.failures) == 0 {
+		return nil
+	}
+	failure := caller.failures[0]
+	caller.failures = caller.failures[1:]
+	return failure
+}
+
+func (caller *flakyCaller) Attempts() int {
+	caller.mu.Lock()
+	defer caller.mu.Unlock()
+
+	return caller.attempts
+}
+
+func (caller *flakyCaller) Invoke(ctx context.Context, request agentloop.Request) (*agentloop.Response, error) {
+	if err := caller.nextFailure(); err != nil {
+		return nil, err
+	}
+	return caller.scriptedCaller.Invoke(ctx, request)
+}
+
+func (caller *flakyCaller) Stream(
+	ctx context.Context,
+	request agentloop.Request,
+	handler agentloop.StreamHandler,
+) (*agentloop.Response, error) {
+	if err := caller.nextFailure(); err != nil {
+		if handlerErr := handler(agentloop.StreamEvent{
+			Type:  agentloop.StreamEventTextDelta,
+			Delta: "partial",
+		}); handlerErr != nil {
+			return nil, handlerErr
+		}
+		return nil, err
+	}
+	return caller.scriptedCaller.Stream(ctx, request, handler)
+}
+
+func newRetryEngine(
+	t *testing.T,
+	fixture *executionFixture,
+	caller agentloop.Caller,
+	events agentloop.SessionEventHandler,
+) *agentloop.Engine {
+	t.Helper()
+
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return caller, nil
+		},
+		Events: events,
+		Retry: agentloop.RetryPolicy{
+			MaxAttempts:  3,
+			InitialDelay: time.Millisecond,
+			MaxDelay:     20 * time.Millisecond,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = engine.Shutdown(shutdownCtx)
+	})
+	return engine
+}
+
+type sessionEventRecorder struct {
+	mu     sync.Mutex
+	events []agentloop.SessionEvent
+}
+
+func (recorder *sessionEventRecorder) handle(_ context.Context, event agentloop.SessionEvent) error {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.events = append(recorder.events, event)
+	return nil
+}
+
+func (recorder *sessionEventRecorder) ofType(eventType agentloop.SessionEventType) []agentloop.SessionEvent {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	var matched []agentloop.SessionEvent
+	for _, event := range recorder.events {
+		if event.Type == eventType {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+func TestEngineRetriesTransientModelFailures(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	caller := &flakyCaller{
+		scriptedCaller: scriptedCaller{responses: []*agentloop.Response{
+			{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+		}},
+		failures: []error{
+			&agentloop.ProviderError{Err: errors.New("overloaded"), StatusCode: 529, Retryable: true},
+			&agentloop.ProviderError{Err: errors.New("rate limited"), StatusCode: 429, Retryable: true, RetryAfter: 5 * time.Millisecond},
+		},
+	}
+	recorder := &sessionEventRecorder{}
+	engine := newRetryEngine(t, fixture, caller, recorder.handle)
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	if caller.Attempts() != 3 {
+		t.Fatalf("attempts = %d, want 3", caller.Attempts())
+	}
+	retries := recorder.ofType(agentloop.SessionEventModelRetry)
+	if len(retries) != 2 {
+		t.Fatalf("retry events = %+v", retries)
+	}
+	for index, event := range retries {
+		retry := event.Retry
+		if retry == nil || retry.Attempt != index+1 || retry.MaxAttempts != 3 || retry.Reason == "" || event.Iteration != 1 {
+			t.Errorf("retry event %d = %+v", index, event)
+		}
+	}
+	if retries[0].Retry.StatusCode != 529 || retries[1].Retry.DelayMs != 5 {
+		t.Errorf("retry details = %+v, %+v", retries[0].Retry, retries[1].Retry)
+	}
+	var deltasByAttempt [4]int
+	for _, event := range recorder.ofType(agentloop.SessionEventModelStream) {
+		if event.Stream.Type == agentloop.StreamEventTextDelta {
+			deltasByAttempt[event.Attempt]++
+		}
+	}
+	if deltasByAttempt != [4]int{0, 1, 1, 1} {
+		t.Errorf("deltas by attempt = %v", deltasByAttempt)
+	}
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	round := loaded.Rounds[0]
+	if round.Status != conversation.RoundCompleted {
+		t.Fatalf("round = %+v", round)
+	}
+	if got := messageText(round.Messages[len(round.Messages)-1]); got != "done" {
+		t.Errorf("final message = %q, want only the successful attempt", got)
+	}
+}
+
+func TestEngineDoesNotRetryFatalModelFailures(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		failures []error
+		attempts int
+	}{
+		{
+			name:     "fatal provider error",
+			failures: []error{&agentloop.ProviderError{Err: errors.New("bad request"), StatusCode: 400}},
+			attempts: 1,
+		},
+		{
+			name:     "unclassified error",
+			failures: []error{errors.New("boom")},
+			attempts: 1,
+		},
+		{
+			name:     "retry-after beyond max delay",
+			failures: []error{&agentloop.ProviderError{Err: errors.New("slow down"), Retryable: true, RetryAfter: time.Minute}},
+			attempts: 1,
+		},
+		{
+			name: "attempts exhausted",
+			failures: []error{
+				&agentloop.ProviderError{Err: errors.New("unavailable"), Retryable: true},
+				&agentloop.ProviderError{Err: errors.New("unavailable"), Retryable: true},
+				&agentloop.ProviderError{Err: errors.New("unavailable"), Retryable: true},
+			},
+			attempts: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			fixture := newExecutionFixture(t, 8_192)
+			caller := &flakyCaller{failures: test.failures}
+			engine := newRetryEngine(t, fixture, caller, nil)
+			session := fixture.createSession(t)
+
+			if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+				t.Fatal(err)
+			}
+			waitForExecution(t, engine, session.ID)
+
+			if caller.Attempts() != test.attempts {
+				t.Errorf("attempts = %d, want %d", caller.Attempts(), test.attempts)
+			}
+			loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if round := loaded.Rounds[0]; round.Status != conversation.RoundFailed || round.Error == nil {
+				t.Errorf("round = %+v", round)
+			}
+		})
+	}
+}
+
+func TestNewEngineRejectsInvalidRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	_, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return nil, errors.New("unused")
+		},
+		Retry: agentloop.RetryPolicy{InitialDelay: time.Minute, MaxDelay: time.Second},
+	})
+	if err == nil {
+		t.Fatal("NewEngine accepted an initial delay above the max delay")
+	}
+}
@@ -0,0 +1,119 @@
+package agentloop
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/application/apperrors"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+type SteerResult struct {
+	SessionID uuid.UUID `json:"sessionId"`
+	RoundID   uuid.UUID `json:"roundId"`
+	Queued    int       `json:"queued"`
+}
+
+// Steer queues user content for a running round. The loop appends it as a
+// user message at its next iteration boundary, after any tool results, so the
+// model sees the correction before its next call. A round that already
+// produced its final answer no longer accepts steering, and content a round
+// ends without taking moves to the front of the session's prompt queue.
+func (engine *Engine) Steer(
+	_ context.Context,
+	sessionID string,
+	content conversation.Content,
+) (*SteerResult, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+	if len(content) == 0 {
+		return nil, apperrors.Validation("steering content must not be empty")
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	execution, ok := engine.active[id]
+	if !ok || execution.steeringClosed {
+		return nil, apperrors.NotFound("session " + sessionID + " is not running")
+	}
+	execution.steering = append(execution.steering, content)
+	return &SteerResult{
+		SessionID: id,
+		RoundID:   execution.roundID,
+		Queued:    len(execution.steering),
+	}, nil
+}
+
+// takeSteering drains the queued steering content. When closing is set and
+// nothing is queued, the round stops accepting steering so that content sent
+// after the final answer is rejected instead of silently dropped.
+func (engine *Engine) takeSteering(execution *activeExecution, closing bool) []conversation.Content {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	steering := execution.steering
+	execution.steering = nil
+	if closing && len(steering) == 0 {
+		execution.steeringClosed = true
+	}
+	return steering
+}
+
+// requeueSteeringLocked closes steering on a released execution and puts the
+// content it did not take at the front of the session's prompt queue, as one
+// prompt. It must be called with engine.mu held and returns the queue event to
+// emit, or nil when nothing was left.
+func (engine *Engine) requeueSteeringLocked(sessionID uuid.UUID, execution *activeExecution) *QueueEvent {
+	execution.steeringClosed = true
+	steering := execution.steering
+	execution.steering = nil
+	if len(steering) == 0 {
+		return nil
+	}
+
+	content := make(conversation.Content, 0, len(steering))
+	for _, steered := range steering {
+		content = append(content, steered...)
+	}
+	prompt := QueuedPrompt{ID: shared.NewID(), Content: content, QueuedAt: time.Now().UTC()}
+	queue := append([]QueuedPrompt{prompt}, engine.queues[sessionID]...)
+	engine.setQueueLocked(sessionID, queue)
+	return &QueueEvent{
+		Type:      QueueEventEnqueued,
+		SessionID: sessionID,
+		Prompt:    &prompt,
+		Prompts:   queueSnapshot(queue),
+	}
+}
+
+func (engine *Engine) appendSteering(
+	ctx context.Context,
+	prepared *preparedExecution,
+	iteration int,
+	steering []conversation.Content,
+) error {
+	for _, content := range steering {
+		message, err := prepared.session.AppendUserMessage(prepared.roundID, content)
+		if err != nil {
+			return fmt.Errorf("append steering message at iteration %d: %w", iteration, err)
+		}
+		if err := engine.saveProgress(ctx, prepared.session); err != nil {
+			return fmt.Errorf("save steering message at iteration %d: %w", iteration, err)
+		}
+		if err := engine.emit(ctx, prepared, SessionEvent{
+			Type:      SessionEventMessageAppended,
+			Iteration: iteration,
+			Message:   &message,
+		}); err != nil {
+			return fmt.Errorf("emit steering message at iteration %d: %w", iteration, err)
+		}
+	}
+	return nil
+}
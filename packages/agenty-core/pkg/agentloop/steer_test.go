@@ -0,0 +1,204 @@
+package agentloop_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func messageText(message conversation.Message) string {
+	if len(message.Content) == 0 {
+		return ""
+	}
+	text, _ := message.Content[0].(conversation.TextBlock)
+	return text.Text
+}
+
+func TestEngineAppendsSteeringAfterToolResults(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	toolStarted := make(chan struct{})
+	toolRelease := make(chan struct{})
+	if err := fixture.registry.Register(&executionTestTool{
+		definition: agentloop.ToolDefinition{
+			Name:        "lookup",
+			InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+		},
+		execute: func(context.Context, agentloop.CallContext, []byte) (conversation.Content, error) {
+			close(toolStarted)
+			<-toolRelease
+			return conversation.Text("tool result"), nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-1", Name: "lookup", Input: []byte(`{}`)}),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	var appended []conversation.Message
+	engine := fixture.newEngineWithEvents(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	}, func(_ context.Context, event agentloop.SessionEvent) error {
+		if event.Type == agentloop.SessionEventMessageAppended {
+			appended = append(appended, *event.Message)
+		}
+		return nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Steer(t.Context(), session.ID.String(), conversation.Text("too early")); appErrorCode(err) != application.CodeNotFound {
+		t.Fatalf("steer idle session error = %v, want not found", err)
+	}
+	started, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-toolStarted:
+	case <-time.After(time.Second):
+		t.Fatal("tool did not start")
+	}
+	if _, err := engine.Steer(t.Context(), session.ID.String(), nil); appErrorCode(err) != application.CodeValidation {
+		t.Errorf("empty steer error = %v, want validation", err)
+	}
+	result, err := engine.Steer(t.Context(), session.ID.String(), conversation.Text("use the other file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RoundID != started.RoundID || result.Queued != 1 {
+		t.Errorf("steer result = %+v", result)
+	}
+	close(toolRelease)
+	waitForExecution(t, engine, session.ID)
+
+	requests := caller.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(requests))
+	}
+	messages := requests[1].Messages
+	if len(toolResultBlocks(messages[len(messages)-2].Content)) != 1 {
+		t.Fatalf("second to last request message = %+v", messages[len(messages)-2])
+	}
+	steering := messages[len(messages)-1]
+	if steering.Role != conversation.RoleUser || messageText(steering) != "use the other file" {
+		t.Errorf("steering message = %+v", steering)
+	}
+	if len(appended) != 5 || messageText(appended[3]) != "use the other file" {
+		t.Errorf("appended messages = %+v", appended)
+	}
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	round := loaded.Rounds[0]
+	if round.Status != conversation.RoundCompleted || len(round.Messages) != 6 || messageText(round.Messages[4]) != "use the other file" {
+		t.Errorf("round = %+v", round)
+	}
+}
+
+func TestEngineContinuesAfterFinalAnswerWhenSteered(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	caller := &scriptedCaller{
+		responses: []*agentloop.Response{
+			{Content: conversation.Text("first answer"), StopReason: agentloop.StopReasonEndTurn},
+			{Content: conversation.Text("revised answer"), StopReason: agentloop.StopReasonEndTurn},
+		},
+		started: started,
+		release: release,
+	}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := engine.Steer(t.Context(), session.ID.String(), conversation.Text("be brief")); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	waitForExecution(t, engine, session.ID)
+
+	if got := len(caller.Requests()); got != 2 {
+		t.Fatalf("requests = %d, want 2", got)
+	}
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := loaded.Rounds[0].Messages
+	last := messages[len(messages)-1]
+	if messageText(messages[len(messages)-2]) != "be brief" || messageText(last) != "revised answer" {
+		t.Errorf("round messages = %+v", messages)
+	}
+	if _, err := engine.Steer(t.Context(), session.ID.String(), conversation.Text("late")); appErrorCode(err) != application.CodeNotFound {
+		t.Errorf("steer after round error = %v, want not found", err)
+	}
+}
+
+func TestEngineQueuesSteeringOfRoundThatFails(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	caller := &scriptedCaller{started: started, release: release}
+	recorder := &queueEventRecorder{}
+	engine, err := agentloop.NewEngine(t.Context(), agentloop.Dependencies{
+		Sessions: fixture.sessions,
+		Agents:   fixture.agents,
+		Catalog:  fixture.catalog,
+		Tools:    fixture.registry,
+		NewCaller: func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+			return caller, nil
+		},
+		Queue: recorder.handle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = engine.Shutdown(shutdownCtx)
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := engine.Steer(t.Context(), session.ID.String(), conversation.Text("be brief")); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	waitForExecution(t, engine, session.ID)
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if len(recorder.events) == 0 || recorder.events[0].Type != agentloop.QueueEventEnqueued ||
+		recorder.events[0].Prompt == nil || recorder.events[0].Prompt.Content[0] != (conversation.TextBlock{Text: "be brief"}) {
+		t.Fatalf("queue events = %+v, want the steering queued", recorder.events)
+	}
+	queue, err := engine.ListQueue(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Prompts) != 1 || queue.Prompts[0].ID != recorder.events[0].Prompt.ID {
+		t.Errorf("queue = %+v, want the steering held", queue)
+	}
+}
@@ -0,0 +1,191 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/application/apperrors"
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+// defaultMaxDelegationDepth bounds how many sub-agents may be nested below a
+// session started by the user.
+const defaultMaxDelegationDepth = 2
+
+type SubagentRequest struct {
+	AgentCode string
+	Prompt    string
+}
+
+type SubagentResult struct {
+	SessionID uuid.UUID                `json:"sessionId"`
+	RoundID   uuid.UUID                `json:"roundId"`
+	Status    conversation.RoundStatus `json:"status"`
+	Answer    string                   `json:"answer"`
+	Usage     conversation.TokenUsage  `json:"usage"`
+}
+
+// SubagentRunner runs a delegated subtask to completion in a child session.
+type SubagentRunner interface {
+	RunSubagent(ctx context.Context, callContext CallContext, request SubagentRequest) (*SubagentResult, error)
+}
+
+var _ SubagentRunner = (*Engine)(nil)
+
+// RunSubagent starts a child session of the calling session for the requested
+// agent and blocks until its round ends. Stopping the parent round cancels the
+// child. Only the child's final answer is returned; its transcript stays in
+// the child session.
+func (engine *Engine) RunSubagent(
+	ctx context.Context,
+	callContext CallContext,
+	request SubagentRequest,
+) (*SubagentResult, error) {
+	code, err := shared.NewCode(request.AgentCode)
+	if err != nil {
+		return nil, apperrors.Validation(err.Error())
+	}
+	if strings.TrimSpace(request.Prompt) == "" {
+		return nil, apperrors.Validation("sub-agent prompt must not be empty")
+	}
+
+	parent, err := engine.sessions.Load(ctx, callContext.SessionID)
+	if err != nil {
+		if errors.Is(err, conversation.ErrSessionNotFound) {
+			return nil, apperrors.NotFound("session " + callContext.SessionID.String() + " not found")
+		}
+		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to load parent session", err)
+	}
+	depth := parent.Depth() + 1
+	if depth > engine.maxDelegationDepth {
+		return nil, apperrors.Validation("sub-agent delegation is limited to a depth of " + strconv.Itoa(engine.maxDelegationDepth))
+	}
+	definition, err := engine.agents.Get(ctx, code)
+	if err != nil {
+		if errors.Is(err, agent.ErrNotFound) {
+			return nil, apperrors.NotFound("agent " + code.String() + " not found")
+		}
+		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to load agent", err)
+	}
+
+	child := startChildSession(parent, definition, conversation.SessionParent{
+		SessionID: callContext.SessionID,
+		RoundID:   callContext.RoundID,
+		ToolUseID: callContext.ToolUseID,
+		Depth:     depth,
+	}, callContext.Cwd, callContext.Mode)
+
+	// Reserve the child before saving it, so a session that never gets a
+	// round is not left behind.
+	runCtx, execution, err := engine.reserve(child.ID)
+	if err != nil {
+		return nil, err
+	}
+	stopChild := context.AfterFunc(ctx, execution.cancel)
+	defer stopChild()
+
+	if err := engine.saveProgress(ctx, child); err != nil {
+		engine.release(child.ID, execution)
+		return nil, apperrors.WrapError(apperrors.CodeInternal, "failed to save child session", err)
+	}
+	prepared, err := engine.prepare(ctx, runCtx, child.ID, userPrompt(conversation.Text(request.Prompt)))
+	if err != nil {
+		if deleteErr := engine.sessions.Delete(context.WithoutCancel(ctx), child.ID); deleteErr != nil {
+			engine.logger.ErrorContext(ctx, "failed to delete child session",
+				"sessionId", child.ID,
+				"error", deleteErr,
+			)
+		}
+		engine.release(child.ID, execution)
+		return nil, err
+	}
+	engine.mu.Lock()
+	execution.roundID = prepared.roundID
+	engine.mu.Unlock()
+
+	engine.run(runCtx, child.ID, execution, prepared)
+
+	round := prepared.session.Rounds[len(prepared.session.Rounds)-1]
+	result := &SubagentResult{
+		SessionID: child.ID,
+		RoundID:   round.ID,
+		Status:    round.Status,
+		Usage:     round.Usage,
+	}
+	if round.Status != conversation.RoundCompleted {
+		reason := string(round.Status)
+		if round.Error != nil {
+			reason += ": " + *round.Error
+		}
+		return result, fmt.Errorf("sub-agent session %s ended with %s", child.ID, reason)
+	}
+	result.Answer = finalAnswer(round)
+	return result, nil
+}
+
+// startChildSession configures the child from the agent's defaults, falling
+// back to the parent's current model settings. A child of a round in plan
+// mode plans too, and every child shares the parent's workspace roots. mode
+// is the calling round's; when it is empty the parent session's is used.
+func startChildSession(
+	parent *conversation.Session,
+	definition *agent.Agent,
+	link conversation.SessionParent,
+	cwd string,
+	mode conversation.SessionMode,
+) *conversation.Session {
+	var model shared.ModelRef
+	if parent.CurrentModel != nil {
+		model = *parent.CurrentModel
+	}
+	contextWindow := parent.ContextWindow
+	if definition.DefaultModel != nil && !definition.DefaultModel.IsZero() {
+		model = *definition.DefaultModel
+		contextWindow = definition.DefaultContextWindow
+	}
+	effort := parent.CurrentReasoningEffort
+	if definition.DefaultReasoningEffort != "" {
+		effort = definition.DefaultReasoningEffort
+	}
+	var childCwd *string
+	if cwd != "" {
+		childCwd = &cwd
+	} else {
+		childCwd = parent.Cwd
+	}
+	child := conversation.StartChildSession(link, definition.Code, model, contextWindow, effort, childCwd)
+	if mode == "" {
+		mode = parent.Mode
+	}
+	if mode.Effective() == conversation.ModePlan {
+		child.SetMode(conversation.ModePlan)
+	}
+	if len(parent.WorkspaceRoots) > 0 {
+		child.SetWorkspaceRoots(parent.WorkspaceRoots)
+	}
+	return child
+}
+
+func finalAnswer(round conversation.Round) string {
+	for index := len(round.Messages) - 1; index >= 0; index-- {
+		message := round.Messages[index]
+		if message.Role != conversation.RoleAssistant {
+			continue
+		}
+		parts := make([]string, 0, len(message.Content))
+		for _, block := range message.Content {
+			if text, ok := block.(conversation.TextBlock); ok && text.Text != "" {
+				parts = append(parts, text.Text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
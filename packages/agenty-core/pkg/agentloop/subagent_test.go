@@ -0,0 +1,270 @@
+package agentloop_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+func TestEngineRunsSubagentInChildSession(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	reviewer, err := agent.New("reviewer", "Reviewer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fixture.agents.Save(t.Context(), reviewer); err != nil {
+		t.Fatal(err)
+	}
+	var subagent *agentloop.SubagentResult
+	if err := fixture.registry.Register(&executionTestTool{
+		definition: agentloop.ToolDefinition{
+			Name:        "delegate",
+			InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+		},
+		execute: func(ctx context.Context, callContext agentloop.CallContext, _ []byte) (conversation.Content, error) {
+			result, err := callContext.Subagents.RunSubagent(ctx, callContext, agentloop.SubagentRequest{
+				AgentCode: "reviewer",
+				Prompt:    "review the change",
+			})
+			if err != nil {
+				return nil, err
+			}
+			subagent = result
+			return conversation.Text(result.Answer), nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-delegate", Name: "delegate", Input: []byte(`{}`)}),
+		{Content: conversation.Text("looks good"), StopReason: agentloop.StopReasonEndTurn},
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	var mu sync.Mutex
+	childEvents := make([]agentloop.SessionEvent, 0)
+	engine := fixture.newEngineWithEvents(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	}, func(_ context.Context, event agentloop.SessionEvent) error {
+		if event.Parent != nil {
+			mu.Lock()
+			childEvents = append(childEvents, event)
+			mu.Unlock()
+		}
+		return nil
+	})
+	session := fixture.createSession(t)
+
+	started, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	requests := caller.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("requests = %d, want 3", len(requests))
+	}
+	if len(requests[1].Messages) != 2 || messageText(requests[1].Messages[1]) != "review the change" {
+		t.Errorf("child request messages = %+v", requests[1].Messages)
+	}
+	results := toolResultBlocks(requests[2].Messages[len(requests[2].Messages)-1].Content)
+	if len(results) != 1 || results[0].IsError {
+		t.Fatalf("parent tool results = %+v", results)
+	}
+	if text, _ := results[0].Content[0].(conversation.TextBlock); text.Text != "looks good" {
+		t.Errorf("parent tool result = %+v", results[0])
+	}
+
+	if subagent == nil || subagent.Status != conversation.RoundCompleted {
+		t.Fatalf("sub-agent result = %+v", subagent)
+	}
+	child, err := fixture.sessions.Load(t.Context(), subagent.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantParent := conversation.SessionParent{
+		SessionID: session.ID,
+		RoundID:   started.RoundID,
+		ToolUseID: "call-delegate",
+		Depth:     1,
+	}
+	if child.AgentCode != "reviewer" || child.Parent == nil || *child.Parent != wantParent {
+		t.Errorf("child session agent = %s, parent = %+v", child.AgentCode, child.Parent)
+	}
+	if child.Cwd == nil || *child.Cwd != "/workspace" {
+		t.Errorf("child cwd = %v", child.Cwd)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(childEvents) == 0 {
+		t.Fatal("child session events were not forwarded")
+	}
+	for _, event := range childEvents {
+		if event.SessionID != child.ID || *event.Parent != wantParent {
+			t.Errorf("child event = %+v", event)
+		}
+	}
+}
+
+func TestEngineRejectsSubagentsBeyondDepthLimit(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return &scriptedCaller{}, nil
+	})
+	root := fixture.createSession(t)
+	nested := conversation.StartChildSession(
+		conversation.SessionParent{SessionID: root.ID, Depth: 2},
+		"coder",
+		shared.NewModelRef("openai", "gpt-5"),
+		128_000,
+		shared.ReasoningOff,
+		nil,
+	)
+	if err := fixture.sessions.Save(t.Context(), nested); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := engine.RunSubagent(t.Context(), agentloop.CallContext{SessionID: nested.ID}, agentloop.SubagentRequest{
+		AgentCode: "coder",
+		Prompt:    "go deeper",
+	})
+	if appErrorCode(err) != application.CodeValidation || !strings.Contains(err.Error(), "depth") {
+		t.Errorf("nested delegation error = %v, want depth validation", err)
+	}
+	if _, err := engine.RunSubagent(t.Context(), agentloop.CallContext{SessionID: root.ID}, agentloop.SubagentRequest{
+		AgentCode: "missing",
+		Prompt:    "help",
+	}); appErrorCode(err) != application.CodeNotFound {
+		t.Errorf("unknown agent error = %v, want not found", err)
+	}
+}
+
+func TestEngineLeavesNoChildSessionWhenSubagentCannotStart(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	broken, err := agent.New("broken", "Broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken.DefaultModel = ptr(shared.NewModelRef("missing", "gpt-5"))
+	broken.DefaultContextWindow = 128_000
+	if err := fixture.agents.Save(t.Context(), broken); err != nil {
+		t.Fatal(err)
+	}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return &scriptedCaller{}, nil
+	})
+	root := fixture.createSession(t)
+	callContext := agentloop.CallContext{SessionID: root.ID}
+	sessionCount := func() int {
+		sessions, err := fixture.sessions.List(t.Context(), conversation.ListQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(sessions)
+	}
+
+	if _, err := engine.RunSubagent(t.Context(), callContext, agentloop.SubagentRequest{
+		AgentCode: "broken",
+		Prompt:    "help",
+	}); err == nil {
+		t.Fatal("sub-agent with a missing provider started")
+	}
+	if count := sessionCount(); count != 1 {
+		t.Errorf("sessions after failed prepare = %d, want 1", count)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := engine.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.RunSubagent(t.Context(), callContext, agentloop.SubagentRequest{
+		AgentCode: "coder",
+		Prompt:    "help",
+	}); err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Fatalf("sub-agent after shutdown error = %v", err)
+	}
+	if count := sessionCount(); count != 1 {
+		t.Errorf("sessions after shutdown = %d, want 1", count)
+	}
+}
+
+func TestEngineStartsSubagentInModeOfCallingRound(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	var subagent *agentloop.SubagentResult
+	if err := fixture.registry.Register(&executionTestTool{
+		definition: agentloop.ToolDefinition{
+			Name:        "delegate",
+			InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+		},
+		execute: func(ctx context.Context, callContext agentloop.CallContext, _ []byte) (conversation.Content, error) {
+			// The user switches the session to build while the round plans.
+			parent, err := fixture.sessions.Load(ctx, callContext.SessionID)
+			if err != nil {
+				return nil, err
+			}
+			parent.SetMode(conversation.ModeBuild)
+			if err := fixture.sessions.Save(ctx, parent); err != nil {
+				return nil, err
+			}
+			result, err := callContext.Subagents.RunSubagent(ctx, callContext, agentloop.SubagentRequest{
+				AgentCode: "coder",
+				Prompt:    "look around",
+			})
+			if err != nil {
+				return nil, err
+			}
+			subagent = result
+			return conversation.Text(result.Answer), nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(conversation.ToolUseBlock{ID: "call-delegate", Name: "delegate", Input: []byte(`{}`)}),
+		{Content: conversation.Text("found it"), StopReason: agentloop.StopReasonEndTurn},
+		{Content: conversation.Text("the plan"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	engine := fixture.newEngine(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	})
+	session := fixture.createSession(t)
+	session.SetMode(conversation.ModePlan)
+	if err := fixture.sessions.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("plan it")); err != nil {
+		t.Fatal(err)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	if subagent == nil {
+		t.Fatal("sub-agent did not run")
+	}
+	child, err := fixture.sessions.Load(t.Context(), subagent.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.Mode != conversation.ModePlan || child.Rounds[0].Mode.Effective() != conversation.ModePlan {
+		t.Errorf("child mode = %q, round mode = %q, want plan", child.Mode, child.Rounds[0].Mode)
+	}
+}
@@ -114,6 +114,17 @@ func (repository *sessionRepositoryFake) Load(
 	return conversation.ReplaySession(events), nil
 }
 
+func (repository *sessionRepositoryFake) Events(_ context.Context, id uuid.UUID) ([]shared.Event, error) {
+	repository.mu.RLock()
+	defer repository.mu.RUnlock()
+
+	events, ok := repository.events[id]
+	if !ok {
+		return nil, storage.ErrConversationNotFound
+	}
+	return append([]shared.Event(nil), events...), nil
+}
+
 func (repository *sessionRepositoryFake) Save(
 	_ context.Context,
 	session *conversation.Session,
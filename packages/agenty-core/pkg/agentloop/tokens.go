@@ -0,0 +1,181 @@
+package agentloop
+
+import (
+	"encoding/json"
+	"math"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+const (
+	messageOverheadTokens int64 = 4
+	blockOverheadTokens   int64 = 3
+	// imageTokens approximates one image as providers bill a picture scaled to
+	// about a megapixel.
+	imageTokens int64 = 1_600
+)
+
+// Tokenizer counts the tokens a model reads for a piece of text.
+type Tokenizer interface {
+	CountTokens(text string) int64
+}
+
+// TokenizerFactory returns the tokenizer of a catalog model, or nil to fall
+// back to the built-in estimate.
+type TokenizerFactory func(provider catalog.Provider, model catalog.Model) Tokenizer
+
+// estimatedTokenizer weighs every rune by its UTF-8 width, which follows
+// byte-level BPE vocabularies much closer than a flat rune count: ASCII text
+// averages four characters per token while CJK characters take one each.
+type estimatedTokenizer struct{}
+
+func (estimatedTokenizer) CountTokens(text string) int64 {
+	var quarters int64
+	for _, r := range text {
+		switch width := utf8.RuneLen(r); {
+		case width <= 1:
+			quarters++
+		case width == 2:
+			quarters += 2
+		case width == 3:
+			quarters += 4
+		default:
+			quarters += 8
+		}
+	}
+	return (quarters + 3) / 4
+}
+
+func (engine *Engine) tokenizer(provider catalog.Provider, model catalog.Model) Tokenizer {
+	if engine.newTokenizer != nil {
+		if tokenizer := engine.newTokenizer(provider, model); tokenizer != nil {
+			return tokenizer
+		}
+	}
+	return estimatedTokenizer{}
+}
+
+// tokenCalibration anchors estimates to the prompt size the provider reported
+// for the last call, so only the messages appended since have to be counted.
+type tokenCalibration struct {
+	promptTokens  int64
+	messages      int
+	lastMessageID uuid.UUID
+	systemPrompt  string
+	tools         int
+}
+
+func (calibration *tokenCalibration) covers(request Request) bool {
+	return calibration != nil &&
+		calibration.messages > 0 &&
+		calibration.messages <= len(request.Messages) &&
+		request.Messages[calibration.messages-1].ID == calibration.lastMessageID &&
+		calibration.systemPrompt == request.SystemPrompt &&
+		calibration.tools == len(request.Tools)
+}
+
+// calibrateTokens records the prompt tokens the provider billed for request.
+func calibrateTokens(prepared *preparedExecution, request Request, usage conversation.TokenUsage) {
+	tokens := promptTokens(prepared.apiType, usage)
+	if tokens <= 0 || len(request.Messages) == 0 {
+		prepared.calibration = nil
+		return
+	}
+	prepared.calibration = &tokenCalibration{
+		promptTokens:  tokens,
+		messages:      len(request.Messages),
+		lastMessageID: request.Messages[len(request.Messages)-1].ID,
+		systemPrompt:  request.SystemPrompt,
+		tools:         len(request.Tools),
+	}
+}
+
+// promptTokens returns the whole prompt size of a call. Anthropic reports
+// cached prompt tokens apart from the input, the other providers include them.
+func promptTokens(apiType catalog.APIType, usage conversation.TokenUsage) int64 {
+	if apiType == catalog.APIAnthropic {
+		return usage.Input + usage.CachedRead + usage.CacheWrite
+	}
+	return usage.Input
+}
+
+// cacheHitRatio returns the share of the prompt tokens of usage served from
+// the provider's cache, rounded to three decimals.
+func cacheHitRatio(apiType catalog.APIType, usage conversation.TokenUsage) float64 {
+	prompt := promptTokens(apiType, usage)
+	if prompt <= 0 || usage.CachedRead <= 0 {
+		return 0
+	}
+	return math.Round(min(float64(usage.CachedRead)/float64(prompt), 1)*1_000) / 1_000
+}
+
+// requestTokens estimates the prompt size of request for the round's model,
+// counting only the messages appended since the last calibrated call.
+func requestTokens(prepared *preparedExecution, request Request) int64 {
+	tokenizer := preparedTokenizer(prepared)
+	calibration := prepared.calibration
+	if !calibration.covers(request) {
+		return estimateRequestTokens(tokenizer, request)
+	}
+	tokens := calibration.promptTokens
+	for _, message := range request.Messages[calibration.messages:] {
+		tokens += estimateMessageTokens(tokenizer, message)
+	}
+	return tokens
+}
+
+func preparedTokenizer(prepared *preparedExecution) Tokenizer {
+	if prepared.tokenizer == nil {
+		return estimatedTokenizer{}
+	}
+	return prepared.tokenizer
+}
+
+func estimateRequestTokens(tokenizer Tokenizer, request Request) int64 {
+	tokens := tokenizer.CountTokens(request.SystemPrompt)
+	for _, message := range request.Messages {
+		tokens += estimateMessageTokens(tokenizer, message)
+	}
+	for _, tool := range request.Tools {
+		encoded, _ := json.Marshal(tool)
+		tokens += tokenizer.CountTokens(string(encoded))
+	}
+	return tokens
+}
+
+func estimateMessageTokens(tokenizer Tokenizer, message conversation.Message) int64 {
+	return messageOverheadTokens + estimateContentTokens(tokenizer, message.Content)
+}
+
+func estimateContentTokens(tokenizer Tokenizer, content conversation.Content) int64 {
+	var tokens int64
+	for _, block := range content {
+		tokens += blockOverheadTokens
+		switch block := block.(type) {
+		case conversation.TextBlock:
+			tokens += tokenizer.CountTokens(block.Text)
+		case conversation.ReasoningBlock:
+			tokens += tokenizer.CountTokens(block.Reasoning)
+		case conversation.ToolUseBlock:
+			tokens += tokenizer.CountTokens(block.Name) + tokenizer.CountTokens(string(block.Input))
+		case conversation.ShellCallBlock:
+			call := block.ToolUseBlock()
+			tokens += tokenizer.CountTokens(call.Name) + tokenizer.CountTokens(string(call.Input))
+		case conversation.ApplyPatchCallBlock:
+			call := block.ToolUseBlock()
+			tokens += tokenizer.CountTokens(call.Name) + tokenizer.CountTokens(string(call.Input))
+		case conversation.ToolResultBlock:
+			tokens += estimateContentTokens(tokenizer, block.Content)
+		case conversation.ImageBlock:
+			tokens += imageTokens
+		default:
+			encoded, _ := json.Marshal(block)
+			tokens += tokenizer.CountTokens(string(encoded))
+		}
+	}
+	return tokens
+}
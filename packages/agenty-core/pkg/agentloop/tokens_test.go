@@ -0,0 +1,99 @@
+package agentloop
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func TestEstimatedTokenizerWeighsRunesByWidth(t *testing.T) {
+	t.Parallel()
+
+	tokenizer := estimatedTokenizer{}
+	if got := tokenizer.CountTokens(strings.Repeat("a", 40)); got != 10 {
+		t.Errorf("ASCII tokens = %d, want 10", got)
+	}
+	if got := tokenizer.CountTokens(strings.Repeat("字", 40)); got != 40 {
+		t.Errorf("CJK tokens = %d, want 40", got)
+	}
+	if got := tokenizer.CountTokens(""); got != 0 {
+		t.Errorf("empty tokens = %d, want 0", got)
+	}
+}
+
+func TestEstimateContentTokensChargesImagesAFixedCost(t *testing.T) {
+	t.Parallel()
+
+	image := conversation.Content{conversation.ImageBlock{MimeType: "image/png", Data: strings.Repeat("A", 1_000_000)}}
+	if got := estimateContentTokens(estimatedTokenizer{}, image); got != blockOverheadTokens+imageTokens {
+		t.Errorf("image tokens = %d, want %d", got, blockOverheadTokens+imageTokens)
+	}
+}
+
+func TestRequestTokensCountsOnlyMessagesSinceTheCalibratedCall(t *testing.T) {
+	t.Parallel()
+
+	first := conversation.Message{ID: uuid.New(), Role: conversation.RoleUser, Content: conversation.Text("hello")}
+	request := Request{SystemPrompt: "Be precise.", Messages: []conversation.Message{first}}
+	prepared := &preparedExecution{apiType: catalog.APIAnthropic, tokenizer: estimatedTokenizer{}}
+	calibrateTokens(prepared, request, conversation.TokenUsage{Input: 10, CachedRead: 900, CacheWrite: 90})
+
+	reply := conversation.Message{ID: uuid.New(), Role: conversation.RoleAssistant, Content: conversation.Text(strings.Repeat("a", 40))}
+	request.Messages = append(request.Messages, reply)
+	want := 1_000 + messageOverheadTokens + blockOverheadTokens + 10
+	if got := requestTokens(prepared, request); got != want {
+		t.Errorf("calibrated tokens = %d, want %d", got, want)
+	}
+
+	request.Messages[0].ID = uuid.New()
+	if got := requestTokens(prepared, request); got != estimateRequestTokens(estimatedTokenizer{}, request) {
+		t.Errorf("tokens after the history changed = %d, want a full estimate", got)
+	}
+}
+
+func TestCacheHitRatioCountsTheWholePrompt(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		apiType catalog.APIType
+		usage   conversation.TokenUsage
+		want    float64
+	}{
+		{
+			name:    "cached tokens apart from the input",
+			apiType: catalog.APIAnthropic,
+			usage:   conversation.TokenUsage{Input: 100, CachedRead: 750, CacheWrite: 150},
+			want:    0.75,
+		},
+		{
+			name:    "cached tokens within the input",
+			apiType: catalog.APIOpenAI,
+			usage: conversation.TokenUsage{Input: 1_000, CachedRead: 600}.
+				Add(conversation.TokenUsage{Input: 1_000, CachedRead: 900}),
+			want: 0.75,
+		},
+		{
+			name:    "rounded",
+			apiType: catalog.APIOpenAI,
+			usage:   conversation.TokenUsage{Input: 3, CachedRead: 2},
+			want:    0.667,
+		},
+		{
+			name:    "no cache reads",
+			apiType: catalog.APIAnthropic,
+			usage:   conversation.TokenUsage{Input: 5, CacheWrite: 100},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cacheHitRatio(test.apiType, test.usage); got != test.want {
+				t.Errorf("cache hit ratio = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -11,7 +11,36 @@ import (
 type CallContext struct {
 	SessionID uuid.UUID
 	RoundID   uuid.UUID
+	ToolUseID string
 	Cwd       string
+	// WorkspaceRoots lists the directories besides Cwd the session's file
+	// tools may use.
+	WorkspaceRoots []string
+	// Mode is the mode of the calling round, which a sub-agent it starts
+	// follows. It is empty outside of rounds.
+	Mode conversation.SessionMode
+	// Subagents runs delegated subtasks in child sessions. It is nil where
+	// delegation is unavailable, such as during compaction.
+	Subagents SubagentRunner
+	// Progress receives the output of a call while it runs. It is nil where
+	// nobody follows the call; tools report through ReportProgress.
+	Progress func(ToolProgress)
+
+	running *runningToolCalls
+}
+
+// ReportProgress passes a chunk of the call's output on one of its streams
+// to Progress, if set.
+func (callContext CallContext) ReportProgress(stream string, index int, text string) {
+	if callContext.Progress == nil || text == "" {
+		return
+	}
+	callContext.Progress(ToolProgress{
+		ToolUseID: callContext.ToolUseID,
+		Stream:    stream,
+		Index:     index,
+		Text:      text,
+	})
 }
 
 type Tool interface {
@@ -23,6 +52,36 @@ type Tool interface {
 	) (conversation.Content, error)
 }
 
+// ToolConcurrency tells the registry how a call may overlap with the other
+// calls of the same model response.
+type ToolConcurrency string
+
+const (
+	// ToolReadOnly calls run beside each other and wait only for earlier
+	// mutating calls on the same resources.
+	ToolReadOnly ToolConcurrency = "read_only"
+	// ToolMutating calls run one at a time in the model's order, after the
+	// earlier read-only calls on the same resources.
+	ToolMutating ToolConcurrency = "mutating"
+	// ToolExclusive calls run alone, after every earlier call of the batch
+	// and before every later one.
+	ToolExclusive ToolConcurrency = "exclusive"
+)
+
+// ToolEffects describes what one call does. Resources are absolute paths,
+// each covering the paths below it; none means the call may touch anything.
+type ToolEffects struct {
+	Concurrency ToolConcurrency
+	Resources   []string
+}
+
+// ScheduledTool is a tool that declares the effects of its calls. Calls of
+// tools without declared effects run beside every call but exclusive ones.
+type ScheduledTool interface {
+	Tool
+	Effects(callContext CallContext, input []byte) ToolEffects
+}
+
 type ToolRuntime interface {
 	Definitions() []ToolDefinition
 	ExecuteBatch(
@@ -32,6 +91,15 @@ type ToolRuntime interface {
 	) []conversation.ToolResultBlock
 }
 
+// SessionResources holds what tools keep running for a session after their
+// calls return, such as background processes.
+type SessionResources interface {
+	// ReleaseSession stops what a deleted session left running.
+	ReleaseSession(sessionID uuid.UUID)
+	// Close stops everything; the engine calls it when it shuts down.
+	Close()
+}
+
 func markNativeShellResults(
 	calls conversation.Content,
 	results []conversation.ToolResultBlock,
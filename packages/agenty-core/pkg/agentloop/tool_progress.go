@@ -0,0 +1,126 @@
+package agentloop
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/application/apperrors"
+)
+
+var errToolCallCancelled = errors.New("tool call was cancelled by the user")
+
+// ToolProgress is a chunk of output a tool call produced while running.
+type ToolProgress struct {
+	ToolUseID string `json:"toolUseId"`
+	// Stream names the output, such as stdout or stderr.
+	Stream string `json:"stream"`
+	// Index tells apart the streams of a call that runs several commands.
+	Index int    `json:"index,omitempty"`
+	Text  string `json:"text"`
+}
+
+type ToolCallCancelResult struct {
+	SessionID       uuid.UUID `json:"sessionId"`
+	RoundID         uuid.UUID `json:"roundId"`
+	ToolUseID       string    `json:"toolUseId"`
+	CancelRequested bool      `json:"cancelRequested"`
+}
+
+// runningToolCalls lets the calls of a batch be cancelled one by one while
+// the batch runs, including calls still waiting for their turn.
+type runningToolCalls struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+func newRunningToolCalls() *runningToolCalls {
+	return &runningToolCalls{cancels: make(map[string]context.CancelCauseFunc)}
+}
+
+// start returns the context of a call, which stays cancellable until done
+// is called.
+func (calls *runningToolCalls) start(ctx context.Context, toolUseID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	calls.mu.Lock()
+	calls.cancels[toolUseID] = cancel
+	calls.mu.Unlock()
+
+	return ctx, func() {
+		calls.mu.Lock()
+		delete(calls.cancels, toolUseID)
+		calls.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+func (calls *runningToolCalls) cancel(toolUseID string) bool {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+
+	cancel, ok := calls.cancels[toolUseID]
+	if ok {
+		cancel(errToolCallCancelled)
+	}
+	return ok
+}
+
+// toolProgress returns the Progress of a batch, which forwards output as
+// tool_progress events, or nil when nobody listens. The calls of a batch
+// report concurrently, so it emits one event at a time.
+func (engine *Engine) toolProgress(
+	ctx context.Context,
+	prepared *preparedExecution,
+	iteration int,
+) func(ToolProgress) {
+	if engine.events == nil {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(progress ToolProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := engine.emit(ctx, prepared, SessionEvent{
+			Type:      SessionEventToolProgress,
+			Iteration: iteration,
+			Progress:  &progress,
+		}); err != nil {
+			engine.logger.WarnContext(ctx, "failed to emit tool progress",
+				"sessionId", prepared.session.ID, "toolUseId", progress.ToolUseID, "error", err)
+		}
+	}
+}
+
+// CancelToolCall stops one tool call of a session's running batch, whether it
+// runs or still waits for its turn. The call ends with an error result and the
+// round goes on.
+func (engine *Engine) CancelToolCall(
+	_ context.Context,
+	sessionID string,
+	toolUseID string,
+) (*ToolCallCancelResult, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, apperrors.Validation("invalid session id: " + err.Error())
+	}
+	if toolUseID == "" {
+		return nil, apperrors.Validation("tool use id must not be empty")
+	}
+
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	execution, ok := engine.active[id]
+	if !ok || execution.tools == nil || !execution.tools.cancel(toolUseID) {
+		return nil, apperrors.NotFound("tool call " + toolUseID + " of session " + sessionID + " is not running")
+	}
+	return &ToolCallCancelResult{
+		SessionID:       id,
+		RoundID:         execution.roundID,
+		ToolUseID:       toolUseID,
+		CancelRequested: true,
+	}, nil
+}
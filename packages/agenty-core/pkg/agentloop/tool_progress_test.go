@@ -0,0 +1,177 @@
+package agentloop_test
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+)
+
+func TestEngineStreamsToolProgressAndCancelsASingleCall(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	if err := fixture.registry.Register(&executionTestTool{
+		definition: agentloop.ToolDefinition{
+			Name:        "build",
+			InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+		},
+		execute: func(ctx context.Context, callContext agentloop.CallContext, _ []byte) (conversation.Content, error) {
+			callContext.ReportProgress("stdout", 0, "compiling\n")
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	readCalls := registerCountingTool(t, fixture, "read_file")
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(
+			conversation.ToolUseBlock{ID: "call-build", Name: "build", Input: []byte(`{}`)},
+			conversation.ToolUseBlock{ID: "call-read", Name: "read_file", Input: []byte(`{}`)},
+		),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	progress := make(chan agentloop.SessionEvent, 4)
+	engine := fixture.newEngineWithEvents(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	}, func(_ context.Context, event agentloop.SessionEvent) error {
+		if event.Type == agentloop.SessionEventToolProgress {
+			progress <- event
+		}
+		return nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("build it")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-progress:
+		if event.Progress == nil || *event.Progress != (agentloop.ToolProgress{
+			ToolUseID: "call-build",
+			Stream:    "stdout",
+			Text:      "compiling\n",
+		}) {
+			t.Fatalf("progress event = %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no tool progress")
+	}
+	if _, err := engine.CancelToolCall(t.Context(), session.ID.String(), "call-unknown"); appErrorCode(err) != application.CodeNotFound {
+		t.Fatalf("cancel unknown call error = %v, want not found", err)
+	}
+	result, err := engine.CancelToolCall(t.Context(), session.ID.String(), "call-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.CancelRequested || result.ToolUseID != "call-build" {
+		t.Errorf("cancel result = %+v", result)
+	}
+	waitForExecution(t, engine, session.ID)
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	round := loaded.Rounds[0]
+	if round.Status != conversation.RoundCompleted || readCalls.Load() != 1 {
+		t.Fatalf("round = %+v, read calls = %d", round, readCalls.Load())
+	}
+	results := toolResultBlocks(round.Messages[len(round.Messages)-2].Content)
+	if len(results) != 2 || !results[0].IsError || results[1].IsError {
+		t.Fatalf("tool results = %+v", results)
+	}
+	if text, ok := results[0].Content[0].(conversation.TextBlock); !ok || !strings.Contains(text.Text, "cancelled by the user") {
+		t.Errorf("cancelled result = %+v", results[0].Content)
+	}
+}
+
+func TestEngineCancelsToolCallWaitingForItsTurn(t *testing.T) {
+	t.Parallel()
+
+	fixture := newExecutionFixture(t, 8_192)
+	release := make(chan struct{})
+	var runs atomic.Int32
+	if err := fixture.registry.Register(&scheduledTestTool{
+		testTool: testTool{
+			definition: agentloop.ToolDefinition{
+				Name:        "build",
+				InputSchema: agentloop.JSONSchema{Type: agentloop.JSONSchemaTypeObject},
+			},
+			execute: func(_ context.Context, callContext agentloop.CallContext, _ []byte) (conversation.Content, error) {
+				runs.Add(1)
+				callContext.ReportProgress("stdout", 0, "compiling\n")
+				<-release
+				return conversation.Text("built"), nil
+			},
+		},
+		concurrency: agentloop.ToolExclusive,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	caller := &scriptedCaller{responses: []*agentloop.Response{
+		toolUseResponse(
+			conversation.ToolUseBlock{ID: "call-first", Name: "build", Input: []byte(`{}`)},
+			conversation.ToolUseBlock{ID: "call-queued", Name: "build", Input: []byte(`{}`)},
+		),
+		{Content: conversation.Text("done"), StopReason: agentloop.StopReasonEndTurn},
+	}}
+	progress := make(chan agentloop.SessionEvent, 4)
+	engine := fixture.newEngineWithEvents(t, func(context.Context, catalog.Provider, catalog.Model) (agentloop.Caller, error) {
+		return caller, nil
+	}, func(_ context.Context, event agentloop.SessionEvent) error {
+		if event.Type == agentloop.SessionEventToolProgress {
+			progress <- event
+		}
+		return nil
+	})
+	session := fixture.createSession(t)
+
+	if _, err := engine.Start(t.Context(), session.ID.String(), conversation.Text("build twice")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-progress:
+	case <-time.After(time.Second):
+		t.Fatal("first call did not start")
+	}
+	// The waiting call registers as its batch starts, maybe after the first
+	// call reported progress.
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := engine.CancelToolCall(t.Context(), session.ID.String(), "call-queued")
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			close(release)
+			t.Fatalf("cancel waiting call: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	waitForExecution(t, engine, session.ID)
+
+	loaded, err := fixture.sessions.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	round := loaded.Rounds[0]
+	if round.Status != conversation.RoundCompleted || runs.Load() != 1 {
+		t.Fatalf("round = %+v, runs = %d", round, runs.Load())
+	}
+	results := toolResultBlocks(round.Messages[len(round.Messages)-2].Content)
+	if len(results) != 2 || results[0].IsError || !results[1].IsError {
+		t.Fatalf("tool results = %+v", results)
+	}
+	if text, ok := results[1].Content[0].(conversation.TextBlock); !ok || !strings.Contains(text.Text, "cancelled by the user") {
+		t.Errorf("cancelled result = %+v", results[1].Content)
+	}
+}
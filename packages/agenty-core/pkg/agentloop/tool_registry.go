@@ -2,22 +2,41 @@ package agentloop
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
 
 	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/utils"
 )
 
+// defaultMaxParallelism caps the calls of one batch that run at once.
+const defaultMaxParallelism = 8
+
 type Registry struct {
-	mu    sync.RWMutex
-	tools map[string]Tool
+	mu             sync.RWMutex
+	tools          map[string]Tool
+	maxParallelism int
 }
 
 var _ ToolRuntime = (*Registry)(nil)
 
 func NewRegistry() *Registry {
-	return &Registry{tools: make(map[string]Tool)}
+	return &Registry{tools: make(map[string]Tool), maxParallelism: defaultMaxParallelism}
+}
+
+// SetMaxParallelism limits how many calls of a batch run at once. Values
+// below one restore the default.
+func (r *Registry) SetMaxParallelism(limit int) {
+	if limit < 1 {
+		limit = defaultMaxParallelism
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxParallelism = limit
 }
 
 func (r *Registry) Register(tool Tool) error {
@@ -80,13 +99,48 @@ func (r *Registry) ExecuteBatch(
 	calls []conversation.ToolUseBlock,
 ) []conversation.ToolResultBlock {
 	results := make([]conversation.ToolResultBlock, len(calls))
+	effects := make([]ToolEffects, len(calls))
+	done := make([]chan struct{}, len(calls))
+	for index, call := range calls {
+		effects[index] = r.effects(callContext, call)
+		done[index] = make(chan struct{})
+	}
+
+	r.mu.RLock()
+	slots := make(chan struct{}, r.maxParallelism)
+	r.mu.RUnlock()
 
 	var waitGroup sync.WaitGroup
 	waitGroup.Add(len(calls))
 	for index, call := range calls {
 		go func() {
 			defer waitGroup.Done()
-			results[index] = r.execute(ctx, callContext, call)
+			defer close(done[index])
+
+			// The call can be cancelled from here on, also while it waits.
+			callCtx := ctx
+			if callContext.running != nil {
+				var finish func()
+				callCtx, finish = callContext.running.start(ctx, call.ID)
+				defer finish()
+			}
+			// Earlier calls never wait for later ones, so waiting in the
+			// model's order cannot deadlock.
+			for earlier := range index {
+				if conflicts(effects[earlier], effects[index]) {
+					select {
+					case <-done[earlier]:
+					case <-callCtx.Done():
+					}
+				}
+			}
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-callCtx.Done():
+			}
+
+			results[index] = r.execute(callCtx, callContext, call)
 		}()
 	}
 	waitGroup.Wait()
@@ -94,6 +148,56 @@ func (r *Registry) ExecuteBatch(
 	return results
 }
 
+// effects returns the declared effects of call. Calls whose tool panics while
+// declaring them run alone.
+func (r *Registry) effects(callContext CallContext, call conversation.ToolUseBlock) (effects ToolEffects) {
+	tool, ok := r.Get(call.Name)
+	if !ok {
+		return ToolEffects{}
+	}
+	scheduled, ok := tool.(ScheduledTool)
+	if !ok {
+		return ToolEffects{}
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			effects = ToolEffects{Concurrency: ToolExclusive}
+		}
+	}()
+	callContext.ToolUseID = call.ID
+	return scheduled.Effects(callContext, call.Input)
+}
+
+// conflicts reports whether later has to wait for earlier to finish.
+func conflicts(earlier, later ToolEffects) bool {
+	switch {
+	case earlier.Concurrency == ToolExclusive || later.Concurrency == ToolExclusive:
+		return true
+	case earlier.Concurrency == "" || later.Concurrency == "":
+		return false
+	case earlier.Concurrency == ToolReadOnly && later.Concurrency == ToolReadOnly:
+		return false
+	case earlier.Concurrency == ToolMutating && later.Concurrency == ToolMutating:
+		return true
+	}
+	return overlaps(earlier.Resources, later.Resources)
+}
+
+func overlaps(first, second []string) bool {
+	if len(first) == 0 || len(second) == 0 {
+		return true
+	}
+	for _, a := range first {
+		for _, b := range second {
+			if utils.WithinPath(a, b) || utils.WithinPath(b, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (r *Registry) execute(
 	ctx context.Context,
 	callContext CallContext,
@@ -115,8 +219,17 @@ func (r *Registry) execute(
 		return result
 	}
 
-	content, err := tool.Execute(ctx, callContext, call.Input)
+	callContext.ToolUseID = call.ID
+	// A call cancelled while it waited for its turn does not start.
+	err := ctx.Err()
+	var content conversation.Content
+	if err == nil {
+		content, err = tool.Execute(ctx, callContext, call.Input)
+	}
 	if err != nil {
+		if errors.Is(context.Cause(ctx), errToolCallCancelled) {
+			err = errToolCallCancelled
+		}
 		result.Content = conversation.Text(fmt.Sprintf("tool %q failed: %v", call.Name, err))
 		result.IsError = true
 		return result
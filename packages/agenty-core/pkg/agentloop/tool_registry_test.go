@@ -3,6 +3,9 @@ package agentloop_test
 import (
 	"context"
 	"errors"
+	"slices"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -169,3 +172,164 @@ func TestRegistryExecuteBatchIsolatesFailures(t *testing.T) {
 		}
 	}
 }
+
+type scheduledTestTool struct {
+	testTool
+	concurrency agentloop.ToolConcurrency
+}
+
+func (tool *scheduledTestTool) Effects(_ agentloop.CallContext, input []byte) agentloop.ToolEffects {
+	effects := agentloop.ToolEffects{Concurrency: tool.concurrency}
+	if path := string(input); path != "" {
+		effects.Resources = []string{path}
+	}
+	return effects
+}
+
+// scheduleRecorder registers read, write and shell tools whose calls hold
+// for a while and record when they start and end.
+type scheduleRecorder struct {
+	mu     sync.Mutex
+	log    []string
+	active int
+	peak   int
+}
+
+func (recorder *scheduleRecorder) register(t *testing.T, registry *agentloop.Registry) {
+	t.Helper()
+
+	for name, concurrency := range map[string]agentloop.ToolConcurrency{
+		"read":  agentloop.ToolReadOnly,
+		"write": agentloop.ToolMutating,
+		"shell": agentloop.ToolExclusive,
+	} {
+		tool := &scheduledTestTool{
+			testTool: testTool{
+				definition: agentloop.ToolDefinition{Name: name},
+				execute: func(_ context.Context, callContext agentloop.CallContext, _ []byte) (conversation.Content, error) {
+					recorder.record("start "+callContext.ToolUseID, 1)
+					time.Sleep(50 * time.Millisecond)
+					recorder.record("end "+callContext.ToolUseID, -1)
+					return conversation.Text("ok"), nil
+				},
+			},
+			concurrency: concurrency,
+		}
+		if err := registry.Register(tool); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (recorder *scheduleRecorder) record(entry string, delta int) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.log = append(recorder.log, entry)
+	recorder.active += delta
+	recorder.peak = max(recorder.peak, recorder.active)
+}
+
+func (recorder *scheduleRecorder) index(entry string) int {
+	return slices.Index(recorder.log, entry)
+}
+
+func TestRegistryExecuteBatchSchedulesByDeclaredEffects(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name string
+		// calls are tool name and resource pairs, given IDs 1, 2 and so on.
+		calls [][2]string
+		// serialized lists calls that must end before another starts.
+		serialized [][2]string
+		wantPeak   int
+	}{
+		{
+			name:       "mutating calls keep the model's order",
+			calls:      [][2]string{{"write", "/repo/a"}, {"write", "/repo/b"}, {"write", "/repo/a"}},
+			serialized: [][2]string{{"1", "2"}, {"2", "3"}},
+			wantPeak:   1,
+		},
+		{
+			name:     "read-only calls run beside each other",
+			calls:    [][2]string{{"read", "/repo/a"}, {"read", "/repo/a"}, {"read", "/repo"}},
+			wantPeak: 3,
+		},
+		{
+			name:       "reads wait for earlier writes below their path",
+			calls:      [][2]string{{"write", "/repo/a"}, {"read", "/repo"}, {"read", "/other"}},
+			serialized: [][2]string{{"1", "2"}},
+			wantPeak:   2,
+		},
+		{
+			name:       "writes wait for earlier reads of their path",
+			calls:      [][2]string{{"read", "/repo/a"}, {"write", "/repo/a"}, {"read", "/repo/b"}},
+			serialized: [][2]string{{"1", "2"}},
+			wantPeak:   2,
+		},
+		{
+			name:       "calls without resources touch everything",
+			calls:      [][2]string{{"write", ""}, {"read", "/repo/a"}},
+			serialized: [][2]string{{"1", "2"}},
+			wantPeak:   1,
+		},
+		{
+			name:       "exclusive calls run alone",
+			calls:      [][2]string{{"read", "/repo/a"}, {"shell", ""}, {"read", "/repo/b"}},
+			serialized: [][2]string{{"1", "2"}, {"2", "3"}},
+			wantPeak:   1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			registry := agentloop.NewRegistry()
+			recorder := &scheduleRecorder{}
+			recorder.register(t, registry)
+			calls := make([]conversation.ToolUseBlock, 0, len(test.calls))
+			for index, call := range test.calls {
+				calls = append(calls, conversation.ToolUseBlock{
+					ID:    strconv.Itoa(index + 1),
+					Name:  call[0],
+					Input: []byte(call[1]),
+				})
+			}
+
+			results := registry.ExecuteBatch(t.Context(), agentloop.CallContext{}, calls)
+
+			for index, result := range results {
+				if result.IsError || result.ToolUseID != calls[index].ID {
+					t.Errorf("result %d = %+v", index, result)
+				}
+			}
+			for _, pair := range test.serialized {
+				if recorder.index("end "+pair[0]) > recorder.index("start "+pair[1]) {
+					t.Errorf("call %s started before call %s ended: %v", pair[1], pair[0], recorder.log)
+				}
+			}
+			if recorder.peak != test.wantPeak {
+				t.Errorf("peak active calls = %d, want %d: %v", recorder.peak, test.wantPeak, recorder.log)
+			}
+		})
+	}
+}
+
+func TestRegistryExecuteBatchLimitsParallelism(t *testing.T) {
+	t.Parallel()
+
+	registry := agentloop.NewRegistry()
+	registry.SetMaxParallelism(2)
+	recorder := &scheduleRecorder{}
+	recorder.register(t, registry)
+	calls := make([]conversation.ToolUseBlock, 0, 5)
+	for index := range 5 {
+		calls = append(calls, conversation.ToolUseBlock{ID: strconv.Itoa(index + 1), Name: "read", Input: []byte("/repo")})
+	}
+
+	registry.ExecuteBatch(t.Context(), agentloop.CallContext{}, calls)
+
+	if recorder.peak != 2 {
+		t.Errorf("peak active calls = %d, want 2", recorder.peak)
+	}
+}
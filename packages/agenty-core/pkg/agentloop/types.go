@@ -53,6 +53,9 @@ type Request struct {
 	MaxOutputTokens       int64                  `json:"maxOutputTokens"`
 	ReasoningEffort       shared.ReasoningEffort `json:"reasoningEffort,omitempty"`
 	ReasoningBudgetTokens int64                  `json:"reasoningBudgetTokens,omitempty"`
+	// CacheKey is shared by the requests of one session, so providers that
+	// route prompt caching by key serve them from the same cache.
+	CacheKey string `json:"cacheKey,omitempty"`
 }
 
 type Response struct {
@@ -82,19 +85,68 @@ const (
 	SessionEventMessageAppended SessionEventType = "message_appended"
 	SessionEventModelStream     SessionEventType = "model_stream"
 	SessionEventRoundEnded      SessionEventType = "round_ended"
+	// SessionEventToolApprovalRequested announces tool calls that wait for
+	// session.approveToolCalls or session.rejectToolCalls before they run.
+	SessionEventToolApprovalRequested SessionEventType = "tool_approval_requested"
+	// SessionEventModelRetry announces that a model call failed transiently
+	// and will be repeated. Stream deltas already emitted for the iteration
+	// belong to the failed attempt and must be discarded.
+	SessionEventModelRetry SessionEventType = "model_retry"
+	// SessionEventModelFallback announces that the round moved to the next
+	// model of its agent's fallback chain. Stream deltas already emitted for
+	// the iteration must be discarded, as with model_retry.
+	SessionEventModelFallback SessionEventType = "model_fallback"
+	// SessionEventTitleSet announces the title generated for a session after
+	// its first completed round.
+	SessionEventTitleSet SessionEventType = "title_set"
+	// SessionEventToolProgress carries output of a running tool call. The
+	// call's result still arrives in full with its message.
+	SessionEventToolProgress SessionEventType = "tool_progress"
 )
 
 type SessionEvent struct {
-	Type      SessionEventType         `json:"type"`
-	SessionID uuid.UUID                `json:"sessionId"`
-	RoundID   uuid.UUID                `json:"roundId"`
-	Sequence  uint64                   `json:"sequence"`
-	Iteration int                      `json:"iteration,omitempty"`
-	Stream    *StreamEvent             `json:"stream,omitempty"`
-	Message   *conversation.Message    `json:"message,omitempty"`
-	Status    conversation.RoundStatus `json:"status,omitempty"`
-	Usage     *conversation.TokenUsage `json:"usage,omitempty"`
-	Error     *string                  `json:"error,omitempty"`
+	Type      SessionEventType `json:"type"`
+	SessionID uuid.UUID        `json:"sessionId"`
+	RoundID   uuid.UUID        `json:"roundId"`
+	Sequence  uint64           `json:"sequence"`
+	// Parent links events of a child session to the delegating tool call.
+	Parent    *conversation.SessionParent `json:"parent,omitempty"`
+	Iteration int                         `json:"iteration,omitempty"`
+	// Attempt numbers the model call within the iteration on model_stream
+	// events, starting at one, so deltas of a retried call can be told apart.
+	Attempt   int                         `json:"attempt,omitempty"`
+	Stream    *StreamEvent                `json:"stream,omitempty"`
+	Retry     *ModelRetry                 `json:"retry,omitempty"`
+	Fallback  *ModelFallback              `json:"fallback,omitempty"`
+	Message   *conversation.Message       `json:"message,omitempty"`
+	ToolCalls []conversation.ToolUseBlock `json:"toolCalls,omitempty"`
+	Progress  *ToolProgress               `json:"progress,omitempty"`
+	Status    conversation.RoundStatus    `json:"status,omitempty"`
+	// BudgetLimit is set on round_ended when Status is budget_exceeded.
+	BudgetLimit conversation.BudgetLimit `json:"budgetLimit,omitempty"`
+	Usage       *conversation.TokenUsage `json:"usage,omitempty"`
+	// CacheHitRatio is the share of the prompt tokens of Usage, or of the
+	// message's usage, that the provider served from its prompt cache.
+	CacheHitRatio float64 `json:"cacheHitRatio,omitempty"`
+	Error         *string `json:"error,omitempty"`
+	Title         string  `json:"title,omitempty"`
+}
+
+// ModelRetry describes a failed model call attempt and the wait before the
+// next one.
+type ModelRetry struct {
+	Attempt     int    `json:"attempt"`
+	MaxAttempts int    `json:"maxAttempts"`
+	DelayMs     int64  `json:"delayMs"`
+	Reason      string `json:"reason"`
+	StatusCode  int    `json:"statusCode,omitempty"`
+}
+
+// ModelFallback describes a switch to a fallback model within a round.
+type ModelFallback struct {
+	From   shared.ModelRef `json:"from"`
+	To     shared.ModelRef `json:"to"`
+	Reason string          `json:"reason"`
 }
 
 type SessionEventHandler func(ctx context.Context, event SessionEvent) error
@@ -107,11 +159,21 @@ const (
 	CompactionEventFailed    CompactionEventType = "failed"
 )
 
+// CompactionTier tells how a compaction shrank the context: by pruning stale
+// tool output, or by having the model summarize the session.
+type CompactionTier string
+
+const (
+	CompactionTierPrune   CompactionTier = "prune"
+	CompactionTierSummary CompactionTier = "summary"
+)
+
 type CompactionEvent struct {
 	Type                CompactionEventType            `json:"type"`
 	SessionID           uuid.UUID                      `json:"sessionId"`
 	CompactionID        uuid.UUID                      `json:"compactionId,omitempty"`
 	Trigger             conversation.CompactionTrigger `json:"trigger"`
+	Tier                CompactionTier                 `json:"tier,omitempty"`
 	ContextTokensBefore int64                          `json:"contextTokensBefore,omitempty"`
 	ContextTokensAfter  int64                          `json:"contextTokensAfter,omitempty"`
 	Usage               *conversation.TokenUsage       `json:"usage,omitempty"`
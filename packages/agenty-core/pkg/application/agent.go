@@ -27,14 +27,19 @@ func NewAgentService(repo agentRepository) *AgentService {
 }
 
 type AgentInput struct {
-	Name                   string                 `json:"name"`
-	Description            string                 `json:"description,omitempty"`
-	Soul                   string                 `json:"soul,omitempty"`
-	DefaultModel           *shared.ModelRef       `json:"defaultModel,omitempty"`
-	DefaultContextWindow   int64                  `json:"defaultContextWindow,omitempty"`
-	DefaultReasoningEffort shared.ReasoningEffort `json:"defaultReasoningEffort,omitempty"`
-	IsDefault              bool                   `json:"isDefault,omitempty"`
-	Metadata               shared.Metadata        `json:"metadata,omitempty"`
+	Name                   string                      `json:"name"`
+	Description            string                      `json:"description,omitempty"`
+	Soul                   string                      `json:"soul,omitempty"`
+	DefaultModel           *shared.ModelRef            `json:"defaultModel,omitempty"`
+	FallbackModels         []shared.ModelRef           `json:"fallbackModels,omitempty"`
+	LightModel             *shared.ModelRef            `json:"lightModel,omitempty"`
+	DefaultContextWindow   int64                       `json:"defaultContextWindow,omitempty"`
+	DefaultReasoningEffort shared.ReasoningEffort      `json:"defaultReasoningEffort,omitempty"`
+	ToolPolicies           map[string]agent.ToolPolicy `json:"toolPolicies,omitempty"`
+	Tools                  agent.ToolAccess            `json:"tools,omitzero"`
+	Budget                 agent.Budget                `json:"budget,omitzero"`
+	IsDefault              bool                        `json:"isDefault,omitempty"`
+	Metadata               shared.Metadata             `json:"metadata,omitempty"`
 }
 
 func (s *AgentService) Create(ctx context.Context, code string, in AgentInput) (*agent.Agent, error) {
@@ -58,12 +63,32 @@ func (s *AgentService) Create(ctx context.Context, code string, in AgentInput) (
 	if in.DefaultReasoningEffort != "" && !in.DefaultReasoningEffort.Valid() {
 		return nil, Validation("invalid reasoning effort: " + string(in.DefaultReasoningEffort))
 	}
+	if err := agent.ValidateToolPolicies(in.ToolPolicies); err != nil {
+		return nil, Validation(err.Error())
+	}
+	if err := in.Tools.Validate(); err != nil {
+		return nil, Validation(err.Error())
+	}
+	if err := in.Budget.Validate(); err != nil {
+		return nil, Validation(err.Error())
+	}
+	if err := agent.ValidateFallbackModels(in.FallbackModels); err != nil {
+		return nil, Validation(err.Error())
+	}
+	if err := agent.ValidateLightModel(in.LightModel); err != nil {
+		return nil, Validation(err.Error())
+	}
 
 	a.Description = in.Description
 	a.Soul = in.Soul
 	a.DefaultModel = in.DefaultModel
+	a.FallbackModels = in.FallbackModels
+	a.LightModel = in.LightModel
 	a.DefaultContextWindow = in.DefaultContextWindow
 	a.DefaultReasoningEffort = in.DefaultReasoningEffort
+	a.ToolPolicies = in.ToolPolicies
+	a.Tools = in.Tools
+	a.Budget = in.Budget
 	a.IsDefault = in.IsDefault
 	a.Metadata = in.Metadata
 
@@ -100,14 +125,19 @@ func (s *AgentService) List(ctx context.Context) ([]*agent.Agent, error) {
 }
 
 type AgentUpdate struct {
-	Name                   *string                 `json:"name,omitempty"`
-	Description            *string                 `json:"description,omitempty"`
-	Soul                   *string                 `json:"soul,omitempty"`
-	DefaultModel           *shared.ModelRef        `json:"defaultModel,omitempty"`
-	DefaultContextWindow   *int64                  `json:"defaultContextWindow,omitempty"`
-	DefaultReasoningEffort *shared.ReasoningEffort `json:"defaultReasoningEffort,omitempty"`
-	IsDefault              *bool                   `json:"isDefault,omitempty"`
-	Metadata               *shared.Metadata        `json:"metadata,omitempty"`
+	Name                   *string                      `json:"name,omitempty"`
+	Description            *string                      `json:"description,omitempty"`
+	Soul                   *string                      `json:"soul,omitempty"`
+	DefaultModel           *shared.ModelRef             `json:"defaultModel,omitempty"`
+	FallbackModels         *[]shared.ModelRef           `json:"fallbackModels,omitempty"`
+	LightModel             *shared.ModelRef             `json:"lightModel,omitempty"`
+	DefaultContextWindow   *int64                       `json:"defaultContextWindow,omitempty"`
+	DefaultReasoningEffort *shared.ReasoningEffort      `json:"defaultReasoningEffort,omitempty"`
+	ToolPolicies           *map[string]agent.ToolPolicy `json:"toolPolicies,omitempty"`
+	Tools                  *agent.ToolAccess            `json:"tools,omitempty"`
+	Budget                 *agent.Budget                `json:"budget,omitempty"`
+	IsDefault              *bool                        `json:"isDefault,omitempty"`
+	Metadata               *shared.Metadata             `json:"metadata,omitempty"`
 }
 
 func (s *AgentService) Update(ctx context.Context, code string, upd AgentUpdate) (*agent.Agent, error) {
@@ -135,6 +165,18 @@ func (s *AgentService) Update(ctx context.Context, code string, upd AgentUpdate)
 	if upd.DefaultModel != nil {
 		a.DefaultModel = upd.DefaultModel
 	}
+	if upd.FallbackModels != nil {
+		if err := agent.ValidateFallbackModels(*upd.FallbackModels); err != nil {
+			return nil, Validation(err.Error())
+		}
+		a.FallbackModels = *upd.FallbackModels
+	}
+	if upd.LightModel != nil {
+		if err := agent.ValidateLightModel(upd.LightModel); err != nil {
+			return nil, Validation(err.Error())
+		}
+		a.LightModel = upd.LightModel
+	}
 	if upd.DefaultContextWindow != nil {
 		a.DefaultContextWindow = *upd.DefaultContextWindow
 	}
@@ -144,6 +186,24 @@ func (s *AgentService) Update(ctx context.Context, code string, upd AgentUpdate)
 		}
 		a.DefaultReasoningEffort = *upd.DefaultReasoningEffort
 	}
+	if upd.ToolPolicies != nil {
+		if err := agent.ValidateToolPolicies(*upd.ToolPolicies); err != nil {
+			return nil, Validation(err.Error())
+		}
+		a.ToolPolicies = *upd.ToolPolicies
+	}
+	if upd.Tools != nil {
+		if err := upd.Tools.Validate(); err != nil {
+			return nil, Validation(err.Error())
+		}
+		a.Tools = *upd.Tools
+	}
+	if upd.Budget != nil {
+		if err := upd.Budget.Validate(); err != nil {
+			return nil, Validation(err.Error())
+		}
+		a.Budget = *upd.Budget
+	}
 	if upd.IsDefault != nil {
 		a.IsDefault = *upd.IsDefault
 	}
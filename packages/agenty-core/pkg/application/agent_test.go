@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/masteryyh/agenty-core/pkg/application"
+	"github.com/masteryyh/agenty-core/pkg/domain/agent"
 	"github.com/masteryyh/agenty-core/pkg/domain/shared"
 )
 
@@ -155,6 +156,87 @@ func TestAgentUpdateRejectsInvalidReasoningEffort(t *testing.T) {
 	}
 }
 
+func TestAgentUpdateBudget(t *testing.T) {
+	agentSvc, _, _ := newServices(t)
+	if _, err := agentSvc.Create(t.Context(), "coder", application.AgentInput{Name: "Coder"}); err != nil {
+		t.Fatal(err)
+	}
+
+	budget := agent.Budget{MaxIterations: 3, MaxToolCalls: 10, MaxDurationSeconds: 60}
+	updated, err := agentSvc.Update(t.Context(), "coder", application.AgentUpdate{Budget: &budget})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Budget != budget {
+		t.Errorf("budget = %+v, want %+v", updated.Budget, budget)
+	}
+
+	invalid := agent.Budget{MaxTotalTokens: -1}
+	_, err = agentSvc.Update(t.Context(), "coder", application.AgentUpdate{Budget: &invalid})
+	if code := appErrorCode(err); code != application.CodeValidation {
+		t.Errorf("code = %v, want validation", code)
+	}
+}
+
+func TestAgentFallbackModels(t *testing.T) {
+	agentSvc, _, _ := newServices(t)
+	fallbacks := []shared.ModelRef{shared.NewModelRef("anthropic", "claude-sonnet")}
+	created, err := agentSvc.Create(t.Context(), "coder", application.AgentInput{Name: "Coder", FallbackModels: fallbacks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created.FallbackModels) != 1 || created.FallbackModels[0] != fallbacks[0] {
+		t.Errorf("fallback models = %+v", created.FallbackModels)
+	}
+
+	duplicated := []shared.ModelRef{fallbacks[0], fallbacks[0]}
+	_, err = agentSvc.Update(t.Context(), "coder", application.AgentUpdate{FallbackModels: &duplicated})
+	if code := appErrorCode(err); code != application.CodeValidation {
+		t.Errorf("duplicate code = %v, want validation", code)
+	}
+	incomplete := []shared.ModelRef{{ProviderCode: "anthropic"}}
+	_, err = agentSvc.Update(t.Context(), "coder", application.AgentUpdate{FallbackModels: &incomplete})
+	if code := appErrorCode(err); code != application.CodeValidation {
+		t.Errorf("incomplete code = %v, want validation", code)
+	}
+}
+
+func TestAgentLightModel(t *testing.T) {
+	agentSvc, _, _ := newServices(t)
+	light := shared.NewModelRef("anthropic", "claude-haiku")
+	created, err := agentSvc.Create(t.Context(), "coder", application.AgentInput{Name: "Coder", LightModel: &light})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.LightModel == nil || *created.LightModel != light {
+		t.Errorf("light model = %+v", created.LightModel)
+	}
+
+	incomplete := shared.ModelRef{ModelCode: "claude-haiku"}
+	_, err = agentSvc.Update(t.Context(), "coder", application.AgentUpdate{LightModel: &incomplete})
+	if code := appErrorCode(err); code != application.CodeValidation {
+		t.Errorf("incomplete code = %v, want validation", code)
+	}
+}
+
+func TestAgentToolAccess(t *testing.T) {
+	agentSvc, _, _ := newServices(t)
+	access := agent.ToolAccess{Allow: []string{"read_*", "search"}, Deny: []string{"read_secret"}}
+	created, err := agentSvc.Create(t.Context(), "reviewer", application.AgentInput{Name: "Reviewer", Tools: access})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Tools.Allows("read_file") || created.Tools.Allows("read_secret") || created.Tools.Allows("shell") {
+		t.Errorf("tool access = %+v", created.Tools)
+	}
+
+	invalid := agent.ToolAccess{Deny: []string{"mcp_["}}
+	_, err = agentSvc.Update(t.Context(), "reviewer", application.AgentUpdate{Tools: &invalid})
+	if code := appErrorCode(err); code != application.CodeValidation {
+		t.Errorf("invalid pattern code = %v, want validation", code)
+	}
+}
+
 func TestAgentDelete(t *testing.T) {
 	agentSvc, _, _ := newServices(t)
 	ctx := context.Background()
@@ -3,6 +3,9 @@ package application
 import (
 	"context"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
 
 	"github.com/google/uuid"
 
@@ -14,10 +17,18 @@ import (
 type SessionService struct {
 	repo           sessionRepository
 	executionState sessionExecutionState
+	resources      sessionResources
 }
 
 type sessionExecutionState interface {
 	ExecuteSessionIfIdle(sessionID uuid.UUID, execute func() error) (bool, error)
+	DeleteSessionIfIdle(ctx context.Context, sessionID uuid.UUID, deleteSession func() error) (bool, error)
+}
+
+// sessionResources holds what the tools of a session left running, such as
+// background processes.
+type sessionResources interface {
+	ReleaseSession(sessionID uuid.UUID)
 }
 
 type SessionServiceOption func(*SessionService)
@@ -28,8 +39,17 @@ func WithSessionExecutionState(state sessionExecutionState) SessionServiceOption
 	}
 }
 
+// WithSessionResources releases what a session left running once it is
+// deleted.
+func WithSessionResources(resources sessionResources) SessionServiceOption {
+	return func(service *SessionService) {
+		service.resources = resources
+	}
+}
+
 type sessionRepository interface {
 	Load(ctx context.Context, id uuid.UUID) (*conversation.Session, error)
+	Events(ctx context.Context, id uuid.UUID) ([]shared.Event, error)
 	Save(ctx context.Context, session *conversation.Session) error
 	List(ctx context.Context, query conversation.ListQuery) ([]conversation.SessionSummary, error)
 	Delete(ctx context.Context, id uuid.UUID) error
@@ -51,6 +71,8 @@ type SessionCreateInput struct {
 	ContextWindow   int64                  `json:"contextWindow,omitempty"`
 	ReasoningEffort shared.ReasoningEffort `json:"reasoningEffort,omitempty"`
 	Cwd             *string                `json:"cwd,omitempty"`
+	// WorkspaceRoots lists directories besides Cwd the file tools may use.
+	WorkspaceRoots []string `json:"workspaceRoots,omitempty"`
 }
 
 func (s *SessionService) Create(ctx context.Context, in SessionCreateInput) (*conversation.Session, error) {
@@ -74,6 +96,10 @@ func (s *SessionService) Create(ctx context.Context, in SessionCreateInput) (*co
 	if !effort.Valid() {
 		return nil, Validation("invalid reasoning effort: " + string(effort))
 	}
+	roots, err := normalizeWorkspaceRoots(in.WorkspaceRoots)
+	if err != nil {
+		return nil, err
+	}
 
 	session := conversation.StartSession(
 		agentCode,
@@ -82,6 +108,9 @@ func (s *SessionService) Create(ctx context.Context, in SessionCreateInput) (*co
 		effort,
 		in.Cwd,
 	)
+	if len(roots) > 0 {
+		session.SetWorkspaceRoots(roots)
+	}
 	if err := s.repo.Save(ctx, session); err != nil {
 		return nil, Internal("failed to save session: " + err.Error())
 	}
@@ -102,13 +131,108 @@ func (s *SessionService) Get(ctx context.Context, idStr string) (*conversation.S
 		}
 		return nil, Internal("failed to load session: " + err.Error())
 	}
+	if hasOpenRound(sess) && s.executionState != nil {
+		sess, err = s.recoverInterrupted(ctx, id, sess)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return sess.VisibleCopy(), nil
 }
 
+// recoverInterrupted closes rounds left open by a crash. A session that is
+// running keeps its open round, which is live rather than interrupted.
+func (s *SessionService) recoverInterrupted(ctx context.Context, id uuid.UUID, sess *conversation.Session) (*conversation.Session, error) {
+	_, err := s.executionState.ExecuteSessionIfIdle(id, func() error {
+		// Reload under the execution lock: the snapshot may predate a round
+		// that has finished since.
+		current, err := s.repo.Load(ctx, id)
+		if err != nil {
+			return Internal("failed to load session: " + err.Error())
+		}
+		recovered, err := current.RecoverInterruptedRounds()
+		if err != nil {
+			return Internal("failed to recover interrupted rounds: " + err.Error())
+		}
+		if len(recovered) > 0 {
+			if err := s.repo.Save(ctx, current); err != nil {
+				return Internal("failed to save recovered rounds: " + err.Error())
+			}
+			current.ClearPending()
+		}
+		sess = current
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func hasOpenRound(sess *conversation.Session) bool {
+	for _, round := range sess.Rounds {
+		if !round.Status.Terminal() {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionForkInput selects the fork point by exactly one of MessageID and
+// RoundID. A round must have finished to be forked after.
+type SessionForkInput struct {
+	SessionID string `json:"sessionId"`
+	MessageID string `json:"messageId,omitempty"`
+	RoundID   string `json:"roundId,omitempty"`
+}
+
+// Fork creates a session that continues from a message or round of another
+// session, leaving the source untouched.
+func (s *SessionService) Fork(ctx context.Context, in SessionForkInput) (*conversation.Session, error) {
+	sourceID, err := uuid.Parse(in.SessionID)
+	if err != nil {
+		return nil, Validation("invalid session id: " + err.Error())
+	}
+	if (in.MessageID == "") == (in.RoundID == "") {
+		return nil, Validation("exactly one of messageId and roundId is required")
+	}
+	var point conversation.ForkPoint
+	if in.MessageID != "" {
+		if point.MessageID, err = uuid.Parse(in.MessageID); err != nil {
+			return nil, Validation("invalid message id: " + err.Error())
+		}
+	} else if point.RoundID, err = uuid.Parse(in.RoundID); err != nil {
+		return nil, Validation("invalid round id: " + err.Error())
+	}
+
+	events, err := s.repo.Events(ctx, sourceID)
+	if err != nil {
+		if errors.Is(err, storage.ErrConversationNotFound) {
+			return nil, NotFound("session " + in.SessionID + " not found")
+		}
+		return nil, Internal("failed to load session: " + err.Error())
+	}
+	fork, err := conversation.ForkSession(events, point)
+	if err != nil {
+		switch {
+		case errors.Is(err, conversation.ErrRoundNotFound):
+			return nil, NotFound("round " + in.RoundID + " not found")
+		case errors.Is(err, conversation.ErrInvalidForkPoint) && in.RoundID != "":
+			return nil, Validation("round " + in.RoundID + " has not finished")
+		case errors.Is(err, conversation.ErrInvalidForkPoint):
+			return nil, NotFound("message " + in.MessageID + " not found")
+		}
+		return nil, Internal("failed to fork session: " + err.Error())
+	}
+	return s.saveUpdated(ctx, fork)
+}
+
 type SessionListQuery struct {
 	AgentCode string
-	Limit     int
-	Offset    int
+	// ForkedFrom, when set, lists only forks of the given session.
+	ForkedFrom string
+	Limit      int
+	Offset     int
 }
 
 func (s *SessionService) List(ctx context.Context, q SessionListQuery) ([]conversation.SessionSummary, error) {
@@ -121,7 +245,21 @@ func (s *SessionService) List(ctx context.Context, q SessionListQuery) ([]conver
 		agentCode = &sv
 	}
 
-	sums, err := s.repo.List(ctx, conversation.ListQuery{AgentCode: agentCode, Limit: q.Limit, Offset: q.Offset})
+	var forkedFrom *uuid.UUID
+	if q.ForkedFrom != "" {
+		id, err := uuid.Parse(q.ForkedFrom)
+		if err != nil {
+			return nil, Validation("invalid session id: " + err.Error())
+		}
+		forkedFrom = &id
+	}
+
+	sums, err := s.repo.List(ctx, conversation.ListQuery{
+		AgentCode:  agentCode,
+		ForkedFrom: forkedFrom,
+		Limit:      q.Limit,
+		Offset:     q.Offset,
+	})
 	if err != nil {
 		return nil, Internal("failed to list sessions: " + err.Error())
 	}
@@ -143,24 +281,79 @@ func (s *SessionService) Delete(ctx context.Context, idStr string) error {
 			}
 			return Internal("failed to delete session: " + err.Error())
 		}
-
 		return nil
 	}
 	if s.executionState == nil {
-		return deleteSession()
+		if err := deleteSession(); err != nil {
+			return err
+		}
+		s.releaseSession(id)
+		return nil
 	}
 
-	executed, err := s.executionState.ExecuteSessionIfIdle(id, deleteSession)
+	executed, err := s.executionState.DeleteSessionIfIdle(ctx, id, deleteSession)
 	if err != nil {
 		return err
 	}
 	if !executed {
 		return AlreadyExists("session " + idStr + " is running")
 	}
+	// Stopping processes may take a while, so it happens outside the engine
+	// lock that DeleteSessionIfIdle holds.
+	s.releaseSession(id)
 
 	return nil
 }
 
+func (s *SessionService) releaseSession(id uuid.UUID) {
+	if s.resources != nil {
+		s.resources.ReleaseSession(id)
+	}
+}
+
+// Rewind rolls an idle session back to before the round with the given
+// sequence. The reverted rounds stay in the transcript but leave the session's
+// rounds and model context.
+func (s *SessionService) Rewind(ctx context.Context, idStr string, round int) (*conversation.Session, error) {
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, Validation("invalid session id: " + err.Error())
+	}
+	var rewound *conversation.Session
+	rewind := func() error {
+		sess, err := s.loadForUpdate(ctx, idStr)
+		if err != nil {
+			return err
+		}
+		if _, err := sess.RevertRounds(round); err != nil {
+			switch {
+			case errors.Is(err, conversation.ErrRoundNotFound):
+				return NotFound(fmt.Sprintf("round %d not found in session %s", round, idStr))
+			case errors.Is(err, conversation.ErrRevertRunningRound), errors.Is(err, conversation.ErrRevertCompactedRound):
+				return Validation(err.Error())
+			}
+			return Internal("failed to rewind session: " + err.Error())
+		}
+		rewound, err = s.saveUpdated(ctx, sess)
+		return err
+	}
+	if s.executionState == nil {
+		if err := rewind(); err != nil {
+			return nil, err
+		}
+		return rewound, nil
+	}
+
+	executed, err := s.executionState.ExecuteSessionIfIdle(id, rewind)
+	if err != nil {
+		return nil, err
+	}
+	if !executed {
+		return nil, AlreadyExists("session " + idStr + " is running")
+	}
+	return rewound, nil
+}
+
 func (s *SessionService) SetTitle(ctx context.Context, idStr, title string) (*conversation.Session, error) {
 	sess, err := s.loadForUpdate(ctx, idStr)
 	if err != nil {
@@ -201,6 +394,21 @@ func (s *SessionService) SetReasoningEffort(ctx context.Context, idStr string, e
 	return s.saveUpdated(ctx, sess)
 }
 
+// SetMode switches the session between build and plan mode. The change
+// applies from the next round on.
+func (s *SessionService) SetMode(ctx context.Context, idStr string, mode conversation.SessionMode) (*conversation.Session, error) {
+	sess, err := s.loadForUpdate(ctx, idStr)
+	if err != nil {
+		return nil, err
+	}
+	if !mode.Valid() {
+		return nil, Validation("invalid session mode: " + string(mode))
+	}
+
+	sess.SetMode(mode)
+	return s.saveUpdated(ctx, sess)
+}
+
 func (s *SessionService) SetCwd(ctx context.Context, idStr string, cwd *string) (*conversation.Session, error) {
 	sess, err := s.loadForUpdate(ctx, idStr)
 	if err != nil {
@@ -210,6 +418,34 @@ func (s *SessionService) SetCwd(ctx context.Context, idStr string, cwd *string)
 	return s.saveUpdated(ctx, sess)
 }
 
+// SetWorkspaceRoots replaces the directories besides the working directory
+// that the session's file tools may use. Roots must be absolute.
+func (s *SessionService) SetWorkspaceRoots(ctx context.Context, idStr string, roots []string) (*conversation.Session, error) {
+	normalized, err := normalizeWorkspaceRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := s.loadForUpdate(ctx, idStr)
+	if err != nil {
+		return nil, err
+	}
+	sess.SetWorkspaceRoots(normalized)
+	return s.saveUpdated(ctx, sess)
+}
+
+func normalizeWorkspaceRoots(roots []string) ([]string, error) {
+	normalized := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if !filepath.IsAbs(root) {
+			return nil, Validation("workspace root must be an absolute path: " + root)
+		}
+		if root = filepath.Clean(root); !slices.Contains(normalized, root) {
+			normalized = append(normalized, root)
+		}
+	}
+	return normalized, nil
+}
+
 func (s *SessionService) loadForUpdate(ctx context.Context, idStr string) (*conversation.Session, error) {
 	id, err := uuid.Parse(idStr)
 	if err != nil {
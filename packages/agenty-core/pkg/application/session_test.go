@@ -2,7 +2,12 @@ package application_test
 
 import (
 	"context"
+	"slices"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 
 	"github.com/masteryyh/agenty-core/pkg/application"
 	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
@@ -247,6 +252,47 @@ func TestSessionSetReasoningEffortAndCwd(t *testing.T) {
 	}
 }
 
+func TestSessionSetWorkspaceRoots(t *testing.T) {
+	_, _, sessionSvc := newServices(t)
+	ctx := context.Background()
+	id := newSession(t, sessionSvc, "coder")
+
+	if _, err := sessionSvc.SetWorkspaceRoots(ctx, id, []string{"/shared/docs/", "/shared/docs", "/opt/data"}); err != nil {
+		t.Fatalf("SetWorkspaceRoots: %v", err)
+	}
+	got, err := sessionSvc.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.WorkspaceRoots, []string{"/shared/docs", "/opt/data"}) {
+		t.Errorf("workspace roots = %q, want cleaned and deduplicated", got.WorkspaceRoots)
+	}
+
+	if _, err := sessionSvc.SetWorkspaceRoots(ctx, id, []string{"docs"}); appErrorCode(err) != application.CodeValidation {
+		t.Errorf("relative workspace root error = %v, want validation", err)
+	}
+}
+
+func TestSessionSetMode(t *testing.T) {
+	_, _, sessionSvc := newServices(t)
+	ctx := context.Background()
+	id := newSession(t, sessionSvc, "coder")
+
+	if _, err := sessionSvc.SetMode(ctx, id, conversation.ModePlan); err != nil {
+		t.Fatalf("SetMode: %v", err)
+	}
+	got, err := sessionSvc.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != conversation.ModePlan {
+		t.Errorf("mode = %q, want plan", got.Mode)
+	}
+	if _, err := sessionSvc.SetMode(ctx, id, "review"); appErrorCode(err) != application.CodeValidation {
+		t.Errorf("invalid mode error = %v, want validation", err)
+	}
+}
+
 func TestSessionDelete(t *testing.T) {
 	_, _, sessionSvc := newServices(t)
 	ctx := context.Background()
@@ -261,6 +307,88 @@ func TestSessionDelete(t *testing.T) {
 	}
 }
 
+type sessionResourcesFake struct {
+	released []uuid.UUID
+}
+
+func (resources *sessionResourcesFake) ReleaseSession(sessionID uuid.UUID) {
+	resources.released = append(resources.released, sessionID)
+}
+
+func TestSessionDeleteReleasesSessionResources(t *testing.T) {
+	repository := newSessionRepositoryFake()
+	resources := &sessionResourcesFake{}
+	sessionSvc := application.NewSessionService(repository, application.WithSessionResources(resources))
+	session := conversation.StartSession("coder", shared.NewModelRef("anthropic", "claude-opus-4-8"), 200_000, shared.ReasoningOff, nil)
+	if err := repository.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sessionSvc.Delete(t.Context(), uuid.NewString()); appErrorCode(err) != application.CodeNotFound {
+		t.Fatalf("delete missing session error = %v, want not found", err)
+	}
+	if err := sessionSvc.Delete(t.Context(), session.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	if len(resources.released) != 1 || resources.released[0] != session.ID {
+		t.Errorf("released = %v, want only %s", resources.released, session.ID)
+	}
+}
+
+// stuckResourcesFake stands in for a process of one session that ignores
+// SIGTERM, so releasing that session lasts until unblock closes.
+type stuckResourcesFake struct {
+	stuck    uuid.UUID
+	stopping chan struct{}
+	unblock  chan struct{}
+}
+
+func (resources *stuckResourcesFake) ReleaseSession(sessionID uuid.UUID) {
+	if sessionID == resources.stuck {
+		close(resources.stopping)
+		<-resources.unblock
+	}
+}
+
+func TestSessionDeleteReleasesResourcesOutsideExecutionLock(t *testing.T) {
+	repository := newSessionRepositoryFake()
+	model := shared.NewModelRef("anthropic", "claude-opus-4-8")
+	stuck := conversation.StartSession("coder", model, 200_000, shared.ReasoningOff, nil)
+	other := conversation.StartSession("coder", model, 200_000, shared.ReasoningOff, nil)
+	for _, session := range []*conversation.Session{stuck, other} {
+		if err := repository.Save(t.Context(), session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resources := &stuckResourcesFake{stuck: stuck.ID, stopping: make(chan struct{}), unblock: make(chan struct{})}
+	sessionSvc := application.NewSessionService(
+		repository,
+		application.WithSessionExecutionState(&executionStateFake{}),
+		application.WithSessionResources(resources),
+	)
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- sessionSvc.Delete(t.Context(), stuck.ID.String()) }()
+	<-resources.stopping
+
+	// Other sessions keep working while the stuck process is stopped.
+	otherDeleted := make(chan error, 1)
+	go func() { otherDeleted <- sessionSvc.Delete(t.Context(), other.ID.String()) }()
+	select {
+	case err := <-otherDeleted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deleting another session waited for the stuck process")
+	}
+
+	close(resources.unblock)
+	if err := <-deleted; err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestSessionGetNotFound(t *testing.T) {
 	_, _, sessionSvc := newServices(t)
 	id := "01957f5e-7c2a-7c2a-9c2a-2c2a2c2a2c2a"
@@ -301,3 +429,187 @@ func TestSessionRejectsInvalidID(t *testing.T) {
 		})
 	}
 }
+
+// executionStateFake runs execute under one lock for every session, as the
+// engine does.
+type executionStateFake struct {
+	mu      sync.Mutex
+	running bool
+}
+
+func (state *executionStateFake) ExecuteSessionIfIdle(_ uuid.UUID, execute func() error) (bool, error) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.running {
+		return false, nil
+	}
+	return true, execute()
+}
+
+func (state *executionStateFake) DeleteSessionIfIdle(
+	_ context.Context,
+	sessionID uuid.UUID,
+	deleteSession func() error,
+) (bool, error) {
+	return state.ExecuteSessionIfIdle(sessionID, deleteSession)
+}
+
+func TestSessionGetClosesInterruptedRoundsOnlyWhenIdle(t *testing.T) {
+	repository := newSessionRepositoryFake()
+	state := &executionStateFake{running: true}
+	sessionSvc := application.NewSessionService(repository, application.WithSessionExecutionState(state))
+
+	session := conversation.StartSession("coder", shared.NewModelRef("anthropic", "claude-opus-4-8"), 200_000, shared.ReasoningOff, nil)
+	roundID, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendUserMessage(roundID, conversation.Text("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := sessionSvc.Get(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live.Rounds[0].Status != conversation.RoundRunning {
+		t.Errorf("running round status = %s, want running", live.Rounds[0].Status)
+	}
+
+	state.running = false
+	recovered, err := sessionSvc.Get(t.Context(), session.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.Rounds[0].Status != conversation.RoundInterrupted {
+		t.Errorf("orphaned round status = %s, want interrupted", recovered.Rounds[0].Status)
+	}
+	reloaded, err := repository.Load(t.Context(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Rounds[0].Status != conversation.RoundInterrupted {
+		t.Errorf("persisted round status = %s, want interrupted", reloaded.Rounds[0].Status)
+	}
+}
+
+func TestSessionForkAndListForks(t *testing.T) {
+	repository := newSessionRepositoryFake()
+	sessionSvc := application.NewSessionService(repository)
+
+	model := shared.NewModelRef("anthropic", "claude-opus-4-8")
+	source := conversation.StartSession("coder", model, 200_000, shared.ReasoningOff, nil)
+	roundID, err := source.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := source.AppendUserMessage(roundID, conversation.Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.Save(t.Context(), source); err != nil {
+		t.Fatal(err)
+	}
+	sourceID := source.ID.String()
+
+	fork, err := sessionSvc.Fork(t.Context(), application.SessionForkInput{SessionID: sourceID, MessageID: message.ID.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.ForkedFrom == nil || fork.ForkedFrom.SessionID != source.ID || fork.ForkedFrom.MessageID != message.ID {
+		t.Errorf("forked from = %+v", fork.ForkedFrom)
+	}
+	if fork.Rounds[0].Status != conversation.RoundCompleted {
+		t.Errorf("forked round status = %s, want completed", fork.Rounds[0].Status)
+	}
+	source.ClearPending()
+	if unchanged, err := repository.Load(t.Context(), source.ID); err != nil || unchanged.Rounds[0].Status != conversation.RoundRunning {
+		t.Errorf("source round after fork = %+v, %v", unchanged.Rounds, err)
+	}
+
+	forks, err := sessionSvc.List(t.Context(), application.SessionListQuery{ForkedFrom: sourceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forks) != 1 || forks[0].ID != fork.ID || forks[0].ForkedFromMessageID == nil || *forks[0].ForkedFromMessageID != message.ID {
+		t.Errorf("forks = %+v", forks)
+	}
+
+	tests := []struct {
+		name  string
+		input application.SessionForkInput
+		code  application.Code
+	}{
+		{name: "no fork point", input: application.SessionForkInput{SessionID: sourceID}, code: application.CodeValidation},
+		{
+			name:  "both fork points",
+			input: application.SessionForkInput{SessionID: sourceID, MessageID: message.ID.String(), RoundID: roundID.String()},
+			code:  application.CodeValidation,
+		},
+		{name: "running round", input: application.SessionForkInput{SessionID: sourceID, RoundID: roundID.String()}, code: application.CodeValidation},
+		{name: "unknown message", input: application.SessionForkInput{SessionID: sourceID, MessageID: uuid.NewString()}, code: application.CodeNotFound},
+		{name: "unknown session", input: application.SessionForkInput{SessionID: uuid.NewString(), MessageID: message.ID.String()}, code: application.CodeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := sessionSvc.Fork(t.Context(), tt.input); appErrorCode(err) != tt.code {
+				t.Errorf("error = %v, want code %v", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestSessionRewind(t *testing.T) {
+	repository := newSessionRepositoryFake()
+	state := &executionStateFake{running: true}
+	sessionSvc := application.NewSessionService(repository, application.WithSessionExecutionState(state))
+
+	session := conversation.StartSession("coder", shared.NewModelRef("anthropic", "claude-opus-4-8"), 200_000, shared.ReasoningOff, nil)
+	for _, prompt := range []string{"first", "second"} {
+		roundID, err := session.StartRound()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.AppendUserMessage(roundID, conversation.Text(prompt)); err != nil {
+			t.Fatal(err)
+		}
+		if err := session.CompleteRound(roundID, conversation.RoundCompleted, conversation.TokenUsage{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repository.Save(t.Context(), session); err != nil {
+		t.Fatal(err)
+	}
+	id := session.ID.String()
+
+	if _, err := sessionSvc.Rewind(t.Context(), id, 2); appErrorCode(err) != application.CodeAlreadyExists {
+		t.Errorf("rewind of running session error = %v, want already exists", err)
+	}
+	state.running = false
+	if _, err := sessionSvc.Rewind(t.Context(), id, 3); appErrorCode(err) != application.CodeNotFound {
+		t.Errorf("rewind of missing round error = %v, want not found", err)
+	}
+
+	rewound, err := sessionSvc.Rewind(t.Context(), id, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewound.Rounds) != 1 {
+		t.Errorf("rounds after rewind = %d, want 1", len(rewound.Rounds))
+	}
+	summaries, err := sessionSvc.List(t.Context(), application.SessionListQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].RoundCount != 1 {
+		t.Errorf("summaries after rewind = %+v", summaries)
+	}
+	if events := repository.events[session.ID]; len(events) == 0 {
+		t.Fatal("transcript is empty")
+	} else if _, ok := events[len(events)-1].(conversation.RoundsReverted); !ok {
+		t.Errorf("last transcript event = %T, want rounds reverted", events[len(events)-1])
+	}
+}
@@ -179,6 +179,20 @@ func (r *sessionRepositoryFake) Load(_ context.Context, id uuid.UUID) (*conversa
 	return conversation.ReplaySession(events), nil
 }
 
+func (r *sessionRepositoryFake) Events(_ context.Context, id uuid.UUID) ([]shared.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.loadErr != nil {
+		return nil, r.loadErr
+	}
+	events, ok := r.events[id]
+	if !ok {
+		return nil, storage.ErrConversationNotFound
+	}
+	return append([]shared.Event(nil), events...), nil
+}
+
 func (r *sessionRepositoryFake) Save(_ context.Context, session *conversation.Session) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -200,6 +214,9 @@ func (r *sessionRepositoryFake) List(_ context.Context, query conversation.ListQ
 	result := make([]conversation.SessionSummary, 0, len(r.events))
 	for _, events := range r.events {
 		summary := conversation.ReplaySession(events).Summary()
+		if query.ForkedFrom != nil && (summary.ForkedFromSessionID == nil || *summary.ForkedFromSessionID != *query.ForkedFrom) {
+			continue
+		}
 		if query.AgentCode == nil || summary.AgentCode == *query.AgentCode {
 			result = append(result, summary)
 		}
@@ -23,10 +23,13 @@ Sometimes there will be a piece of XML data that follows user's message, which c
 	<provider>deepseek</provider>
 	<timezone>Asia/Shanghai</timezone>
 	<reasoning-effort>high</reasoning-effort>
+	<mode>build</mode>
 </metadata>
 ` + "```" + `
 
 You will receive this at the very beginning of the session, and maybe more after if something has changed by user or harness. You must follow these messages and treat them as truth.
+
+The mode is either build or plan. In plan mode you cannot change the workspace: tools that write files or run shell commands are unavailable, so investigate with read-only tools and answer with a plan. Once the mode switches back to build, carry out the plan.
 </basic>
 
 <soul>
@@ -38,13 +41,22 @@ var baseSystemPromptTemplate = template.Must(
 )
 
 type Agent struct {
-	Code                   shared.Code            `json:"code"`
-	Name                   string                 `json:"name"`
-	Description            string                 `json:"description,omitempty"`
-	Soul                   string                 `json:"soul"`
-	DefaultModel           *shared.ModelRef       `json:"defaultModel,omitempty"`
+	Code         shared.Code      `json:"code"`
+	Name         string           `json:"name"`
+	Description  string           `json:"description,omitempty"`
+	Soul         string           `json:"soul"`
+	DefaultModel *shared.ModelRef `json:"defaultModel,omitempty"`
+	// LightModel writes compaction summaries and session titles in place of
+	// the session model.
+	LightModel *shared.ModelRef `json:"lightModel,omitempty"`
+	// FallbackModels are tried in order when the session model fails with a
+	// provider-level error.
+	FallbackModels         []shared.ModelRef      `json:"fallbackModels,omitempty"`
 	DefaultContextWindow   int64                  `json:"defaultContextWindow"`
 	DefaultReasoningEffort shared.ReasoningEffort `json:"defaultReasoningEffort,omitempty"`
+	ToolPolicies           map[string]ToolPolicy  `json:"toolPolicies,omitempty"`
+	Tools                  ToolAccess             `json:"tools,omitzero"`
+	Budget                 Budget                 `json:"budget,omitzero"`
 	IsDefault              bool                   `json:"isDefault"`
 	Metadata               shared.Metadata        `json:"metadata,omitempty"`
 	CreatedAt              time.Time              `json:"createdAt"`
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+// Budget bounds the work of a single round. A zero field means the limit is
+// not set; the engine falls back to its own default for MaxIterations only.
+type Budget struct {
+	MaxIterations      int   `json:"maxIterations,omitempty"`
+	MaxTotalTokens     int64 `json:"maxTotalTokens,omitempty"`
+	MaxToolCalls       int   `json:"maxToolCalls,omitempty"`
+	MaxDurationSeconds int64 `json:"maxDurationSeconds,omitempty"`
+}
+
+func (b Budget) Validate() error {
+	switch {
+	case b.MaxIterations < 0:
+		return fmt.Errorf("agent: budget maxIterations must not be negative")
+	case b.MaxTotalTokens < 0:
+		return fmt.Errorf("agent: budget maxTotalTokens must not be negative")
+	case b.MaxToolCalls < 0:
+		return fmt.Errorf("agent: budget maxToolCalls must not be negative")
+	case b.MaxDurationSeconds < 0:
+		return fmt.Errorf("agent: budget maxDurationSeconds must not be negative")
+	}
+	return nil
+}
+
+func (b Budget) MaxDuration() time.Duration {
+	return time.Duration(b.MaxDurationSeconds) * time.Second
+}
@@ -0,0 +1,31 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+// ValidateFallbackModels rejects incomplete or repeated model references in a
+// fallback chain.
+func ValidateFallbackModels(models []shared.ModelRef) error {
+	seen := make(map[shared.ModelRef]struct{}, len(models))
+	for index, model := range models {
+		if model.ProviderCode.IsZero() || model.ModelCode.IsZero() {
+			return fmt.Errorf("agent: fallback model %d must set both provider and model", index)
+		}
+		if _, duplicate := seen[model]; duplicate {
+			return fmt.Errorf("agent: fallback model %s is listed more than once", model)
+		}
+		seen[model] = struct{}{}
+	}
+	return nil
+}
+
+// ValidateLightModel rejects an incomplete light model reference.
+func ValidateLightModel(model *shared.ModelRef) error {
+	if model != nil && (model.ProviderCode.IsZero() || model.ModelCode.IsZero()) {
+		return fmt.Errorf("agent: light model must set both provider and model")
+	}
+	return nil
+}
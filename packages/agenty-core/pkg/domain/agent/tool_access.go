@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+)
+
+// ToolAccess limits the tools an agent can see and call. Entries are tool
+// names or path.Match globs such as "mcp_*". An empty Allow list allows every
+// tool, and Deny wins over Allow, so {"deny":["*"]} leaves an agent without
+// tools.
+type ToolAccess struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+func (t ToolAccess) Validate() error {
+	for _, patterns := range [][]string{t.Allow, t.Deny} {
+		for _, pattern := range patterns {
+			if pattern == "" {
+				return fmt.Errorf("agent: tool access has an empty pattern")
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("agent: invalid tool pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the tool with the given name is available.
+func (t ToolAccess) Allows(name string) bool {
+	if matchesAny(t.Deny, name) {
+		return false
+	}
+	return len(t.Allow) == 0 || matchesAny(t.Allow, name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
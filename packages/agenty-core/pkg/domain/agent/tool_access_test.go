@@ -0,0 +1,29 @@
+package agent
+
+import "testing"
+
+func TestToolAccessAllows(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		access ToolAccess
+		tool   string
+		want   bool
+	}{
+		{name: "empty allows everything", tool: "shell", want: true},
+		{name: "allow glob", access: ToolAccess{Allow: []string{"mcp_*"}}, tool: "mcp_github", want: true},
+		{name: "outside allow list", access: ToolAccess{Allow: []string{"mcp_*"}}, tool: "shell", want: false},
+		{name: "deny wins", access: ToolAccess{Allow: []string{"*"}, Deny: []string{"shell"}}, tool: "shell", want: false},
+		{name: "deny all", access: ToolAccess{Deny: []string{"*"}}, tool: "read_file", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tt.access.Allows(tt.tool); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.tool, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package agent
+
+import "fmt"
+
+// ToolPolicy decides whether the engine may execute a tool call without a
+// human checkpoint.
+type ToolPolicy string
+
+const (
+	ToolPolicyAllow ToolPolicy = "allow"
+	ToolPolicyAsk   ToolPolicy = "ask"
+	ToolPolicyDeny  ToolPolicy = "deny"
+)
+
+func (p ToolPolicy) Valid() bool {
+	switch p {
+	case ToolPolicyAllow, ToolPolicyAsk, ToolPolicyDeny:
+		return true
+	default:
+		return false
+	}
+}
+
+// ValidateToolPolicies reports the first tool name with an empty name or an
+// unknown policy.
+func ValidateToolPolicies(policies map[string]ToolPolicy) error {
+	for name, policy := range policies {
+		if name == "" {
+			return fmt.Errorf("agent: tool policy has an empty tool name")
+		}
+		if !policy.Valid() {
+			return fmt.Errorf("agent: invalid policy %q for tool %q", policy, name)
+		}
+	}
+	return nil
+}
+
+// ToolPolicy returns the agent-level override for a tool, if any.
+func (a *Agent) ToolPolicy(name string) (ToolPolicy, bool) {
+	policy, ok := a.ToolPolicies[name]
+	return policy, ok
+}
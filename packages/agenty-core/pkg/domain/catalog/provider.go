@@ -80,3 +80,14 @@ func (p *Provider) DefaultModel() (*Model, bool) {
 	}
 	return nil, false
 }
+
+// LightModel returns the first model marked light, which the agent loop uses
+// for summaries and titles when the agent names none.
+func (p *Provider) LightModel() (*Model, bool) {
+	for i := range p.Models {
+		if p.Models[i].Light {
+			return &p.Models[i], true
+		}
+	}
+	return nil, false
+}
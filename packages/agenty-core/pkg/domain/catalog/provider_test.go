@@ -54,3 +54,18 @@ func TestProvider_ModelLifecycle(t *testing.T) {
 		t.Error("DefaultModel found a model after the default was removed")
 	}
 }
+
+func TestProvider_LightModel(t *testing.T) {
+	t.Parallel()
+
+	p := &Provider{Models: []Model{{Code: "model-a", IsDefault: true}}}
+	if _, ok := p.LightModel(); ok {
+		t.Error("LightModel found a model when none is marked light")
+	}
+
+	p.AddModel(Model{Code: "model-mini", Light: true})
+	light, ok := p.LightModel()
+	if !ok || light.Code != "model-mini" {
+		t.Errorf("light model = %+v, %v", light, ok)
+	}
+}
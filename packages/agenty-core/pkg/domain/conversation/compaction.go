@@ -184,6 +184,13 @@ func (s *Session) updateMetadataCwd(cwd *string) {
 	s.metadata.Cwd = *cwd
 }
 
+func (s *Session) updateMetadataMode(mode SessionMode) {
+	if s.metadata == nil || !s.hasCompactionSummary() {
+		return
+	}
+	s.metadata.Mode = string(mode.Effective())
+}
+
 func (s *Session) hasCompactionSummary() bool {
 	for _, message := range s.context {
 		if kind, _ := message.Metadata["compactionKind"].(string); kind == compactionKindSummary {
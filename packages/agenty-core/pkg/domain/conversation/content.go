@@ -149,6 +149,14 @@ type ShellCommandOutput struct {
 	Stdout  string       `json:"stdout"`
 	Stderr  string       `json:"stderr"`
 	Outcome ShellOutcome `json:"outcome"`
+	Usage   *ShellUsage  `json:"usage,omitempty"`
+}
+
+// ShellUsage is what a command consumed. PeakRSSBytes is zero where the
+// platform does not report it.
+type ShellUsage struct {
+	PeakRSSBytes int64 `json:"peakRssBytes,omitempty"`
+	CPUTimeMs    int64 `json:"cpuTimeMs"`
 }
 
 type ShellCallOutputBlock struct {
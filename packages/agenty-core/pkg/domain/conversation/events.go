@@ -15,14 +15,18 @@ const (
 	EventSessionModelSet           = "session_model_set"
 	EventSessionReasoningEffortSet = "session_reasoning_effort_set"
 	EventSessionCwdSet             = "session_cwd_set"
+	EventSessionWorkspaceRootsSet  = "session_workspace_roots_set"
+	EventSessionModeSet            = "session_mode_set"
 	EventRoundStarted              = "round_started"
 	EventMessageAppended           = "message_appended"
 	EventSessionCompacted          = "session_compacted"
+	EventToolResultsPruned         = "tool_results_pruned"
 	// EventSessionMetadataRefreshed is retained for replaying development
 	// transcripts written before compaction metadata became derived state.
 	EventSessionMetadataRefreshed = "session_metadata_refreshed"
 	EventRoundEnded               = "round_ended"
 	EventSessionTitleSet          = "session_title_set"
+	EventRoundsReverted           = "rounds_reverted"
 )
 
 type SessionStarted struct {
@@ -32,6 +36,8 @@ type SessionStarted struct {
 	ContextWindow   int64                  `json:"contextWindow"`
 	ReasoningEffort shared.ReasoningEffort `json:"reasoningEffort,omitempty"`
 	Cwd             *string                `json:"cwd,omitempty"`
+	Parent          *SessionParent         `json:"parent,omitempty"`
+	ForkedFrom      *SessionFork           `json:"forkedFrom,omitempty"`
 	At              time.Time              `json:"occurredAt"`
 }
 
@@ -86,6 +92,36 @@ func (e SessionCwdSet) OccurredAt() time.Time {
 	return e.At
 }
 
+// SessionWorkspaceRootsSet replaces the directories besides the working
+// directory that the session's file tools may use.
+type SessionWorkspaceRootsSet struct {
+	SessionID uuid.UUID `json:"sessionId"`
+	Roots     []string  `json:"roots"`
+	At        time.Time `json:"occurredAt"`
+}
+
+func (SessionWorkspaceRootsSet) EventType() string {
+	return EventSessionWorkspaceRootsSet
+}
+
+func (e SessionWorkspaceRootsSet) OccurredAt() time.Time {
+	return e.At
+}
+
+type SessionModeSet struct {
+	SessionID uuid.UUID   `json:"sessionId"`
+	Mode      SessionMode `json:"mode"`
+	At        time.Time   `json:"occurredAt"`
+}
+
+func (SessionModeSet) EventType() string {
+	return EventSessionModeSet
+}
+
+func (e SessionModeSet) OccurredAt() time.Time {
+	return e.At
+}
+
 type RoundStarted struct {
 	SessionID       uuid.UUID              `json:"sessionId"`
 	RoundID         uuid.UUID              `json:"roundId"`
@@ -94,6 +130,7 @@ type RoundStarted struct {
 	ContextWindow   int64                  `json:"contextWindow"`
 	ReasoningEffort shared.ReasoningEffort `json:"reasoningEffort,omitempty"`
 	Cwd             *string                `json:"cwd,omitempty"`
+	Mode            SessionMode            `json:"mode,omitempty"`
 	At              time.Time              `json:"occurredAt"`
 }
 
@@ -146,6 +183,25 @@ func (e SessionCompacted) OccurredAt() time.Time {
 	return e.At
 }
 
+// ToolResultsPruned replaces the results of earlier tool calls with a short
+// placeholder in the model context, the cheaper tier of compaction.
+type ToolResultsPruned struct {
+	SessionID           uuid.UUID         `json:"sessionId"`
+	CompactionID        uuid.UUID         `json:"compactionId"`
+	Trigger             CompactionTrigger `json:"trigger"`
+	ToolUseIDs          []string          `json:"toolUseIds"`
+	ContextTokensBefore int64             `json:"contextTokensBefore"`
+	At                  time.Time         `json:"occurredAt"`
+}
+
+func (ToolResultsPruned) EventType() string {
+	return EventToolResultsPruned
+}
+
+func (e ToolResultsPruned) OccurredAt() time.Time {
+	return e.At
+}
+
 type SessionMetadataRefreshed struct {
 	SessionID uuid.UUID `json:"sessionId"`
 	Message   Message   `json:"message"`
@@ -161,12 +217,13 @@ func (e SessionMetadataRefreshed) OccurredAt() time.Time {
 }
 
 type RoundEnded struct {
-	SessionID uuid.UUID   `json:"sessionId"`
-	RoundID   uuid.UUID   `json:"roundId"`
-	Status    RoundStatus `json:"status"`
-	Usage     TokenUsage  `json:"usage"`
-	Error     *string     `json:"error,omitempty"`
-	At        time.Time   `json:"occurredAt"`
+	SessionID   uuid.UUID   `json:"sessionId"`
+	RoundID     uuid.UUID   `json:"roundId"`
+	Status      RoundStatus `json:"status"`
+	Usage       TokenUsage  `json:"usage"`
+	Error       *string     `json:"error,omitempty"`
+	BudgetLimit BudgetLimit `json:"budgetLimit,omitempty"`
+	At          time.Time   `json:"occurredAt"`
 }
 
 func (RoundEnded) EventType() string {
@@ -191,6 +248,24 @@ func (e SessionTitleSet) OccurredAt() time.Time {
 	return e.At
 }
 
+// RoundsReverted rewinds a session to before the round with sequence
+// FromSequence. The reverted rounds stay in the transcript for audit but no
+// longer belong to the session's rounds or model context.
+type RoundsReverted struct {
+	SessionID    uuid.UUID   `json:"sessionId"`
+	FromSequence int         `json:"fromSequence"`
+	RoundIDs     []uuid.UUID `json:"roundIds"`
+	At           time.Time   `json:"occurredAt"`
+}
+
+func (RoundsReverted) EventType() string {
+	return EventRoundsReverted
+}
+
+func (e RoundsReverted) OccurredAt() time.Time {
+	return e.At
+}
+
 func DecodeEvent(env shared.Envelope) (shared.Event, error) {
 	switch env.Type {
 	case EventSessionStarted:
@@ -201,18 +276,26 @@ func DecodeEvent(env shared.Envelope) (shared.Event, error) {
 		return decodePayload[SessionReasoningEffortSet](env.Payload)
 	case EventSessionCwdSet:
 		return decodePayload[SessionCwdSet](env.Payload)
+	case EventSessionWorkspaceRootsSet:
+		return decodePayload[SessionWorkspaceRootsSet](env.Payload)
+	case EventSessionModeSet:
+		return decodePayload[SessionModeSet](env.Payload)
 	case EventRoundStarted:
 		return decodePayload[RoundStarted](env.Payload)
 	case EventMessageAppended:
 		return decodePayload[MessageAppended](env.Payload)
 	case EventSessionCompacted:
 		return decodePayload[SessionCompacted](env.Payload)
+	case EventToolResultsPruned:
+		return decodePayload[ToolResultsPruned](env.Payload)
 	case EventSessionMetadataRefreshed:
 		return decodePayload[SessionMetadataRefreshed](env.Payload)
 	case EventRoundEnded:
 		return decodePayload[RoundEnded](env.Payload)
 	case EventSessionTitleSet:
 		return decodePayload[SessionTitleSet](env.Payload)
+	case EventRoundsReverted:
+		return decodePayload[RoundsReverted](env.Payload)
 	default:
 		return nil, fmt.Errorf("conversation: unknown event type %q", env.Type)
 	}
@@ -6,6 +6,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+
 	"github.com/masteryyh/agenty-core/pkg/domain/shared"
 )
 
@@ -27,12 +29,16 @@ func TestEventEnvelopeRoundTrip(t *testing.T) {
 		{name: "model set", event: SessionModelSet{SessionID: sessionID, Model: model, ContextWindow: 200_000, At: at}},
 		{name: "reasoning effort set", event: SessionReasoningEffortSet{SessionID: sessionID, ReasoningEffort: shared.ReasoningHigh, At: at}},
 		{name: "cwd cleared", event: SessionCwdSet{SessionID: sessionID, Cwd: nil, At: at}},
-		{name: "round started", event: RoundStarted{SessionID: sessionID, RoundID: roundID, Sequence: 1, Model: model, ContextWindow: 200_000, ReasoningEffort: shared.ReasoningHigh, Cwd: &cwd, At: at}},
+		{name: "workspace roots set", event: SessionWorkspaceRootsSet{SessionID: sessionID, Roots: []string{"/shared/docs"}, At: at}},
+		{name: "mode set", event: SessionModeSet{SessionID: sessionID, Mode: ModePlan, At: at}},
+		{name: "round started", event: RoundStarted{SessionID: sessionID, RoundID: roundID, Sequence: 1, Model: model, ContextWindow: 200_000, ReasoningEffort: shared.ReasoningHigh, Cwd: &cwd, Mode: ModePlan, At: at}},
 		{name: "message appended", event: MessageAppended{SessionID: sessionID, Message: Message{ID: shared.NewID(), RoundID: roundID, Role: RoleAssistant, Content: Text("hi"), Model: &model, Usage: &TokenUsage{Input: 10, Output: 20, Total: 30}, CreatedAt: at}, At: at}},
 		{name: "session compacted", event: SessionCompacted{SessionID: sessionID, CompactionID: shared.NewID(), Trigger: CompactionTriggerAuto, Summary: "done", ContextTokensBefore: 100, Usage: TokenUsage{Input: 10, Output: 20, Total: 30}, At: at}},
+		{name: "tool results pruned", event: ToolResultsPruned{SessionID: sessionID, CompactionID: shared.NewID(), Trigger: CompactionTriggerAuto, ToolUseIDs: []string{"call-1"}, ContextTokensBefore: 100, At: at}},
 		{name: "session metadata refreshed", event: SessionMetadataRefreshed{SessionID: sessionID, Message: Message{ID: shared.NewID(), Role: RoleUser, Visibility: MessageHidden, Content: Text("<metadata/>")}, At: at}},
 		{name: "round failed", event: RoundEnded{SessionID: sessionID, RoundID: roundID, Status: RoundFailed, Usage: TokenUsage{Input: 10, Output: 20, Total: 30}, Error: &errMessage, At: at}},
 		{name: "title set", event: SessionTitleSet{SessionID: sessionID, Title: "greeting", At: at}},
+		{name: "rounds reverted", event: RoundsReverted{SessionID: sessionID, FromSequence: 1, RoundIDs: []uuid.UUID{roundID}, At: at}},
 	}
 
 	for i, tt := range tests {
@@ -0,0 +1,193 @@
+package conversation
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+var ErrInvalidForkPoint = errors.New("conversation: invalid fork point")
+
+const forkedToolCallMessage = "tool call was not carried over to the forked session"
+
+// SessionFork links a forked session to the message of the source session
+// its transcript ends with.
+type SessionFork struct {
+	SessionID uuid.UUID `json:"sessionId"`
+	MessageID uuid.UUID `json:"messageId"`
+}
+
+// ForkPoint selects where a fork ends: after a message, or after a finished
+// round. Exactly one of the two must be set.
+type ForkPoint struct {
+	MessageID uuid.UUID
+	RoundID   uuid.UUID
+}
+
+// ForkSession starts a session whose transcript reproduces the source
+// session's events up to the fork point, so the fork inherits the model,
+// reasoning effort, cwd, workspace roots and compaction state in effect there. A round cut
+// in the middle is closed as completed, with synthetic results for tool calls
+// it left unanswered.
+func ForkSession(events []shared.Event, point ForkPoint) (*Session, error) {
+	if (point.MessageID == uuid.Nil) == (point.RoundID == uuid.Nil) {
+		return nil, ErrInvalidForkPoint
+	}
+	source := ReplaySession(events)
+	if source.ID == uuid.Nil {
+		return nil, ErrInvalidForkPoint
+	}
+
+	roundID, messageID, err := source.resolveForkPoint(point)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := forkCutoff(events, roundID, messageID)
+	if cutoff < 0 {
+		return nil, ErrInvalidForkPoint
+	}
+
+	s := &Session{Rounds: make([]Round, 0)}
+	id := shared.NewID()
+	for _, event := range events[:cutoff+1] {
+		if started, ok := event.(SessionStarted); ok {
+			started.SessionID = id
+			started.Parent = nil
+			started.ForkedFrom = &SessionFork{SessionID: source.ID, MessageID: messageID}
+			started.At = now()
+			s.record(started)
+			continue
+		}
+		s.record(withSessionID(event, id))
+	}
+
+	if round, _, ok := s.findRound(roundID); ok && !round.Status.Terminal() {
+		if err := s.closeForkedRound(*round); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// resolveForkPoint returns the round the fork ends in and the message the
+// fork ends with. A round fork ends with the round's last message.
+func (s *Session) resolveForkPoint(point ForkPoint) (uuid.UUID, uuid.UUID, error) {
+	if point.RoundID != uuid.Nil {
+		round, _, ok := s.findRound(point.RoundID)
+		if !ok {
+			return uuid.Nil, uuid.Nil, ErrRoundNotFound
+		}
+		if !round.Status.Terminal() || len(round.Messages) == 0 {
+			return uuid.Nil, uuid.Nil, ErrInvalidForkPoint
+		}
+		return round.ID, round.Messages[len(round.Messages)-1].ID, nil
+	}
+
+	for _, round := range s.Rounds {
+		for _, message := range round.Messages {
+			if message.ID == point.MessageID {
+				return round.ID, message.ID, nil
+			}
+		}
+	}
+	return uuid.Nil, uuid.Nil, ErrInvalidForkPoint
+}
+
+// forkCutoff returns the index of the last event a fork copies: the round's
+// end when the message is the last one of a finished round, otherwise the
+// message itself.
+func forkCutoff(events []shared.Event, roundID, messageID uuid.UUID) int {
+	cutoff := -1
+	for index, event := range events {
+		switch ev := event.(type) {
+		case MessageAppended:
+			if cutoff >= 0 && ev.Message.RoundID == roundID {
+				return cutoff
+			}
+			if ev.Message.ID == messageID {
+				cutoff = index
+			}
+		case RoundEnded:
+			if cutoff >= 0 && ev.RoundID == roundID {
+				return index
+			}
+		}
+	}
+	return cutoff
+}
+
+func (s *Session) closeForkedRound(round Round) error {
+	var usage TokenUsage
+	for _, message := range round.Messages {
+		if message.Usage != nil {
+			usage = usage.Add(*message.Usage)
+		}
+	}
+	if unanswered := unansweredToolCalls(round.Messages); len(unanswered) > 0 {
+		content := make(Content, 0, len(unanswered))
+		for _, id := range unanswered {
+			content = append(content, ToolResultBlock{
+				ToolUseID: id,
+				Content:   Text(forkedToolCallMessage),
+				IsError:   true,
+			})
+		}
+		if _, err := s.AppendUserMessage(round.ID, content); err != nil {
+			return err
+		}
+	}
+	return s.endRound(RoundEnded{
+		SessionID: s.ID,
+		RoundID:   round.ID,
+		Status:    RoundCompleted,
+		Usage:     usage,
+	})
+}
+
+func withSessionID(event shared.Event, id uuid.UUID) shared.Event {
+	switch ev := event.(type) {
+	case SessionModelSet:
+		ev.SessionID = id
+		return ev
+	case SessionReasoningEffortSet:
+		ev.SessionID = id
+		return ev
+	case SessionCwdSet:
+		ev.SessionID = id
+		return ev
+	case SessionWorkspaceRootsSet:
+		ev.SessionID = id
+		return ev
+	case SessionModeSet:
+		ev.SessionID = id
+		return ev
+	case RoundStarted:
+		ev.SessionID = id
+		return ev
+	case MessageAppended:
+		ev.SessionID = id
+		return ev
+	case SessionCompacted:
+		ev.SessionID = id
+		return ev
+	case ToolResultsPruned:
+		ev.SessionID = id
+		return ev
+	case SessionMetadataRefreshed:
+		ev.SessionID = id
+		return ev
+	case RoundEnded:
+		ev.SessionID = id
+		return ev
+	case SessionTitleSet:
+		ev.SessionID = id
+		return ev
+	case RoundsReverted:
+		ev.SessionID = id
+		return ev
+	default:
+		return event
+	}
+}
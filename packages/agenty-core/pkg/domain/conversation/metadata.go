@@ -10,6 +10,7 @@ type SessionMetadata struct {
 	Provider        string
 	Timezone        string
 	ReasoningEffort string
+	Mode            string
 }
 
 type MetadataUpdate struct {
@@ -19,6 +20,7 @@ type MetadataUpdate struct {
 	Provider        *string  `xml:"provider,omitempty"`
 	Timezone        *string  `xml:"timezone,omitempty"`
 	ReasoningEffort *string  `xml:"reasoning-effort,omitempty"`
+	Mode            *string  `xml:"mode,omitempty"`
 }
 
 func (metadata SessionMetadata) Diff(previous *SessionMetadata) MetadataUpdate {
@@ -38,6 +40,9 @@ func (metadata SessionMetadata) Diff(previous *SessionMetadata) MetadataUpdate {
 	if previous == nil || metadata.ReasoningEffort != previous.ReasoningEffort {
 		update.ReasoningEffort = metadataStringPointer(metadata.ReasoningEffort)
 	}
+	if previous == nil || metadata.Mode != previous.Mode {
+		update.Mode = metadataStringPointer(metadata.Mode)
+	}
 
 	return update
 }
@@ -47,7 +52,8 @@ func (update MetadataUpdate) Empty() bool {
 		update.Model == nil &&
 		update.Provider == nil &&
 		update.Timezone == nil &&
-		update.ReasoningEffort == nil
+		update.ReasoningEffort == nil &&
+		update.Mode == nil
 }
 
 func (update MetadataUpdate) XML() (string, error) {
@@ -65,6 +71,7 @@ func (metadata SessionMetadata) XML() (string, error) {
 		Provider:        metadataStringPointer(metadata.Provider),
 		Timezone:        metadataStringPointer(metadata.Timezone),
 		ReasoningEffort: metadataStringPointer(metadata.ReasoningEffort),
+		Mode:            metadataStringPointer(metadata.Mode),
 	}.XML()
 }
 
@@ -101,6 +108,9 @@ func (s *Session) applyMessageMetadata(message Message) {
 	if update.ReasoningEffort != nil {
 		s.metadata.ReasoningEffort = *update.ReasoningEffort
 	}
+	if update.Mode != nil {
+		s.metadata.Mode = *update.Mode
+	}
 }
 
 func parseMetadataMessage(message Message) (MetadataUpdate, bool) {
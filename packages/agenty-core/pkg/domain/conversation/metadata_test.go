@@ -17,6 +17,7 @@ func TestMetadataUpdateXML(t *testing.T) {
 		Provider:        "provider",
 		Timezone:        "Asia/Shanghai",
 		ReasoningEffort: string(shared.ReasoningHigh),
+		Mode:            string(ModePlan),
 	}).Diff(nil)
 
 	got, err := update.XML()
@@ -27,6 +28,7 @@ func TestMetadataUpdateXML(t *testing.T) {
 		"<metadata>",
 		"<cwd>/workspace/&lt;shared&gt;&amp;</cwd>",
 		"<reasoning-effort>high</reasoning-effort>",
+		"<mode>plan</mode>",
 		"</metadata>",
 	} {
 		if !strings.Contains(got, want) {
@@ -0,0 +1,27 @@
+package conversation
+
+// SessionMode decides whether the agent may change the workspace. The empty
+// mode of sessions started before modes existed behaves as ModeBuild.
+type SessionMode string
+
+const (
+	ModeBuild SessionMode = "build"
+	ModePlan  SessionMode = "plan"
+)
+
+func (m SessionMode) Valid() bool {
+	switch m {
+	case ModeBuild, ModePlan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Effective resolves the empty mode to ModeBuild.
+func (m SessionMode) Effective() SessionMode {
+	if m == "" {
+		return ModeBuild
+	}
+	return m
+}
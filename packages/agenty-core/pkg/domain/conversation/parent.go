@@ -0,0 +1,12 @@
+package conversation
+
+import "github.com/google/uuid"
+
+// SessionParent links a child session to the tool call that delegated it.
+type SessionParent struct {
+	SessionID uuid.UUID `json:"sessionId"`
+	RoundID   uuid.UUID `json:"roundId"`
+	ToolUseID string    `json:"toolUseId,omitempty"`
+	// Depth counts the delegations between the root session and this one.
+	Depth int `json:"depth"`
+}
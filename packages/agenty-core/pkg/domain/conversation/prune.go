@@ -0,0 +1,89 @@
+package conversation
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+const prunedToolResultText = "[tool output pruned to save context; run the tool again if it is still needed]"
+
+type PruneInput struct {
+	CompactionID        uuid.UUID
+	Trigger             CompactionTrigger
+	ToolUseIDs          []string
+	ContextTokensBefore int64
+	At                  time.Time
+}
+
+// PruneToolResults records that the results of the given tool calls are
+// replaced by a short placeholder in the model context. Like Compact, it
+// leaves the rounds untouched and is reapplied during replay.
+func (s *Session) PruneToolResults(input PruneInput) (ToolResultsPruned, error) {
+	if input.Trigger != CompactionTriggerManual && input.Trigger != CompactionTriggerAuto && input.Trigger != CompactionTriggerModelSwitch {
+		return ToolResultsPruned{}, ErrInvalidCompaction
+	}
+	if len(input.ToolUseIDs) == 0 || s.ID == uuid.Nil {
+		return ToolResultsPruned{}, ErrInvalidCompaction
+	}
+
+	at := input.At
+	if at.IsZero() {
+		at = now()
+	}
+	event := ToolResultsPruned{
+		SessionID:           s.ID,
+		CompactionID:        input.CompactionID,
+		Trigger:             input.Trigger,
+		ToolUseIDs:          append([]string(nil), input.ToolUseIDs...),
+		ContextTokensBefore: input.ContextTokensBefore,
+		At:                  at,
+	}
+	if event.CompactionID == uuid.Nil {
+		event.CompactionID = shared.NewID()
+	}
+	s.record(event)
+	return event, nil
+}
+
+// IsPrunedToolResult reports whether block is the placeholder of a pruned
+// tool result.
+func IsPrunedToolResult(block ToolResultBlock) bool {
+	if len(block.Content) != 1 {
+		return false
+	}
+	text, ok := block.Content[0].(TextBlock)
+	return ok && text.Text == prunedToolResultText
+}
+
+func (s *Session) applyPrune(event ToolResultsPruned) {
+	pruned := make(map[string]struct{}, len(event.ToolUseIDs))
+	for _, id := range event.ToolUseIDs {
+		pruned[id] = struct{}{}
+	}
+	for index, message := range s.context {
+		var cloned *Message
+		for blockIndex, block := range message.Content {
+			result, ok := block.(ToolResultBlock)
+			if !ok {
+				continue
+			}
+			if _, ok := pruned[result.ToolUseID]; !ok {
+				continue
+			}
+			if cloned == nil {
+				// Context messages share their content with the rounds, which
+				// keep the full output.
+				clone := cloneMessage(message)
+				cloned = &clone
+			}
+			result.Content = Text(prunedToolResultText)
+			cloned.Content[blockIndex] = result
+		}
+		if cloned != nil {
+			s.context[index] = *cloned
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package conversation
+
+import (
+	"github.com/google/uuid"
+)
+
+const (
+	interruptedRoundError      = "the round was interrupted before it finished"
+	interruptedToolCallMessage = "tool call was not completed because the round was interrupted"
+)
+
+// RecoverInterruptedRounds closes every round that is still open, which only
+// happens when the process stopped in the middle of it. Tool calls left
+// without a result receive synthetic error results first, so the transcript
+// stays valid for every provider. It returns the IDs of the closed rounds and
+// must only be called while no round of the session is executing.
+func (s *Session) RecoverInterruptedRounds() ([]uuid.UUID, error) {
+	var recovered []uuid.UUID
+	for index := range s.Rounds {
+		round := s.Rounds[index]
+		if round.Status.Terminal() {
+			continue
+		}
+
+		var usage TokenUsage
+		for _, message := range round.Messages {
+			if message.Usage != nil {
+				usage = usage.Add(*message.Usage)
+			}
+		}
+		if unanswered := unansweredToolCalls(round.Messages); len(unanswered) > 0 {
+			content := make(Content, 0, len(unanswered))
+			for _, id := range unanswered {
+				content = append(content, ToolResultBlock{
+					ToolUseID: id,
+					Content:   Text(interruptedToolCallMessage),
+					IsError:   true,
+				})
+			}
+			if _, err := s.AppendUserMessage(round.ID, content); err != nil {
+				return recovered, err
+			}
+		}
+
+		errMsg := interruptedRoundError
+		if err := s.endRound(RoundEnded{
+			SessionID: s.ID,
+			RoundID:   round.ID,
+			Status:    RoundInterrupted,
+			Usage:     usage,
+			Error:     &errMsg,
+		}); err != nil {
+			return recovered, err
+		}
+		recovered = append(recovered, round.ID)
+	}
+	return recovered, nil
+}
+
+// unansweredToolCalls lists, in call order, the IDs of tool calls that no
+// later tool result answers.
+func unansweredToolCalls(messages []Message) []string {
+	answered := make(map[string]struct{})
+	for _, message := range messages {
+		for _, block := range message.Content {
+			if result, ok := block.(ToolResultBlock); ok {
+				answered[result.ToolUseID] = struct{}{}
+			}
+		}
+	}
+
+	var unanswered []string
+	for _, message := range messages {
+		if message.Role != RoleAssistant {
+			continue
+		}
+		for _, block := range message.Content {
+			var id string
+			switch call := block.(type) {
+			case ToolUseBlock:
+				id = call.ID
+			case ShellCallBlock:
+				id = call.CallID
+			case ApplyPatchCallBlock:
+				id = call.CallID
+			default:
+				continue
+			}
+			if _, ok := answered[id]; !ok && id != "" {
+				unanswered = append(unanswered, id)
+			}
+		}
+	}
+	return unanswered
+}
@@ -20,6 +20,8 @@ type ListQuery struct {
 	Limit int
 	// Offset skips the first N rows for pagination.
 	Offset int
+	// ForkedFrom, when set, restricts results to forks of one session.
+	ForkedFrom *uuid.UUID
 }
 
 // Repository is the persistence port for the conversation aggregate. An
@@ -28,6 +30,8 @@ type ListQuery struct {
 type Repository interface {
 	// Load reconstructs a session by replaying its transcript.
 	Load(ctx context.Context, id uuid.UUID) (*Session, error)
+	// Events returns the session's transcript events in order.
+	Events(ctx context.Context, id uuid.UUID) ([]shared.Event, error)
 	// Save durably appends the session's pending events and refreshes its
 	// projection, then the caller may ClearPending.
 	Save(ctx context.Context, session *Session) error
@@ -0,0 +1,66 @@
+package conversation
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrRevertRunningRound   = errors.New("conversation: cannot revert while a round is running")
+	ErrRevertCompactedRound = errors.New("conversation: cannot revert rounds folded into a compaction summary")
+)
+
+// RevertRounds rewinds the session to before the round with the given
+// sequence, dropping that round and every later one from the session's rounds
+// and model context. Rounds summarized by a compaction cannot be reverted,
+// because the summary would still describe them.
+func (s *Session) RevertRounds(fromSequence int) (RoundsReverted, error) {
+	if fromSequence < 1 || fromSequence > len(s.Rounds) {
+		return RoundsReverted{}, ErrRoundNotFound
+	}
+	if !s.Rounds[len(s.Rounds)-1].Status.Terminal() {
+		return RoundsReverted{}, ErrRevertRunningRound
+	}
+	if fromSequence <= s.compactedRounds {
+		return RoundsReverted{}, ErrRevertCompactedRound
+	}
+
+	reverted := s.Rounds[fromSequence-1:]
+	event := RoundsReverted{
+		SessionID:    s.ID,
+		FromSequence: fromSequence,
+		RoundIDs:     make([]uuid.UUID, 0, len(reverted)),
+		At:           now(),
+	}
+	for _, round := range reverted {
+		event.RoundIDs = append(event.RoundIDs, round.ID)
+	}
+	s.record(event)
+	return event, nil
+}
+
+func (s *Session) applyRevert(event RoundsReverted) {
+	if event.FromSequence < 1 || event.FromSequence > len(s.Rounds) {
+		return
+	}
+	reverted := make(map[uuid.UUID]struct{}, len(s.Rounds)-event.FromSequence+1)
+	for _, round := range s.Rounds[event.FromSequence-1:] {
+		reverted[round.ID] = struct{}{}
+	}
+	s.Rounds = s.Rounds[:event.FromSequence-1]
+
+	context := make([]Message, 0, len(s.context))
+	for _, message := range s.context {
+		if _, ok := reverted[message.RoundID]; !ok {
+			context = append(context, message)
+		}
+	}
+	s.context = context
+
+	// The metadata the model last saw may have been sent in a reverted round.
+	s.metadata = nil
+	for _, message := range s.context {
+		s.applyMessageMetadata(message)
+	}
+}
@@ -16,11 +16,36 @@ const (
 	RoundCompleted RoundStatus = "completed"
 	RoundFailed    RoundStatus = "failed"
 	RoundCancelled RoundStatus = "cancelled"
+	// RoundBudgetExceeded ends a round that ran out of its agent's execution
+	// budget; Round.BudgetLimit names the limit that was hit.
+	RoundBudgetExceeded RoundStatus = "budget_exceeded"
+	// RoundInterrupted closes a round found still open after the process
+	// stopped in the middle of it.
+	RoundInterrupted RoundStatus = "interrupted"
 )
 
 func (s RoundStatus) Terminal() bool {
 	switch s {
-	case RoundCompleted, RoundFailed, RoundCancelled:
+	case RoundCompleted, RoundFailed, RoundCancelled, RoundBudgetExceeded, RoundInterrupted:
+		return true
+	default:
+		return false
+	}
+}
+
+// BudgetLimit names the execution budget limit that ended a round.
+type BudgetLimit string
+
+const (
+	BudgetLimitIterations BudgetLimit = "iterations"
+	BudgetLimitTokens     BudgetLimit = "tokens"
+	BudgetLimitToolCalls  BudgetLimit = "tool_calls"
+	BudgetLimitDuration   BudgetLimit = "duration"
+)
+
+func (l BudgetLimit) Valid() bool {
+	switch l {
+	case BudgetLimitIterations, BudgetLimitTokens, BudgetLimitToolCalls, BudgetLimitDuration:
 		return true
 	default:
 		return false
@@ -36,9 +61,26 @@ type Round struct {
 	ContextWindow   int64                  `json:"contextWindow"`
 	ReasoningEffort shared.ReasoningEffort `json:"reasoningEffort,omitempty"`
 	Cwd             *string                `json:"cwd,omitempty"`
+	Mode            SessionMode            `json:"mode,omitempty"`
 	Messages        []Message              `json:"messages"`
 	Usage           TokenUsage             `json:"usage"`
 	Error           *string                `json:"error,omitempty"`
+	BudgetLimit     BudgetLimit            `json:"budgetLimit,omitempty"`
 	StartedAt       time.Time              `json:"startedAt"`
 	EndedAt         *time.Time             `json:"endedAt,omitempty"`
 }
+
+// Prompt returns the message that opened the round: its first user message
+// other than the session metadata sent ahead of it.
+func (r Round) Prompt() (Message, bool) {
+	for _, message := range r.Messages {
+		if message.Role != RoleUser {
+			continue
+		}
+		if _, ok := parseMetadataMessage(message); ok {
+			continue
+		}
+		return message, true
+	}
+	return Message{}, false
+}
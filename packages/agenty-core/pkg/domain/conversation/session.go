@@ -3,6 +3,7 @@ package conversation
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,9 +24,13 @@ type Session struct {
 	AgentCode              shared.Code            `json:"agentCode"`
 	Title                  *string                `json:"title,omitempty"`
 	Cwd                    *string                `json:"cwd,omitempty"`
+	WorkspaceRoots         []string               `json:"workspaceRoots,omitempty"`
 	CurrentModel           *shared.ModelRef       `json:"currentModel,omitempty"`
 	ContextWindow          int64                  `json:"contextWindow"`
 	CurrentReasoningEffort shared.ReasoningEffort `json:"currentReasoningEffort,omitempty"`
+	Mode                   SessionMode            `json:"mode,omitempty"`
+	Parent                 *SessionParent         `json:"parent,omitempty"`
+	ForkedFrom             *SessionFork           `json:"forkedFrom,omitempty"`
 	Rounds                 []Round                `json:"rounds"`
 	CreatedAt              time.Time              `json:"createdAt"`
 	UpdatedAt              time.Time              `json:"updatedAt"`
@@ -33,6 +38,9 @@ type Session struct {
 	pending  []shared.Event
 	metadata *SessionMetadata
 	context  []Message
+	// compactedRounds is the number of rounds started before the latest
+	// compaction; their content is folded into its summary.
+	compactedRounds int
 }
 
 type CompactionInput struct {
@@ -58,6 +66,32 @@ func StartSession(agentCode shared.Code, model shared.ModelRef, contextWindow in
 	return s
 }
 
+// StartChildSession starts a session that runs a subtask delegated from a
+// tool call in another session's round.
+func StartChildSession(parent SessionParent, agentCode shared.Code, model shared.ModelRef, contextWindow int64, effort shared.ReasoningEffort, cwd *string) *Session {
+	s := &Session{Rounds: make([]Round, 0)}
+	s.record(SessionStarted{
+		SessionID:       shared.NewID(),
+		Agent:           agentCode,
+		Model:           model,
+		ContextWindow:   contextWindow,
+		ReasoningEffort: effort,
+		Cwd:             cloneString(cwd),
+		Parent:          &parent,
+		At:              now(),
+	})
+	return s
+}
+
+// Depth is the delegation depth of the session: zero for sessions started by
+// the user, one more than the parent's depth for child sessions.
+func (s *Session) Depth() int {
+	if s.Parent == nil {
+		return 0
+	}
+	return s.Parent.Depth
+}
+
 func (s *Session) SetModel(model shared.ModelRef, contextWindow int64) {
 	s.record(SessionModelSet{
 		SessionID:     s.ID,
@@ -79,6 +113,16 @@ func (s *Session) SetCwd(cwd *string) {
 	s.record(SessionCwdSet{SessionID: s.ID, Cwd: cloneString(cwd), At: now()})
 }
 
+// SetWorkspaceRoots replaces the directories besides the working directory
+// that the session's file tools may use.
+func (s *Session) SetWorkspaceRoots(roots []string) {
+	s.record(SessionWorkspaceRootsSet{SessionID: s.ID, Roots: slices.Clone(roots), At: now()})
+}
+
+func (s *Session) SetMode(mode SessionMode) {
+	s.record(SessionModeSet{SessionID: s.ID, Mode: mode, At: now()})
+}
+
 func (s *Session) StartRound() (uuid.UUID, error) {
 	if s.CurrentModel == nil || s.CurrentModel.IsZero() {
 		return uuid.Nil, ErrModelNotConfigured
@@ -93,6 +137,7 @@ func (s *Session) StartRound() (uuid.UUID, error) {
 		ContextWindow:   s.ContextWindow,
 		ReasoningEffort: s.CurrentReasoningEffort,
 		Cwd:             s.Cwd,
+		Mode:            s.Mode,
 		At:              now(),
 	})
 	return id, nil
@@ -190,23 +235,48 @@ func (s *Session) CompleteRound(roundID uuid.UUID, status RoundStatus, usage Tok
 	if !status.Terminal() {
 		return fmt.Errorf("conversation: %q is not a terminal round status", status)
 	}
-
-	r, _, ok := s.findRound(roundID)
-	if !ok {
-		return ErrRoundNotFound
+	if status == RoundBudgetExceeded {
+		return fmt.Errorf("conversation: use ExceedRoundBudget to end a round over budget")
 	}
-	if r.Status.Terminal() {
-		return ErrRoundNotRunning
+	if status == RoundInterrupted {
+		return fmt.Errorf("conversation: use RecoverInterruptedRounds to close interrupted rounds")
 	}
-
-	s.record(RoundEnded{
+	return s.endRound(RoundEnded{
 		SessionID: s.ID,
 		RoundID:   roundID,
 		Status:    status,
 		Usage:     usage,
 		Error:     errMsg,
-		At:        now(),
 	})
+}
+
+// ExceedRoundBudget ends a running round because the given budget limit was
+// reached.
+func (s *Session) ExceedRoundBudget(roundID uuid.UUID, limit BudgetLimit, usage TokenUsage, errMsg *string) error {
+	if !limit.Valid() {
+		return fmt.Errorf("conversation: invalid budget limit %q", limit)
+	}
+	return s.endRound(RoundEnded{
+		SessionID:   s.ID,
+		RoundID:     roundID,
+		Status:      RoundBudgetExceeded,
+		Usage:       usage,
+		Error:       errMsg,
+		BudgetLimit: limit,
+	})
+}
+
+func (s *Session) endRound(event RoundEnded) error {
+	r, _, ok := s.findRound(event.RoundID)
+	if !ok {
+		return ErrRoundNotFound
+	}
+	if r.Status.Terminal() {
+		return ErrRoundNotRunning
+	}
+
+	event.At = now()
+	s.record(event)
 	return nil
 }
 
@@ -224,6 +294,7 @@ func (s *Session) ClearPending() {
 
 func (s *Session) VisibleCopy() *Session {
 	copy := *s
+	copy.WorkspaceRoots = slices.Clone(s.WorkspaceRoots)
 	copy.Rounds = make([]Round, len(s.Rounds))
 	if s.metadata != nil {
 		metadata := *s.metadata
@@ -272,6 +343,14 @@ func (s *Session) apply(e shared.Event) {
 		s.ContextWindow = ev.ContextWindow
 		s.CurrentReasoningEffort = ev.ReasoningEffort
 		s.Cwd = cloneString(ev.Cwd)
+		if ev.Parent != nil {
+			parent := *ev.Parent
+			s.Parent = &parent
+		}
+		if ev.ForkedFrom != nil {
+			fork := *ev.ForkedFrom
+			s.ForkedFrom = &fork
+		}
 		s.Rounds = make([]Round, 0)
 		s.context = make([]Message, 0)
 		s.CreatedAt = ev.At
@@ -281,17 +360,25 @@ func (s *Session) apply(e shared.Event) {
 		s.ContextWindow = ev.ContextWindow
 		s.updateMetadataModel(ev.Model)
 		s.refreshCompactionMetadata()
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
 	case SessionReasoningEffortSet:
 		s.CurrentReasoningEffort = ev.ReasoningEffort
 		s.updateMetadataReasoningEffort(ev.ReasoningEffort)
 		s.refreshCompactionMetadata()
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
 	case SessionCwdSet:
 		s.Cwd = cloneString(ev.Cwd)
 		s.updateMetadataCwd(ev.Cwd)
 		s.refreshCompactionMetadata()
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
+	case SessionWorkspaceRootsSet:
+		s.WorkspaceRoots = slices.Clone(ev.Roots)
+		s.touch(ev.At)
+	case SessionModeSet:
+		s.Mode = ev.Mode
+		s.updateMetadataMode(ev.Mode)
+		s.refreshCompactionMetadata()
+		s.touch(ev.At)
 	case RoundStarted:
 		s.Rounds = append(s.Rounds, Round{
 			ID:              ev.RoundID,
@@ -302,37 +389,54 @@ func (s *Session) apply(e shared.Event) {
 			ContextWindow:   ev.ContextWindow,
 			ReasoningEffort: ev.ReasoningEffort,
 			Cwd:             cloneString(ev.Cwd),
+			Mode:            ev.Mode,
 			Messages:        []Message{},
 			StartedAt:       ev.At,
 		})
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
 	case MessageAppended:
 		if r, _, ok := s.findRound(ev.Message.RoundID); ok {
 			r.Messages = append(r.Messages, ev.Message)
 		}
 		s.context = append(s.context, ev.Message)
 		s.applyMessageMetadata(ev.Message)
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
 	case SessionCompacted:
 		s.applyCompaction(ev)
-		s.UpdatedAt = ev.At
+		s.compactedRounds = len(s.Rounds)
+		s.touch(ev.At)
+	case ToolResultsPruned:
+		s.applyPrune(ev)
+		s.touch(ev.At)
 	case SessionMetadataRefreshed:
 		s.applyMessageMetadata(ev.Message)
 		s.refreshCompactionMetadata()
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
 	case RoundEnded:
 		if r, _, ok := s.findRound(ev.RoundID); ok {
 			r.Status = ev.Status
 			r.Usage = ev.Usage
 			r.Error = ev.Error
+			r.BudgetLimit = ev.BudgetLimit
 			ended := ev.At
 			r.EndedAt = &ended
 		}
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
 	case SessionTitleSet:
 		title := ev.Title
 		s.Title = &title
-		s.UpdatedAt = ev.At
+		s.touch(ev.At)
+	case RoundsReverted:
+		s.applyRevert(ev)
+		s.touch(ev.At)
+	}
+}
+
+// touch advances UpdatedAt. Forked sessions replay events older than their
+// start, which must not move it backwards.
+func (s *Session) touch(at time.Time) {
+	if at.After(s.UpdatedAt) {
+		s.UpdatedAt = at
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"testing"
 
@@ -66,16 +67,20 @@ func TestSessionConfigurationAndRoundSnapshots(t *testing.T) {
 	session.SetModel(model2, 128_000)
 	session.SetReasoningEffort(shared.ReasoningLow)
 	session.SetCwd(&cwd2)
+	session.SetMode(ModePlan)
+	roots := []string{"/shared/docs"}
+	session.SetWorkspaceRoots(roots)
+	roots[0] = "/mutated/root"
 	cwd2 = "/also/mutated"
 	round2, err := session.StartRound()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if got := session.Rounds[0]; got.ID != round1 || got.Model != model1 || got.ContextWindow != 200_000 || got.ReasoningEffort != shared.ReasoningHigh || got.Cwd == nil || *got.Cwd != "/workspace/one" {
+	if got := session.Rounds[0]; got.ID != round1 || got.Model != model1 || got.ContextWindow != 200_000 || got.ReasoningEffort != shared.ReasoningHigh || got.Cwd == nil || *got.Cwd != "/workspace/one" || got.Mode.Effective() != ModeBuild {
 		t.Errorf("first round snapshot = %+v", got)
 	}
-	if got := session.Rounds[1]; got.ID != round2 || got.Model != model2 || got.ContextWindow != 128_000 || got.ReasoningEffort != shared.ReasoningLow || got.Cwd == nil || *got.Cwd != "/workspace/two" {
+	if got := session.Rounds[1]; got.ID != round2 || got.Model != model2 || got.ContextWindow != 128_000 || got.ReasoningEffort != shared.ReasoningLow || got.Cwd == nil || *got.Cwd != "/workspace/two" || got.Mode != ModePlan {
 		t.Errorf("second round snapshot = %+v", got)
 	}
 
@@ -86,6 +91,12 @@ func TestSessionConfigurationAndRoundSnapshots(t *testing.T) {
 	if replayed.Cwd == nil || *replayed.Cwd != "/workspace/two" || replayed.CurrentReasoningEffort != shared.ReasoningLow {
 		t.Errorf("replayed execution configuration = cwd %v, reasoning %q", replayed.Cwd, replayed.CurrentReasoningEffort)
 	}
+	if !slices.Equal(replayed.WorkspaceRoots, []string{"/shared/docs"}) {
+		t.Errorf("replayed workspace roots = %q", replayed.WorkspaceRoots)
+	}
+	if replayed.Mode != ModePlan || replayed.Rounds[1].Mode != ModePlan {
+		t.Errorf("replayed mode = %q, round mode %q, want plan", replayed.Mode, replayed.Rounds[1].Mode)
+	}
 }
 
 func TestSessionLifecycleAndReplay(t *testing.T) {
@@ -181,6 +192,54 @@ func TestSessionCompactionReplacesOnlyEffectiveContext(t *testing.T) {
 	}
 }
 
+func TestSessionPruneToolResultsReplacesOnlyEffectiveContext(t *testing.T) {
+	t.Parallel()
+
+	model := shared.NewModelRef("anthropic", "claude-opus")
+	session := StartSession("coder", model, 200_000, shared.ReasoningOff, nil)
+	roundID, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendUserMessage(roundID, Text("read both")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendAssistantMessage(roundID, Content{
+		ToolUseBlock{ID: "call-1", Name: "read_file", Input: shared.RawJSON(`{}`)},
+		ToolUseBlock{ID: "call-2", Name: "read_file", Input: shared.RawJSON(`{}`)},
+	}, model, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendUserMessage(roundID, Content{
+		ToolResultBlock{ToolUseID: "call-1", Content: Text("old output")},
+		ToolResultBlock{ToolUseID: "call-2", Content: Text("new output")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.PruneToolResults(PruneInput{Trigger: CompactionTriggerModelSwitch}); !errors.Is(err, ErrInvalidCompaction) {
+		t.Fatalf("prune without tool calls err = %v, want invalid compaction", err)
+	}
+	if _, err := session.PruneToolResults(PruneInput{Trigger: CompactionTriggerAuto, ToolUseIDs: []string{"call-1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertPruned := func(t *testing.T, session *Session) {
+		t.Helper()
+		context := session.ContextMessages()
+		results := context[len(context)-1].Content
+		if !IsPrunedToolResult(results[0].(ToolResultBlock)) || IsPrunedToolResult(results[1].(ToolResultBlock)) {
+			t.Errorf("context results = %+v, want only call-1 pruned", results)
+		}
+		raw := session.Rounds[0].Messages[2].Content[0].(ToolResultBlock)
+		if IsPrunedToolResult(raw) {
+			t.Errorf("round result = %+v, want the full output kept", raw)
+		}
+	}
+	assertPruned(t, session)
+	assertPruned(t, ReplaySession(roundTripEvents(t, session.PendingEvents())))
+}
+
 func TestSessionCompactionMetadataTracksModelChangesInPlace(t *testing.T) {
 	t.Parallel()
 
@@ -383,6 +442,290 @@ func TestSessionCompleteRoundTerminalStatuses(t *testing.T) {
 	}
 }
 
+func TestSessionExceedRoundBudgetRecordsLimitAndReplays(t *testing.T) {
+	t.Parallel()
+
+	session := StartSession("coder", shared.NewModelRef("anthropic", "claude-opus"), 200_000, shared.ReasoningOff, nil)
+	roundID, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.CompleteRound(roundID, RoundBudgetExceeded, TokenUsage{}, nil); err == nil {
+		t.Error("CompleteRound accepted budget_exceeded without a limit")
+	}
+	if err := session.ExceedRoundBudget(roundID, "minutes", TokenUsage{}, nil); err == nil {
+		t.Error("ExceedRoundBudget accepted an invalid limit")
+	}
+	if err := session.ExceedRoundBudget(roundID, BudgetLimitToolCalls, TokenUsage{Total: 7}, stringPointer("too many tool calls")); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.ExceedRoundBudget(roundID, BudgetLimitToolCalls, TokenUsage{}, nil); !errors.Is(err, ErrRoundNotRunning) {
+		t.Errorf("second ExceedRoundBudget error = %v, want ErrRoundNotRunning", err)
+	}
+
+	replayed := ReplaySession(session.PendingEvents())
+	for _, round := range []Round{session.Rounds[0], replayed.Rounds[0]} {
+		if round.Status != RoundBudgetExceeded || round.BudgetLimit != BudgetLimitToolCalls || round.Usage.Total != 7 || round.EndedAt == nil {
+			t.Errorf("round = %+v", round)
+		}
+	}
+}
+
+func TestSessionRecoverInterruptedRoundsClosesDanglingToolCalls(t *testing.T) {
+	t.Parallel()
+
+	model := shared.NewModelRef("anthropic", "claude-opus")
+	session := StartSession("coder", model, 200_000, shared.ReasoningOff, nil)
+	completedID, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.CompleteRound(completedID, RoundCompleted, TokenUsage{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	roundID, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendUserMessage(roundID, Text("run the tests")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendAssistantMessage(roundID, Content{
+		ToolUseBlock{ID: "call-read", Name: "read_file", Input: shared.RawJSON(`{}`)},
+		ShellCallBlock{CallID: "call-shell", Commands: []string{"go test ./..."}},
+	}, model, &TokenUsage{Input: 10, Output: 2, Total: 12}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.AppendUserMessage(roundID, Content{
+		ToolResultBlock{ToolUseID: "call-read", Content: Text("ok")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash leaves the round open in the transcript.
+	interrupted := ReplaySession(session.PendingEvents())
+	if interrupted.Rounds[1].Status != RoundRunning {
+		t.Fatalf("replayed round = %+v", interrupted.Rounds[1])
+	}
+	if err := interrupted.CompleteRound(roundID, RoundInterrupted, TokenUsage{}, nil); err == nil {
+		t.Error("CompleteRound accepted interrupted")
+	}
+	recovered, err := interrupted.RecoverInterruptedRounds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 || recovered[0] != roundID {
+		t.Fatalf("recovered = %v, want [%s]", recovered, roundID)
+	}
+
+	events := append(session.PendingEvents(), interrupted.PendingEvents()...)
+	for _, candidate := range []*Session{interrupted, ReplaySession(events)} {
+		round := candidate.Rounds[1]
+		if round.Status != RoundInterrupted || round.Error == nil || round.Usage.Total != 12 || round.EndedAt == nil {
+			t.Errorf("round = %+v", round)
+		}
+		last := round.Messages[len(round.Messages)-1]
+		if len(last.Content) != 1 {
+			t.Fatalf("closing message = %+v", last)
+		}
+		result, ok := last.Content[0].(ToolResultBlock)
+		if !ok || result.ToolUseID != "call-shell" || !result.IsError {
+			t.Errorf("synthetic result = %+v", last.Content[0])
+		}
+		if candidate.Rounds[0].Status != RoundCompleted {
+			t.Errorf("completed round changed to %s", candidate.Rounds[0].Status)
+		}
+	}
+
+	again, err := interrupted.RecoverInterruptedRounds()
+	if err != nil || len(again) != 0 {
+		t.Errorf("second recovery = %v, %v", again, err)
+	}
+}
+
+func TestSessionRevertRoundsDropsRoundsFromContext(t *testing.T) {
+	t.Parallel()
+
+	model := shared.NewModelRef("anthropic", "claude-opus")
+	session := StartSession("coder", model, 200_000, shared.ReasoningOff, nil)
+	var roundIDs []uuid.UUID
+	for index, prompt := range []string{"first", "second", "third"} {
+		roundID, err := session.StartRound()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.AppendUserMessage(roundID, Text(prompt)); err != nil {
+			t.Fatal(err)
+		}
+		if index == 1 {
+			if _, err := session.AppendHiddenUserMessage(roundID, Text("<metadata><model>other</model></metadata>")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := session.CompleteRound(roundID, RoundCompleted, TokenUsage{}, nil); err != nil {
+			t.Fatal(err)
+		}
+		roundIDs = append(roundIDs, roundID)
+	}
+
+	event, err := session.RevertRounds(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.RoundIDs) != 2 || event.RoundIDs[0] != roundIDs[1] || event.RoundIDs[1] != roundIDs[2] {
+		t.Errorf("reverted round IDs = %v", event.RoundIDs)
+	}
+	if len(session.Rounds) != 1 || session.Rounds[0].ID != roundIDs[0] {
+		t.Fatalf("rounds = %+v", session.Rounds)
+	}
+	if context := session.ContextMessages(); len(context) != 1 || context[0].Content[0].(TextBlock).Text != "first" {
+		t.Errorf("context = %+v", context)
+	}
+	if session.LastMetadata() != nil {
+		t.Errorf("metadata = %+v, want the reverted update dropped", session.LastMetadata())
+	}
+
+	next, err := session.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Rounds[1].ID != next || session.Rounds[1].Sequence != 2 {
+		t.Errorf("round after revert = %+v", session.Rounds[1])
+	}
+	replayed := ReplaySession(roundTripEvents(t, session.PendingEvents()))
+	if len(replayed.Rounds) != 2 || replayed.Rounds[1].ID != next || len(replayed.ContextMessages()) != 1 {
+		t.Errorf("replayed rounds = %+v", replayed.Rounds)
+	}
+
+	if _, err := session.RevertRounds(2); !errors.Is(err, ErrRevertRunningRound) {
+		t.Errorf("revert with running round error = %v", err)
+	}
+	if err := session.CompleteRound(next, RoundCompleted, TokenUsage{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.RevertRounds(3); !errors.Is(err, ErrRoundNotFound) {
+		t.Errorf("revert beyond last round error = %v", err)
+	}
+	if _, err := session.Compact(CompactionInput{Trigger: CompactionTriggerManual, Summary: "summary"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.RevertRounds(2); !errors.Is(err, ErrRevertCompactedRound) {
+		t.Errorf("revert of compacted round error = %v", err)
+	}
+}
+
+func TestForkSessionReproducesTranscriptUpToForkPoint(t *testing.T) {
+	t.Parallel()
+
+	model := shared.NewModelRef("anthropic", "claude-opus")
+	cwd := "/workspace"
+	source := StartSession("coder", model, 200_000, shared.ReasoningHigh, &cwd)
+	first, err := source.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.AppendUserMessage(first, Text("goal")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.AppendAssistantMessage(first, Text("done"), model, &TokenUsage{Total: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.CompleteRound(first, RoundCompleted, TokenUsage{Total: 4}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Compact(CompactionInput{Trigger: CompactionTriggerManual, Summary: "goal reached"}); err != nil {
+		t.Fatal(err)
+	}
+	second, err := source.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.AppendUserMessage(second, Text("next")); err != nil {
+		t.Fatal(err)
+	}
+	call, err := source.AppendAssistantMessage(second, Content{
+		ToolUseBlock{ID: "call-1", Name: "shell", Input: json.RawMessage(`{}`)},
+	}, model, &TokenUsage{Total: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.AppendAssistantMessage(second, Text("later"), model, nil); err != nil {
+		t.Fatal(err)
+	}
+	events := source.PendingEvents()
+
+	byRound, err := ForkSession(events, ForkPoint{RoundID: first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byRound.ID == source.ID || byRound.ForkedFrom == nil || byRound.ForkedFrom.SessionID != source.ID ||
+		byRound.ForkedFrom.MessageID != source.Rounds[0].Messages[1].ID {
+		t.Fatalf("forked from = %+v", byRound.ForkedFrom)
+	}
+	if len(byRound.Rounds) != 1 || byRound.Rounds[0].Status != RoundCompleted || byRound.Rounds[0].SessionID != byRound.ID {
+		t.Errorf("round fork rounds = %+v", byRound.Rounds)
+	}
+	if byRound.CurrentReasoningEffort != shared.ReasoningHigh || byRound.Cwd == nil || *byRound.Cwd != cwd {
+		t.Errorf("round fork settings = %+v", byRound)
+	}
+	if len(byRound.ContextMessages()) != 2 {
+		t.Errorf("round fork context = %+v, want no compaction yet", byRound.ContextMessages())
+	}
+
+	byMessage, err := ForkSession(events, ForkPoint{MessageID: call.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !byMessage.hasCompactionSummary() {
+		t.Error("message fork lost the compaction state")
+	}
+	cut := byMessage.Rounds[1]
+	if cut.Status != RoundCompleted || cut.Usage.Total != 6 || len(cut.Messages) != 3 {
+		t.Fatalf("cut round = %+v", cut)
+	}
+	if results := cut.Messages[2].Content; len(results) != 1 || !results[0].(ToolResultBlock).IsError {
+		t.Errorf("synthetic results = %+v", results)
+	}
+	if byMessage.UpdatedAt.Before(byMessage.CreatedAt) {
+		t.Errorf("updatedAt %v is before createdAt %v", byMessage.UpdatedAt, byMessage.CreatedAt)
+	}
+
+	replayed := ReplaySession(roundTripEvents(t, byMessage.PendingEvents()))
+	if replayed.ForkedFrom == nil || *replayed.ForkedFrom != *byMessage.ForkedFrom || len(replayed.Rounds) != 2 {
+		t.Errorf("replayed fork = %+v", replayed)
+	}
+
+	if _, err := ForkSession(events, ForkPoint{RoundID: second}); !errors.Is(err, ErrInvalidForkPoint) {
+		t.Errorf("running round fork error = %v", err)
+	}
+	if _, err := ForkSession(events, ForkPoint{MessageID: uuid.New()}); !errors.Is(err, ErrInvalidForkPoint) {
+		t.Errorf("unknown message fork error = %v", err)
+	}
+	if _, err := ForkSession(events, ForkPoint{}); !errors.Is(err, ErrInvalidForkPoint) {
+		t.Errorf("empty fork point error = %v", err)
+	}
+}
+
+func TestStartChildSessionRecordsParentLink(t *testing.T) {
+	t.Parallel()
+
+	parent := SessionParent{SessionID: uuid.New(), RoundID: uuid.New(), ToolUseID: "call-1", Depth: 1}
+	session := StartChildSession(parent, "reviewer", shared.NewModelRef("anthropic", "claude-opus"), 200_000, shared.ReasoningOff, nil)
+	replayed := ReplaySession(session.PendingEvents())
+	for _, got := range []*Session{session, replayed} {
+		if got.Parent == nil || *got.Parent != parent || got.Depth() != 1 {
+			t.Errorf("session parent = %+v", got.Parent)
+		}
+	}
+	if summary := replayed.Summary(); summary.ParentSessionID == nil || *summary.ParentSessionID != parent.SessionID {
+		t.Errorf("summary = %+v", summary)
+	}
+	root := StartSession("coder", shared.NewModelRef("anthropic", "claude-opus"), 200_000, shared.ReasoningOff, nil)
+	if root.Parent != nil || root.Depth() != 0 || root.Summary().ParentSessionID != nil {
+		t.Errorf("root session parent = %+v", root.Parent)
+	}
+}
+
 func TestSessionRequiresConfiguredModelAndTerminalStatus(t *testing.T) {
 	t.Parallel()
 
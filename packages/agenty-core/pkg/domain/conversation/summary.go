@@ -16,6 +16,11 @@ type SessionSummary struct {
 	LastModelCode       shared.ModelCode       `json:"lastModelCode"`
 	ContextWindow       int64                  `json:"contextWindow"`
 	LastReasoningEffort shared.ReasoningEffort `json:"lastReasoningEffort"`
+	RoundCount          int                    `json:"roundCount"`
+	ParentSessionID     *uuid.UUID             `json:"parentSessionId,omitempty"`
+	ParentRoundID       *uuid.UUID             `json:"parentRoundId,omitempty"`
+	ForkedFromSessionID *uuid.UUID             `json:"forkedFromSessionId,omitempty"`
+	ForkedFromMessageID *uuid.UUID             `json:"forkedFromMessageId,omitempty"`
 	CreatedAt           time.Time              `json:"createdAt"`
 	UpdatedAt           time.Time              `json:"updatedAt"`
 }
@@ -26,6 +31,7 @@ func (s *Session) Summary() SessionSummary {
 		AgentCode:           s.AgentCode,
 		ContextWindow:       s.ContextWindow,
 		LastReasoningEffort: s.CurrentReasoningEffort,
+		RoundCount:          len(s.Rounds),
 		CreatedAt:           s.CreatedAt,
 		UpdatedAt:           s.UpdatedAt,
 	}
@@ -37,6 +43,18 @@ func (s *Session) Summary() SessionSummary {
 		sum.LastProviderCode = s.CurrentModel.ProviderCode
 		sum.LastModelCode = s.CurrentModel.ModelCode
 	}
+	if s.Parent != nil {
+		parentSessionID := s.Parent.SessionID
+		parentRoundID := s.Parent.RoundID
+		sum.ParentSessionID = &parentSessionID
+		sum.ParentRoundID = &parentRoundID
+	}
+	if s.ForkedFrom != nil {
+		forkedFromSessionID := s.ForkedFrom.SessionID
+		forkedFromMessageID := s.ForkedFrom.MessageID
+		sum.ForkedFromSessionID = &forkedFromSessionID
+		sum.ForkedFromMessageID = &forkedFromMessageID
+	}
 
 	return sum
 }
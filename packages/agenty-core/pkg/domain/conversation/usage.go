@@ -1,5 +1,8 @@
 package conversation
 
+// TokenUsage counts the tokens of one or more model calls as the providers
+// report them. Anthropic leaves the tokens read from or written to its prompt
+// cache out of Input; the other providers include them.
 type TokenUsage struct {
 	Input      int64 `json:"input"`
 	Output     int64 `json:"output"`
@@ -0,0 +1,287 @@
+// Package cassette records model calls to a file and serves them back, so a
+// real session can be captured once and rerun offline through the whole
+// engine and RPC stack.
+//
+// A cassette is a JSON Lines file with one successful call per line. Calls
+// are matched on a hash of the model and the request, leaving out message
+// IDs, round IDs and timestamps, which differ between runs. Identical
+// requests are served in the order they were recorded.
+package cassette
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+const (
+	// EnvRecord names the cassette file every model call is recorded to.
+	EnvRecord = "AGENTY_CASSETTE_RECORD"
+	// EnvReplay names the cassette file model calls are served from instead
+	// of the providers.
+	EnvReplay = "AGENTY_CASSETTE_REPLAY"
+)
+
+var ErrNoRecording = errors.New("cassette: no recorded response for request")
+
+type Mode string
+
+const (
+	ModeRecord Mode = "record"
+	ModeReplay Mode = "replay"
+)
+
+type interaction struct {
+	Key      string                  `json:"key"`
+	Model    shared.ModelRef         `json:"model"`
+	Request  agentloop.Request       `json:"request"`
+	Events   []agentloop.StreamEvent `json:"events,omitempty"`
+	Response *agentloop.Response     `json:"response"`
+}
+
+type Cassette struct {
+	mode Mode
+	path string
+
+	mu       sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	recorded map[string][]interaction
+}
+
+// FromEnv opens the cassette selected by EnvRecord or EnvReplay. It returns
+// nil when neither is set.
+func FromEnv(getenv func(string) string) (*Cassette, error) {
+	record := strings.TrimSpace(getenv(EnvRecord))
+	replay := strings.TrimSpace(getenv(EnvReplay))
+	switch {
+	case record != "" && replay != "":
+		return nil, fmt.Errorf("cassette: %s and %s are mutually exclusive", EnvRecord, EnvReplay)
+	case record != "":
+		return Record(record)
+	case replay != "":
+		return Replay(replay)
+	default:
+		return nil, nil
+	}
+}
+
+// Record creates or truncates the cassette at path.
+func Record(path string) (*Cassette, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: create %s: %w", path, err)
+	}
+	return &Cassette{mode: ModeRecord, path: path, file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Replay loads the cassette at path.
+func Replay(path string) (*Cassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	recorded := make(map[string][]interaction)
+	decoder := json.NewDecoder(file)
+	for {
+		var entry interaction
+		if err := decoder.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("cassette: read %s: %w", path, err)
+		}
+		if entry.Response == nil {
+			return nil, fmt.Errorf("cassette: read %s: interaction %s has no response", path, entry.Key)
+		}
+		recorded[entry.Key] = append(recorded[entry.Key], entry)
+	}
+	return &Cassette{mode: ModeReplay, path: path, recorded: recorded}, nil
+}
+
+func (c *Cassette) Mode() Mode {
+	return c.mode
+}
+
+func (c *Cassette) Path() string {
+	return c.path
+}
+
+// Wrap returns a caller for model that records the calls made through next,
+// or serves recorded calls when replaying, in which case next may be nil.
+func (c *Cassette) Wrap(model shared.ModelRef, next agentloop.Caller) agentloop.Caller {
+	return &caller{cassette: c, model: model, next: next}
+}
+
+func (c *Cassette) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+func (c *Cassette) record(entry interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.encoder.Encode(entry); err != nil {
+		return fmt.Errorf("cassette: write %s: %w", c.path, err)
+	}
+	return nil
+}
+
+func (c *Cassette) take(key string) (interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := c.recorded[key]
+	if len(entries) == 0 {
+		return interaction{}, false
+	}
+	c.recorded[key] = entries[1:]
+	return entries[0], true
+}
+
+type caller struct {
+	cassette *Cassette
+	model    shared.ModelRef
+	next     agentloop.Caller
+}
+
+func (caller *caller) Invoke(ctx context.Context, request agentloop.Request) (*agentloop.Response, error) {
+	return caller.call(ctx, request, nil)
+}
+
+func (caller *caller) Stream(
+	ctx context.Context,
+	request agentloop.Request,
+	handler agentloop.StreamHandler,
+) (*agentloop.Response, error) {
+	return caller.call(ctx, request, handler)
+}
+
+func (caller *caller) call(
+	ctx context.Context,
+	request agentloop.Request,
+	handler agentloop.StreamHandler,
+) (*agentloop.Response, error) {
+	entry := interaction{Model: caller.model, Request: normalizeRequest(request)}
+	key, err := requestKey(entry.Model, entry.Request)
+	if err != nil {
+		return nil, err
+	}
+	entry.Key = key
+
+	if caller.cassette.mode == ModeReplay {
+		return caller.replay(ctx, key, handler)
+	}
+
+	var response *agentloop.Response
+	if handler == nil {
+		response, err = caller.next.Invoke(ctx, request)
+	} else {
+		response, err = caller.next.Stream(ctx, request, func(event agentloop.StreamEvent) error {
+			entry.Events = append(entry.Events, event)
+			return handler(event)
+		})
+	}
+	if err != nil || response == nil {
+		return response, err
+	}
+	entry.Response = response
+	if err := caller.cassette.record(entry); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (caller *caller) replay(
+	ctx context.Context,
+	key string,
+	handler agentloop.StreamHandler,
+) (*agentloop.Response, error) {
+	entry, ok := caller.cassette.take(key)
+	if !ok {
+		return nil, fmt.Errorf("%w %s to %s", ErrNoRecording, key, caller.model)
+	}
+	if handler != nil {
+		for _, event := range entry.Events {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := handler(event); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entry.Response, nil
+}
+
+// normalizeRequest clears the request and message fields that differ between
+// runs of the same session.
+func normalizeRequest(request agentloop.Request) agentloop.Request {
+	request.CacheKey = ""
+	messages := make([]conversation.Message, len(request.Messages))
+	for index, message := range request.Messages {
+		message.ID = uuid.Nil
+		message.RoundID = uuid.Nil
+		message.CreatedAt = time.Time{}
+		message.Content = normalizeContent(message.Content)
+		messages[index] = message
+	}
+	request.Messages = messages
+	return request
+}
+
+// normalizeContent returns a copy of content without the resource usage of
+// shell commands, which is measured anew on every run.
+func normalizeContent(content conversation.Content) conversation.Content {
+	if content == nil {
+		return nil
+	}
+	normalized := make(conversation.Content, len(content))
+	for index, block := range content {
+		switch block := block.(type) {
+		case conversation.ToolResultBlock:
+			block.Content = normalizeContent(block.Content)
+			normalized[index] = block
+		case conversation.ShellCallOutputBlock:
+			output := make([]conversation.ShellCommandOutput, len(block.Output))
+			for index, command := range block.Output {
+				command.Usage = nil
+				output[index] = command
+			}
+			block.Output = output
+			normalized[index] = block
+		default:
+			normalized[index] = block
+		}
+	}
+	return normalized
+}
+
+func requestKey(model shared.ModelRef, request agentloop.Request) (string, error) {
+	encoded, err := json.Marshal(struct {
+		Model   shared.ModelRef   `json:"model"`
+		Request agentloop.Request `json:"request"`
+	}{Model: model, Request: request})
+	if err != nil {
+		return "", fmt.Errorf("cassette: encode request: %w", err)
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
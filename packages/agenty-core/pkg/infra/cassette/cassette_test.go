@@ -0,0 +1,197 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
+	"github.com/masteryyh/agenty-core/pkg/domain/shared"
+)
+
+type streamingCaller struct {
+	calls int
+}
+
+func (caller *streamingCaller) Invoke(context.Context, agentloop.Request) (*agentloop.Response, error) {
+	caller.calls++
+	return &agentloop.Response{ID: "invoke", Content: conversation.Text("invoked"), StopReason: agentloop.StopReasonEndTurn}, nil
+}
+
+func (caller *streamingCaller) Stream(
+	_ context.Context,
+	_ agentloop.Request,
+	handler agentloop.StreamHandler,
+) (*agentloop.Response, error) {
+	caller.calls++
+	for _, delta := range []string{"hel", "lo"} {
+		if err := handler(agentloop.StreamEvent{Type: agentloop.StreamEventTextDelta, Delta: delta}); err != nil {
+			return nil, err
+		}
+	}
+	return &agentloop.Response{
+		ID:         "stream",
+		Content:    conversation.Text("hello"),
+		Usage:      conversation.TokenUsage{Input: 3, Output: 2, Total: 5},
+		StopReason: agentloop.StopReasonEndTurn,
+	}, nil
+}
+
+func cassetteRequest(text string) agentloop.Request {
+	return agentloop.Request{
+		SystemPrompt: "system",
+		Messages: []conversation.Message{{
+			ID:        shared.NewID(),
+			RoundID:   shared.NewID(),
+			Role:      conversation.RoleUser,
+			Content:   conversation.Text(text),
+			CreatedAt: time.Now(),
+		}},
+		MaxOutputTokens: 1024,
+		CacheKey:        shared.NewID().String(),
+	}
+}
+
+func TestCassetteReplaysRecordedCalls(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "session.cassette.jsonl")
+	model := shared.NewModelRef("openai", "gpt-5")
+	recorder, err := Record(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &streamingCaller{}
+	recording := recorder.Wrap(model, upstream)
+	var recorded []string
+	if _, err := recording.Stream(t.Context(), cassetteRequest("hi"), func(event agentloop.StreamEvent) error {
+		recorded = append(recorded, event.Delta)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recording.Invoke(t.Context(), cassetteRequest("summarize")); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if upstream.calls != 2 || len(recorded) != 2 {
+		t.Fatalf("upstream calls = %d, recorded deltas = %q", upstream.calls, recorded)
+	}
+
+	player, err := Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaying := player.Wrap(model, nil)
+	var replayed []string
+	response, err := replaying.Stream(t.Context(), cassetteRequest("hi"), func(event agentloop.StreamEvent) error {
+		replayed = append(replayed, event.Delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != "stream" || response.Usage.Total != 5 || len(replayed) != 2 || replayed[0] != "hel" || replayed[1] != "lo" {
+		t.Errorf("replayed response = %+v, deltas = %q", response, replayed)
+	}
+	if response, err := replaying.Invoke(t.Context(), cassetteRequest("summarize")); err != nil || response.ID != "invoke" {
+		t.Errorf("replayed invoke = %+v, %v", response, err)
+	}
+
+	if _, err := replaying.Invoke(t.Context(), cassetteRequest("hi")); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("exhausted request error = %v, want ErrNoRecording", err)
+	}
+	other := player.Wrap(shared.NewModelRef("anthropic", "claude"), nil)
+	if _, err := other.Invoke(t.Context(), cassetteRequest("summarize")); !errors.Is(err, ErrNoRecording) {
+		t.Errorf("other model error = %v, want ErrNoRecording", err)
+	}
+}
+
+func TestFromEnvSelectsMode(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	env := func(values map[string]string) func(string) string {
+		return func(key string) string { return values[key] }
+	}
+
+	if recorder, err := FromEnv(env(nil)); recorder != nil || err != nil {
+		t.Errorf("FromEnv without variables = %v, %v", recorder, err)
+	}
+	path := filepath.Join(dir, "calls.jsonl")
+	recorder, err := FromEnv(env(map[string]string{EnvRecord: path}))
+	if err != nil || recorder.Mode() != ModeRecord {
+		t.Fatalf("FromEnv record = %v, %v", recorder, err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	player, err := FromEnv(env(map[string]string{EnvReplay: path}))
+	if err != nil || player.Mode() != ModeReplay {
+		t.Errorf("FromEnv replay = %v, %v", player, err)
+	}
+	if _, err := FromEnv(env(map[string]string{EnvRecord: path, EnvReplay: path})); err == nil {
+		t.Error("FromEnv with both variables succeeded")
+	}
+}
+
+func shellResultRequest(cpuTimeMs int64) agentloop.Request {
+	request := cassetteRequest("run the tests")
+	exitCode := int64(0)
+	request.Messages = append(request.Messages, conversation.Message{
+		ID:   shared.NewID(),
+		Role: conversation.RoleUser,
+		Content: conversation.Content{conversation.ToolResultBlock{
+			ToolUseID: "call-shell",
+			Content: conversation.Content{conversation.ShellCallOutputBlock{
+				CallID: "call-shell",
+				Output: []conversation.ShellCommandOutput{{
+					Stdout:  "ok\n",
+					Outcome: conversation.ShellOutcome{Type: "exit", ExitCode: &exitCode},
+					Usage:   &conversation.ShellUsage{PeakRSSBytes: 1 << 20, CPUTimeMs: cpuTimeMs},
+				}},
+			}},
+		}},
+		CreatedAt: time.Now(),
+	})
+	return request
+}
+
+func TestCassetteReplaysRoundWithShellResult(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "shell.cassette.jsonl")
+	model := shared.NewModelRef("openai", "gpt-5")
+	recorder, err := Record(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := shellResultRequest(12)
+	if _, err := recorder.Wrap(model, &streamingCaller{}).Invoke(t.Context(), recorded); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	result := recorded.Messages[1].Content[0].(conversation.ToolResultBlock)
+	if usage := result.Content[0].(conversation.ShellCallOutputBlock).Output[0].Usage; usage == nil || usage.CPUTimeMs != 12 {
+		t.Errorf("recording changed the caller's shell usage to %+v", usage)
+	}
+
+	player, err := Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := player.Wrap(model, nil).Invoke(t.Context(), shellResultRequest(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != "invoke" {
+		t.Errorf("replayed response = %+v", response)
+	}
+}
@@ -305,6 +305,31 @@ func TestLoadReadsLoggingFromConfigFile(t *testing.T) {
 	}
 }
 
+func TestLoadReadsHooksFromConfigFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv(EnvDataDir, tmpDir)
+
+	data := `{"version":1,"hooks":{"preToolUse":[{"tools":["shell*"],"command":"./guard.sh","timeoutSeconds":5}],"roundEnd":[{"command":"notify"}]}}`
+	if err := os.WriteFile(filepath.Join(tmpDir, "config.json"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, _, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Hooks.PreToolUse) != 1 {
+		t.Fatalf("preToolUse = %+v, want one hook", cfg.Hooks.PreToolUse)
+	}
+	hook := cfg.Hooks.PreToolUse[0]
+	if hook.Command != "./guard.sh" || hook.TimeoutSeconds != 5 || len(hook.Tools) != 1 || hook.Tools[0] != "shell*" {
+		t.Errorf("preToolUse hook = %+v", hook)
+	}
+	if len(cfg.Hooks.RoundEnd) != 1 || cfg.Hooks.RoundEnd[0].Command != "notify" {
+		t.Errorf("roundEnd = %+v, want the notify hook", cfg.Hooks.RoundEnd)
+	}
+}
+
 func TestLoadAppliesEnvOverrides(t *testing.T) {
 	tmpDir := t.TempDir()
 	t.Setenv(EnvDataDir, tmpDir)
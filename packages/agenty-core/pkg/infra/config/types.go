@@ -12,6 +12,118 @@ type Config struct {
 	// Logging configures the slog file logger. Empty fields fall back to the
 	// logger defaults (info level, text format).
 	Logging LoggingConfig `mapstructure:"logging"`
+
+	// ToolPolicies maps tool names to allow, ask, or deny. Agents may override
+	// individual tools; tools absent from both are allowed.
+	ToolPolicies map[string]string `mapstructure:"toolPolicies"`
+
+	// MaxParallelTools caps the tool calls of one model response that run at
+	// once; zero selects the default of 8.
+	MaxParallelTools int `mapstructure:"maxParallelTools"`
+
+	// Shell configures the shell tool.
+	Shell ShellConfig `mapstructure:"shell"`
+
+	// Workspace bounds the paths the file tools may use.
+	Workspace WorkspaceConfig `mapstructure:"workspace"`
+
+	// Hooks lists user commands run at points of the agent loop.
+	Hooks HooksConfig `mapstructure:"hooks"`
+}
+
+// ShellConfig configures the shell tool.
+type ShellConfig struct {
+	// Persistent keeps one shell per session on a pseudo-terminal, so the
+	// working directory, variables and functions carry over between calls.
+	// It is Linux only and ignored when the sandbox is enabled; otherwise
+	// every command runs in a fresh shell.
+	Persistent bool `mapstructure:"persistent"`
+
+	// Sandbox confines shell commands and background processes.
+	Sandbox SandboxConfig `mapstructure:"sandbox"`
+
+	// Limits caps the resources of shell commands and background processes.
+	Limits LimitsConfig `mapstructure:"limits"`
+}
+
+// SandboxConfig confines what agent commands can touch. Sandboxed commands
+// may read everything but the data directory and write only below the
+// session working directory, WritablePaths and a private /tmp. The sandbox
+// uses Linux namespaces and Landlock; elsewhere sandboxed commands fail.
+type SandboxConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// WritablePaths lists further directories commands may write below.
+	WritablePaths []string `mapstructure:"writablePaths"`
+
+	// DenyNetwork leaves commands only a loopback interface of their own.
+	DenyNetwork bool `mapstructure:"denyNetwork"`
+}
+
+// LimitsConfig caps what agent commands may consume. Zero leaves a limit
+// unset. The rlimits apply to each command and need a Unix system.
+type LimitsConfig struct {
+	CPUSeconds     uint64 `mapstructure:"cpuSeconds"`
+	AddressSpaceMB uint64 `mapstructure:"addressSpaceMB"`
+	OpenFiles      uint64 `mapstructure:"openFiles"`
+
+	// Processes caps the processes of the user agenty-core runs as, counting
+	// those it did not start.
+	Processes uint64 `mapstructure:"processes"`
+
+	// SessionMemoryMB and SessionProcesses cap what the commands of a session
+	// use together. They need a cgroup v2 group delegated to agenty-core,
+	// such as a systemd service with Delegate=yes, and are ignored otherwise.
+	SessionMemoryMB  int64 `mapstructure:"sessionMemoryMB"`
+	SessionProcesses int64 `mapstructure:"sessionProcesses"`
+}
+
+// WorkspaceConfig bounds the paths the file, search and patch tools may use
+// to the session working directory, the session's workspace roots and
+// AllowedPaths.
+type WorkspaceConfig struct {
+	// AllowedPaths lists directories every session may use.
+	AllowedPaths []string `mapstructure:"allowedPaths"`
+
+	// ReadAnywhere lets the tools read outside the workspace while still
+	// writing only inside it.
+	ReadAnywhere bool `mapstructure:"readAnywhere"`
+}
+
+// HooksConfig lists the hooks of each lifecycle point. Hooks of one point run
+// in order, each receiving a JSON description of the point on stdin.
+type HooksConfig struct {
+	// PreToolUse hooks run before a tool call and may block or rewrite it.
+	PreToolUse []HookConfig `mapstructure:"preToolUse"`
+
+	// PostToolUse hooks run after a tool call with its result.
+	PostToolUse []HookConfig `mapstructure:"postToolUse"`
+
+	// RoundStart hooks run once a round has recorded its prompt.
+	RoundStart []HookConfig `mapstructure:"roundStart"`
+
+	// RoundEnd hooks run after a round has finished, with its status.
+	RoundEnd []HookConfig `mapstructure:"roundEnd"`
+
+	// PreCompaction hooks run before the session context is summarized.
+	PreCompaction []HookConfig `mapstructure:"preCompaction"`
+}
+
+// HookConfig is a single user hook.
+type HookConfig struct {
+	// Tools restricts tool hooks to tool names matching one of these globs;
+	// empty matches every tool.
+	Tools []string `mapstructure:"tools"`
+
+	// Command is run through the platform shell.
+	Command string `mapstructure:"command"`
+
+	// TimeoutSeconds bounds the command; zero selects the default of 60.
+	TimeoutSeconds int `mapstructure:"timeoutSeconds"`
+
+	// FailOpen lets the tool call run when a pre-tool-use hook times out or
+	// fails; by default the call is blocked.
+	FailOpen bool `mapstructure:"failOpen"`
 }
 
 // LoggingConfig mirrors the AGENTY_LOG_LEVEL / AGENTY_LOG_FORMAT environment
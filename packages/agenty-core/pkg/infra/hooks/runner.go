@@ -0,0 +1,210 @@
+// Package hooks runs the user commands configured under "hooks" in the config
+// file at points of the agent loop.
+//
+// Every command receives the agentloop.HookInput as JSON on stdin and runs in
+// the round's working directory. Exit code 0 lets the loop continue; stdout
+// may then hold a JSON object:
+//
+//	{"decision":"block","reason":"...","toolInput":{...},"additionalContext":"..."}
+//
+// where every field is optional, or plain text that is added as context.
+// Exit code 2 blocks a pre-tool-use call with stderr as the reason. Any other
+// exit code, a timeout or unparsable output is reported as a failed hook. A
+// failed pre-tool-use hook blocks the call too, unless it is set to fail open;
+// other failed hooks are otherwise ignored.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/infra/config"
+)
+
+const (
+	DefaultTimeout = 60 * time.Second
+
+	blockExitCode = 2
+	waitDelay     = time.Second
+)
+
+type hook struct {
+	tools    []string
+	command  string
+	timeout  time.Duration
+	failOpen bool
+}
+
+type output struct {
+	Decision          string          `json:"decision"`
+	Reason            string          `json:"reason"`
+	ToolInput         json.RawMessage `json:"toolInput"`
+	AdditionalContext string          `json:"additionalContext"`
+}
+
+// Runner implements agentloop.HookRunner by running shell commands.
+type Runner struct {
+	hooks map[agentloop.HookEvent][]hook
+}
+
+var _ agentloop.HookRunner = (*Runner)(nil)
+
+// NewRunner validates the configured hooks.
+func NewRunner(cfg config.HooksConfig) (*Runner, error) {
+	runner := &Runner{hooks: make(map[agentloop.HookEvent][]hook)}
+	for event, configured := range map[agentloop.HookEvent][]config.HookConfig{
+		agentloop.HookPreToolUse:    cfg.PreToolUse,
+		agentloop.HookPostToolUse:   cfg.PostToolUse,
+		agentloop.HookRoundStart:    cfg.RoundStart,
+		agentloop.HookRoundEnd:      cfg.RoundEnd,
+		agentloop.HookPreCompaction: cfg.PreCompaction,
+	} {
+		for index, entry := range configured {
+			h, err := newHook(entry)
+			if err != nil {
+				return nil, fmt.Errorf("hooks: %s hook %d: %w", event, index, err)
+			}
+			runner.hooks[event] = append(runner.hooks[event], h)
+		}
+	}
+	return runner, nil
+}
+
+func newHook(entry config.HookConfig) (hook, error) {
+	command := strings.TrimSpace(entry.Command)
+	if command == "" {
+		return hook{}, errors.New("command must not be empty")
+	}
+	if entry.TimeoutSeconds < 0 {
+		return hook{}, errors.New("timeout must not be negative")
+	}
+	for _, pattern := range entry.Tools {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return hook{}, fmt.Errorf("invalid tool pattern %q: %w", pattern, err)
+		}
+	}
+	timeout := DefaultTimeout
+	if entry.TimeoutSeconds > 0 {
+		timeout = time.Duration(entry.TimeoutSeconds) * time.Second
+	}
+	return hook{tools: entry.Tools, command: command, timeout: timeout, failOpen: entry.FailOpen}, nil
+}
+
+// Run runs the hooks of the input's event in order. A rewritten tool input is
+// passed on to the following hooks, and a block skips them. A pre-tool-use
+// hook that fails blocks the call unless it fails open.
+func (runner *Runner) Run(ctx context.Context, input agentloop.HookInput) (agentloop.HookOutcome, error) {
+	var outcome agentloop.HookOutcome
+	var errs []error
+	for _, h := range runner.hooks[input.Event] {
+		if input.ToolName != "" && !h.matches(input.ToolName) {
+			continue
+		}
+		result, err := h.run(ctx, input)
+		if err != nil {
+			err = fmt.Errorf("hook %q: %w", h.command, err)
+			errs = append(errs, err)
+			if input.Event == agentloop.HookPreToolUse && !h.failOpen {
+				outcome.Block = true
+				outcome.Reason = err.Error()
+				break
+			}
+			continue
+		}
+		outcome.Context = append(outcome.Context, result.Context...)
+		if result.ToolInput != nil {
+			outcome.ToolInput = result.ToolInput
+			input.ToolInput = result.ToolInput
+		}
+		if result.Block {
+			outcome.Block = true
+			outcome.Reason = result.Reason
+			break
+		}
+	}
+	return outcome, errors.Join(errs...)
+}
+
+func (h hook) matches(tool string) bool {
+	if len(h.tools) == 0 {
+		return true
+	}
+	for _, pattern := range h.tools {
+		if matched, _ := path.Match(pattern, tool); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (h hook) run(ctx context.Context, input agentloop.HookInput) (agentloop.HookOutcome, error) {
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return agentloop.HookOutcome{}, fmt.Errorf("encode input: %w", err)
+	}
+	runCtx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	process := newCommand(runCtx, h.command)
+	process.Dir = input.Cwd
+	process.Stdin = bytes.NewReader(payload)
+	process.Stdout = &stdout
+	process.Stderr = &stderr
+	process.WaitDelay = waitDelay
+	if err := process.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == blockExitCode && runCtx.Err() == nil {
+			return agentloop.HookOutcome{Block: true, Reason: strings.TrimSpace(stderr.String())}, nil
+		}
+		if runCtx.Err() != nil {
+			return agentloop.HookOutcome{}, fmt.Errorf("timed out after %s", h.timeout)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return agentloop.HookOutcome{}, fmt.Errorf("%w: %s", err, message)
+		}
+		return agentloop.HookOutcome{}, err
+	}
+	return parseOutput(stdout.Bytes())
+}
+
+func parseOutput(stdout []byte) (agentloop.HookOutcome, error) {
+	text := strings.TrimSpace(string(stdout))
+	if text == "" {
+		return agentloop.HookOutcome{}, nil
+	}
+	if !strings.HasPrefix(text, "{") {
+		return agentloop.HookOutcome{Context: []string{text}}, nil
+	}
+
+	var parsed output
+	if err := json.Unmarshal([]byte(text), &parsed); err != nil {
+		return agentloop.HookOutcome{}, fmt.Errorf("decode output: %w", err)
+	}
+	outcome := agentloop.HookOutcome{Reason: parsed.Reason}
+	switch parsed.Decision {
+	case "":
+	case "block":
+		outcome.Block = true
+	default:
+		return agentloop.HookOutcome{}, fmt.Errorf("unknown decision %q", parsed.Decision)
+	}
+	if len(parsed.ToolInput) > 0 && string(parsed.ToolInput) != "null" {
+		if !json.Valid(parsed.ToolInput) || parsed.ToolInput[0] != '{' {
+			return agentloop.HookOutcome{}, errors.New("toolInput must be a JSON object")
+		}
+		outcome.ToolInput = parsed.ToolInput
+	}
+	if context := strings.TrimSpace(parsed.AdditionalContext); context != "" {
+		outcome.Context = []string{context}
+	}
+	return outcome, nil
+}
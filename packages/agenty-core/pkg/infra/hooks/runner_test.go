@@ -0,0 +1,190 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/infra/config"
+)
+
+func TestRunnerPassesInputAndCollectsOutput(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	runner, err := NewRunner(config.HooksConfig{
+		PreToolUse: []config.HookConfig{
+			{Command: "cat > payload.json; printf 'checked by a hook'"},
+			{Tools: []string{"shell*"}, Command: `printf '{"toolInput":{"commands":["ls"]},"additionalContext":"rewrote the command"}'`},
+			{Tools: []string{"read"}, Command: "exit 1"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+
+	input := agentloop.HookInput{
+		Event:     agentloop.HookPreToolUse,
+		SessionID: uuid.New(),
+		Cwd:       dir,
+		ToolName:  "shell",
+		ToolUseID: "call-1",
+		ToolInput: json.RawMessage(`{"commands":["rm -rf /"]}`),
+	}
+	outcome, err := runner.Run(context.Background(), input)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if outcome.Block {
+		t.Fatalf("outcome = %+v, want the call to proceed", outcome)
+	}
+	if string(outcome.ToolInput) != `{"commands":["ls"]}` {
+		t.Errorf("tool input = %s, want the rewritten input", outcome.ToolInput)
+	}
+	if strings.Join(outcome.Context, "|") != "checked by a hook|rewrote the command" {
+		t.Errorf("context = %q", outcome.Context)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "payload.json"))
+	if err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	var payload agentloop.HookInput
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Event != agentloop.HookPreToolUse || payload.SessionID != input.SessionID ||
+		payload.ToolUseID != "call-1" || string(payload.ToolInput) != string(input.ToolInput) {
+		t.Errorf("payload = %+v, want %+v", payload, input)
+	}
+}
+
+func TestRunnerBlocksOnExitCodeTwoOrDecision(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		command string
+		reason  string
+	}{
+		{name: "exit code", command: "echo 'not on main' >&2; exit 2", reason: "not on main"},
+		{name: "decision", command: `printf '{"decision":"block","reason":"read only"}'`, reason: "read only"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			runner, err := NewRunner(config.HooksConfig{
+				PreToolUse: []config.HookConfig{
+					{Command: tt.command},
+					{Command: "printf 'must not run'"},
+				},
+			})
+			if err != nil {
+				t.Fatalf("NewRunner: %v", err)
+			}
+			outcome, err := runner.Run(context.Background(), agentloop.HookInput{
+				Event:    agentloop.HookPreToolUse,
+				ToolName: "shell",
+			})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if !outcome.Block || outcome.Reason != tt.reason || len(outcome.Context) != 0 {
+				t.Errorf("outcome = %+v, want a block with reason %q", outcome, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRunnerReportsFailedHooksAndRunsTheRest(t *testing.T) {
+	t.Parallel()
+
+	runner, err := NewRunner(config.HooksConfig{
+		RoundEnd: []config.HookConfig{
+			{Command: "echo broken >&2; exit 1"},
+			{Command: "sleep 5", TimeoutSeconds: 1},
+			{Command: "printf 'still ran'"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	outcome, err := runner.Run(context.Background(), agentloop.HookInput{Event: agentloop.HookRoundEnd})
+	if err == nil || !strings.Contains(err.Error(), "broken") || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want the failure and the timeout", err)
+	}
+	if len(outcome.Context) != 1 || outcome.Context[0] != "still ran" {
+		t.Errorf("context = %q, want the last hook's output", outcome.Context)
+	}
+}
+
+func TestRunnerFailsPreToolUseClosedUnlessFailOpen(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		hook    config.HookConfig
+		failure string
+	}{
+		{name: "crash", hook: config.HookConfig{Command: "echo broken >&2; exit 1"}, failure: "broken"},
+		{name: "timeout", hook: config.HookConfig{Command: "sleep 5", TimeoutSeconds: 1}, failure: "timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			closed, err := NewRunner(config.HooksConfig{
+				PreToolUse: []config.HookConfig{tt.hook, {Command: "printf 'must not run'"}},
+			})
+			if err != nil {
+				t.Fatalf("NewRunner: %v", err)
+			}
+			input := agentloop.HookInput{Event: agentloop.HookPreToolUse, ToolName: "shell"}
+			outcome, err := closed.Run(context.Background(), input)
+			if err == nil || !strings.Contains(err.Error(), tt.failure) {
+				t.Errorf("err = %v, want %q", err, tt.failure)
+			}
+			if !outcome.Block || !strings.Contains(outcome.Reason, tt.failure) || len(outcome.Context) != 0 {
+				t.Errorf("fail-closed outcome = %+v, want a block naming the failure", outcome)
+			}
+
+			open := tt.hook
+			open.FailOpen = true
+			opened, err := NewRunner(config.HooksConfig{
+				PreToolUse: []config.HookConfig{open, {Command: "printf 'still ran'"}},
+			})
+			if err != nil {
+				t.Fatalf("NewRunner: %v", err)
+			}
+			outcome, err = opened.Run(context.Background(), input)
+			if err == nil || !strings.Contains(err.Error(), tt.failure) {
+				t.Errorf("err = %v, want %q", err, tt.failure)
+			}
+			if outcome.Block || len(outcome.Context) != 1 || outcome.Context[0] != "still ran" {
+				t.Errorf("fail-open outcome = %+v, want the call to proceed", outcome)
+			}
+		})
+	}
+}
+
+func TestNewRunnerRejectsInvalidHooks(t *testing.T) {
+	t.Parallel()
+
+	tests := []config.HooksConfig{
+		{PreToolUse: []config.HookConfig{{Command: " "}}},
+		{PostToolUse: []config.HookConfig{{Command: "true", TimeoutSeconds: -1}}},
+		{PreToolUse: []config.HookConfig{{Command: "true", Tools: []string{"["}}}},
+	}
+	for _, cfg := range tests {
+		if _, err := NewRunner(cfg); err == nil {
+			t.Errorf("NewRunner(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
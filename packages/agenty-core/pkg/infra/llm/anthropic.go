@@ -27,7 +27,7 @@ func (caller *anthropicCaller) Invoke(ctx context.Context, request modelRequest)
 
 	result, err := caller.client.Messages.New(ctx, params)
 	if err != nil {
-		return nil, fmt.Errorf("llm: invoke anthropic messages API: %w", err)
+		return nil, providerError("llm: invoke anthropic messages API", err)
 	}
 
 	return anthropicResponse(result)
@@ -65,7 +65,7 @@ func (caller *anthropicCaller) Stream(
 		}
 	}
 	if err := stream.Err(); err != nil {
-		return nil, fmt.Errorf("llm: stream anthropic messages API: %w", err)
+		return nil, providerError("llm: stream anthropic messages API", err)
 	}
 
 	final, err := anthropicResponse(&accumulator)
@@ -130,7 +130,9 @@ func (caller *anthropicCaller) params(request modelRequest) (anthropic.MessageNe
 		Model:     anthropic.Model(caller.model.Code.String()),
 		Messages:  messages,
 		MaxTokens: request.MaxOutputTokens,
-		Tools:     tools,
+	}
+	if len(tools) > 0 {
+		params.Tools = tools
 	}
 	if prompt != "" {
 		params.System = []anthropic.TextBlockParam{{Text: prompt}}
@@ -145,10 +147,51 @@ func (caller *anthropicCaller) params(request modelRequest) (anthropic.MessageNe
 		}
 		params.OutputConfig.Effort = anthropic.OutputConfigEffort(effort)
 	}
+	anthropicCacheBreakpoints(&params)
 
 	return params, nil
 }
 
+// anthropicCacheBreakpoints marks where Anthropic caches the prompt prefix:
+// after the tools, after the system prompt and at the end of the last two
+// user turns. The earlier turn ends where the previous call of the loop
+// ended, so every call reads what the previous one wrote however many tool
+// results were added since. When compaction rewrites the history only the
+// turn marks miss; the tools and system prompt stay cached.
+func anthropicCacheBreakpoints(params *anthropic.MessageNewParams) {
+	if len(params.Tools) > 0 {
+		if control := params.Tools[len(params.Tools)-1].GetCacheControl(); control != nil {
+			*control = anthropic.NewCacheControlEphemeralParam()
+		}
+	}
+	if len(params.System) > 0 {
+		params.System[len(params.System)-1].CacheControl = anthropic.NewCacheControlEphemeralParam()
+	}
+	marked := 0
+	for index := len(params.Messages) - 1; index >= 0 && marked < 2; index-- {
+		message := params.Messages[index]
+		if message.Role == anthropic.MessageParamRoleUser && markAnthropicCacheBreakpoint(message.Content) {
+			marked++
+		}
+	}
+}
+
+// markAnthropicCacheBreakpoint marks the last block that accepts a cache
+// breakpoint; thinking blocks and empty text do not.
+func markAnthropicCacheBreakpoint(content []anthropic.ContentBlockParamUnion) bool {
+	for index := len(content) - 1; index >= 0; index-- {
+		block := content[index]
+		if block.OfText != nil && block.OfText.Text == "" {
+			continue
+		}
+		if control := block.GetCacheControl(); control != nil {
+			*control = anthropic.NewCacheControlEphemeralParam()
+			return true
+		}
+	}
+	return false
+}
+
 func anthropicTools(definitions []modelToolDefinition) ([]anthropic.ToolUnionParam, error) {
 	tools := make([]anthropic.ToolUnionParam, 0, len(definitions))
 	for _, definition := range definitions {
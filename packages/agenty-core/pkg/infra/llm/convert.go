@@ -110,6 +110,12 @@ type shellCommandOutputWire struct {
 	Stdout  string           `json:"stdout"`
 	Stderr  string           `json:"stderr"`
 	Outcome shellOutcomeWire `json:"outcome"`
+	Usage   *shellUsageWire  `json:"usage,omitempty"`
+}
+
+type shellUsageWire struct {
+	PeakRSSBytes int64 `json:"peak_rss_bytes,omitempty"`
+	CPUTimeMs    int64 `json:"cpu_time_ms"`
 }
 
 type shellOutcomeWire struct {
@@ -120,13 +126,19 @@ type shellOutcomeWire struct {
 func marshalShellCallOutput(value conversation.ShellCallOutputBlock) (string, error) {
 	output := make([]shellCommandOutputWire, 0, len(value.Output))
 	for _, command := range value.Output {
-		output = append(output, shellCommandOutputWire{
+		converted := shellCommandOutputWire{
 			Stdout: command.Stdout,
 			Stderr: command.Stderr,
 			Outcome: shellOutcomeWire{
 				Type: command.Outcome.Type, ExitCode: command.Outcome.ExitCode,
 			},
-		})
+		}
+		if command.Usage != nil {
+			converted.Usage = &shellUsageWire{
+				PeakRSSBytes: command.Usage.PeakRSSBytes, CPUTimeMs: command.Usage.CPUTimeMs,
+			}
+		}
+		output = append(output, converted)
 	}
 
 	encoded, err := json.MarshalString(shellCallOutputWire{
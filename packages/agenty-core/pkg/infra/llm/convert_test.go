@@ -166,6 +166,76 @@ func TestProviderRequestConversions(t *testing.T) {
 	})
 }
 
+func TestPromptCachingParams(t *testing.T) {
+	t.Parallel()
+
+	request := modelRequest{
+		SystemPrompt: "Be concise.",
+		Messages: []conversation.Message{
+			{Role: conversation.RoleUser, Content: conversation.Text("first")},
+			{Role: conversation.RoleAssistant, Content: conversation.Content{
+				conversation.ToolUseBlock{ID: "call_1", Name: "lookup", Input: shared.RawJSON(`{"q":"x"}`)},
+			}},
+			{Role: conversation.RoleUser, Content: conversation.Content{
+				conversation.ToolResultBlock{ToolUseID: "call_1", Content: conversation.Text("found")},
+			}},
+			{Role: conversation.RoleAssistant, Content: conversation.Text("answer")},
+			{Role: conversation.RoleUser, Content: conversation.Content{
+				conversation.TextBlock{Text: "second"},
+				conversation.TextBlock{},
+			}},
+		},
+		Tools:           []modelToolDefinition{testTool(), testTool()},
+		MaxOutputTokens: 128,
+		CacheKey:        "session-1",
+	}
+
+	t.Run("Anthropic Messages", func(t *testing.T) {
+		t.Parallel()
+
+		params, err := (&anthropicCaller{model: testModel()}).params(request)
+		if err != nil {
+			t.Fatalf("convert request: %v", err)
+		}
+		cached := func(control *anthropic.CacheControlEphemeralParam) bool {
+			return control != nil && control.Type == "ephemeral"
+		}
+		if cached(params.Tools[0].GetCacheControl()) || !cached(params.Tools[1].GetCacheControl()) {
+			t.Error("cache breakpoint is not on the last tool only")
+		}
+		if !cached(&params.System[0].CacheControl) {
+			t.Error("system prompt has no cache breakpoint")
+		}
+		want := map[[2]int]bool{{2, 0}: true, {4, 0}: true}
+		for messageIndex, message := range params.Messages {
+			for blockIndex, block := range message.Content {
+				if got := cached(block.GetCacheControl()); got != want[[2]int{messageIndex, blockIndex}] {
+					t.Errorf("message %d block %d cached = %v", messageIndex, blockIndex, got)
+				}
+			}
+		}
+	})
+
+	t.Run("OpenAI Responses", func(t *testing.T) {
+		t.Parallel()
+
+		native, err := (&openAIResponsesCaller{model: testModel(), nativeOpenAI: true}).params(request)
+		if err != nil {
+			t.Fatalf("convert request: %v", err)
+		}
+		if native.PromptCacheKey.Value != "session-1" {
+			t.Errorf("prompt cache key = %q, want session-1", native.PromptCacheKey.Value)
+		}
+		compatible, err := (&openAIResponsesCaller{model: testModel()}).params(request)
+		if err != nil {
+			t.Fatalf("convert request: %v", err)
+		}
+		if compatible.PromptCacheKey.Valid() {
+			t.Errorf("compatible prompt cache key = %q, want none", compatible.PromptCacheKey.Value)
+		}
+	})
+}
+
 func modelWithReasoningNative(native string) catalog.Model {
 	return catalog.Model{
 		Code: "test-model",
@@ -586,6 +656,9 @@ func TestProviderResponseConversions(t *testing.T) {
 			t.Fatalf("convert response: %v", err)
 		}
 		assertResponse(t, response, modelStopReasonToolUse, 3, 15)
+		if response.Usage.Input != 10 || response.Usage.CachedRead != 3 || response.Usage.CacheWrite != 2 {
+			t.Errorf("usage = %+v, want cached tokens apart from the input", response.Usage)
+		}
 	})
 
 	t.Run("Google GenAI", func(t *testing.T) {
@@ -944,6 +1017,75 @@ func TestOpenAIResponsesShellOutputUsesPersistedSource(t *testing.T) {
 	}
 }
 
+func TestOpenAIResponsesShellOutputSendsSandboxViolationsAsExits(t *testing.T) {
+	t.Parallel()
+
+	for _, outcome := range []string{"sandbox_violation", "resource_limit"} {
+		exitCode := int64(1)
+		item, err := openAIResponsesShellCallOutput(conversation.ShellCallOutputBlock{
+			CallID: "call_1",
+			Output: []conversation.ShellCommandOutput{{
+				Stderr:  "touch: cannot touch '/x': Read-only file system",
+				Outcome: conversation.ShellOutcome{Type: outcome, ExitCode: &exitCode},
+			}},
+		})
+		if err != nil || item.OfShellCallOutput == nil {
+			t.Fatalf("%s: item = %#v, err = %v", outcome, item, err)
+		}
+		converted := item.OfShellCallOutput.Output[0].Outcome
+		if converted.OfExit == nil || converted.OfExit.ExitCode != 1 {
+			t.Errorf("%s: outcome = %#v, want exit 1", outcome, converted)
+		}
+	}
+}
+
+func TestOpenAIResponsesShellOutputIncludesUsage(t *testing.T) {
+	t.Parallel()
+
+	exitCode := int64(0)
+	item, err := openAIResponsesShellCallOutput(conversation.ShellCallOutputBlock{
+		CallID: "call_1",
+		Output: []conversation.ShellCommandOutput{
+			{
+				Stderr:  "warning",
+				Outcome: conversation.ShellOutcome{Type: "exit", ExitCode: &exitCode},
+				Usage:   &conversation.ShellUsage{PeakRSSBytes: 4096, CPUTimeMs: 12},
+			},
+			{Stderr: "plain", Outcome: conversation.ShellOutcome{Type: "exit", ExitCode: &exitCode}},
+		},
+	})
+	if err != nil || item.OfShellCallOutput == nil {
+		t.Fatalf("item = %#v, err = %v", item, err)
+	}
+	output := item.OfShellCallOutput.Output
+	if output[0].Stderr != "warning\nusage: cpu_time_ms=12 peak_rss_bytes=4096" {
+		t.Errorf("stderr = %q, want the usage appended", output[0].Stderr)
+	}
+	if output[1].Stderr != "plain" {
+		t.Errorf("stderr without usage = %q", output[1].Stderr)
+	}
+}
+
+func TestMarshalShellCallOutputIncludesUsage(t *testing.T) {
+	t.Parallel()
+
+	exitCode := int64(0)
+	encoded, err := marshalShellCallOutput(conversation.ShellCallOutputBlock{
+		CallID: "call_1",
+		Output: []conversation.ShellCommandOutput{{
+			Stdout:  "hi",
+			Outcome: conversation.ShellOutcome{Type: "exit", ExitCode: &exitCode},
+			Usage:   &conversation.ShellUsage{PeakRSSBytes: 4096, CPUTimeMs: 12},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(encoded, `"usage":{"peak_rss_bytes":4096,"cpu_time_ms":12}`) {
+		t.Errorf("encoded = %s, want the usage", encoded)
+	}
+}
+
 func TestStreamEventConversions(t *testing.T) {
 	t.Parallel()
 
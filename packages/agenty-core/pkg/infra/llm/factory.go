@@ -100,7 +100,11 @@ func nativeOpenAIResponsesProvider(provider catalog.Provider) bool {
 }
 
 func newOpenAIClient(provider catalog.Provider, config factoryConfig) openai.Client {
-	options := []openaioption.RequestOption{openaioption.WithAPIKey(provider.APIKey)}
+	// The engine retries model calls itself so it can report each attempt.
+	options := []openaioption.RequestOption{
+		openaioption.WithAPIKey(provider.APIKey),
+		openaioption.WithMaxRetries(0),
+	}
 	if provider.BaseURL != "" {
 		options = append(options, openaioption.WithBaseURL(provider.BaseURL))
 	}
@@ -112,7 +116,10 @@ func newOpenAIClient(provider catalog.Provider, config factoryConfig) openai.Cli
 }
 
 func newAnthropicClient(provider catalog.Provider, config factoryConfig) anthropic.Client {
-	options := []anthropicoption.RequestOption{anthropicoption.WithAPIKey(provider.APIKey)}
+	options := []anthropicoption.RequestOption{
+		anthropicoption.WithAPIKey(provider.APIKey),
+		anthropicoption.WithMaxRetries(0),
+	}
 	if provider.BaseURL != "" {
 		options = append(options, anthropicoption.WithBaseURL(provider.BaseURL))
 	}
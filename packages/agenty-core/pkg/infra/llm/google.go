@@ -27,7 +27,7 @@ func (caller *googleCaller) Invoke(ctx context.Context, request modelRequest) (*
 
 	result, err := caller.client.Models.GenerateContent(ctx, caller.model.Code.String(), contents, config)
 	if err != nil {
-		return nil, fmt.Errorf("llm: invoke Google GenAI SDK: %w", err)
+		return nil, providerError("llm: invoke Google GenAI SDK", err)
 	}
 
 	return googleResponse(result)
@@ -51,7 +51,7 @@ func (caller *googleCaller) Stream(
 		config,
 	) {
 		if streamErr != nil {
-			return nil, fmt.Errorf("llm: stream Google GenAI SDK: %w", streamErr)
+			return nil, providerError("llm: stream Google GenAI SDK", streamErr)
 		}
 		if err := emitGoogleChunk(handler, chunk); err != nil {
 			return nil, err
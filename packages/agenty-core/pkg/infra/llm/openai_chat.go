@@ -27,7 +27,7 @@ func (caller *openAIChatCaller) Invoke(ctx context.Context, request modelRequest
 
 	result, err := caller.client.Chat.Completions.New(ctx, params)
 	if err != nil {
-		return nil, fmt.Errorf("llm: invoke OpenAI Chat Completions API: %w", err)
+		return nil, providerError("llm: invoke OpenAI Chat Completions API", err)
 	}
 
 	return openAIChatResponse(result)
@@ -81,7 +81,7 @@ func (caller *openAIChatCaller) Stream(
 		}
 	}
 	if err := stream.Err(); err != nil {
-		return nil, fmt.Errorf("llm: stream OpenAI Chat Completions API: %w", err)
+		return nil, providerError("llm: stream OpenAI Chat Completions API", err)
 	}
 
 	final, err := openAIChatResponse(&accumulator.ChatCompletion)
@@ -148,7 +148,9 @@ func (caller *openAIChatCaller) params(request modelRequest) (openai.ChatComplet
 		StreamOptions: openai.ChatCompletionStreamOptionsParam{
 			IncludeUsage: openai.Bool(true),
 		},
-		Tools: tools,
+	}
+	if len(tools) > 0 {
+		params.Tools = tools
 	}
 	if effort != "" {
 		params.ReasoningEffort = openaishared.ReasoningEffort(effort)
@@ -30,7 +30,7 @@ func (caller *openAIResponsesCaller) Invoke(ctx context.Context, request modelRe
 
 	result, err := caller.client.Responses.New(ctx, params)
 	if err != nil {
-		return nil, fmt.Errorf("llm: invoke OpenAI Responses API: %w", err)
+		return nil, providerError("llm: invoke OpenAI Responses API", err)
 	}
 
 	return openAIResponsesResponse(result)
@@ -144,7 +144,7 @@ func (caller *openAIResponsesCaller) Stream(
 		}
 	}
 	if err := stream.Err(); err != nil {
-		return nil, fmt.Errorf("llm: stream OpenAI Responses API: %w", err)
+		return nil, providerError("llm: stream OpenAI Responses API", err)
 	}
 	if final == nil {
 		return nil, fmt.Errorf("llm: OpenAI Responses stream ended without a completed response")
@@ -185,11 +185,17 @@ func (caller *openAIResponsesCaller) params(request modelRequest) (responses.Res
 		Input:           responses.ResponseNewParamsInputUnion{OfInputItemList: input},
 		MaxOutputTokens: openai.Int(request.MaxOutputTokens),
 		Store:           openai.Bool(false),
-		Tools:           tools,
+	}
+	if len(tools) > 0 {
+		params.Tools = tools
 	}
 	if instructions != "" {
 		params.Instructions = openai.String(instructions)
 	}
+	// OpenAI routes requests with the same key to the same cache.
+	if request.CacheKey != "" && caller.nativeOpenAI {
+		params.PromptCacheKey = openai.String(request.CacheKey)
+	}
 	if effort != "" {
 		params.Reasoning.Effort = openaishared.ReasoningEffort(effort)
 		params.Include = []responses.ResponseIncludable{
@@ -619,13 +625,15 @@ func openAIResponsesShellCallOutput(
 	for index, command := range output.Output {
 		converted := responses.ResponseFunctionShellCallOutputContentParam{
 			Stdout: command.Stdout,
-			Stderr: command.Stderr,
+			Stderr: appendShellUsage(command.Stderr, command.Usage),
 		}
 		switch command.Outcome.Type {
 		case "timeout":
 			timeout := responses.NewResponseFunctionShellCallOutputContentOutcomeTimeoutParam()
 			converted.Outcome.OfTimeout = &timeout
-		case "exit":
+		case "exit", "sandbox_violation", "resource_limit":
+			// The Responses API knows only exits and timeouts; the stderr of a
+			// sandbox violation or resource limit explains it.
 			if command.Outcome.ExitCode == nil {
 				return responses.ResponseInputItemUnionParam{}, invalidRequest(
 					"shell output %d exit outcome has no exit code", index,
@@ -649,6 +657,22 @@ func openAIResponsesShellCallOutput(
 	return item, nil
 }
 
+// appendShellUsage adds what a command consumed to its stderr, as the native
+// shell output has no field for it.
+func appendShellUsage(stderr string, usage *conversation.ShellUsage) string {
+	if usage == nil {
+		return stderr
+	}
+	if stderr != "" && !strings.HasSuffix(stderr, "\n") {
+		stderr += "\n"
+	}
+	line := fmt.Sprintf("usage: cpu_time_ms=%d", usage.CPUTimeMs)
+	if usage.PeakRSSBytes > 0 {
+		line += fmt.Sprintf(" peak_rss_bytes=%d", usage.PeakRSSBytes)
+	}
+	return stderr + line
+}
+
 func openAIResponsesResponse(result *responses.Response) (*modelResponse, error) {
 	content := make(conversation.Content, 0, len(result.Output))
 	hasToolUse := false
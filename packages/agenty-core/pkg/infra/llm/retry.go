@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v3"
+	"google.golang.org/genai"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+)
+
+// providerError wraps a failed SDK call in an agentloop.ProviderError that
+// tells the engine whether repeating the request may succeed.
+func providerError(message string, err error) error {
+	result := &agentloop.ProviderError{Err: fmt.Errorf("%s: %w", message, err)}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return result
+	}
+
+	var openAIErr *openai.Error
+	var anthropicErr *anthropic.Error
+	var googleErr genai.APIError
+	var googleErrPtr *genai.APIError
+	switch {
+	case errors.As(err, &openAIErr):
+		result.StatusCode = openAIErr.StatusCode
+		result.Retryable = retryableResponse(openAIErr.Response, openAIErr.StatusCode)
+		result.RetryAfter = retryAfterHeader(openAIErr.Response)
+	case errors.As(err, &anthropicErr):
+		result.StatusCode = anthropicErr.StatusCode
+		result.Retryable = retryableResponse(anthropicErr.Response, anthropicErr.StatusCode) ||
+			retryableAnthropicType(anthropicErr.Type())
+		result.RetryAfter = retryAfterHeader(anthropicErr.Response)
+	case errors.As(err, &googleErr):
+		classifyGoogleError(result, googleErr)
+	case errors.As(err, &googleErrPtr) && googleErrPtr != nil:
+		classifyGoogleError(result, *googleErrPtr)
+	default:
+		result.Retryable = transientNetworkError(err)
+	}
+	return result
+}
+
+func classifyGoogleError(result *agentloop.ProviderError, err genai.APIError) {
+	result.StatusCode = err.Code
+	result.Retryable = retryableStatus(err.Code)
+	result.RetryAfter = googleRetryDelay(err.Details)
+}
+
+// retryableResponse prefers the x-should-retry header that OpenAI and
+// Anthropic send over the status code heuristics.
+func retryableResponse(response *http.Response, statusCode int) bool {
+	if response != nil {
+		switch response.Header.Get("x-should-retry") {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	return retryableStatus(statusCode)
+}
+
+func retryableStatus(statusCode int) bool {
+	switch {
+	case statusCode == http.StatusRequestTimeout,
+		statusCode == http.StatusConflict,
+		statusCode == http.StatusTooManyRequests:
+		return true
+	default:
+		return statusCode >= http.StatusInternalServerError
+	}
+}
+
+// retryableAnthropicType covers errors delivered inside an already open
+// stream, where the HTTP status is still 200.
+func retryableAnthropicType(errorType anthropic.ErrorType) bool {
+	switch errorType {
+	case anthropic.ErrorTypeOverloadedError,
+		anthropic.ErrorTypeRateLimitError,
+		anthropic.ErrorTypeAPIError,
+		anthropic.ErrorTypeTimeoutError:
+		return true
+	default:
+		return false
+	}
+}
+
+func retryAfterHeader(response *http.Response) time.Duration {
+	if response == nil {
+		return 0
+	}
+	if value := response.Header.Get("retry-after-ms"); value != "" {
+		if milliseconds, err := strconv.ParseFloat(value, 64); err == nil && milliseconds > 0 {
+			return time.Duration(milliseconds * float64(time.Millisecond))
+		}
+	}
+	value := response.Header.Get("retry-after")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// googleRetryDelay reads the google.rpc.RetryInfo detail Gemini attaches to
+// quota errors.
+func googleRetryDelay(details []map[string]any) time.Duration {
+	for _, detail := range details {
+		kind, _ := detail["@type"].(string)
+		if !strings.HasSuffix(kind, "google.rpc.RetryInfo") {
+			continue
+		}
+		value, _ := detail["retryDelay"].(string)
+		delay, err := time.ParseDuration(value)
+		if err == nil && delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+func transientNetworkError(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
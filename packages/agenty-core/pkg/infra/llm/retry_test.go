@@ -0,0 +1,157 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v3"
+	"google.golang.org/genai"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+)
+
+func TestProviderErrorClassification(t *testing.T) {
+	t.Parallel()
+
+	request := &http.Request{Method: http.MethodPost, URL: &url.URL{Scheme: "https", Host: "api.test"}}
+	response := func(status int, header http.Header) *http.Response {
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{StatusCode: status, Header: header, Request: request}
+	}
+	overloaded := &anthropic.Error{}
+	if err := overloaded.UnmarshalJSON([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"busy"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	overloaded.StatusCode = http.StatusOK
+	overloaded.Request = request
+	overloaded.Response = response(http.StatusOK, nil)
+
+	tests := []struct {
+		name       string
+		err        error
+		retryable  bool
+		status     int
+		retryAfter time.Duration
+	}{
+		{
+			name: "openai rate limit with retry-after",
+			err: &openai.Error{
+				StatusCode: http.StatusTooManyRequests,
+				Request:    request,
+				Response:   response(http.StatusTooManyRequests, http.Header{"Retry-After": {"2"}}),
+			},
+			retryable:  true,
+			status:     http.StatusTooManyRequests,
+			retryAfter: 2 * time.Second,
+		},
+		{
+			name: "openai bad request",
+			err: &openai.Error{
+				StatusCode: http.StatusBadRequest,
+				Request:    request,
+				Response:   response(http.StatusBadRequest, nil),
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "openai should-retry header overrides status",
+			err: &openai.Error{
+				StatusCode: http.StatusInternalServerError,
+				Request:    request,
+				Response:   response(http.StatusInternalServerError, http.Header{"X-Should-Retry": {"false"}}),
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "anthropic overloaded with retry-after-ms",
+			err: &anthropic.Error{
+				StatusCode: 529,
+				Request:    request,
+				Response:   response(529, http.Header{"Retry-After-Ms": {"1500"}}),
+			},
+			retryable:  true,
+			status:     529,
+			retryAfter: 1500 * time.Millisecond,
+		},
+		{
+			name:      "anthropic overloaded inside stream",
+			err:       overloaded,
+			retryable: true,
+			status:    http.StatusOK,
+		},
+		{
+			name: "anthropic authentication",
+			err: &anthropic.Error{
+				StatusCode: http.StatusUnauthorized,
+				Request:    request,
+				Response:   response(http.StatusUnauthorized, nil),
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "gemini quota with retry info",
+			err: genai.APIError{Code: http.StatusTooManyRequests, Details: []map[string]any{
+				{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "7s"},
+			}},
+			retryable:  true,
+			status:     http.StatusTooManyRequests,
+			retryAfter: 7 * time.Second,
+		},
+		{
+			name:      "gemini unavailable",
+			err:       genai.APIError{Code: http.StatusServiceUnavailable},
+			retryable: true,
+			status:    http.StatusServiceUnavailable,
+		},
+		{
+			name:   "gemini invalid argument",
+			err:    genai.APIError{Code: http.StatusBadRequest},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:      "truncated stream",
+			err:       fmt.Errorf("read body: %w", io.ErrUnexpectedEOF),
+			retryable: true,
+		},
+		{
+			name: "cancelled",
+			err:  &url.Error{Op: "Post", URL: "https://api.test", Err: context.Canceled},
+		},
+		{
+			name: "unknown",
+			err:  errors.New("boom"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := providerError("llm: invoke test API", test.err)
+			var classified *agentloop.ProviderError
+			if !errors.As(err, &classified) {
+				t.Fatalf("error = %T, want *agentloop.ProviderError", err)
+			}
+			if want := "llm: invoke test API: " + test.err.Error(); err.Error() != want {
+				t.Errorf("error = %q, want %q", err.Error(), want)
+			}
+			if classified.Retryable != test.retryable {
+				t.Errorf("retryable = %v, want %v", classified.Retryable, test.retryable)
+			}
+			if classified.StatusCode != test.status {
+				t.Errorf("status = %d, want %d", classified.StatusCode, test.status)
+			}
+			if classified.RetryAfter != test.retryAfter {
+				t.Errorf("retry after = %s, want %s", classified.RetryAfter, test.retryAfter)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
 	"github.com/masteryyh/agenty-core/pkg/application"
 	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
 	"github.com/masteryyh/agenty-core/pkg/domain/conversation"
@@ -73,6 +74,7 @@ func newDispatcherWithCaller(t *testing.T, caller agentloop.Caller) *rpc.Dispatc
 		application.NewInitializeService(agentService, providerService, initialization),
 		sessionService,
 		execution,
+		builtin.NewProcesses(),
 	)
 	return d
 }
@@ -0,0 +1,39 @@
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
+	"github.com/masteryyh/agenty-core/pkg/infra/rpc"
+)
+
+// RegisterProcessHandlers registers process.* methods on d.
+func RegisterProcessHandlers(d *rpc.Dispatcher, processes *builtin.Processes) {
+	d.Register("process.list", processList(processes))
+}
+
+type processListParams struct {
+	// SessionID limits the list to one session; empty lists every session.
+	SessionID string `json:"sessionId,omitempty"`
+}
+
+func processList(processes *builtin.Processes) rpc.Handler {
+	return func(_ context.Context, params json.RawMessage) (any, error) {
+		var p processListParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		sessionID := uuid.Nil
+		if p.SessionID != "" {
+			id, err := uuid.Parse(p.SessionID)
+			if err != nil {
+				return nil, rpc.InvalidParams("invalid session id: " + err.Error())
+			}
+			sessionID = id
+		}
+		return processes.List(sessionID), nil
+	}
+}
@@ -2,6 +2,7 @@ package adapter
 
 import (
 	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/agentloop/builtin"
 	"github.com/masteryyh/agenty-core/pkg/application"
 	"github.com/masteryyh/agenty-core/pkg/infra/rpc"
 )
@@ -13,9 +14,11 @@ func RegisterAll(
 	initializeSvc *application.InitializeService,
 	sessionSvc *application.SessionService,
 	execution *agentloop.Engine,
+	processes *builtin.Processes,
 ) {
 	RegisterAgentHandlers(d, agentSvc)
 	RegisterProviderHandlers(d, providerSvc)
 	RegisterInitializeHandlers(d, initializeSvc)
 	RegisterSessionHandlers(d, sessionSvc, execution)
+	RegisterProcessHandlers(d, processes)
 }
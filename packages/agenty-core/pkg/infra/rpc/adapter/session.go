@@ -20,13 +20,26 @@ func RegisterSessionHandlers(
 	d.Register("session.get", sessionGet(svc))
 	d.Register("session.list", sessionList(svc))
 	d.Register("session.delete", sessionDelete(svc))
+	d.Register("session.fork", sessionFork(svc))
 	d.Register("session.setTitle", sessionSetTitle(svc))
 	d.Register("session.setModel", sessionSetModel(execution))
 	d.Register("session.setReasoningEffort", sessionSetReasoningEffort(svc))
 	d.Register("session.setCwd", sessionSetCwd(svc))
+	d.Register("session.setWorkspaceRoots", sessionSetWorkspaceRoots(svc))
+	d.Register("session.setMode", sessionSetMode(svc))
 	d.Register("session.start", sessionStart(execution))
 	d.Register("session.compact", sessionCompact(execution))
 	d.Register("session.stop", sessionStop(execution))
+	d.Register("session.steer", sessionSteer(execution))
+	d.Register("session.resume", sessionResume(execution))
+	d.Register("session.rewind", sessionRewind(svc))
+	d.Register("session.regenerate", sessionRegenerate(execution))
+	d.Register("session.queue.list", sessionQueueList(execution))
+	d.Register("session.queue.clear", sessionQueueClear(execution))
+	d.Register("session.queue.resume", sessionQueueResume(execution))
+	d.Register("session.approveToolCalls", sessionApproveToolCalls(execution))
+	d.Register("session.rejectToolCalls", sessionRejectToolCalls(execution))
+	d.Register("session.cancelToolCall", sessionCancelToolCall(execution))
 }
 
 type idParams struct {
@@ -54,9 +67,10 @@ func sessionGet(svc *application.SessionService) rpc.Handler {
 }
 
 type sessionListParams struct {
-	AgentCode string `json:"agentCode,omitempty"`
-	Limit     int    `json:"limit,omitempty"`
-	Offset    int    `json:"offset,omitempty"`
+	AgentCode  string `json:"agentCode,omitempty"`
+	ForkedFrom string `json:"forkedFrom,omitempty"`
+	Limit      int    `json:"limit,omitempty"`
+	Offset     int    `json:"offset,omitempty"`
 }
 
 func sessionList(svc *application.SessionService) rpc.Handler {
@@ -66,13 +80,24 @@ func sessionList(svc *application.SessionService) rpc.Handler {
 			return nil, rpc.InvalidParams("invalid params: " + err.Error())
 		}
 		return wrap(svc.List(ctx, application.SessionListQuery{
-			AgentCode: p.AgentCode,
-			Limit:     p.Limit,
-			Offset:    p.Offset,
+			AgentCode:  p.AgentCode,
+			ForkedFrom: p.ForkedFrom,
+			Limit:      p.Limit,
+			Offset:     p.Offset,
 		}))
 	}
 }
 
+func sessionFork(svc *application.SessionService) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p application.SessionForkInput
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(svc.Fork(ctx, p))
+	}
+}
+
 func sessionDelete(svc *application.SessionService) rpc.Handler {
 	return func(ctx context.Context, params json.RawMessage) (any, error) {
 		var p idParams
@@ -147,17 +172,56 @@ func sessionSetCwd(svc *application.SessionService) rpc.Handler {
 	}
 }
 
-type sessionStartParams struct {
+type sessionSetWorkspaceRootsParams struct {
+	ID    string   `json:"id"`
+	Roots []string `json:"roots"`
+}
+
+func sessionSetWorkspaceRoots(svc *application.SessionService) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionSetWorkspaceRootsParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(svc.SetWorkspaceRoots(ctx, p.ID, p.Roots))
+	}
+}
+
+type sessionSetModeParams struct {
+	ID   string                   `json:"id"`
+	Mode conversation.SessionMode `json:"mode"`
+}
+
+func sessionSetMode(svc *application.SessionService) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionSetModeParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(svc.SetMode(ctx, p.ID, p.Mode))
+	}
+}
+
+type sessionContentParams struct {
 	ID      string               `json:"id"`
 	Content conversation.Content `json:"content"`
 }
 
+type sessionStartParams struct {
+	sessionContentParams
+	// Queue enqueues the content behind a running round instead of failing.
+	Queue bool `json:"queue,omitempty"`
+}
+
 func sessionStart(execution *agentloop.Engine) rpc.Handler {
 	return func(ctx context.Context, params json.RawMessage) (any, error) {
 		var p sessionStartParams
 		if err := decodeParams(params, &p); err != nil {
 			return nil, rpc.InvalidParams("invalid params: " + err.Error())
 		}
+		if p.Queue {
+			return wrap(execution.StartOrQueue(ctx, p.ID, p.Content))
+		}
 		return wrap(execution.Start(ctx, p.ID, p.Content))
 	}
 }
@@ -181,3 +245,135 @@ func sessionStop(execution *agentloop.Engine) rpc.Handler {
 		return wrap(execution.Stop(ctx, p.ID))
 	}
 }
+
+func sessionSteer(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionContentParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.Steer(ctx, p.ID, p.Content))
+	}
+}
+
+func sessionResume(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p idParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.Resume(ctx, p.ID))
+	}
+}
+
+type sessionRewindParams struct {
+	ID    string `json:"id"`
+	Round int    `json:"round"`
+}
+
+func sessionRewind(svc *application.SessionService) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionRewindParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(svc.Rewind(ctx, p.ID, p.Round))
+	}
+}
+
+type sessionRegenerateParams struct {
+	ID           string `json:"id"`
+	ProviderCode string `json:"providerCode,omitempty"`
+	ModelCode    string `json:"modelCode,omitempty"`
+}
+
+func sessionRegenerate(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionRegenerateParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.Regenerate(ctx, p.ID, p.ProviderCode, p.ModelCode))
+	}
+}
+
+func sessionQueueList(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p idParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.ListQueue(ctx, p.ID))
+	}
+}
+
+type sessionQueueClearParams struct {
+	ID        string   `json:"id"`
+	PromptIDs []string `json:"promptIds,omitempty"`
+}
+
+func sessionQueueClear(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionQueueClearParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.ClearQueue(ctx, p.ID, p.PromptIDs))
+	}
+}
+
+func sessionQueueResume(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p idParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.ResumeQueue(ctx, p.ID))
+	}
+}
+
+type sessionApproveToolCallsParams struct {
+	ID         string   `json:"id"`
+	ToolUseIDs []string `json:"toolUseIds,omitempty"`
+}
+
+func sessionApproveToolCalls(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionApproveToolCallsParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.ApproveToolCalls(ctx, p.ID, p.ToolUseIDs))
+	}
+}
+
+type sessionRejectToolCallsParams struct {
+	ID         string   `json:"id"`
+	ToolUseIDs []string `json:"toolUseIds,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+func sessionRejectToolCalls(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionRejectToolCallsParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.RejectToolCalls(ctx, p.ID, p.ToolUseIDs, p.Reason))
+	}
+}
+
+type sessionCancelToolCallParams struct {
+	ID        string `json:"id"`
+	ToolUseID string `json:"toolUseId"`
+}
+
+func sessionCancelToolCall(execution *agentloop.Engine) rpc.Handler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p sessionCancelToolCallParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, rpc.InvalidParams("invalid params: " + err.Error())
+		}
+		return wrap(execution.CancelToolCall(ctx, p.ID, p.ToolUseID))
+	}
+}
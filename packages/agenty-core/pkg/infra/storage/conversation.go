@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -40,6 +41,21 @@ func (r *ConversationRepository) Load(ctx context.Context, id uuid.UUID) (*conve
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	events, err := r.sessionEvents(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return conversation.ReplaySession(events), nil
+}
+
+func (r *ConversationRepository) Events(ctx context.Context, id uuid.UUID) ([]shared.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.sessionEvents(ctx, id)
+}
+
+func (r *ConversationRepository) sessionEvents(ctx context.Context, id uuid.UUID) ([]shared.Event, error) {
 	sum, err := r.getSession(ctx, id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -55,8 +71,7 @@ func (r *ConversationRepository) Load(ctx context.Context, id uuid.UUID) (*conve
 	if err != nil {
 		return nil, err
 	}
-
-	return conversation.ReplaySession(events), nil
+	return events, nil
 }
 
 func (r *ConversationRepository) Save(ctx context.Context, session *conversation.Session) error {
@@ -129,8 +144,8 @@ func (r *ConversationRepository) Delete(ctx context.Context, id uuid.UUID) error
 
 func (r *ConversationRepository) upsertSession(ctx context.Context, sum conversation.SessionSummary) error {
 	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO sessions (id, title, agent_code, last_provider_code, last_model_code, context_window, last_reasoning_effort, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO sessions (id, title, agent_code, last_provider_code, last_model_code, context_window, last_reasoning_effort, round_count, parent_session_id, parent_round_id, forked_from_session_id, forked_from_message_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(id) DO UPDATE SET
 			title = excluded.title,
 			agent_code = excluded.agent_code,
@@ -138,6 +153,11 @@ func (r *ConversationRepository) upsertSession(ctx context.Context, sum conversa
 			last_model_code = excluded.last_model_code,
 			context_window = excluded.context_window,
 			last_reasoning_effort = excluded.last_reasoning_effort,
+			round_count = excluded.round_count,
+			parent_session_id = excluded.parent_session_id,
+			parent_round_id = excluded.parent_round_id,
+			forked_from_session_id = excluded.forked_from_session_id,
+			forked_from_message_id = excluded.forked_from_message_id,
 			updated_at = excluded.updated_at
 	`,
 		sum.ID.String(),
@@ -147,6 +167,11 @@ func (r *ConversationRepository) upsertSession(ctx context.Context, sum conversa
 		sum.LastModelCode.String(),
 		sum.ContextWindow,
 		sum.LastReasoningEffort,
+		sum.RoundCount,
+		optionalUUIDString(sum.ParentSessionID),
+		optionalUUIDString(sum.ParentRoundID),
+		optionalUUIDString(sum.ForkedFromSessionID),
+		optionalUUIDString(sum.ForkedFromMessageID),
 		sum.CreatedAt.Format(time.RFC3339),
 		sum.UpdatedAt.Format(time.RFC3339),
 	)
@@ -155,12 +180,12 @@ func (r *ConversationRepository) upsertSession(ctx context.Context, sum conversa
 
 func (r *ConversationRepository) getSession(ctx context.Context, id uuid.UUID) (conversation.SessionSummary, error) {
 	var sum conversation.SessionSummary
-	var idStr, agentStr, providerStr, modelStr, effortStr, createdStr, updatedStr string
+	var idStr, agentStr, providerStr, modelStr, effortStr, parentSessionStr, parentRoundStr, forkedSessionStr, forkedMessageStr, createdStr, updatedStr string
 
 	err := r.db.QueryRowContext(ctx, `
-		SELECT id, title, agent_code, last_provider_code, last_model_code, context_window, last_reasoning_effort, created_at, updated_at
+		SELECT id, title, agent_code, last_provider_code, last_model_code, context_window, last_reasoning_effort, round_count, parent_session_id, parent_round_id, forked_from_session_id, forked_from_message_id, created_at, updated_at
 		FROM sessions WHERE id = ?
-	`, id.String()).Scan(&idStr, &sum.Title, &agentStr, &providerStr, &modelStr, &sum.ContextWindow, &effortStr, &createdStr, &updatedStr)
+	`, id.String()).Scan(&idStr, &sum.Title, &agentStr, &providerStr, &modelStr, &sum.ContextWindow, &effortStr, &sum.RoundCount, &parentSessionStr, &parentRoundStr, &forkedSessionStr, &forkedMessageStr, &createdStr, &updatedStr)
 
 	if err != nil {
 		return conversation.SessionSummary{}, err
@@ -183,6 +208,18 @@ func (r *ConversationRepository) getSession(ctx context.Context, id uuid.UUID) (
 		}
 	}
 	sum.LastReasoningEffort = shared.ReasoningEffort(effortStr)
+	if sum.ParentSessionID, err = parseOptionalUUID(parentSessionStr); err != nil {
+		return conversation.SessionSummary{}, err
+	}
+	if sum.ParentRoundID, err = parseOptionalUUID(parentRoundStr); err != nil {
+		return conversation.SessionSummary{}, err
+	}
+	if sum.ForkedFromSessionID, err = parseOptionalUUID(forkedSessionStr); err != nil {
+		return conversation.SessionSummary{}, err
+	}
+	if sum.ForkedFromMessageID, err = parseOptionalUUID(forkedMessageStr); err != nil {
+		return conversation.SessionSummary{}, err
+	}
 	if sum.CreatedAt, err = time.Parse(time.RFC3339, createdStr); err != nil {
 		return conversation.SessionSummary{}, err
 	}
@@ -194,13 +231,21 @@ func (r *ConversationRepository) getSession(ctx context.Context, id uuid.UUID) (
 }
 
 func (r *ConversationRepository) listSessions(ctx context.Context, query conversation.ListQuery) ([]conversation.SessionSummary, error) {
-	q := "SELECT id, title, agent_code, last_provider_code, last_model_code, context_window, last_reasoning_effort, created_at, updated_at FROM sessions"
+	q := "SELECT id, title, agent_code, last_provider_code, last_model_code, context_window, last_reasoning_effort, round_count, parent_session_id, parent_round_id, forked_from_session_id, forked_from_message_id, created_at, updated_at FROM sessions"
 	args := []any{}
 
+	conditions := []string{}
 	if query.AgentCode != nil {
-		q += " WHERE agent_code = ?"
+		conditions = append(conditions, "agent_code = ?")
 		args = append(args, query.AgentCode.String())
 	}
+	if query.ForkedFrom != nil {
+		conditions = append(conditions, "forked_from_session_id = ?")
+		args = append(args, query.ForkedFrom.String())
+	}
+	if len(conditions) > 0 {
+		q += " WHERE " + strings.Join(conditions, " AND ")
+	}
 
 	q += " ORDER BY updated_at DESC"
 
@@ -222,9 +267,9 @@ func (r *ConversationRepository) listSessions(ctx context.Context, query convers
 	results := make([]conversation.SessionSummary, 0)
 	for rows.Next() {
 		var sum conversation.SessionSummary
-		var idStr, agentStr, providerStr, modelStr, effortStr, createdStr, updatedStr string
+		var idStr, agentStr, providerStr, modelStr, effortStr, parentSessionStr, parentRoundStr, forkedSessionStr, forkedMessageStr, createdStr, updatedStr string
 
-		if err := rows.Scan(&idStr, &sum.Title, &agentStr, &providerStr, &modelStr, &sum.ContextWindow, &effortStr, &createdStr, &updatedStr); err != nil {
+		if err := rows.Scan(&idStr, &sum.Title, &agentStr, &providerStr, &modelStr, &sum.ContextWindow, &effortStr, &sum.RoundCount, &parentSessionStr, &parentRoundStr, &forkedSessionStr, &forkedMessageStr, &createdStr, &updatedStr); err != nil {
 			return nil, err
 		}
 
@@ -245,6 +290,18 @@ func (r *ConversationRepository) listSessions(ctx context.Context, query convers
 			}
 		}
 		sum.LastReasoningEffort = shared.ReasoningEffort(effortStr)
+		if sum.ParentSessionID, err = parseOptionalUUID(parentSessionStr); err != nil {
+			return nil, err
+		}
+		if sum.ParentRoundID, err = parseOptionalUUID(parentRoundStr); err != nil {
+			return nil, err
+		}
+		if sum.ForkedFromSessionID, err = parseOptionalUUID(forkedSessionStr); err != nil {
+			return nil, err
+		}
+		if sum.ForkedFromMessageID, err = parseOptionalUUID(forkedMessageStr); err != nil {
+			return nil, err
+		}
 		if sum.CreatedAt, err = time.Parse(time.RFC3339, createdStr); err != nil {
 			return nil, err
 		}
@@ -257,6 +314,24 @@ func (r *ConversationRepository) listSessions(ctx context.Context, query convers
 	return results, rows.Err()
 }
 
+func optionalUUIDString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
+func parseOptionalUUID(value string) (*uuid.UUID, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
 func (r *ConversationRepository) deleteSession(ctx context.Context, id uuid.UUID) error {
 	_, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id.String())
 	return err
@@ -479,6 +479,108 @@ func TestConversationSaveAndLoad(t *testing.T) {
 	}
 }
 
+func TestConversationProjectsChildSessionParent(t *testing.T) {
+	repo := newConversationRepo(t)
+	ctx := context.Background()
+
+	parent := conversation.StartSession(mustCode("coder"), defaultModel(), 200_000, shared.ReasoningOff, nil)
+	roundID, err := parent.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(ctx, parent); err != nil {
+		t.Fatal(err)
+	}
+	child := conversation.StartChildSession(
+		conversation.SessionParent{SessionID: parent.ID, RoundID: roundID, ToolUseID: "call-1", Depth: 1},
+		mustCode("reviewer"),
+		defaultModel(),
+		200_000,
+		shared.ReasoningOff,
+		nil,
+	)
+	if err := repo.Save(ctx, child); err != nil {
+		t.Fatal(err)
+	}
+
+	sums, err := repo.List(ctx, conversation.ListQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 2 {
+		t.Fatalf("listed %d sessions, want 2", len(sums))
+	}
+	for _, sum := range sums {
+		switch sum.ID {
+		case parent.ID:
+			if sum.ParentSessionID != nil || sum.ParentRoundID != nil {
+				t.Errorf("parent summary = %+v", sum)
+			}
+		case child.ID:
+			if sum.ParentSessionID == nil || *sum.ParentSessionID != parent.ID || sum.ParentRoundID == nil || *sum.ParentRoundID != roundID {
+				t.Errorf("child summary = %+v", sum)
+			}
+		}
+	}
+
+	loaded, err := repo.Load(ctx, child.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Parent == nil || loaded.Parent.ToolUseID != "call-1" || loaded.Depth() != 1 {
+		t.Errorf("loaded parent = %+v", loaded.Parent)
+	}
+}
+
+func TestConversationProjectsForkLineage(t *testing.T) {
+	repo := newConversationRepo(t)
+	ctx := context.Background()
+
+	source := conversation.StartSession(mustCode("coder"), defaultModel(), 200_000, shared.ReasoningOff, nil)
+	roundID, err := source.StartRound()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := source.AppendUserMessage(roundID, conversation.Text("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(ctx, source); err != nil {
+		t.Fatal(err)
+	}
+	events, err := repo.Events(ctx, source.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fork, err := conversation.ForkSession(events, conversation.ForkPoint{MessageID: message.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Save(ctx, fork); err != nil {
+		t.Fatal(err)
+	}
+
+	forks, err := repo.List(ctx, conversation.ListQuery{ForkedFrom: &source.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forks) != 1 || forks[0].ID != fork.ID {
+		t.Fatalf("forks = %+v", forks)
+	}
+	if got := forks[0]; got.ForkedFromSessionID == nil || *got.ForkedFromSessionID != source.ID ||
+		got.ForkedFromMessageID == nil || *got.ForkedFromMessageID != message.ID || got.RoundCount != 1 {
+		t.Errorf("fork summary = %+v", got)
+	}
+
+	loaded, err := repo.Load(ctx, fork.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Rounds) != 1 || loaded.Rounds[0].Messages[0].ID != message.ID {
+		t.Errorf("forked rounds = %+v", loaded.Rounds)
+	}
+}
+
 func TestConversationSaveWithCanceledContextHasNoSideEffects(t *testing.T) {
 	repo := newConversationRepo(t)
 	session := conversation.StartSession(mustCode("coder"), defaultModel(), 200_000, shared.ReasoningOff, nil)
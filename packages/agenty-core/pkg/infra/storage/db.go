@@ -2,6 +2,7 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -15,6 +16,11 @@ CREATE TABLE IF NOT EXISTS sessions (
 	last_model_code TEXT NOT NULL DEFAULT '',
 	context_window INTEGER NOT NULL DEFAULT 0,
 	last_reasoning_effort TEXT NOT NULL DEFAULT '',
+	round_count INTEGER NOT NULL DEFAULT 0,
+	parent_session_id TEXT NOT NULL DEFAULT '',
+	parent_round_id TEXT NOT NULL DEFAULT '',
+	forked_from_session_id TEXT NOT NULL DEFAULT '',
+	forked_from_message_id TEXT NOT NULL DEFAULT '',
 	created_at TEXT NOT NULL,
 	updated_at TEXT NOT NULL
 );
@@ -23,6 +29,21 @@ CREATE INDEX IF NOT EXISTS idx_sessions_agent_code ON sessions(agent_code);
 CREATE INDEX IF NOT EXISTS idx_sessions_updated_at ON sessions(updated_at DESC);
 `
 
+// columnMigrations adds projection columns introduced after the first schema
+// to databases created by older versions. CREATE TABLE IF NOT EXISTS leaves
+// such tables untouched.
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{table: "sessions", column: "parent_session_id", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "sessions", column: "parent_round_id", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "sessions", column: "forked_from_session_id", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "sessions", column: "forked_from_message_id", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "sessions", column: "round_count", definition: "INTEGER NOT NULL DEFAULT 0"},
+}
+
 func OpenDB(path string) (*sql.DB, error) {
 	return openDB(path)
 }
@@ -40,5 +61,34 @@ func openDB(path string) (*sql.DB, error) {
 		d.Close()
 		return nil, err
 	}
+	if err := migrateColumns(d); err != nil {
+		d.Close()
+		return nil, err
+	}
 	return d, nil
 }
+
+func migrateColumns(d *sql.DB) error {
+	for _, migration := range columnMigrations {
+		var count int
+		if err := d.QueryRow(
+			"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+			migration.table,
+			migration.column,
+		).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := d.Exec(fmt.Sprintf(
+			"ALTER TABLE %s ADD COLUMN %s %s",
+			migration.table,
+			migration.column,
+			migration.definition,
+		)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
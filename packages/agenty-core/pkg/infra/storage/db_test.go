@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"database/sql"
 	"path/filepath"
 	"testing"
 )
@@ -17,7 +18,9 @@ func TestOpenDBInitializesSchema(t *testing.T) {
 	wantColumns := map[string]bool{
 		"id": false, "title": false, "agent_code": false,
 		"last_provider_code": false, "last_model_code": false,
-		"context_window": false, "last_reasoning_effort": false,
+		"context_window": false, "last_reasoning_effort": false, "round_count": false,
+		"parent_session_id": false, "parent_round_id": false,
+		"forked_from_session_id": false, "forked_from_message_id": false,
 		"created_at": false, "updated_at": false,
 	}
 	rows, err := db.Query("SELECT name FROM pragma_table_info('sessions')")
@@ -43,3 +46,56 @@ func TestOpenDBInitializesSchema(t *testing.T) {
 		}
 	}
 }
+
+func TestOpenDBAddsColumnsToExistingSessionsTable(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(`CREATE TABLE sessions (
+		id TEXT PRIMARY KEY NOT NULL,
+		title TEXT NOT NULL DEFAULT '',
+		agent_code TEXT NOT NULL,
+		last_provider_code TEXT NOT NULL DEFAULT '',
+		last_model_code TEXT NOT NULL DEFAULT '',
+		context_window INTEGER NOT NULL DEFAULT 0,
+		last_reasoning_effort TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := legacy.Exec(
+		"INSERT INTO sessions (id, agent_code, created_at, updated_at) VALUES ('legacy', 'coder', '', '')",
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		db, err := OpenDB(path)
+		if err != nil {
+			t.Fatalf("OpenDB: %v", err)
+		}
+		var parentSessionID, forkedFromSessionID string
+		if err := db.QueryRow(
+			"SELECT parent_session_id, forked_from_session_id FROM sessions WHERE id = 'legacy'",
+		).Scan(&parentSessionID, &forkedFromSessionID); err != nil {
+			t.Fatal(err)
+		}
+		if parentSessionID != "" {
+			t.Errorf("parent_session_id = %q, want empty", parentSessionID)
+		}
+		if forkedFromSessionID != "" {
+			t.Errorf("forked_from_session_id = %q, want empty", forkedFromSessionID)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
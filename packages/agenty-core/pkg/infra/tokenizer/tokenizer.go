@@ -0,0 +1,111 @@
+// Package tokenizer counts prompt tokens locally for the agent loop's
+// compaction decisions.
+//
+// OpenAI models are counted with their own BPE vocabulary. Anthropic and
+// Gemini do not publish theirs, so their counts come from the closest OpenAI
+// vocabulary scaled by a measured ratio; the agent loop further anchors every
+// estimate to the prompt size the provider reported for the previous call.
+package tokenizer
+
+import (
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+
+	"github.com/masteryyh/agenty-core/pkg/agentloop"
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+)
+
+const (
+	encodingO200K  = tiktoken.MODEL_O200K_BASE
+	encodingCL100K = tiktoken.MODEL_CL100K_BASE
+
+	// anthropicScale is how many Claude tokens one cl100k_base token averages
+	// over English prose and source code.
+	anthropicScale = 1.2
+	// geminiScale is how many Gemini tokens one o200k_base token averages.
+	geminiScale = 1.05
+)
+
+var (
+	mu        sync.Mutex
+	encodings = make(map[string]*tiktoken.Tiktoken)
+)
+
+// The vocabularies are embedded, so counting never downloads them.
+func init() {
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
+// BPE counts tokens with one of OpenAI's byte pair encodings, scaled for
+// models that only approximate it.
+type BPE struct {
+	encoding *tiktoken.Tiktoken
+	scale    float64
+}
+
+var _ agentloop.Tokenizer = (*BPE)(nil)
+
+// New returns the tokenizer for model, or nil when no vocabulary could be
+// loaded, in which case the agent loop falls back to its own estimate.
+func New(provider catalog.Provider, model catalog.Model) agentloop.Tokenizer {
+	name, scale := encodingFor(provider.Type, model.Code.String())
+	encoding, err := load(name)
+	if err != nil {
+		slog.Warn("failed to load tokenizer", "encoding", name, "model", model.Code.String(), "error", err)
+		return nil
+	}
+	return &BPE{encoding: encoding, scale: scale}
+}
+
+func (bpe *BPE) CountTokens(text string) int64 {
+	if text == "" {
+		return 0
+	}
+	tokens := len(bpe.encoding.EncodeOrdinary(text))
+	if bpe.scale == 1 {
+		return int64(tokens)
+	}
+	return int64(math.Ceil(float64(tokens) * bpe.scale))
+}
+
+func encodingFor(apiType catalog.APIType, model string) (string, float64) {
+	switch apiType {
+	case catalog.APIAnthropic:
+		return encodingCL100K, anthropicScale
+	case catalog.APIGemini:
+		return encodingO200K, geminiScale
+	}
+	// Providers behind OpenAI compatible APIs prefix models with their
+	// vendor, as in "openai/gpt-4o".
+	model = strings.ToLower(model[strings.LastIndex(model, "/")+1:])
+	if name, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return name, 1
+	}
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return name, 1
+		}
+	}
+	return encodingO200K, 1
+}
+
+// load builds each encoding once, as that takes a noticeable fraction of a
+// second.
+func load(name string) (*tiktoken.Tiktoken, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if encoding, ok := encodings[name]; ok {
+		return encoding, nil
+	}
+	encoding, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	encodings[name] = encoding
+	return encoding, nil
+}
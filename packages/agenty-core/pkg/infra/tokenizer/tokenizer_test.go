@@ -0,0 +1,54 @@
+package tokenizer
+
+import (
+	"testing"
+
+	"github.com/masteryyh/agenty-core/pkg/domain/catalog"
+)
+
+func TestEncodingForPicksTheModelVocabulary(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		apiType  catalog.APIType
+		model    string
+		encoding string
+		scale    float64
+	}{
+		{apiType: catalog.APIOpenAI, model: "gpt-5", encoding: encodingO200K, scale: 1},
+		{apiType: catalog.APIOpenAI, model: "gpt-4-turbo", encoding: encodingCL100K, scale: 1},
+		{apiType: catalog.APIOpenAICompletions, model: "openai/GPT-4o-mini", encoding: encodingO200K, scale: 1},
+		{apiType: catalog.APIOpenAICompletions, model: "gpt-3.5-turbo", encoding: encodingCL100K, scale: 1},
+		{apiType: catalog.APIAnthropic, model: "claude-sonnet-4-5", encoding: encodingCL100K, scale: anthropicScale},
+		{apiType: catalog.APIGemini, model: "gemini-2.5-pro", encoding: encodingO200K, scale: geminiScale},
+	}
+	for _, tt := range tests {
+		encoding, scale := encodingFor(tt.apiType, tt.model)
+		if encoding != tt.encoding || scale != tt.scale {
+			t.Errorf("encodingFor(%s, %s) = %s x%v, want %s x%v", tt.apiType, tt.model, encoding, scale, tt.encoding, tt.scale)
+		}
+	}
+}
+
+func TestCountTokensUsesTheBPEVocabulary(t *testing.T) {
+	t.Parallel()
+
+	openai := New(catalog.Provider{Type: catalog.APIOpenAI}, catalog.Model{Code: "gpt-4o"})
+	if openai == nil {
+		t.Fatal("New returned no tokenizer")
+	}
+	if got := openai.CountTokens("hello world"); got != 2 {
+		t.Errorf("hello world = %d tokens, want 2", got)
+	}
+	if got := openai.CountTokens("<|endoftext|>"); got < 2 {
+		t.Errorf("special token text = %d tokens, want it counted as plain text", got)
+	}
+	if got := openai.CountTokens(""); got != 0 {
+		t.Errorf("empty text = %d tokens, want 0", got)
+	}
+
+	anthropic := New(catalog.Provider{Type: catalog.APIAnthropic}, catalog.Model{Code: "claude-sonnet-4-5"})
+	if got := anthropic.CountTokens("hello world"); got != 3 {
+		t.Errorf("scaled hello world = %d tokens, want 3", got)
+	}
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// caseInsensitivePaths holds where file systems ignore case by default.
+var caseInsensitivePaths = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// WithinPath reports whether path is root or below it. Paths are compared
+// without case where file systems ignore it.
+func WithinPath(path, root string) bool {
+	if caseInsensitivePaths {
+		path, root = strings.ToLower(path), strings.ToLower(root)
+	}
+	relative, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) &&
+		!filepath.IsAbs(relative)
+}
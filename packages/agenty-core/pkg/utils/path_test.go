@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWithinPath(t *testing.T) {
+	t.Parallel()
+
+	root := filepath.FromSlash("/work/project")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: root, want: true},
+		{path: filepath.Join(root, "src", "main.go"), want: true},
+		{path: root + string(filepath.Separator), want: true},
+		{path: filepath.FromSlash("/work/project-other"), want: false},
+		{path: filepath.FromSlash("/work"), want: false},
+		{path: filepath.FromSlash("/work/project/../other"), want: false},
+		{path: "relative", want: false},
+	}
+	for _, test := range tests {
+		if got := WithinPath(test.path, root); got != test.want {
+			t.Errorf("WithinPath(%q, %q) = %v, want %v", test.path, root, got, test.want)
+		}
+	}
+	if !WithinPath(filepath.FromSlash("/etc"), filepath.FromSlash("/")) {
+		t.Error("WithinPath(/etc, /) = false, want true")
+	}
+	if got := WithinPath(filepath.FromSlash("/WORK/Project/a"), root); got != caseInsensitivePaths {
+		t.Errorf("WithinPath with different case = %v, want %v", got, caseInsensitivePaths)
+	}
+}
@@ -0,0 +1,216 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// cgroupLeaf is the group the current process moves to, as a group holding
+// processes cannot pass controllers on to its children.
+const cgroupLeaf = "agenty-core"
+
+// Cgroups keeps a cgroup v2 group per name below the group of the current
+// process, capping the memory and processes of everything started in it. It
+// needs that group delegated to the current user, as systemd does for
+// services with Delegate=yes.
+type Cgroups struct {
+	root         string
+	memoryMax    int64
+	processesMax int64
+
+	mu     sync.Mutex
+	groups map[string]*os.File
+}
+
+// NewCgroups takes over the cgroup of the current process. memoryMax and
+// processesMax cap each group; zero leaves that resource uncapped but still
+// accounted.
+func NewCgroups(memoryMax, processesMax int64) (*Cgroups, error) {
+	own, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	return delegateCgroup(own, memoryMax, processesMax)
+}
+
+// ownCgroup returns the directory of the cgroup v2 group of the current
+// process.
+func ownCgroup() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("sandbox: read cgroup: %w", err)
+	}
+	path, ok := "", false
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if path, ok = strings.CutPrefix(line, "0::"); ok {
+			break
+		}
+	}
+	if !ok {
+		return "", errors.New("sandbox: cgroup v2 is not in use")
+	}
+
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", fmt.Errorf("sandbox: read mounts: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		separator := slices.Index(fields, "-")
+		if separator < 5 || separator+1 >= len(fields) || fields[separator+1] != "cgroup2" {
+			continue
+		}
+		relative, ok := strings.CutPrefix(path, unescapeMountPath(fields[3]))
+		if !ok {
+			continue
+		}
+		return filepath.Join(unescapeMountPath(fields[4]), relative), nil
+	}
+	return "", errors.New("sandbox: cgroup v2 is not mounted")
+}
+
+func delegateCgroup(own string, memoryMax, processesMax int64) (*Cgroups, error) {
+	data, err := os.ReadFile(filepath.Join(own, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: read cgroup controllers: %w", err)
+	}
+	available := strings.Fields(string(data))
+	for _, controller := range []string{"memory", "pids"} {
+		if !slices.Contains(available, controller) {
+			return nil, fmt.Errorf("sandbox: cgroup controller %s is not delegated", controller)
+		}
+	}
+
+	leaf := filepath.Join(own, cgroupLeaf)
+	if err := os.Mkdir(leaf, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("sandbox: create cgroup: %w", err)
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return nil, err
+	}
+	if err := writeCgroupFile(own, "cgroup.subtree_control", "+memory +pids"); err != nil {
+		return nil, fmt.Errorf("%w; the group may hold other processes", err)
+	}
+	return &Cgroups{
+		root:         own,
+		memoryMax:    memoryMax,
+		processesMax: processesMax,
+		groups:       make(map[string]*os.File),
+	}, nil
+}
+
+// Join makes process, which must not have started, start in the group name,
+// creating the group if needed.
+func (cgroups *Cgroups) Join(process *exec.Cmd, name string) error {
+	cgroups.mu.Lock()
+	defer cgroups.mu.Unlock()
+
+	group, ok := cgroups.groups[name]
+	if !ok {
+		path := cgroups.path(name)
+		if err := os.Mkdir(path, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("sandbox: create cgroup: %w", err)
+		}
+		if err := writeCgroupMax(path, "memory.max", cgroups.memoryMax); err != nil {
+			return err
+		}
+		if err := writeCgroupMax(path, "pids.max", cgroups.processesMax); err != nil {
+			return err
+		}
+		var err error
+		if group, err = os.Open(path); err != nil {
+			return fmt.Errorf("sandbox: open cgroup: %w", err)
+		}
+		cgroups.groups[name] = group
+	}
+
+	if process.SysProcAttr == nil {
+		process.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	process.SysProcAttr.UseCgroupFD = true
+	process.SysProcAttr.CgroupFD = int(group.Fd())
+	return nil
+}
+
+// Events returns how often the commands of the group name hit its limits so
+// far.
+func (cgroups *Cgroups) Events(name string) CgroupEvents {
+	path := cgroups.path(name)
+	return CgroupEvents{
+		OOMKills:           readCgroupEvent(path, "memory.events", "oom_kill"),
+		ProcessLimitDenied: readCgroupEvent(path, "pids.events", "max"),
+	}
+}
+
+// Remove deletes the group name, whose processes must have exited.
+func (cgroups *Cgroups) Remove(name string) {
+	cgroups.mu.Lock()
+	defer cgroups.mu.Unlock()
+	cgroups.removeLocked(name)
+}
+
+// Close deletes every group.
+func (cgroups *Cgroups) Close() {
+	cgroups.mu.Lock()
+	defer cgroups.mu.Unlock()
+	for name := range cgroups.groups {
+		cgroups.removeLocked(name)
+	}
+}
+
+func (cgroups *Cgroups) removeLocked(name string) {
+	group, ok := cgroups.groups[name]
+	if !ok {
+		return
+	}
+	delete(cgroups.groups, name)
+	_ = group.Close()
+	_ = syscall.Rmdir(cgroups.path(name))
+}
+
+func (cgroups *Cgroups) path(name string) string {
+	return filepath.Join(cgroups.root, "session-"+name)
+}
+
+func writeCgroupMax(path, file string, value int64) error {
+	if value <= 0 {
+		return writeCgroupFile(path, file, "max")
+	}
+	return writeCgroupFile(path, file, strconv.FormatInt(value, 10))
+}
+
+func writeCgroupFile(path, file, value string) error {
+	if err := os.WriteFile(filepath.Join(path, file), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("sandbox: write %s: %w", file, err)
+	}
+	return nil
+}
+
+// readCgroupEvent returns the counter key of an events file, or zero when it
+// cannot be read.
+func readCgroupEvent(path, file, key string) int64 {
+	data, err := os.ReadFile(filepath.Join(path, file))
+	if err != nil {
+		return 0
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, key+" "); ok {
+			count, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			return count
+		}
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestCgroupsCapSessions(t *testing.T) {
+	t.Parallel()
+
+	own := t.TempDir()
+	writeFile(t, filepath.Join(own, "cgroup.controllers"), "cpu memory pids")
+	cgroups, err := delegateCgroup(own, 64<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(own, cgroupLeaf, "cgroup.procs")); got != strconv.Itoa(os.Getpid()) {
+		t.Errorf("leaf processes = %q, want the current process", got)
+	}
+	if got := readFile(t, filepath.Join(own, "cgroup.subtree_control")); got != "+memory +pids" {
+		t.Errorf("subtree_control = %q", got)
+	}
+
+	process := exec.Command("true")
+	if err := cgroups.Join(process, "one"); err != nil {
+		t.Fatal(err)
+	}
+	group := filepath.Join(own, "session-one")
+	if got := readFile(t, filepath.Join(group, "memory.max")); got != "67108864" {
+		t.Errorf("memory.max = %q", got)
+	}
+	if got := readFile(t, filepath.Join(group, "pids.max")); got != "max" {
+		t.Errorf("pids.max = %q", got)
+	}
+	if !process.SysProcAttr.UseCgroupFD {
+		t.Error("process does not start in the group")
+	}
+
+	writeFile(t, filepath.Join(group, "memory.events"), "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n")
+	writeFile(t, filepath.Join(group, "pids.events"), "max 2\n")
+	if events := cgroups.Events("one"); events != (CgroupEvents{OOMKills: 1, ProcessLimitDenied: 2}) {
+		t.Errorf("events = %+v", events)
+	}
+	cgroups.Close()
+}
+
+func TestDelegateCgroupRequiresControllers(t *testing.T) {
+	t.Parallel()
+
+	own := t.TempDir()
+	writeFile(t, filepath.Join(own, "cgroup.controllers"), "cpu io")
+	if _, err := delegateCgroup(own, 0, 0); err == nil {
+		t.Fatal("delegateCgroup() error = nil, want missing controllers")
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Cgroups is Linux only.
+type Cgroups struct{}
+
+// NewCgroups returns an error, as cgroups are Linux only.
+func NewCgroups(int64, int64) (*Cgroups, error) {
+	return nil, errors.New("sandbox: cgroups are only supported on Linux")
+}
+
+// Join returns an error.
+func (*Cgroups) Join(*exec.Cmd, string) error {
+	return errors.New("sandbox: cgroups are only supported on Linux")
+}
+
+// Events returns no events.
+func (*Cgroups) Events(string) CgroupEvents {
+	return CgroupEvents{}
+}
+
+// Remove does nothing.
+func (*Cgroups) Remove(string) {}
+
+// Close does nothing.
+func (*Cgroups) Close() {}
@@ -0,0 +1,16 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package sandbox
+
+import "os/exec"
+
+// SetLimits returns ErrLimitsUnsupported unless limits is zero.
+func SetLimits(_ *exec.Cmd, limits Limits) error {
+	if limits == (Limits{}) {
+		return nil
+	}
+	return ErrLimitsUnsupported
+}
+
+// RunHelper does nothing, as no helper is ever started here.
+func RunHelper() {}
@@ -0,0 +1,127 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	json "github.com/bytedance/sonic"
+	"golang.org/x/sys/unix"
+)
+
+// SetLimits makes process, which must not have started, run under limits. It
+// combines with Wrap in either order.
+func SetLimits(process *exec.Cmd, limits Limits) error {
+	if limits == (Limits{}) {
+		return nil
+	}
+	return updateHelper(process, func(config *helperConfig) {
+		config.Limits = limits
+	})
+}
+
+// updateHelper makes process start as the helper, if it does not already, and
+// applies update to the configuration passed to the helper.
+func updateHelper(process *exec.Cmd, update func(*helperConfig)) error {
+	env := process.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	config := helperConfig{Path: process.Path}
+	index := slices.IndexFunc(env, func(entry string) bool {
+		return strings.HasPrefix(entry, helperEnv+"=")
+	})
+	if index >= 0 {
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(env[index], helperEnv+"=")), &config); err != nil {
+			return fmt.Errorf("sandbox: decode policy: %w", err)
+		}
+		env = slices.Delete(slices.Clone(env), index, index+1)
+	} else {
+		self, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("sandbox: locate executable: %w", err)
+		}
+		process.Path = self
+	}
+	update(&config)
+
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("sandbox: encode policy: %w", err)
+	}
+	process.Env = append(slices.Clone(env), helperEnv+"="+string(encoded))
+	return nil
+}
+
+// RunHelper sets up the sandbox and limits and executes the command when the
+// process was started by Wrap or SetLimits, and returns at once otherwise.
+func RunHelper() {
+	encoded, ok := os.LookupEnv(helperEnv)
+	if !ok {
+		return
+	}
+	// Capabilities, no_new_privs and Landlock apply to the thread that
+	// executes the command.
+	runtime.LockOSThread()
+	err := runHelper(encoded)
+	fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+	os.Exit(HelperFailureExitCode)
+}
+
+// runHelper only returns when the sandbox or limits could not be set up.
+func runHelper(encoded string) error {
+	var config helperConfig
+	if err := json.Unmarshal([]byte(encoded), &config); err != nil {
+		return fmt.Errorf("decode policy: %w", err)
+	}
+	if config.Policy != nil {
+		if err := confine(*config.Policy); err != nil {
+			return err
+		}
+	}
+	if err := applyLimits(config.Limits); err != nil {
+		return err
+	}
+
+	env := slices.DeleteFunc(os.Environ(), func(entry string) bool {
+		return strings.HasPrefix(entry, helperEnv+"=")
+	})
+	return unix.Exec(config.Path, os.Args, env)
+}
+
+func applyLimits(limits Limits) error {
+	resources := []struct {
+		name     string
+		resource int
+		value    uint64
+		// slack lets a soft limit warn before the hard one kills.
+		slack uint64
+	}{
+		{"CPU time", unix.RLIMIT_CPU, limits.CPUSeconds, 1},
+		{"address space", unix.RLIMIT_AS, limits.AddressSpaceBytes, 0},
+		{"open files", unix.RLIMIT_NOFILE, limits.OpenFiles, 0},
+		{"processes", unix.RLIMIT_NPROC, limits.Processes, 0},
+	}
+	for _, resource := range resources {
+		if resource.value == 0 {
+			continue
+		}
+		var current unix.Rlimit
+		if err := unix.Getrlimit(resource.resource, &current); err != nil {
+			return fmt.Errorf("read %s limit: %w", resource.name, err)
+		}
+		limit := unix.Rlimit{
+			Cur: min(resource.value, current.Max),
+			Max: min(resource.value+resource.slack, current.Max),
+		}
+		if err := unix.Setrlimit(resource.resource, &limit); err != nil {
+			return fmt.Errorf("set %s limit: %w", resource.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Package sandbox confines commands with Linux user, mount, PID and network
+// namespaces plus Landlock, none of which need root, and caps their resources
+// with rlimits and cgroups.
+//
+// A sandboxed or limited command starts as a copy of the current executable.
+// The copy sets the sandbox and limits up and then executes the command, so
+// every binary that starts such commands must call RunHelper first thing in
+// main.
+package sandbox
+
+import "errors"
+
+// helperEnv passes the command and policy to the helper. Its presence marks
+// the process as the helper.
+const helperEnv = "AGENTY_SANDBOX_HELPER"
+
+// HelperFailureExitCode is the exit code of a command whose sandbox could not
+// be set up; the reason is written to its stderr with a "sandbox: " prefix.
+const HelperFailureExitCode = 125
+
+// ErrUnsupported is returned where commands cannot be sandboxed.
+var ErrUnsupported = errors.New("sandbox: only supported on Linux")
+
+// ErrLimitsUnsupported is returned where resource limits cannot be set.
+var ErrLimitsUnsupported = errors.New("sandbox: resource limits are not supported on this platform")
+
+// Policy describes what a sandboxed command may do. It may read every file
+// but the hidden ones.
+type Policy struct {
+	// Writable lists the directories the command may write below. It also
+	// gets a private /tmp that disappears with it.
+	Writable []string `json:"writable,omitempty"`
+	// Hidden lists files and directories the command sees as empty. Hiding
+	// needs a private /proc, so the command fails where it cannot get one.
+	Hidden []string `json:"hidden,omitempty"`
+	// DenyNetwork leaves the command only a loopback interface of its own.
+	DenyNetwork bool `json:"denyNetwork,omitempty"`
+}
+
+// Limits are rlimits of a command, which its children inherit. Zero leaves a
+// limit as it is; none is raised above the current hard limit.
+type Limits struct {
+	// CPUSeconds stops the command with SIGXCPU once it used that much CPU.
+	CPUSeconds uint64 `json:"cpuSeconds,omitempty"`
+	// AddressSpaceBytes makes allocations beyond it fail.
+	AddressSpaceBytes uint64 `json:"addressSpaceBytes,omitempty"`
+	OpenFiles         uint64 `json:"openFiles,omitempty"`
+	// Processes caps the processes of the user, counting those the command
+	// did not start.
+	Processes uint64 `json:"processes,omitempty"`
+}
+
+// CgroupEvents counts how often the commands of a group hit its limits.
+type CgroupEvents struct {
+	OOMKills           int64
+	ProcessLimitDenied int64
+}
+
+type helperConfig struct {
+	Path   string  `json:"path"`
+	Policy *Policy `json:"policy,omitempty"`
+	Limits Limits  `json:"limits"`
+}
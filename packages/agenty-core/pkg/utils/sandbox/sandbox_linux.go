@@ -0,0 +1,357 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/masteryyh/agenty-core/pkg/utils"
+)
+
+// privateDirectories get a tmpfs of their own in the sandbox.
+var privateDirectories = []string{"/tmp", "/dev/shm"}
+
+// Supported reports whether the platform can sandbox commands. The kernel may
+// still refuse the namespaces, which fails the command.
+func Supported() bool {
+	return true
+}
+
+// Wrap makes process, which must not have started, run under policy. It
+// combines with SetLimits in either order.
+func Wrap(process *exec.Cmd, policy Policy) error {
+	if err := updateHelper(process, func(config *helperConfig) {
+		config.Policy = &policy
+	}); err != nil {
+		return err
+	}
+
+	attributes := process.SysProcAttr
+	if attributes == nil {
+		attributes = &syscall.SysProcAttr{}
+		process.SysProcAttr = attributes
+	}
+	attributes.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if policy.DenyNetwork {
+		attributes.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	attributes.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+	attributes.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	attributes.GidMappingsEnableSetgroups = false
+	attributes.AmbientCaps = append(attributes.AmbientCaps, unix.CAP_SYS_ADMIN, unix.CAP_NET_ADMIN)
+	return nil
+}
+
+// confine sets up the sandbox described by policy for the current process.
+func confine(policy Policy) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("resolve working directory: %w", err)
+	}
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+
+	writable, err := openWritable(policy.Writable)
+	if err != nil {
+		return err
+	}
+	for _, directory := range privateDirectories {
+		if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := unix.Mount("tmpfs", directory, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("mount private %s: %w", directory, err)
+		}
+	}
+	// Bind the writable directories back, as a private directory may have
+	// covered them.
+	for path, fd := range writable {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return fmt.Errorf("recreate writable %s: %w", path, err)
+		}
+		source := "/proc/self/fd/" + strconv.Itoa(fd)
+		if err := unix.Mount(source, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind writable %s: %w", path, err)
+		}
+		_ = unix.Close(fd)
+	}
+
+	// A fresh /proc shows only the sandbox's processes, so the command cannot
+	// reach other mount namespaces through /proc/<pid>/root. Without it hidden
+	// paths stay readable there.
+	procErr := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+	if procErr != nil && len(policy.Hidden) > 0 {
+		return fmt.Errorf("mount private /proc to hide paths: %w", procErr)
+	}
+	procIsolated := procErr == nil
+	exempt := append(slices.Collect(maps.Keys(writable)), privateDirectories...)
+	if err := remountReadOnly(exempt); err != nil {
+		return err
+	}
+	if err := hide(policy.Hidden); err != nil {
+		return err
+	}
+	// Enter the working directory again, through the new mounts.
+	if err := unix.Chdir(cwd); err != nil {
+		return fmt.Errorf("enter working directory: %w", err)
+	}
+	if policy.DenyNetwork {
+		if err := bringUpLoopback(); err != nil {
+			return err
+		}
+	}
+	return restrict(exempt, procIsolated)
+}
+
+// openWritable opens the existing writable directories by their real paths,
+// which keeps them reachable while other mounts change.
+func openWritable(paths []string) (map[string]int, error) {
+	writable := make(map[string]int, len(paths))
+	for _, path := range paths {
+		real, err := filepath.EvalSymlinks(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolve writable %s: %w", path, err)
+		}
+		if _, ok := writable[real]; ok {
+			continue
+		}
+		fd, err := unix.Open(real, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return nil, fmt.Errorf("open writable %s: %w", path, err)
+		}
+		writable[real] = fd
+	}
+	return writable, nil
+}
+
+// remountReadOnly makes every mount outside exempt read-only, keeping the
+// flags the kernel locked when the namespace was created.
+func remountReadOnly(exempt []string) error {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return fmt.Errorf("read mounts: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[4])
+		if slices.ContainsFunc(exempt, func(root string) bool { return utils.WithinPath(mountPoint, root) }) {
+			continue
+		}
+		flags := uintptr(unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY)
+		readOnly := false
+		for option := range strings.SplitSeq(fields[5], ",") {
+			switch option {
+			case "ro":
+				readOnly = true
+			case "nosuid":
+				flags |= unix.MS_NOSUID
+			case "nodev":
+				flags |= unix.MS_NODEV
+			case "noexec":
+				flags |= unix.MS_NOEXEC
+			case "noatime":
+				flags |= unix.MS_NOATIME
+			case "nodiratime":
+				flags |= unix.MS_NODIRATIME
+			case "relatime":
+				flags |= unix.MS_RELATIME
+			case "strictatime":
+				flags |= unix.MS_STRICTATIME
+			}
+		}
+		if readOnly {
+			continue
+		}
+		err := unix.Mount("", mountPoint, "", flags, "")
+		// A mount point covered by another mount or removed since cannot be
+		// reached, so it needs no remount.
+		if err != nil && !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.EACCES) {
+			return fmt.Errorf("remount %s read-only: %w", mountPoint, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read mounts: %w", err)
+	}
+	return nil
+}
+
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var unescaped strings.Builder
+	for index := 0; index < len(path); index++ {
+		if path[index] == '\\' && index+3 < len(path) {
+			if value, err := strconv.ParseUint(path[index+1:index+4], 8, 8); err == nil {
+				unescaped.WriteByte(byte(value))
+				index += 3
+				continue
+			}
+		}
+		unescaped.WriteByte(path[index])
+	}
+	return unescaped.String()
+}
+
+// hide covers each hidden directory with an empty read-only tmpfs and each
+// hidden file with /dev/null.
+func hide(paths []string) error {
+	for _, path := range paths {
+		real, err := filepath.EvalSymlinks(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("resolve hidden %s: %w", path, err)
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			return fmt.Errorf("hide %s: %w", path, err)
+		}
+		if info.IsDir() {
+			err = unix.Mount("tmpfs", real, "tmpfs", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "size=4k")
+		} else if err = unix.Mount("/dev/null", real, "", unix.MS_BIND, ""); err == nil {
+			err = unix.Mount("", real, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, "")
+		}
+		if err != nil {
+			return fmt.Errorf("hide %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func bringUpLoopback() error {
+	socket, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("bring up loopback: %w", err)
+	}
+	defer unix.Close(socket)
+
+	request, err := unix.NewIfreq("lo")
+	if err == nil {
+		err = unix.IoctlIfreq(socket, unix.SIOCGIFFLAGS, request)
+	}
+	if err == nil {
+		request.SetUint16(request.Uint16() | unix.IFF_UP)
+		err = unix.IoctlIfreq(socket, unix.SIOCSIFFLAGS, request)
+	}
+	if err != nil {
+		return fmt.Errorf("bring up loopback: %w", err)
+	}
+	return nil
+}
+
+// restrict stops the command from gaining privileges, limits its writes to
+// writable with Landlock where the kernel has it, and drops the capabilities
+// the setup needed. Without Landlock the read-only mounts alone confine
+// writes, but only a private /proc keeps other mount namespaces out of reach.
+func restrict(writable []string, procIsolated bool) error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	if err := restrictWrites(writable); err != nil {
+		if !errors.Is(err, errLandlockUnavailable) {
+			return err
+		}
+		if !procIsolated {
+			return fmt.Errorf("neither a private /proc nor Landlock is available")
+		}
+	}
+	if os.Getuid() == 0 {
+		// Root would regain every capability in the namespace when it
+		// executes the command.
+		if err := unix.Prctl(unix.PR_SET_SECUREBITS, secureBitsNoRoot, 0, 0, 0); err != nil {
+			return fmt.Errorf("set securebits: %w", err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clear ambient capabilities: %w", err)
+	}
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("drop capabilities: %w", err)
+	}
+	return nil
+}
+
+// secureBitsNoRoot sets and locks SECBIT_NOROOT and SECBIT_NO_SETUID_FIXUP.
+const secureBitsNoRoot = 0x0f
+
+var errLandlockUnavailable = errors.New("landlock is unavailable")
+
+func restrictWrites(writable []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return errLandlockUnavailable
+	}
+	access := uint64(unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE | unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK | unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attributes := unix.LandlockRulesetAttr{Access_fs: access}
+	ruleset, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&attributes)), unsafe.Sizeof(attributes), 0)
+	if errno != 0 {
+		return fmt.Errorf("create landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(ruleset))
+
+	rules := make(map[string]uint64, len(writable)+1)
+	for _, path := range writable {
+		rules[path] = access
+	}
+	// Terminals and /dev/null stay writable.
+	rules["/dev"] = access & (unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE)
+	for path, allowed := range rules {
+		fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if errors.Is(err, unix.ENOENT) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("open %s for landlock: %w", path, err)
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: allowed, Parent_fd: int32(fd)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, ruleset, unix.LANDLOCK_RULE_PATH_BENEATH,
+			uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+		_ = unix.Close(fd)
+		if errno != 0 {
+			return fmt.Errorf("add landlock rule for %s: %w", path, errno)
+		}
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, ruleset, 0, 0); errno != 0 {
+		return fmt.Errorf("enforce landlock ruleset: %w", errno)
+	}
+	return nil
+}
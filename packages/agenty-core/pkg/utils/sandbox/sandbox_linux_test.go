@@ -0,0 +1,131 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func TestWrapConfinesWritesAndHidesPaths(t *testing.T) {
+	t.Parallel()
+
+	writable := t.TempDir()
+	hidden := t.TempDir()
+	if err := os.WriteFile(filepath.Join(hidden, "secret"), []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	private := "agenty-sandbox-" + filepath.Base(writable)
+	output, stderr := runSandboxed(t, Policy{Writable: []string{writable}, Hidden: []string{hidden}}, `
+		printf written > "$1/file"
+		touch /agenty-sandbox-probe
+		printf private > "/tmp/$3" && printf 'tmp ok\n'
+		ls -A "$2"
+		cat "$2/secret"
+		exit 0
+	`, writable, hidden, private)
+
+	if data, err := os.ReadFile(filepath.Join(writable, "file")); err != nil || string(data) != "written" {
+		t.Errorf("writable file = %q, %v; want written", data, err)
+	}
+	if !strings.Contains(stderr, "Read-only file system") {
+		t.Errorf("stderr = %q, want a read-only error for writes outside the writable paths", stderr)
+	}
+	if !strings.Contains(output, "tmp ok") {
+		t.Errorf("output = %q, want a writable private /tmp", output)
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), private)); !os.IsNotExist(err) {
+		t.Errorf("private /tmp file leaked to the host: %v", err)
+	}
+	if strings.Contains(output, "key") || strings.Contains(output, "secret\n") {
+		t.Errorf("output = %q, want the hidden directory empty", output)
+	}
+}
+
+func TestWrapHidesPathsFromOtherProcesses(t *testing.T) {
+	t.Parallel()
+
+	hidden := t.TempDir()
+	if err := os.WriteFile(filepath.Join(hidden, "secret"), []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output, _ := runSandboxed(t, Policy{Hidden: []string{hidden}}, `
+		cat "/proc/$2/root$1/secret"
+		for root in /proc/[0-9]*/root; do
+			cat "$root$1/secret"
+		done
+		exit 0
+	`, hidden, strconv.Itoa(os.Getpid()))
+
+	if strings.Contains(output, "key") {
+		t.Errorf("output = %q, want the hidden file out of reach through /proc", output)
+	}
+}
+
+func TestWrapDeniesNetwork(t *testing.T) {
+	t.Parallel()
+
+	output, _ := runSandboxed(t, Policy{DenyNetwork: true}, `tail -n +3 /proc/net/dev`)
+	interfaces := strings.Fields(output)
+	if len(interfaces) == 0 || interfaces[0] != "lo:" || strings.Count(output, ":") != 1 {
+		t.Errorf("interfaces = %q, want loopback only", output)
+	}
+}
+
+// runSandboxed returns the stdout and stderr of script run under policy.
+func runSandboxed(t *testing.T, policy Policy, script string, arguments ...string) (string, string) {
+	t.Helper()
+
+	process := exec.Command("/bin/sh", append([]string{"-c", script, "sh"}, arguments...)...)
+	if err := Wrap(process, policy); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	process.Stdout = &stdout
+	process.Stderr = &stderr
+	err := process.Run()
+	if exitError, ok := err.(*exec.ExitError); ok && exitError.ExitCode() == HelperFailureExitCode {
+		t.Skipf("sandbox unavailable: %s", stderr.String())
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			t.Skipf("sandbox unavailable: %v", err)
+		}
+		t.Fatalf("sandboxed command failed: %v\n%s", err, stderr.String())
+	}
+	return stdout.String(), stderr.String()
+}
+
+func TestSetLimitsCombinesWithWrap(t *testing.T) {
+	t.Parallel()
+
+	process := exec.Command("/bin/sh", "-c", "ulimit -n; ulimit -t")
+	if err := SetLimits(process, Limits{CPUSeconds: 7, OpenFiles: 64}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Wrap(process, Policy{}); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	process.Stdout = &stdout
+	process.Stderr = &stderr
+	if err := process.Run(); err != nil {
+		if strings.Contains(stderr.String(), "sandbox: ") {
+			t.Skipf("sandbox unavailable: %s", stderr.String())
+		}
+		t.Fatalf("limited command failed: %v\n%s", err, stderr.String())
+	}
+	if got := strings.Fields(stdout.String()); !slices.Equal(got, []string{"64", "7"}) {
+		t.Errorf("limits = %q, want 64 open files and 7 CPU seconds", got)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package sandbox
+
+import "os/exec"
+
+// Supported reports whether the platform can sandbox commands.
+func Supported() bool {
+	return false
+}
+
+// Wrap returns ErrUnsupported.
+func Wrap(*exec.Cmd, Policy) error {
+	return ErrUnsupported
+}
+
+func confine(Policy) error {
+	return ErrUnsupported
+}